@@ -0,0 +1,178 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// The protocol versions a client can speak, as negotiated through HELLO.
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// Reported by Convert when `src` ends partway through a reply.
+var ErrIncomplete = errors.New("incomplete reply")
+
+// Rewrites the replies in `src` for a client speaking `protocol`, appending
+// them to `dst`, and returns how much of `src` they took up. A reply cut off
+// at the end of `src` is left alone, for the caller to retry once the rest
+// of it arrives.
+//
+// For RESP2 clients, RESP3-only types are downgraded the way Redis does:
+// maps become flat arrays of keys and values, sets and pushes arrays,
+// booleans the integers 1 and 0, nulls `$-1`, doubles, big numbers and
+// verbatim strings bulk strings, bulk errors simple errors, and attributes
+// are dropped altogether. RESP3 clients get RESP2's null bulk strings and
+// arrays as RESP3 nulls, everything else being left as is.
+func Convert(dst, src []byte, protocol int) ([]byte, int, error) {
+	consumed := 0
+	for consumed < len(src) {
+		out, n, err := convertReply(dst, src[consumed:], protocol)
+		if errors.Is(err, ErrIncomplete) {
+			break
+		}
+		if err != nil {
+			return dst, consumed, fmt.Errorf("%s convert: %w", ErrEncodePrefix, err)
+		}
+
+		dst = out
+		consumed += n
+	}
+
+	return dst, consumed, nil
+}
+
+// Converts the single reply at the start of `src`, returning how many bytes
+// it took up.
+func convertReply(dst, src []byte, protocol int) ([]byte, int, error) {
+	end := bytes.Index(src, []byte("\r\n"))
+	if end < 0 {
+		return dst, 0, ErrIncomplete
+	}
+
+	typ, header, n := src[0], src[1:end], end+2
+	downgrade := protocol == RESP2
+
+	switch typ {
+	case '+', '-', ':':
+		return append(dst, src[:n]...), n, nil
+	case '$', '!', '=':
+		length, err := strconv.Atoi(string(header))
+		if err != nil {
+			return dst, 0, fmt.Errorf("%c length: %w", typ, err)
+		}
+
+		if length < 0 {
+			if typ == '$' && !downgrade {
+				return append(dst, "_\r\n"...), n, nil
+			}
+			return append(dst, src[:n]...), n, nil
+		}
+
+		if len(src) < n+length+2 {
+			return dst, 0, ErrIncomplete
+		}
+		data := src[n : n+length]
+		n += length + 2
+
+		switch {
+		case !downgrade || typ == '$':
+			return append(dst, src[:n]...), n, nil
+		case typ == '!':
+			data = bytes.ReplaceAll(bytes.ReplaceAll(data, []byte("\r"), []byte(" ")), []byte("\n"), []byte(" "))
+			dst = append(append(append(dst, '-'), data...), "\r\n"...)
+			return dst, n, nil
+		default:
+			// The format, i.e. `txt:`, isn't part of the string
+			if len(data) >= 4 {
+				data = data[4:]
+			}
+			return appendBulkString(dst, data), n, nil
+		}
+	case '*', '%', '~', '>', '|':
+		length, err := strconv.Atoi(string(header))
+		if err != nil {
+			return dst, 0, fmt.Errorf("%c length: %w", typ, err)
+		}
+
+		if length < 0 {
+			if typ == '*' && !downgrade {
+				return append(dst, "_\r\n"...), n, nil
+			}
+			return append(dst, src[:n]...), n, nil
+		}
+
+		elems := length
+		if typ == '%' || typ == '|' {
+			elems *= 2
+		}
+
+		switch {
+		case !downgrade:
+			dst = append(dst, src[:n]...)
+		case typ == '|':
+			// Dropped along with its elements, which are converted into
+			// a scratch buffer only to find where they end
+		default:
+			dst = append(dst, '*')
+			dst = strconv.AppendInt(dst, int64(elems), 10)
+			dst = append(dst, "\r\n"...)
+		}
+
+		out := dst
+		if downgrade && typ == '|' {
+			out = nil
+		}
+
+		for range elems {
+			var m int
+			out, m, err = convertReply(out, src[n:], protocol)
+			if err != nil {
+				return dst, 0, err
+			}
+			n += m
+		}
+
+		if !downgrade || typ != '|' {
+			return out, n, nil
+		}
+
+		// An attribute is followed by the reply it is about
+		out, m, err := convertReply(dst, src[n:], protocol)
+		if err != nil {
+			return dst, 0, err
+		}
+		return out, n + m, nil
+	case '_':
+		if downgrade {
+			return append(dst, "$-1\r\n"...), n, nil
+		}
+		return append(dst, src[:n]...), n, nil
+	case '#':
+		if !downgrade {
+			return append(dst, src[:n]...), n, nil
+		}
+		if string(header) == "t" {
+			return append(dst, ":1\r\n"...), n, nil
+		}
+		return append(dst, ":0\r\n"...), n, nil
+	case ',', '(':
+		if !downgrade {
+			return append(dst, src[:n]...), n, nil
+		}
+		return appendBulkString(dst, header), n, nil
+	default:
+		return dst, 0, fmt.Errorf("unknown type: %q", typ)
+	}
+}
+
+func appendBulkString(dst, data []byte) []byte {
+	dst = append(dst, '$')
+	dst = strconv.AppendInt(dst, int64(len(data)), 10)
+	dst = append(dst, "\r\n"...)
+	dst = append(dst, data...)
+	return append(dst, "\r\n"...)
+}
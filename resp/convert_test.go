@@ -0,0 +1,49 @@
+package resp
+
+import "testing"
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		protocol int
+		in, want string
+	}{
+		{"map", RESP2, "%2\r\n+a\r\n:1\r\n+b\r\n#t\r\n", "*4\r\n+a\r\n:1\r\n+b\r\n:1\r\n"},
+		{"nested set", RESP2, "*2\r\n~1\r\n$1\r\nx\r\n#f\r\n", "*2\r\n*1\r\n$1\r\nx\r\n:0\r\n"},
+		{"null", RESP2, "_\r\n", "$-1\r\n"},
+		{"double", RESP2, ",-inf\r\n", "$4\r\n-inf\r\n"},
+		{"big number", RESP2, "(12345678901234567890\r\n", "$20\r\n12345678901234567890\r\n"},
+		{"verbatim string", RESP2, "=9\r\ntxt:hello\r\n", "$5\r\nhello\r\n"},
+		{"bulk error", RESP2, "!8\r\nERR a\r\nb\r\n", "-ERR a  b\r\n"},
+		{"attribute", RESP2, "|1\r\n+ttl\r\n:10\r\n$1\r\nv\r\n", "$1\r\nv\r\n"},
+		{"resp2 untouched", RESP2, "*3\r\n$-1\r\n*-1\r\n-ERR x\r\n", "*3\r\n$-1\r\n*-1\r\n-ERR x\r\n"},
+		{"resp3 nulls", RESP3, "*2\r\n$-1\r\n*-1\r\n", "*2\r\n_\r\n_\r\n"},
+		{"resp3 untouched", RESP3, "%1\r\n+a\r\n~1\r\n#t\r\n", "%1\r\n+a\r\n~1\r\n#t\r\n"},
+		{"several replies", RESP2, "+OK\r\n_\r\n", "+OK\r\n$-1\r\n"},
+	}
+
+	for _, tt := range tests {
+		got, n, err := Convert(nil, []byte(tt.in), tt.protocol)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		if string(got) != tt.want || n != len(tt.in) {
+			t.Errorf("%s: got %q after %d of %d bytes, want %q", tt.name, got, n, len(tt.in), tt.want)
+		}
+	}
+}
+
+// A reply split across writes is held back until the rest of it arrives.
+func TestConvertIncomplete(t *testing.T) {
+	in := "+OK\r\n%1\r\n+a\r\n$5\r\nhel"
+	got, n, err := Convert(nil, []byte(in), RESP2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(got) != "+OK\r\n" || n != len("+OK\r\n") {
+		t.Fatalf("got %q after %d bytes, want only the first reply", got, n)
+	}
+}
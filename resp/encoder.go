@@ -2,6 +2,11 @@ package resp
 
 import (
 	"fmt"
+	"maps"
+	"math"
+	"math/big"
+	"slices"
+	"strconv"
 	"strings"
 )
 
@@ -14,11 +19,94 @@ import (
 //
 // Although, perhaps that shouldn't be the concern of these functions.
 
+// Encodes any message, making it the inverse of Parse. The keys of maps and
+// attributes come out as bulk strings, sorted, so that the same message
+// always encodes the same way.
+func Encode(m *Message) (string, error) {
+	var b strings.Builder
+	if len(m.Attributes) > 0 {
+		pairs, err := encodePairs(m.Attributes)
+		if err != nil {
+			return "", fmt.Errorf("%s attributes: %w", ErrEncodePrefix, err)
+		}
+		b.WriteString(fmt.Sprintf("|%d\r\n%s", len(m.Attributes), pairs))
+	}
+
+	switch m.Type {
+	case Array, Pushes, Sets:
+		if m.Type == Array && m.Length < 0 {
+			b.WriteString(EncodeNullArray())
+			break
+		}
+
+		elems := make([]string, len(m.Array))
+		for i, elem := range m.Array {
+			encoded, err := Encode(elem)
+			if err != nil {
+				return "", fmt.Errorf("%s %s: element %d: %w", ErrEncodePrefix, m.Type.String(), i, err)
+			}
+			elems[i] = encoded
+		}
+
+		switch m.Type {
+		case Pushes:
+			b.WriteString(EncodePush(len(elems), elems...))
+		case Sets:
+			b.WriteString(EncodeSet(len(elems), elems...))
+		default:
+			b.WriteString(EncodeArray(len(elems), elems...))
+		}
+	case BigNumbers:
+		if m.BigNumber == nil {
+			return "", fmt.Errorf("%s big number: missing value", ErrEncodePrefix)
+		}
+		b.WriteString(EncodeBigNumber(m.BigNumber))
+	case Booleans:
+		b.WriteString(EncodeBoolean(m.Boolean))
+	case BulkErrors:
+		b.WriteString(EncodeBulkErr(m.String))
+	case BulkString:
+		if m.Length < 0 {
+			b.WriteString(EncodeNullBulkString())
+			break
+		}
+		b.WriteString(EncodeBulkString(m.String))
+	case Doubles:
+		b.WriteString(EncodeDouble(m.Double))
+	case Integer:
+		b.WriteString(EncodeInteger(m.Integer))
+	case Maps:
+		pairs, err := encodePairs(m.Map)
+		if err != nil {
+			return "", fmt.Errorf("%s map: %w", ErrEncodePrefix, err)
+		}
+		b.WriteString(EncodeMap(len(m.Map), pairs))
+	case Nulls:
+		b.WriteString(EncodeNulls())
+	case SimpleError:
+		// Unlike EncodeSimpleErr, the error code is part of the message
+		b.WriteString(fmt.Sprintf("-%s\r\n", m.String))
+	case SimpleString:
+		b.WriteString(EncodeSimpleString(m.String))
+	case VerbatimString:
+		b.WriteString(EncodeVerbatimString(m.Format, m.String))
+	default:
+		return "", fmt.Errorf("%s unsupported type: %s", ErrEncodePrefix, m.Type.String())
+	}
+
+	return b.String(), nil
+}
+
 func EncodeArray(length int, ss ...string) string {
 	s := strings.Join(ss, "")
 	return fmt.Sprintf("*%d\r\n%s", length, s)
 }
 
+// RESP3 Specific Type
+func EncodeBigNumber(n *big.Int) string {
+	return fmt.Sprintf("(%s\r\n", n.String())
+}
+
 func EncodeBoolean(b bool) string {
 	bS := ""
 	if b {
@@ -29,10 +117,20 @@ func EncodeBoolean(b bool) string {
 	return fmt.Sprintf("#%s\r\n", bS)
 }
 
+// RESP3 Specific Type
+func EncodeBulkErr(s string) string {
+	return fmt.Sprintf("!%d\r\n%s\r\n", len(s), s)
+}
+
 func EncodeBulkString(s string) string {
 	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
 }
 
+// RESP3 Specific Type
+func EncodeDouble(f float64) string {
+	return fmt.Sprintf(",%s\r\n", formatDouble(f))
+}
+
 func EncodeInteger(n int) string {
 	return fmt.Sprintf(":%d\r\n", n)
 }
@@ -54,10 +152,78 @@ func EncodeNulls() string {
 	return fmt.Sprintf("_\r\n")
 }
 
+// RESP3 Specific Type
+func EncodePush(length int, ss ...string) string {
+	s := strings.Join(ss, "")
+	return fmt.Sprintf(">%d\r\n%s", length, s)
+}
+
+// RESP3 Specific Type
+func EncodeSet(length int, ss ...string) string {
+	s := strings.Join(ss, "")
+	return fmt.Sprintf("~%d\r\n%s", length, s)
+}
+
 func EncodeSimpleErr(s string) string {
 	return fmt.Sprintf("-ERR %s\r\n", s)
 }
 
+// Encodes an error whose first word is an error code other than the generic
+// `ERR`, i.e. `-NOGROUP No such consumer group`.
+func EncodeSimpleErrWithCode(code string, s string) string {
+	return fmt.Sprintf("-%s %s\r\n", code, s)
+}
+
+const wrongTypeMsg = "Operation against a key holding the wrong kind of value"
+
+// The error every command replies with when the key it was given holds a
+// value of a different type than the command operates on.
+func EncodeWrongTypeErr() string {
+	return EncodeSimpleErrWithCode("WRONGTYPE", wrongTypeMsg)
+}
+
 func EncodeSimpleString(s string) string {
 	return fmt.Sprintf("+%s\r\n", s)
 }
+
+// RESP3 Specific Type
+// The format is three letters, i.e. `txt` for plain text or `mkd` for
+// markdown.
+func EncodeVerbatimString(format string, s string) string {
+	return fmt.Sprintf("=%d\r\n%s:%s\r\n", len(format)+1+len(s), format, s)
+}
+
+// Formats a double the way RESP3 spells them, which differs from Go for
+// the infinities and NaN. Others are written in decimal notation rather than
+// with an exponent, as Redis does for the likes of 1000000.
+func formatDouble(f float64) string {
+	return string(appendDouble(nil, f))
+}
+
+func appendDouble(dst []byte, f float64) []byte {
+	switch {
+	case math.IsInf(f, 1):
+		return append(dst, "inf"...)
+	case math.IsInf(f, -1):
+		return append(dst, "-inf"...)
+	case math.IsNaN(f):
+		return append(dst, "nan"...)
+	default:
+		return strconv.AppendFloat(dst, f, 'f', -1, 64)
+	}
+}
+
+// Encodes the key/value pairs of a map or attribute, ordered by key.
+func encodePairs(m map[string]*Message) (string, error) {
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(m)) {
+		v, err := Encode(m[k])
+		if err != nil {
+			return "", fmt.Errorf("value of %q: %w", k, err)
+		}
+		b.WriteString(EncodeBulkString(k))
+		b.WriteString(v)
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,160 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Inline commands longer than this are refused, the same limit Redis has.
+const maxInlineLength = 64 * 1024
+
+// Reported by ParseCommand for an inline command whose quotes don't close,
+// and for one that goes on past maxInlineLength without ending.
+var (
+	ErrUnbalancedQuotes = errors.New("unbalanced quotes in request")
+	ErrInlineTooBig     = errors.New("too big inline request")
+)
+
+// Parses the next command sent by a client. That is usually an array of
+// bulk strings, but a line of plain text is accepted too, i.e. `PING` typed
+// into telnet, and split into arguments the way redis-cli does. Blank lines
+// are skipped.
+func ParseCommand(r *bufio.Reader) (*Message, error) {
+	for {
+		firstByte, err := r.Peek(1)
+		if err != nil {
+			return &Message{}, fmt.Errorf("%s first byte: %w", ErrProtocolPrefix, err)
+		}
+
+		if firstByte[0] == '*' {
+			return Parse(r)
+		}
+
+		line, err := readInline(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s inline: %w", ErrParsePrefix, err)
+		}
+
+		args, err := SplitArgs(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s inline: %w", ErrParsePrefix, err)
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		msg := &Message{Type: Array, Length: len(args)}
+		for _, a := range args {
+			msg.Array = append(msg.Array, &Message{Type: BulkString, Length: len(a), String: a})
+		}
+
+		return msg, nil
+	}
+}
+
+// Splits a line into arguments separated by whitespace, which may be quoted
+// to contain whitespace themselves. Double quotes understand the escapes
+// `\n`, `\r`, `\t`, `\b`, `\a` and `\xHH`, while single quotes only `\'`.
+// A closing quote must be followed by whitespace or the end of the line.
+func SplitArgs(line string) ([]string, error) {
+	var args []string
+
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+
+		if i == len(line) {
+			return args, nil
+		}
+
+		var (
+			arg    strings.Builder
+			quote  byte
+			closed bool
+		)
+		for ; i < len(line) && !closed; i++ {
+			c := line[i]
+			switch {
+			case quote == '"' && c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]):
+				b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+				arg.WriteByte(byte(b))
+				i += 3
+			case quote == '"' && c == '\\' && i+1 < len(line):
+				i++
+				switch line[i] {
+				case 'n':
+					arg.WriteByte('\n')
+				case 'r':
+					arg.WriteByte('\r')
+				case 't':
+					arg.WriteByte('\t')
+				case 'b':
+					arg.WriteByte('\b')
+				case 'a':
+					arg.WriteByte('\a')
+				default:
+					arg.WriteByte(line[i])
+				}
+			case quote == '\'' && c == '\\' && i+1 < len(line) && line[i+1] == '\'':
+				arg.WriteByte('\'')
+				i++
+			case quote != 0 && c == quote:
+				if i+1 < len(line) && !isSpace(line[i+1]) {
+					return nil, ErrUnbalancedQuotes
+				}
+				quote = 0
+				closed = true
+			case quote != 0:
+				arg.WriteByte(c)
+			case isSpace(c):
+				closed = true
+			case c == '"' || c == '\'':
+				quote = c
+			default:
+				arg.WriteByte(c)
+			}
+		}
+
+		if quote != 0 {
+			return nil, ErrUnbalancedQuotes
+		}
+
+		args = append(args, arg.String())
+	}
+}
+
+// Reads an inline command's line, without its CRLF.
+func readInline(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxInlineLength {
+			return "", ErrInlineTooBig
+		}
+
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		line = line[:len(line)-1]
+		return strings.TrimSuffix(string(line), "\r"), nil
+	}
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\v' || c == '\f'
+}
@@ -0,0 +1,68 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"PING", []string{"PING"}},
+		{"  SET  key\tvalue  ", []string{"SET", "key", "value"}},
+		{`SET k "hello world"`, []string{"SET", "k", "hello world"}},
+		{`SET k "a\"b\n\x41\x4g"`, []string{"SET", "k", "a\"b\nAx4g"}},
+		{`SET k 'it\'s \n'`, []string{"SET", "k", `it's \n`}},
+		{`SET k ""`, []string{"SET", "k", ""}},
+		{`SET k"ey" v`, []string{"SET", "key", "v"}},
+		{"", nil},
+	}
+
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.line)
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+			continue
+		}
+
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %q, want %q", tt.line, got, tt.want)
+		}
+	}
+
+	for _, line := range []string{`SET k "open`, `SET k 'open`, `SET k "a"b`, `SET k 'a'b`} {
+		if _, err := SplitArgs(line); !errors.Is(err, ErrUnbalancedQuotes) {
+			t.Errorf("%q: got %v, want unbalanced quotes", line, err)
+		}
+	}
+}
+
+// Inline and RESP commands can be mixed on one connection, and blank lines
+// between them are skipped.
+func TestParseCommandInline(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("PING\r\n\r\n*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\nECHO \"a b\"\n"))
+
+	for _, want := range [][]string{{"PING"}, {"ECHO", "hi"}, {"ECHO", "a b"}} {
+		msg, err := ParseCommand(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, m := range msg.Array {
+			if m.Type != BulkString {
+				t.Fatalf("%q: argument is %v, not a bulk string", want, m.Type)
+			}
+			got = append(got, m.String)
+		}
+
+		if msg.Type != Array || !slices.Equal(got, want) {
+			t.Fatalf("got %+v, want %q", msg, want)
+		}
+	}
+}
@@ -4,10 +4,20 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"strconv"
 	"strings"
 )
 
+// Bulk strings longer than this are refused rather than allocated, the same
+// limit Redis's proto-max-bulk-len defaults to.
+const maxBulkLength = 512 * 1024 * 1024
+
+// Aggregates only preallocate up to this many elements, so that a bogus
+// length can't make us allocate more than the message actually holds.
+const maxPreallocLength = 1024
+
 func Parse(r *bufio.Reader) (*Message, error) {
 	firstByte, err := r.ReadByte()
 	if err != nil {
@@ -17,46 +27,56 @@ func Parse(r *bufio.Reader) (*Message, error) {
 	switch firstByte {
 	case '+': // SimpleString
 		return parseSimpleString(r)
+	case '-': // SimpleError
+		return parseSimpleError(r)
+	case ':': // Integer
+		return parseInteger(r)
 	case '$': // BulkString
 		return parseBulkString(r)
 	case '*': // Array
 		return parseArray(r)
-	case '%': // Map
+	case '_': // Nulls
+		return parseNull(r)
+	case '#': // Booleans
+		return parseBoolean(r)
+	case ',': // Doubles
+		return parseDouble(r)
+	case '(': // BigNumbers
+		return parseBigNumber(r)
+	case '!': // BulkErrors
+		return parseBulkError(r)
+	case '=': // VerbatimString
+		return parseVerbatimString(r)
+	case '%': // Maps
 		return parseMap(r)
+	case '|': // Attributes
+		return parseAttribute(r)
+	case '~': // Sets
+		return parseSet(r)
+	case '>': // Pushes
+		return parsePush(r)
 	default:
 		return nil, fmt.Errorf("%s unknown type: %q", ErrProtocolPrefix, firstByte)
 	}
 }
 
 func parseArray(r *bufio.Reader) (*Message, error) {
-	arrLen, err := r.ReadString('\n')
-	if err != nil {
-		return nil, fmt.Errorf("%s array: read length: %w", ErrParsePrefix, err)
-	}
-	arrLen = strings.TrimSpace(arrLen)
-	length, err := strconv.Atoi(arrLen)
+	length, err := readLength(r)
 	if err != nil {
-		return nil, fmt.Errorf("%s array: conv length: %w", ErrParsePrefix, err)
+		return nil, fmt.Errorf("%s array: length: %w", ErrParsePrefix, err)
 	}
 
-	// NOTE: not entirely sure the distinction yet between
-	// a null array and zero-lengthed array from a RESP
-	// perspective.
-	if length <= 0 {
+	// RESP2's null array
+	if length == -1 {
 		return &Message{
 			Type:   Array,
 			Length: length,
 		}, nil
 	}
 
-	arr := make([]*Message, 0, length)
-	for range length {
-		val, err := Parse(r)
-		if err != nil {
-			return nil, fmt.Errorf("%s array: recursion: %w", ErrParsePrefix, err)
-		}
-
-		arr = append(arr, val)
+	arr, err := parseElements(r, length)
+	if err != nil {
+		return nil, fmt.Errorf("%s array: %w", ErrParsePrefix, err)
 	}
 
 	return &Message{
@@ -66,15 +86,73 @@ func parseArray(r *bufio.Reader) (*Message, error) {
 	}, nil
 }
 
-func parseBulkString(r *bufio.Reader) (*Message, error) {
-	strLen, err := r.ReadString('\n')
+// The attributes are extra information about the reply that follows them,
+// which they are attached to.
+func parseAttribute(r *bufio.Reader) (*Message, error) {
+	attrs, err := parsePairs(r)
 	if err != nil {
-		return nil, fmt.Errorf("%s bulk string: length: %w", ErrParsePrefix, err)
+		return nil, fmt.Errorf("%s attribute: %w", ErrParsePrefix, err)
+	}
+
+	msg, err := Parse(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s attribute: recursion: reply: %w", ErrParsePrefix, err)
+	}
+
+	msg.Attributes = attrs
+	return msg, nil
+}
+
+func parseBigNumber(r *bufio.Reader) (*Message, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s big number: read: %w", ErrParsePrefix, err)
+	}
+
+	n, ok := new(big.Int).SetString(line, 10)
+	if !ok {
+		return nil, fmt.Errorf("%s big number: invalid: %q", ErrParsePrefix, line)
 	}
-	strLen = strings.TrimSpace(strLen)
-	length, err := strconv.Atoi(strLen)
+
+	return &Message{
+		Type:      BigNumbers,
+		BigNumber: n,
+	}, nil
+}
+
+func parseBoolean(r *bufio.Reader) (*Message, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s boolean: read: %w", ErrParsePrefix, err)
+	}
+
+	switch line {
+	case "t":
+		return &Message{Type: Booleans, Boolean: true}, nil
+	case "f":
+		return &Message{Type: Booleans, Boolean: false}, nil
+	default:
+		return nil, fmt.Errorf("%s boolean: invalid: %q", ErrParsePrefix, line)
+	}
+}
+
+func parseBulkError(r *bufio.Reader) (*Message, error) {
+	data, err := readBlob(r)
 	if err != nil {
-		return nil, fmt.Errorf("%s bulk string: length to int: %w", ErrParsePrefix, err)
+		return nil, fmt.Errorf("%s bulk error: %w", ErrParsePrefix, err)
+	}
+
+	return &Message{
+		Type:   BulkErrors,
+		Length: len(data),
+		String: data,
+	}, nil
+}
+
+func parseBulkString(r *bufio.Reader) (*Message, error) {
+	length, err := readLength(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s bulk string: length: %w", ErrParsePrefix, err)
 	}
 
 	// For RESP2 Compatibility
@@ -87,78 +165,293 @@ func parseBulkString(r *bufio.Reader) (*Message, error) {
 		}, nil
 	}
 
-	data := make([]byte, length)
-	_, err = io.ReadFull(r, data)
+	data, err := readBlobData(r, length)
 	if err != nil {
-		return nil, fmt.Errorf("%s bulk string: read full: %w", ErrParsePrefix, err)
+		return nil, fmt.Errorf("%s bulk string: %w", ErrParsePrefix, err)
 	}
 
-	// Consume trailing CRLF so subsequent connection commands start "clean"
-	r.ReadString('\n')
-
 	return &Message{
 		Type:   BulkString,
 		Length: length,
-		String: string(data),
+		String: data,
+	}, nil
+}
+
+func parseDouble(r *bufio.Reader) (*Message, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s double: read: %w", ErrParsePrefix, err)
+	}
+
+	var f float64
+	switch line {
+	case "inf":
+		f = math.Inf(1)
+	case "-inf":
+		f = math.Inf(-1)
+	case "nan":
+		f = math.NaN()
+	default:
+		f, err = strconv.ParseFloat(line, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s double: conv: %w", ErrParsePrefix, err)
+		}
+	}
+
+	return &Message{
+		Type:   Doubles,
+		Double: f,
+	}, nil
+}
+
+func parseInteger(r *bufio.Reader) (*Message, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s integer: read: %w", ErrParsePrefix, err)
+	}
+
+	n, err := strconv.Atoi(line)
+	if err != nil {
+		return nil, fmt.Errorf("%s integer: conv: %w", ErrParsePrefix, err)
+	}
+
+	return &Message{
+		Type:    Integer,
+		Integer: n,
 	}, nil
 }
 
 func parseMap(r *bufio.Reader) (*Message, error) {
-	mLen, err := r.ReadString('\n')
+	m, err := parsePairs(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s map: %w", ErrParsePrefix, err)
+	}
+
+	// Keys given more than once only count once
+	return &Message{
+		Type:   Maps,
+		Map:    m,
+		Length: len(m),
+	}, nil
+}
+
+func parseNull(r *bufio.Reader) (*Message, error) {
+	line, err := readLine(r)
 	if err != nil {
-		return nil, fmt.Errorf("%s map: read length: %w", ErrParsePrefix, err)
+		return nil, fmt.Errorf("%s null: read: %w", ErrParsePrefix, err)
+	}
+
+	if line != "" {
+		return nil, fmt.Errorf("%s null: unexpected data: %q", ErrParsePrefix, line)
 	}
 
-	mLen = strings.TrimSpace(mLen)
-	length, err := strconv.Atoi(mLen)
+	return &Message{Type: Nulls}, nil
+}
+
+func parsePush(r *bufio.Reader) (*Message, error) {
+	length, err := readLength(r)
 	if err != nil {
-		return nil, fmt.Errorf("%s map: conv length: %w", ErrParsePrefix, err)
+		return nil, fmt.Errorf("%s push: length: %w", ErrParsePrefix, err)
 	}
 
-	// NOTE: not entirely sure if this is the appropriate way to handle
-	// a "zero" length'd map.
-	if length <= 0 {
-		return &Message{
-			Type:   Maps,
-			Length: length,
-		}, nil
+	arr, err := parseElements(r, length)
+	if err != nil {
+		return nil, fmt.Errorf("%s push: %w", ErrParsePrefix, err)
+	}
+
+	return &Message{
+		Type:   Pushes,
+		Array:  arr,
+		Length: length,
+	}, nil
+}
+
+func parseSet(r *bufio.Reader) (*Message, error) {
+	length, err := readLength(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s set: length: %w", ErrParsePrefix, err)
 	}
 
-	m := make(map[string]*Message)
+	arr, err := parseElements(r, length)
+	if err != nil {
+		return nil, fmt.Errorf("%s set: %w", ErrParsePrefix, err)
+	}
+
+	return &Message{
+		Type:   Sets,
+		Array:  arr,
+		Length: length,
+	}, nil
+}
+
+func parseSimpleError(r *bufio.Reader) (*Message, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s simple error: read: %w", ErrParsePrefix, err)
+	}
+
+	return &Message{
+		Type:   SimpleError,
+		String: line,
+	}, nil
+}
+
+func parseSimpleString(r *bufio.Reader) (*Message, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s simple string: read: %w", ErrParsePrefix, err)
+	}
+
+	return &Message{
+		Type:   SimpleString,
+		String: line,
+	}, nil
+}
+
+// Verbatim strings start with a three letter format, i.e. `txt` or `mkd`,
+// and a colon, neither of which is part of the string itself.
+func parseVerbatimString(r *bufio.Reader) (*Message, error) {
+	data, err := readBlob(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s verbatim string: %w", ErrParsePrefix, err)
+	}
+
+	if len(data) < 4 || data[3] != ':' {
+		return nil, fmt.Errorf("%s verbatim string: missing format: %q", ErrParsePrefix, data)
+	}
+
+	return &Message{
+		Type:   VerbatimString,
+		Format: data[:3],
+		Length: len(data) - 4,
+		String: data[4:],
+	}, nil
+}
+
+// Parses the elements of an array, set or push.
+func parseElements(r *bufio.Reader, length int) ([]*Message, error) {
+	if length < 0 {
+		return nil, fmt.Errorf("negative length: %d", length)
+	}
+
+	if length == 0 {
+		return nil, nil
+	}
+
+	arr := make([]*Message, 0, min(length, maxPreallocLength))
+	for range length {
+		val, err := Parse(r)
+		if err != nil {
+			return nil, fmt.Errorf("recursion: %w", err)
+		}
+
+		arr = append(arr, val)
+	}
+
+	return arr, nil
+}
+
+// Parses the length and key/value pairs of a map or attribute.
+func parsePairs(r *bufio.Reader) (map[string]*Message, error) {
+	length, err := readLength(r)
+	if err != nil {
+		return nil, fmt.Errorf("length: %w", err)
+	}
+
+	if length < 0 {
+		return nil, fmt.Errorf("negative length: %d", length)
+	}
+
+	if length == 0 {
+		return nil, nil
+	}
+
+	m := make(map[string]*Message, min(length, maxPreallocLength))
 	for range length {
-		// QUESTION: Will this work for extracting the appropriate key:value pair?
 		keyMsg, err := Parse(r)
 		if err != nil {
-			return nil, fmt.Errorf("%s map: recursion: key: %w", ErrParsePrefix, err)
+			return nil, fmt.Errorf("recursion: key: %w", err)
 		}
 
 		valMsg, err := Parse(r)
 		if err != nil {
-			return nil, fmt.Errorf("%s map: recursion: value: %w", ErrParsePrefix, err)
+			return nil, fmt.Errorf("recursion: value: %w", err)
 		}
 
 		key, err := keyMsg.SerializeKey()
 		if err != nil {
-			return nil, fmt.Errorf("%s map: serialize key: %w", ErrParsePrefix, err)
+			return nil, fmt.Errorf("serialize key: %w", err)
 		}
 
 		m[key] = valMsg
 	}
 
-	return &Message{
-		Type:   Maps,
-		Map:    m,
-		Length: length,
-	}, nil
+	return m, nil
 }
 
-func parseSimpleString(r *bufio.Reader) (*Message, error) {
+// Reads the length and data of a bulk error or verbatim string, neither of
+// which has a null form.
+func readBlob(r *bufio.Reader) (string, error) {
+	length, err := readLength(r)
+	if err != nil {
+		return "", fmt.Errorf("length: %w", err)
+	}
+
+	return readBlobData(r, length)
+}
+
+// Reads `length` bytes and the CRLF terminating them.
+func readBlobData(r *bufio.Reader, length int) (string, error) {
+	if length < 0 || length > maxBulkLength {
+		return "", fmt.Errorf("invalid length: %d", length)
+	}
+
+	data := make([]byte, length)
+	_, err := io.ReadFull(r, data)
+	if err != nil {
+		return "", fmt.Errorf("read full: %w", err)
+	}
+
+	// Consume trailing CRLF so subsequent connection commands start "clean"
+	rest, err := readLine(r)
+	if err != nil {
+		return "", fmt.Errorf("terminator: %w", err)
+	}
+
+	if rest != "" {
+		return "", fmt.Errorf("unexpected data after %d bytes: %q", length, rest)
+	}
+
+	return string(data), nil
+}
+
+// Reads and converts the length line of a bulk or aggregate type.
+func readLength(r *bufio.Reader) (int, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return 0, fmt.Errorf("read: %w", err)
+	}
+
+	length, err := strconv.Atoi(line)
+	if err != nil {
+		return 0, fmt.Errorf("conv: %w", err)
+	}
+
+	if length < -1 {
+		return 0, fmt.Errorf("invalid length: %d", length)
+	}
+
+	return length, nil
+}
+
+// Reads up to the end of the line, returning it without its CRLF. A bare LF
+// is accepted as well.
+func readLine(r *bufio.Reader) (string, error) {
 	line, err := r.ReadString('\n')
 	if err != nil {
-		return nil, fmt.Errorf("%s simple string: read: %w", ErrParsePrefix, err)
+		return "", err
 	}
-	return &Message{
-		Type:   SimpleString,
-		String: strings.TrimSpace(line),
-	}, nil
+
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
 }
@@ -0,0 +1,154 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func parseString(t *testing.T, s string) *Message {
+	t.Helper()
+
+	msg, err := Parse(bufio.NewReader(strings.NewReader(s)))
+	if err != nil {
+		t.Fatalf("parse %q: %v", s, err)
+	}
+
+	return msg
+}
+
+func TestParseRESP3Types(t *testing.T) {
+	if m := parseString(t, ":-42\r\n"); m.Type != Integer || m.Integer != -42 {
+		t.Errorf("integer: got %+v", m)
+	}
+
+	if m := parseString(t, "-WRONGTYPE Operation against a key\r\n"); m.Type != SimpleError || m.String != "WRONGTYPE Operation against a key" {
+		t.Errorf("simple error: got %+v", m)
+	}
+
+	if m := parseString(t, "!21\r\nSYNTAX invalid syntax\r\n"); m.Type != BulkErrors || m.String != "SYNTAX invalid syntax" {
+		t.Errorf("bulk error: got %+v", m)
+	}
+
+	if m := parseString(t, "_\r\n"); m.Type != Nulls {
+		t.Errorf("null: got %+v", m)
+	}
+
+	if m := parseString(t, "#f\r\n"); m.Type != Booleans || m.Boolean {
+		t.Errorf("boolean: got %+v", m)
+	}
+
+	for raw, want := range map[string]float64{"1.5": 1.5, "10": 10, "-inf": math.Inf(-1), "1.23e-4": 1.23e-4} {
+		if m := parseString(t, ","+raw+"\r\n"); m.Type != Doubles || m.Double != want {
+			t.Errorf("double %s: got %+v", raw, m)
+		}
+	}
+
+	if m := parseString(t, ",nan\r\n"); !math.IsNaN(m.Double) {
+		t.Errorf("nan: got %+v", m)
+	}
+
+	want, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+	if m := parseString(t, "(3492890328409238509324850943850943825024385\r\n"); m.Type != BigNumbers || m.BigNumber.Cmp(want) != 0 {
+		t.Errorf("big number: got %+v", m)
+	}
+
+	if m := parseString(t, "=15\r\ntxt:Some string\r\n"); m.Type != VerbatimString || m.Format != "txt" || m.String != "Some string" {
+		t.Errorf("verbatim string: got %+v", m)
+	}
+
+	if m := parseString(t, "~2\r\n+a\r\n:1\r\n"); m.Type != Sets || len(m.Array) != 2 || m.Array[1].Integer != 1 {
+		t.Errorf("set: got %+v", m)
+	}
+
+	if m := parseString(t, ">2\r\n+message\r\n$4\r\nnews\r\n"); m.Type != Pushes || len(m.Array) != 2 || m.Array[1].String != "news" {
+		t.Errorf("push: got %+v", m)
+	}
+
+	m := parseString(t, "|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.19\r\n*1\r\n:2\r\n")
+	if m.Type != Array || len(m.Array) != 1 || m.Attributes["key-popularity"].Map["a"].Double != 0.19 {
+		t.Errorf("attribute: got %+v", m)
+	}
+}
+
+func TestParseRejectsMalformed(t *testing.T) {
+	for _, raw := range []string{
+		"#x\r\n",
+		"_foo\r\n",
+		",abc\r\n",
+		"(1.5\r\n",
+		"=3\r\ntxt\r\n",
+		"$3\r\nabcdef\r\n",
+		"*-2\r\n",
+		"~-1\r\n",
+		":\r\n",
+		"?\r\n",
+	} {
+		if _, err := Parse(bufio.NewReader(strings.NewReader(raw))); err == nil {
+			t.Errorf("%q parsed without an error", raw)
+		}
+	}
+}
+
+// Whatever parses must encode into something that parses right back into
+// the same message, so encoding it again gives the exact same bytes.
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"+OK\r\n",
+		"-ERR unknown command\r\n",
+		":1000\r\n",
+		"$5\r\nhello\r\n",
+		"$-1\r\n",
+		"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n",
+		"*-1\r\n",
+		"_\r\n",
+		"#t\r\n",
+		",3.14\r\n",
+		",inf\r\n",
+		"(3492890328409238509324850943850943825024385\r\n",
+		"!21\r\nSYNTAX invalid syntax\r\n",
+		"=15\r\ntxt:Some string\r\n",
+		"%2\r\n+first\r\n:1\r\n+second\r\n:2\r\n",
+		"~3\r\n+a\r\n+b\r\n+c\r\n",
+		">2\r\n+pubsub\r\n+message\r\n",
+		"|1\r\n+ttl\r\n:3600\r\n$5\r\nvalue\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, err := Parse(bufio.NewReader(bytes.NewReader(data)))
+		if err != nil {
+			return
+		}
+
+		encoded, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("encode %+v: %v", msg, err)
+		}
+
+		r := bufio.NewReader(strings.NewReader(encoded))
+		again, err := Parse(r)
+		if err != nil {
+			t.Fatalf("parse %q: %v", encoded, err)
+		}
+
+		if _, err := r.ReadByte(); !errors.Is(err, io.EOF) {
+			t.Fatalf("parse %q: left data unread", encoded)
+		}
+
+		reencoded, err := Encode(again)
+		if err != nil {
+			t.Fatalf("encode %+v: %v", again, err)
+		}
+
+		if reencoded != encoded {
+			t.Fatalf("round trip changed %q into %q", encoded, reencoded)
+		}
+	})
+}
@@ -2,6 +2,7 @@ package resp
 
 import (
 	"fmt"
+	"math/big"
 	"strconv"
 	"strings"
 )
@@ -19,46 +20,66 @@ type RESPType uint
 
 const (
 	Array RESPType = iota
+	BigNumbers
 	Booleans
 	BulkErrors
 	BulkString
+	Doubles
 	Integer
 	Maps
 	Nulls
+	Pushes
 	Sets
 	SimpleError
 	SimpleString
+	VerbatimString
 )
 
 func (t RESPType) String() string {
 	switch t {
 	case Array:
 		return "Array"
+	case BigNumbers:
+		return "BigNumbers"
 	case Booleans:
 		return "Booleans"
+	case BulkErrors:
+		return "BulkErrors"
 	case BulkString:
 		return "BulkString"
+	case Doubles:
+		return "Doubles"
 	case Integer:
 		return "Integer"
 	case Maps:
 		return "Maps"
 	case Nulls:
 		return "Nulls"
+	case Pushes:
+		return "Pushes"
 	case Sets:
 		return "Sets"
 	case SimpleError:
 		return "SimpleError"
 	case SimpleString:
 		return "SimpleString"
+	case VerbatimString:
+		return "VerbatimString"
 	default:
 		return fmt.Sprintf("UnknownType(%d)", t)
 	}
 }
 
 type Message struct {
-	Type    RESPType
-	Array   []*Message
-	Boolean bool
+	Type  RESPType
+	Array []*Message
+	// Attached to the reply by an attribute (`|`) preceding it
+	Attributes map[string]*Message
+	BigNumber  *big.Int
+	Boolean    bool
+	Double     float64
+	// The three letter format of a verbatim string, i.e. `txt`
+	Format  string
 	Integer int
 	Length  int
 	Map     map[string]*Message // <-- complex keys are serialized to strings
@@ -97,7 +118,11 @@ func (m *Message) SerializeKey() (string, error) {
 		return fmt.Sprintf("int:%d", m.Integer), nil
 	case Booleans:
 		return fmt.Sprintf("bool:%t", m.Boolean), nil
-	case BulkString, SimpleString:
+	case BigNumbers:
+		return fmt.Sprintf("bignum:%s", m.BigNumber), nil
+	case Doubles:
+		return fmt.Sprintf("double:%s", formatDouble(m.Double)), nil
+	case BulkString, SimpleString, VerbatimString:
 		return m.String, nil
 	case Nulls:
 		return "null", nil
@@ -0,0 +1,221 @@
+package resp
+
+import (
+	"io"
+	"math/big"
+	"strconv"
+)
+
+// A buffer that has grown past this to fit a large batch of replies is let
+// go of once flushed, rather than kept for the life of the connection.
+const maxRetainedBuffer = 64 * 1024
+
+// Writes replies to a buffer in memory rather than building strings for
+// them, so a reply costs no allocations once the buffer has grown to fit
+// and a batch of them a single write once flushed. Nothing reaches the
+// underlying writer before Flush, which makes it safe to write replies while
+// holding a lock. Replies are written for the client's protocol: RESP3-only
+// types are downgraded for RESP2 clients the same way Convert does.
+type Writer struct {
+	Protocol int
+
+	dst io.Writer
+	buf []byte
+	// Scratch space for formatting numbers, and the lengths of those that
+	// are written as bulk strings
+	num    []byte
+	length []byte
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{
+		Protocol: RESP3,
+		dst:      w,
+		num:      make([]byte, 0, 32),
+		length:   make([]byte, 0, 20),
+	}
+}
+
+// How many bytes are waiting to be flushed.
+func (w *Writer) Buffered() int {
+	return len(w.buf)
+}
+
+func (w *Writer) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	_, err := w.dst.Write(w.buf)
+	if cap(w.buf) > maxRetainedBuffer {
+		w.buf = nil
+	} else {
+		w.buf = w.buf[:0]
+	}
+
+	return err
+}
+
+// Writes `p` as is, for replies that are already encoded for the writer's
+// protocol.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	return len(p), nil
+}
+
+func (w *Writer) WriteArrayHeader(n int) {
+	w.writeLength('*', n)
+}
+
+// RESP3 Specific Type
+func (w *Writer) WriteBigNumber(n *big.Int) {
+	w.num = n.Append(w.num[:0], 10)
+	if w.Protocol == RESP2 {
+		w.writeBlob('$', w.num)
+		return
+	}
+	w.writeLine('(', w.num)
+}
+
+// RESP3 Specific Type
+func (w *Writer) WriteBool(b bool) {
+	switch {
+	case w.Protocol == RESP2 && b:
+		w.buf = append(w.buf, ":1\r\n"...)
+	case w.Protocol == RESP2:
+		w.buf = append(w.buf, ":0\r\n"...)
+	case b:
+		w.buf = append(w.buf, "#t\r\n"...)
+	default:
+		w.buf = append(w.buf, "#f\r\n"...)
+	}
+}
+
+func (w *Writer) WriteBulk(b []byte) {
+	w.writeLength('$', len(b))
+	w.buf = append(w.buf, b...)
+	w.buf = append(w.buf, "\r\n"...)
+}
+
+func (w *Writer) WriteBulkString(s string) {
+	w.writeLength('$', len(s))
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, "\r\n"...)
+}
+
+// RESP3 Specific Type
+func (w *Writer) WriteDouble(f float64) {
+	w.num = appendDouble(w.num[:0], f)
+	if w.Protocol == RESP2 {
+		w.writeBlob('$', w.num)
+		return
+	}
+	w.writeLine(',', w.num)
+}
+
+// Writes an error whose message starts with its code, i.e. `ERR syntax
+// error` or `WRONGTYPE Operation against a key...`.
+func (w *Writer) WriteError(s string) {
+	w.buf = append(w.buf, '-')
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, "\r\n"...)
+}
+
+func (w *Writer) WriteInt(n int64) {
+	w.num = strconv.AppendInt(w.num[:0], n, 10)
+	w.writeLine(':', w.num)
+}
+
+// RESP3 Specific Type
+// Followed by `n` keys, each followed by its value. RESP2 clients get them
+// as a flat array instead.
+func (w *Writer) WriteMapHeader(n int) {
+	if w.Protocol == RESP2 {
+		w.writeLength('*', n*2)
+		return
+	}
+	w.writeLength('%', n)
+}
+
+// Writes the null a missing value is replied with, which is a null bulk
+// string for RESP2 clients.
+func (w *Writer) WriteNull() {
+	if w.Protocol == RESP2 {
+		w.buf = append(w.buf, "$-1\r\n"...)
+		return
+	}
+	w.buf = append(w.buf, "_\r\n"...)
+}
+
+// Writes the null a missing array is replied with, which is a null array
+// for RESP2 clients.
+func (w *Writer) WriteNullArray() {
+	if w.Protocol == RESP2 {
+		w.buf = append(w.buf, "*-1\r\n"...)
+		return
+	}
+	w.buf = append(w.buf, "_\r\n"...)
+}
+
+// RESP3 Specific Type
+func (w *Writer) WritePushHeader(n int) {
+	if w.Protocol == RESP2 {
+		w.writeLength('*', n)
+		return
+	}
+	w.writeLength('>', n)
+}
+
+// RESP3 Specific Type
+func (w *Writer) WriteSetHeader(n int) {
+	if w.Protocol == RESP2 {
+		w.writeLength('*', n)
+		return
+	}
+	w.writeLength('~', n)
+}
+
+func (w *Writer) WriteSimpleString(s string) {
+	w.buf = append(w.buf, '+')
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, "\r\n"...)
+}
+
+// RESP3 Specific Type
+// The format is three letters, i.e. `txt` for plain text or `mkd` for
+// markdown. RESP2 clients get a bulk string without it.
+func (w *Writer) WriteVerbatimString(format string, s string) {
+	if w.Protocol == RESP2 {
+		w.WriteBulkString(s)
+		return
+	}
+
+	w.writeLength('=', len(format)+1+len(s))
+	w.buf = append(w.buf, format...)
+	w.buf = append(w.buf, ':')
+	w.buf = append(w.buf, s...)
+	w.buf = append(w.buf, "\r\n"...)
+}
+
+// Writes the error replied with when a key holds a different type of value
+// than the command works on, same as EncodeWrongTypeErr.
+func (w *Writer) WriteWrongTypeErr() {
+	w.WriteError("WRONGTYPE " + wrongTypeMsg)
+}
+
+func (w *Writer) writeBlob(typ byte, b []byte) {
+	w.writeLength(typ, len(b))
+	w.buf = append(w.buf, b...)
+	w.buf = append(w.buf, "\r\n"...)
+}
+
+func (w *Writer) writeLength(typ byte, n int) {
+	w.length = strconv.AppendInt(w.length[:0], int64(n), 10)
+	w.writeLine(typ, w.length)
+}
+
+func (w *Writer) writeLine(typ byte, b []byte) {
+	w.buf = append(w.buf, typ)
+	w.buf = append(w.buf, b...)
+	w.buf = append(w.buf, "\r\n"...)
+}
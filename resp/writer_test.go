@@ -0,0 +1,179 @@
+package resp
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"testing"
+)
+
+// The writer must write what the encoders do for RESP3 clients, and what
+// Convert makes of that for RESP2 ones.
+func TestWriterMatchesEncoders(t *testing.T) {
+	n, _ := new(big.Int).SetString("12345678901234567890", 10)
+	tests := []struct {
+		name    string
+		write   func(w *Writer)
+		encoded string
+	}{
+		{"array", func(w *Writer) { w.WriteArrayHeader(2); w.WriteBulkString("a"); w.WriteBulk([]byte("bc")) }, EncodeArray(2, EncodeBulkString("a"), EncodeBulkString("bc"))},
+		{"big number", func(w *Writer) { w.WriteBigNumber(n) }, EncodeBigNumber(n)},
+		{"booleans", func(w *Writer) { w.WriteBool(true); w.WriteBool(false) }, EncodeBoolean(true) + EncodeBoolean(false)},
+		{"double", func(w *Writer) { w.WriteDouble(1.5); w.WriteDouble(math.Inf(-1)) }, EncodeDouble(1.5) + EncodeDouble(math.Inf(-1))},
+		{"error", func(w *Writer) { w.WriteError("ERR syntax error") }, EncodeSimpleErr("syntax error")},
+		{"integer", func(w *Writer) { w.WriteInt(-42) }, EncodeInteger(-42)},
+		{"map", func(w *Writer) { w.WriteMapHeader(1); w.WriteBulkString("k"); w.WriteInt(1) }, EncodeMap(1, EncodeBulkString("k")+EncodeInteger(1))},
+		{"nulls", func(w *Writer) { w.WriteNull(); w.WriteNullArray() }, EncodeNulls() + EncodeNullArray()},
+		{"push", func(w *Writer) { w.WritePushHeader(1); w.WriteSimpleString("message") }, EncodePush(1, EncodeSimpleString("message"))},
+		{"set", func(w *Writer) { w.WriteSetHeader(1); w.WriteBulkString("m") }, EncodeSet(1, EncodeBulkString("m"))},
+		{"wrong type", func(w *Writer) { w.WriteWrongTypeErr() }, EncodeWrongTypeErr()},
+		{"verbatim string", func(w *Writer) { w.WriteVerbatimString("txt", "hi") }, EncodeVerbatimString("txt", "hi")},
+	}
+
+	for _, tt := range tests {
+		for _, protocol := range []int{RESP2, RESP3} {
+			var buf bytes.Buffer
+			w := NewWriter(&buf)
+			w.Protocol = protocol
+			tt.write(w)
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			want, _, err := Convert(nil, []byte(tt.encoded), protocol)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if buf.String() != string(want) {
+				t.Errorf("%s on RESP%d: got %q, want %q", tt.name, protocol, buf.String(), want)
+			}
+		}
+	}
+}
+
+// Counts the writes that reach it.
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.writes++
+	return c.Buffer.Write(p)
+}
+
+// Replies stay in memory, however many there are, until they're flushed in
+// a single write.
+func TestWriterOnlyWritesOnFlush(t *testing.T) {
+	var dst countingWriter
+	w := NewWriter(&dst)
+
+	for range 10_000 {
+		w.WriteBulkString("value")
+	}
+	if dst.writes != 0 {
+		t.Fatalf("%d writes before flushing", dst.writes)
+	}
+
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if dst.writes != 1 || dst.Len() != 10_000*len("$5\r\nvalue\r\n") || w.Buffered() != 0 {
+		t.Fatalf("flushing took %d writes for %d bytes, leaving %d buffered", dst.writes, dst.Len(), w.Buffered())
+	}
+
+	// Nothing to flush means nothing to write
+	w.Flush()
+	if dst.writes != 1 {
+		t.Fatalf("flushing an empty writer wrote to the connection")
+	}
+}
+
+var benchValues = func() []string {
+	vals := make([]string, 10)
+	for i := range vals {
+		vals[i] = "value:" + strconv.Itoa(i*1000)
+	}
+	return vals
+}()
+
+func BenchmarkEncodeBulkString(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		io.Discard.Write([]byte(EncodeBulkString(benchValues[0])))
+	}
+}
+
+func BenchmarkWriterBulkString(b *testing.B) {
+	b.ReportAllocs()
+	w := NewWriter(io.Discard)
+	for b.Loop() {
+		w.WriteBulkString(benchValues[0])
+		w.Flush()
+	}
+}
+
+func BenchmarkEncodeInteger(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		io.Discard.Write([]byte(EncodeInteger(123456)))
+	}
+}
+
+func BenchmarkWriterInteger(b *testing.B) {
+	b.ReportAllocs()
+	w := NewWriter(io.Discard)
+	for b.Loop() {
+		w.WriteInt(123456)
+		w.Flush()
+	}
+}
+
+// An LRANGE-like reply of ten bulk strings.
+func BenchmarkEncodeArray(b *testing.B) {
+	b.ReportAllocs()
+	elems := make([]string, len(benchValues))
+	for b.Loop() {
+		for i, v := range benchValues {
+			elems[i] = EncodeBulkString(v)
+		}
+		io.Discard.Write([]byte(EncodeArray(len(elems), elems...)))
+	}
+}
+
+func BenchmarkWriterArray(b *testing.B) {
+	b.ReportAllocs()
+	w := NewWriter(io.Discard)
+	for b.Loop() {
+		w.WriteArrayHeader(len(benchValues))
+		for _, v := range benchValues {
+			w.WriteBulkString(v)
+		}
+		w.Flush()
+	}
+}
+
+// Sixteen pipelined replies, written one at a time against buffered and
+// flushed once.
+func BenchmarkEncodePipeline(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		for range 16 {
+			io.Discard.Write([]byte(EncodeSimpleString("OK")))
+		}
+	}
+}
+
+func BenchmarkWriterPipeline(b *testing.B) {
+	b.ReportAllocs()
+	w := NewWriter(io.Discard)
+	for b.Loop() {
+		for range 16 {
+			w.WriteSimpleString("OK")
+		}
+		w.Flush()
+	}
+}
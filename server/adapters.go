@@ -2,6 +2,10 @@ package server
 
 import (
 	"fmt"
+	"log"
+	"math"
+	"math/big"
+	"strconv"
 	"strings"
 	"time"
 
@@ -25,21 +29,34 @@ func fromRDB(e *rdb.Entry) (*store.Record, error) {
 			return nil, fmt.Errorf("%s from rdb: case list: %w", ErrAdaptPrefix, err)
 		}
 		sR.Type = store.ArrayType
-		sR.Array = list
-	case rdb.SetEncoded, rdb.SortedSetEncoded:
-		set, err := fromRDPArrayToStoreArray(e)
+		sR.List = store.NewList(list...)
+	case rdb.SortedSetEncoded:
+		zset, err := fromRDBToStoreSortedSet(e)
+		if err != nil {
+			return nil, fmt.Errorf("%s from rdb: case sorted set: %w", ErrAdaptPrefix, err)
+		}
+		sR.Type = store.SortedSetType
+		sR.SortedSet = zset
+	case rdb.SetEncoded:
+		members, err := fromRDPArrayToStoreArray(e)
 		if err != nil {
 			return nil, fmt.Errorf("%s from rdb: case set: %w", ErrAdaptPrefix, err)
 		}
+		set := store.NewSet()
+		for _, m := range members {
+			set.Add(m.String)
+		}
 		sR.Type = store.SetType
-		sR.Array = set
+		sR.Set = set
 	case rdb.HashEncoded:
 		sM, err := fromRDBMapToStoreMap(e)
 		if err != nil {
 			return nil, fmt.Errorf("%s from rdb: case hash map: %w", ErrAdaptPrefix, err)
 		}
 		sR.Type = store.MapType
-		sR.Map = sM
+		for field, v := range sM {
+			sR.SetField(field, v)
+		}
 	default:
 		return nil, fmt.Errorf("%s unsupported rdb type (%s) for entry: %+v", ErrAdaptPrefix, e.ValType.String(), e)
 	}
@@ -65,6 +82,31 @@ func fromRDPArrayToStoreArray(e *rdb.Entry) ([]*store.Record, error) {
 	return storeArr, nil
 }
 
+// Sorted set entries come through as alternating member and score entries,
+// the order RDB serializes them in.
+func fromRDBToStoreSortedSet(e *rdb.Entry) (*store.SortedSet, error) {
+	pairs, err := fromRDPArrayToStoreArray(e)
+	if err != nil {
+		return nil, fmt.Errorf("%s from rdb: sorted set: %w", ErrAdaptPrefix, err)
+	}
+
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("%s from rdb: sorted set: odd number of member/score entries (%d)", ErrAdaptPrefix, len(pairs))
+	}
+
+	zset := store.NewSortedSet()
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(pairs[i+1].String, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s from rdb: sorted set: score of (%s): %w", ErrAdaptPrefix, pairs[i].String, err)
+		}
+
+		zset.Add(pairs[i].String, score, nil)
+	}
+
+	return zset, nil
+}
+
 func fromRDBMapToStoreMap(e *rdb.Entry) (map[string]*store.Record, error) {
 	if e.ValType != rdb.HashEncoded {
 		return nil, fmt.Errorf("%s trying to adapt from RDB (Map) but got (%s)", ErrAdaptPrefix, e.ValType.String())
@@ -99,7 +141,7 @@ func fromRESP(m *resp.Message, expiry time.Time) (*store.Record, error) {
 			return nil, fmt.Errorf("%s from resp: case array: %w", ErrAdaptPrefix, err)
 		}
 		sR.Type = store.ArrayType
-		sR.Array = rS
+		sR.List = store.NewList(rS...)
 	case resp.Booleans:
 		sR.Type = store.BooleanType
 		sR.Boolean = m.Boolean
@@ -115,16 +157,22 @@ func fromRESP(m *resp.Message, expiry time.Time) (*store.Record, error) {
 			return nil, fmt.Errorf("%s from resp: case map: %w", ErrAdaptPrefix, err)
 		}
 		sR.Type = store.MapType
-		sR.Map = sM
+		for field, v := range sM {
+			sR.SetField(field, v)
+		}
 	case resp.Nulls:
 		sR.Type = store.NilType
 	case resp.Sets:
-		rS, err := fromRESPArrayToStoreArray(m, expiry)
-		if err != nil {
-			return nil, fmt.Errorf("%s from resp: case set: %w", ErrAdaptPrefix, err)
+		set := store.NewSet()
+		for _, v := range m.Array {
+			member, err := v.ConvStr()
+			if err != nil {
+				return nil, fmt.Errorf("%s from resp: case set: %w", ErrAdaptPrefix, err)
+			}
+			set.Add(member)
 		}
 		sR.Type = store.SetType
-		sR.Array = rS
+		sR.Set = set
 	default:
 		return nil, fmt.Errorf("%s unsupported resp type (%s) for message: %+v", ErrAdaptPrefix, m.Type.String(), m)
 	}
@@ -183,71 +231,206 @@ func NormalizeIndex(idx int, length int) int {
 	return idx
 }
 
-// NOTE: Might have this simply output a single string that is already
-// encoded as an Array. I'm not sure if this function will be used for
-// any other scenario.
-//
-// Currently it outputs a slice of RESP strings, but not in itself
-// a RESP string, so the output of this function still needs to be
-// encoded before reaching the client.
-func toBulkRESPString(r []*store.Record) ([]string, error) {
-	ss := make([]string, len(r))
-	for i, v := range r {
-		s, err := toRESPString(v)
-		if err != nil {
-			return nil, fmt.Errorf("%s bulk to resp string: %w", ErrAdaptPrefix, err)
-		}
+// Formats INCRBYFLOAT results the way Redis does with its long doubles: a
+// fixed 17 decimals with the trailing zeros trimmed, so 10.1 + 0.2 comes out
+// as 10.3 rather than float64's 10.299999999999999.
+func formatLongDouble(f *big.Float) string {
+	s := f.Text('f', 17)
+	s = strings.TrimRight(s, "0")
+	s = strings.TrimSuffix(s, ".")
+	if s == "-0" {
+		return "0"
+	}
+
+	return s
+}
+
+// Formats a score the way Redis replies with them as strings: `inf`/`-inf`
+// for the infinities and the shortest decimal representation otherwise.
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	default:
+		return strconv.FormatFloat(score, 'f', -1, 64)
+	}
+}
+
+// Names the type of a value the way TYPE replies with it.
+func toTypeName(t store.StoreType) string {
+	switch t {
+	case store.ArrayType:
+		return "list"
+	case store.MapType:
+		return "hash"
+	case store.StringType:
+		return "string"
+	case store.SetType:
+		return "set"
+	case store.SortedSetType:
+		return "zset"
+	case store.StreamType:
+		return "stream"
+	default:
+		return "none"
+	}
+}
+
+// A group's entries-read counter is null when unknown
+func writeRESPEntriesRead(w *resp.Writer, entriesRead int64) {
+	if entriesRead < 0 {
+		w.WriteNull()
+		return
+	}
+
+	w.WriteInt(entriesRead)
+}
 
-		ss[i] = s
+func writeRESPLag(w *resp.Writer, g *store.ConsumerGroup, s *store.Stream) {
+	lag, ok := g.Lag(s)
+	if !ok {
+		w.WriteNull()
+		return
 	}
 
-	return ss, nil
+	w.WriteInt(lag)
 }
 
-func toRESPString(r *store.Record) (string, error) {
-	var b strings.Builder
+// Writes a record as the RESP type matching its own, nesting lists and
+// hashes. A record of a type that has no RESP counterpart is logged and
+// written as a null, as whatever encloses it is already out by then.
+func writeRESPRecord(w *resp.Writer, r *store.Record) {
 	switch r.Type {
-	case store.ArrayType, store.SetType:
-		arrVal := r.Array
-		for _, v := range arrVal {
-			nestedValue, err := toRESPString(v)
-			if err != nil {
-				return "", fmt.Errorf("%s unable to adapt nested array: %+v", ErrAdaptPrefix, v)
-			}
-			b.WriteString(nestedValue)
-		}
-		return resp.EncodeArray(len(arrVal), b.String()), nil
-	case store.BooleanType:
-		b.WriteString(resp.EncodeBoolean(r.Boolean))
 	case store.StringType:
-		b.WriteString(resp.EncodeBulkString(r.String))
+		w.WriteBulkString(r.String)
 	case store.IntegerType:
-		b.WriteString(resp.EncodeInteger(r.Integer))
+		w.WriteInt(int64(r.Integer))
+	case store.BooleanType:
+		w.WriteBool(r.Boolean)
+	case store.NilType:
+		w.WriteNull()
+	case store.ArrayType:
+		w.WriteArrayHeader(r.List.Len())
+		for _, v := range r.List.All() {
+			writeRESPRecord(w, v)
+		}
 	case store.MapType:
-		s, err := toRESPStringFromStoreMap(b, r.Map)
-		if err != nil {
-			return "", fmt.Errorf("%s to resp: %w", ErrAdaptPrefix, err)
+		w.WriteMapHeader(len(r.Map))
+		for field, v := range r.Map {
+			w.WriteBulkString(field)
+			writeRESPRecord(w, v)
 		}
-		b.WriteString(s)
-	case store.NilType:
-		b.WriteString(resp.EncodeNulls())
+	case store.SetType:
+		writeRESPSet(w, r.Set)
 	default:
-		return "", fmt.Errorf("%s unsupported type (%s) from store record: %+v", ErrAdaptPrefix, r.Type.String(), r)
+		log.Printf("%s unsupported type (%s) from store record: %+v", ErrAdaptPrefix, r.Type.String(), r)
+		w.WriteNull()
 	}
+}
 
-	return b.String(), nil
+// Writes records as an array.
+func writeRESPRecords(w *resp.Writer, records []*store.Record) {
+	w.WriteArrayHeader(len(records))
+	for _, r := range records {
+		writeRESPRecord(w, r)
+	}
 }
 
-func toRESPStringFromStoreMap(b strings.Builder, m map[string]*store.Record) (string, error) {
-	for k, v := range m {
-		// Keys are already strings, encode them as BulkStrings
-		b.WriteString(resp.EncodeBulkString(k))
+// Writes a SCAN reply: the cursor to continue from, followed by the array
+// of elements found.
+func writeRESPScan(w *resp.Writer, cursor uint64, elems []string) {
+	var buf [20]byte
+	w.WriteArrayHeader(2)
+	w.WriteBulk(strconv.AppendUint(buf[:0], cursor, 10))
+	w.WriteArrayHeader(len(elems))
+	for _, e := range elems {
+		w.WriteBulkString(e)
+	}
+}
 
-		nestedValue, err := toRESPString(v)
-		if err != nil {
-			return "", fmt.Errorf("%s unable to adapt map value: %+v", ErrAdaptPrefix, v)
+// Writes a score as a double for RESP3 clients and, formatted the same as
+// formatScore does, as a bulk string for RESP2 ones.
+func writeRESPScore(w *resp.Writer, score float64) {
+	w.WriteDouble(score)
+}
+
+func writeRESPSet(w *resp.Writer, set *store.Set) {
+	w.WriteSetHeader(set.Len())
+	for m := range set.All() {
+		w.WriteBulkString(m)
+	}
+}
+
+// Writes sorted set members as a flat array, interleaving their scores when
+// `withScores` is set.
+func writeRESPSortedSetMembers(w *resp.Writer, members []store.ZMember, withScores bool) {
+	if withScores {
+		w.WriteArrayHeader(len(members) * 2)
+	} else {
+		w.WriteArrayHeader(len(members))
+	}
+
+	for _, m := range members {
+		w.WriteBulkString(m.Member)
+		if withScores {
+			writeRESPScore(w, m.Score)
 		}
-		b.WriteString(nestedValue)
 	}
-	return resp.EncodeMap(len(m), b.String()), nil
+}
+
+// Writes stream entries the way XRANGE and friends reply with them: an array
+// of [id, [field, value, ...]] pairs.
+func writeRESPStreamEntries(w *resp.Writer, entries []*store.StreamEntry) {
+	w.WriteArrayHeader(len(entries))
+	for _, e := range entries {
+		writeRESPStreamEntry(w, e)
+	}
+}
+
+// Entries with nil Fields are ones that were deleted while still pending in
+// a consumer group, and get a null array in place of their fields.
+func writeRESPStreamEntry(w *resp.Writer, e *store.StreamEntry) {
+	w.WriteArrayHeader(2)
+	w.WriteBulkString(e.ID.String())
+	if e.Fields == nil {
+		w.WriteNullArray()
+		return
+	}
+
+	writeRESPRecords(w, e.Fields)
+}
+
+func writeRESPStreamIDs(w *resp.Writer, ids []store.StreamID) {
+	w.WriteArrayHeader(len(ids))
+	for _, id := range ids {
+		w.WriteBulkString(id.String())
+	}
+}
+
+// Writes the [key, entries] pair XREAD and XREADGROUP reply with for each
+// stream read.
+func writeRESPStreamRead(w *resp.Writer, key string, entries []*store.StreamEntry) {
+	w.WriteArrayHeader(2)
+	w.WriteBulkString(key)
+	writeRESPStreamEntries(w, entries)
+}
+
+// Writes a ZMPOP reply: the key popped from and its [member, score] pairs,
+// or a null array when nothing was popped.
+func writeRESPZMPop(w *resp.Writer, key string, members []store.ZMember) {
+	if key == "" {
+		w.WriteNullArray()
+		return
+	}
+
+	w.WriteArrayHeader(2)
+	w.WriteBulkString(key)
+	w.WriteArrayHeader(len(members))
+	for _, m := range members {
+		w.WriteArrayHeader(2)
+		w.WriteBulkString(m.Member)
+		writeRESPScore(w, m.Score)
+	}
 }
@@ -2,6 +2,7 @@ package server
 
 import (
 	"net"
+	"slices"
 	"sync"
 
 	"github.com/ev-the-dev/redis-go-clone/store"
@@ -18,15 +19,26 @@ type BlockingManager struct {
  */
 type BlockedClient struct {
 	conn    net.Conn
+	kind    store.StoreType
 	replyCh chan *BlockedClientChanResp
 	subs    []string
+
+	// Stream readers only: the maximum amount of entries to deliver (0 being
+	// unlimited) and, per key, the ID after which entries are wanted. Group
+	// readers are only woken up, as reading through a group mutates it.
+	count     int
+	group     string
+	streamIDs map[string]store.StreamID
 }
 
 type BlockedClientChanResp struct {
-	key string
-	rec *store.Record
+	key     string
+	rec     *store.Record
+	entries []*store.StreamEntry
 }
 
+// NotifyWatchers hands `rec` to the longest waiting client blocked on `key`
+// for a record of the same type.
 func (bm *BlockingManager) NotifyWatchers(key string, rec *store.Record) {
 	bm.mu.Lock()
 	defer bm.mu.Unlock()
@@ -35,15 +47,71 @@ func (bm *BlockingManager) NotifyWatchers(key string, rec *store.Record) {
 		return
 	}
 
-	// FIFO
-	client := watchers[0]
+	// FIFO -- unregistering mutates the queue for `key`, so iterate over a copy
+	for _, client := range slices.Clone(watchers) {
+		if client.kind != rec.Type {
+			continue
+		}
+
+		select {
+		case client.replyCh <- &BlockedClientChanResp{key: key, rec: rec}:
+			bm.unregisterClientLocked(client)
+			return
+		default:
+			// Stale client?
+			bm.unregisterClientLocked(client)
+		}
+	}
+}
+
+// NotifyStreamWatchers, unlike NotifyWatchers, wakes every client blocked on
+// the stream at `key` as reading doesn't consume entries. Each client only
+// receives the entries newer than the ID it is waiting on.
+func (bm *BlockingManager) NotifyStreamWatchers(key string, stream *store.Stream) {
+	bm.mu.Lock()
+	defer bm.mu.Unlock()
+	watchers, exists := bm.queue[key]
+	if !exists || len(watchers) == 0 {
+		return
+	}
 
-	select {
-	case client.replyCh <- &BlockedClientChanResp{key: key, rec: rec}:
+	// Unregistering mutates the queue for `key`, so iterate over a copy
+	for _, client := range slices.Clone(watchers) {
+		if client.kind != store.StreamType {
+			continue
+		}
+
+		if client.group != "" {
+			g, exists := stream.Group(client.group)
+			if exists && g.LastID.Compare(stream.LastID()) >= 0 {
+				continue
+			}
+
+			select {
+			case client.replyCh <- &BlockedClientChanResp{key: key}:
+			default:
+				// Stale client?
+			}
+			bm.unregisterClientLocked(client)
+			continue
+		}
+
+		start, ok := client.streamIDs[key].Next()
+		if !ok {
+			continue
+		}
+
+		entries := stream.Range(start, store.MaxStreamID, client.count, false)
+		if len(entries) == 0 {
+			continue
+		}
+
+		select {
+		case client.replyCh <- &BlockedClientChanResp{key: key, entries: entries}:
+		default:
+			// Stale client?
+		}
 		bm.unregisterClientLocked(client)
-	default:
-		// Stale client?
-		bm.queue[key] = watchers[1:]
 	}
 }
 
@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net"
+
+	"github.com/ev-the-dev/redis-go-clone/resp"
+)
+
+// Reported by HELLO, as the version of Redis whose commands we mimic.
+const serverVersion = "7.4.0"
+
+// Replies are flushed once this much has piled up, even with pipelined
+// commands still waiting, so a long pipeline doesn't hold all of its replies
+// in memory at once.
+const maxBufferedReplies = 64 * 1024
+
+// A connected client. Handlers reply through its resp.Writer, which writes
+// for the protocol the client negotiated. Clients speak RESP2 until they say
+// otherwise with HELLO. Replies are buffered until flushed.
+type client struct {
+	net.Conn
+	id   int64
+	name string
+	w    *resp.Writer
+}
+
+func (s *Server) newClient(conn net.Conn) *client {
+	w := resp.NewWriter(conn)
+	w.Protocol = resp.RESP2
+
+	return &client{
+		Conn: conn,
+		id:   s.clientIDs.Add(1),
+		w:    w,
+	}
+}
@@ -6,8 +6,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
+	"math/big"
+	"math/rand/v2"
 	"net"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,110 +20,293 @@ import (
 	"github.com/ev-the-dev/redis-go-clone/store"
 )
 
-func (s *Server) handleBLPOPCommand(conn net.Conn, msg *resp.Message) {
-	if len(msg.Array) < 2 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `BLPOP` command")))
+// `APPEND key value`
+func (s *Server) handleAppendCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 3 {
+		w.WriteError("ERR Incorrect amount of args for `APPEND` command")
 		return
 	}
 
-	keyMsgs := msg.Array[1 : len(msg.Array)-1]
-	timeoutMsg := msg.Array[len(msg.Array)-1]
+	s.updateKey(w, msg, store.StringType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			record = &store.Record{Type: store.StringType}
+		}
 
-	timeout, err := timeoutMsg.ConvInt()
-	if err != nil {
-		log.Printf("%s: BLPOP: invalid timeout: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Timeout arg for `BLPOP` must be greater than or equal to 0")))
-		return
-	}
-	if timeout == 0 {
-		timeout = 1_000_000
-	}
+		record.String += msg.Array[2].String
+		w.WriteInt(int64(len(record.String)))
+		return record, true
+	})
+}
 
-	emptyKeys := make([]string, 0, len(keyMsgs)/2)
-	for i, km := range keyMsgs {
-		key, err := km.ConvStr()
-		if err != nil {
-			log.Printf("%s: BLPOP: invalid key at pos (%d): %v", ErrCmdPrefix, i, err)
-			conn.Write([]byte(resp.EncodeSimpleErr("Invalid key type for `BLPOP` command")))
+// Handles BLMOVE and BRPOPLPUSH, the latter being BLMOVE with its ends fixed
+// to RIGHT and LEFT: `BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout`
+func (s *Server) handleBlmoveCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+
+	var fromTail, toTail bool
+	var rawTimeout string
+	switch CmdName(cmd) {
+	case BRPOPLPUSH:
+		if len(msg.Array) != 4 {
+			w.WriteError("ERR Incorrect amount of args for `BRPOPLPUSH` command")
 			return
 		}
 
-		record, exists := s.store.Get(key)
-		if !exists || (record.Type == store.ArrayType && len(record.Array) == 0) {
-			emptyKeys = append(emptyKeys, key)
-			continue
+		fromTail, toTail = true, false
+		rawTimeout = msg.Array[3].String
+	default:
+		if len(msg.Array) != 6 {
+			w.WriteError("ERR Incorrect amount of args for `BLMOVE` command")
+			return
 		}
 
-		if record.Type != store.ArrayType {
-			log.Printf("%s: BLPOP: invalid type from key (%s): %s", ErrCmdPrefix, key, record.Type.String())
-			conn.Write([]byte(resp.EncodeSimpleErr(fmt.Sprintf("Provided `BLPOP` Key (%s) produced non array/list type", key))))
+		var okFrom, okTo bool
+		fromTail, okFrom = parseListEnd(msg.Array[3].String)
+		toTail, okTo = parseListEnd(msg.Array[4].String)
+		if !okFrom || !okTo {
+			w.WriteError("ERR syntax error")
 			return
 		}
+		rawTimeout = msg.Array[5].String
+	}
 
-		list := record.Array
-		val := list[0]
-		record.Array = list[1:]
+	timeout, err := parseBlockTimeout(rawTimeout)
+	if err != nil {
+		log.Printf("%s %s: invalid timeout: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
 
-		s.store.Set(key, record)
+	src, dest := msg.Array[1].String, msg.Array[2].String
+	element, err := s.moveListElement(src, dest, fromTail, toTail)
 
-		toResp, err := toRESPString(val)
-		if err != nil {
-			log.Printf("%s: BLPOP: to resp string: %v", ErrCmdPrefix, err)
-			conn.Write([]byte(resp.EncodeSimpleErr("Unable to output popped value")))
-			return
-		}
+	/*** BLOCKING BEGINS ***/
+	if err == nil && element == nil {
+		s.waitForKeys(conn, store.ArrayType, []string{src}, timeout, func(string) bool {
+			element, err = s.moveListElement(src, dest, fromTail, toTail)
+			return err != nil || element != nil
+		})
+	}
+
+	if err != nil {
+		log.Printf("%s %s: %v", ErrCmdPrefix, cmd, err)
+		w.WriteWrongTypeErr()
+		return
+	}
+
+	if element == nil {
+		w.WriteNull()
+		return
+	}
+
+	writeRESPRecord(w, element)
+}
+
+// `BLMPOP timeout numkeys key [key ...] LEFT|RIGHT [COUNT count]`
+func (s *Server) handleBlmpopCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 5 {
+		w.WriteError("ERR Incorrect amount of args for `BLMPOP` command")
+		return
+	}
 
-		conn.Write([]byte(resp.EncodeArray(2, []string{resp.EncodeBulkString(key), toResp}...)))
+	timeout, err := parseBlockTimeout(msg.Array[1].String)
+	if err != nil {
+		log.Printf("%s BLMPOP: invalid timeout: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	opts, err := parseMPOPOptions(msg.Array[2:], "LEFT", "RIGHT")
+	if err != nil {
+		log.Printf("%s BLMPOP: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
 		return
 	}
 
+	key, popped, err := s.popLists(opts.Keys, opts.FromTail, opts.Count)
+
 	/*** BLOCKING BEGINS ***/
-	bc := &BlockedClient{
-		conn:    conn,
-		replyCh: make(chan *BlockedClientChanResp, 1),
-		subs:    emptyKeys,
+	if err == nil && key == "" {
+		s.waitForKeys(conn, store.ArrayType, opts.Keys, timeout, func(k string) bool {
+			key, popped, err = s.popLists([]string{k}, opts.FromTail, opts.Count)
+			return err != nil || key != ""
+		})
 	}
 
-	s.blockingManager.RegisterClient(bc)
+	if err != nil {
+		log.Printf("%s BLMPOP: %v", ErrCmdPrefix, err)
+		w.WriteWrongTypeErr()
+		return
+	}
 
-	select {
-	case res, ok := <-bc.replyCh:
-		if !ok {
-			// NOTE: not entirely sure what to do here
-		}
-		if res.rec.Type != store.ArrayType {
-			log.Printf("%s: BLPOP: blocking: invalid type from key (%s): %s", ErrCmdPrefix, res.key, res.rec.Type.String())
-			conn.Write([]byte(resp.EncodeSimpleErr(fmt.Sprintf("Provided `BLPOP` Key (%s) produced non array/list type", res.key))))
-			return
-		}
+	if key == "" {
+		w.WriteNullArray()
+		return
+	}
 
-		list := res.rec.Array
-		val := list[0]
-		res.rec.Array = list[1:]
+	w.WriteArrayHeader(2)
+	w.WriteBulkString(key)
+	writeRESPRecords(w, popped)
+}
 
-		s.store.Set(res.key, res.rec)
+// Handles BLPOP and BRPOP, popping from the tail when `fromTail` is set:
+// `BLPOP key [key ...] timeout`
+func (s *Server) handleBLPOPCommand(conn net.Conn, msg *resp.Message, fromTail bool) {
+	w, done := replyWriter(conn)
+	defer done()
 
-		if res.rec.Type == store.ArrayType && len(res.rec.Array) != 0 {
-			s.blockingManager.NotifyWatchers(res.key, res.rec)
-		}
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 3 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
 
-		toResp, err := toRESPString(val)
+	keyMsgs := msg.Array[1 : len(msg.Array)-1]
+	timeoutMsg := msg.Array[len(msg.Array)-1]
+
+	timeout, err := parseBlockTimeout(timeoutMsg.String)
+	if err != nil {
+		log.Printf("%s: %s: invalid timeout: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	keys := make([]string, len(keyMsgs))
+	for i, km := range keyMsgs {
+		keys[i], err = km.ConvStr()
 		if err != nil {
-			log.Printf("%s: BLPOP: blocking: to resp string: %v", ErrCmdPrefix, err)
-			conn.Write([]byte(resp.EncodeSimpleErr("Unable to output blocked popped value")))
+			log.Printf("%s: %s: invalid key at pos (%d): %v", ErrCmdPrefix, cmd, i, err)
+			w.WriteError(fmt.Sprintf("ERR Invalid key type for `%s` command", cmd))
 			return
 		}
-		conn.Write([]byte(resp.EncodeArray(2, []string{resp.EncodeBulkString(res.key), toResp}...)))
-	case <-time.After(time.Duration(timeout) * time.Second):
-		conn.Write([]byte(resp.EncodeNullArray()))
-		s.blockingManager.UnregisterClient(bc)
 	}
+
+	key, popped, err := s.popLists(keys, fromTail, 1)
+
+	/*** BLOCKING BEGINS ***/
+	if err == nil && key == "" {
+		s.waitForKeys(conn, store.ArrayType, keys, timeout, func(k string) bool {
+			key, popped, err = s.popLists([]string{k}, fromTail, 1)
+			return err != nil || key != ""
+		})
+	}
+
+	if err != nil {
+		log.Printf("%s: %s: %v", ErrCmdPrefix, cmd, err)
+		w.WriteWrongTypeErr()
+		return
+	}
+
+	if key == "" {
+		w.WriteNullArray()
+		return
+	}
+
+	w.WriteArrayHeader(2)
+	w.WriteBulkString(key)
+	writeRESPRecord(w, popped[0])
+}
+
+func (s *Server) handleBzmpopCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 5 {
+		w.WriteError("ERR Incorrect amount of args for `BZMPOP` command")
+		return
+	}
+
+	timeout, err := parseBlockTimeout(msg.Array[1].String)
+	if err != nil {
+		log.Printf("%s BZMPOP: invalid timeout: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	opts, err := parseMPOPOptions(msg.Array[2:], "MIN", "MAX")
+	if err != nil {
+		log.Printf("%s BZMPOP: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	key, popped, err := s.popSortedSets(opts.Keys, opts.FromTail, opts.Count)
+	if err == nil && key == "" {
+		s.waitForKeys(conn, store.SortedSetType, opts.Keys, timeout, func(k string) bool {
+			key, popped, err = s.popSortedSets([]string{k}, opts.FromTail, opts.Count)
+			return err != nil || key != ""
+		})
+	}
+
+	if err != nil {
+		log.Printf("%s BZMPOP: %v", ErrCmdPrefix, err)
+		w.WriteWrongTypeErr()
+		return
+	}
+
+	writeRESPZMPop(w, key, popped)
+}
+
+// Handles BZPOPMIN and BZPOPMAX: `BZPOPMIN key [key ...] timeout`
+func (s *Server) handleBzpopCommand(conn net.Conn, msg *resp.Message, highest bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 3 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	timeout, err := parseBlockTimeout(msg.Array[len(msg.Array)-1].String)
+	if err != nil {
+		log.Printf("%s %s: invalid timeout: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	keys := messageStrings(msg.Array[1 : len(msg.Array)-1])
+	key, popped, err := s.popSortedSets(keys, highest, 1)
+	if err == nil && key == "" {
+		s.waitForKeys(conn, store.SortedSetType, keys, timeout, func(k string) bool {
+			key, popped, err = s.popSortedSets([]string{k}, highest, 1)
+			return err != nil || key != ""
+		})
+	}
+
+	if err != nil {
+		log.Printf("%s %s: %v", ErrCmdPrefix, cmd, err)
+		w.WriteWrongTypeErr()
+		return
+	}
+
+	if key == "" {
+		w.WriteNullArray()
+		return
+	}
+
+	w.WriteArrayHeader(3)
+	w.WriteBulkString(key)
+	w.WriteBulkString(popped[0].Member)
+	writeRESPScore(w, popped[0].Score)
 }
 
 func (s *Server) handleConfigCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
 	// NOTE: if I need support just the `CONFIG` command this needs to change
 	if len(msg.Array) < 3 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `CONFIG *` command")))
+		w.WriteError("ERR Incorrect amount of args for `CONFIG *` command")
 		return
 	}
 
@@ -127,11 +315,14 @@ func (s *Server) handleConfigCommand(conn net.Conn, msg *resp.Message) {
 	case "GET":
 		s.handleConfigGetCommand(conn, msg)
 	default:
-		conn.Write([]byte(resp.EncodeSimpleErr("Unknown CONFIG subcommand")))
+		w.WriteError("ERR Unknown CONFIG subcommand")
 	}
 }
 
 func (s *Server) handleConfigGetCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
 	result := make([]string, 0, len(msg.Array)*2)
 	// Starting at 2 because `CONFIG` is 0 and `GET` is 1
 	for i := 2; i < len(msg.Array); i++ {
@@ -139,575 +330,5545 @@ func (s *Server) handleConfigGetCommand(conn net.Conn, msg *resp.Message) {
 		// TODO: support glob pattern matching
 		switch strings.ToLower(m.String) {
 		case "dir":
-			result = append(result, resp.EncodeBulkString("dir"), resp.EncodeBulkString(s.config.Dir))
+			result = append(result, "dir", s.config.Dir)
 		case "dbfilename":
-			result = append(result, resp.EncodeBulkString("dbfilename"), resp.EncodeBulkString(s.config.DBFilename))
+			result = append(result, "dbfilename", s.config.DBFilename)
 		default:
-			conn.Write([]byte(resp.EncodeSimpleErr("Unrecognized config key")))
+			w.WriteError("ERR Unrecognized config key")
 		}
 	}
 
-	conn.Write([]byte(resp.EncodeArray(len(result), result...)))
+	w.WriteArrayHeader(len(result))
+	for _, r := range result {
+		w.WriteBulkString(r)
+	}
 }
 
-func (s *Server) handleConnection(conn net.Conn) {
-	defer conn.Close()
-	reader := bufio.NewReader(conn)
+func (s *Server) handleConnection(netConn net.Conn) {
+	defer netConn.Close()
+	reader := bufio.NewReader(netConn)
+
+	// Handlers write their replies through the client, which converts them
+	// to the protocol it negotiated
+	client := s.newClient(netConn)
+	var conn net.Conn = client
 
 	for {
-		// Parse RESP command
-		msg, err := resp.Parse(reader)
+		// Replies are held back while pipelined commands are already waiting
+		// to be read, so a batch of them goes out in a single write once the
+		// batch is done
+		if reader.Buffered() == 0 || client.w.Buffered() >= maxBufferedReplies {
+			if err := client.w.Flush(); err != nil {
+				log.Printf("%s flush: %v\n", ErrConnPrefix, err)
+				return
+			}
+		}
+
+		// Parse RESP command, or an inline one typed in by hand
+		msg, err := resp.ParseCommand(reader)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				fmt.Println("Client disconnected.")
 				return
 			}
 			log.Printf("%s %v\n", ErrCmdPrefix, err)
-			continue
+
+			// Whatever follows a request that couldn't be read could be
+			// anything, even a command smuggled in after the cut-off, so
+			// there's no carrying on from here
+			reply := "Protocol error: invalid request"
+			for _, protoErr := range []error{resp.ErrInlineTooBig, resp.ErrUnbalancedQuotes} {
+				if errors.Is(err, protoErr) {
+					reply = "Protocol error: " + protoErr.Error()
+				}
+			}
+			client.w.WriteError("ERR " + reply)
+			client.w.Flush()
+			return
 		}
 
 		if msg.Type != resp.Array || len(msg.Array) <= 0 {
-			conn.Write([]byte(resp.EncodeSimpleErr("Expected command array")))
+			client.w.WriteError("ERR Expected command array")
 			continue
 		}
 
 		cmdMsg := msg.Array[0]
 		if cmdMsg.Type != resp.BulkString {
-			conn.Write([]byte(resp.EncodeSimpleErr("Command must be bulk string type")))
+			client.w.WriteError("ERR Command must be bulk string type")
 			continue
 		}
 
 		switch CmdName(strings.ToUpper(cmdMsg.String)) {
 		case PING:
-			conn.Write([]byte("+PONG\r\n"))
+			client.w.WriteSimpleString("PONG")
+		case APPEND:
+			s.handleAppendCommand(conn, msg)
+		case BLMOVE:
+			s.handleBlmoveCommand(conn, msg)
+		case BLMPOP:
+			s.handleBlmpopCommand(conn, msg)
 		case BLPOP:
-			s.handleBLPOPCommand(conn, msg)
+			s.handleBLPOPCommand(conn, msg, false)
+		case BRPOP:
+			s.handleBLPOPCommand(conn, msg, true)
+		case BRPOPLPUSH:
+			s.handleBlmoveCommand(conn, msg)
+		case BZMPOP:
+			s.handleBzmpopCommand(conn, msg)
+		case BZPOPMAX:
+			s.handleBzpopCommand(conn, msg, true)
+		case BZPOPMIN:
+			s.handleBzpopCommand(conn, msg, false)
 		case CONFIG:
 			s.handleConfigCommand(conn, msg)
+		case COPY:
+			s.handleCopyCommand(conn, msg)
+		case DBSIZE:
+			s.handleDbsizeCommand(conn, msg)
+		case DECR:
+			s.handleIncrCommand(conn, msg, true)
+		case DECRBY:
+			s.handleIncrCommand(conn, msg, true)
+		case DEL:
+			s.handleDelCommand(conn, msg)
 		case ECHO:
 			s.handleEchoCommand(conn, msg)
+		case EXISTS:
+			s.handleExistsCommand(conn, msg)
+		case EXPIRE:
+			s.handleExpireCommand(conn, msg, time.Second, false)
+		case EXPIREAT:
+			s.handleExpireCommand(conn, msg, time.Second, true)
+		case EXPIRETIME:
+			s.handleTtlCommand(conn, msg, time.Second, true)
+		case FLUSHALL:
+			s.handleFlushCommand(conn, msg)
+		case FLUSHDB:
+			s.handleFlushCommand(conn, msg)
 		case GET:
 			s.handleGetCommand(conn, msg)
+		case GETDEL:
+			s.handleGetdelCommand(conn, msg)
+		case GETEX:
+			s.handleGetexCommand(conn, msg)
+		case GETRANGE:
+			s.handleGetrangeCommand(conn, msg)
+		case HDEL:
+			s.handleHdelCommand(conn, msg)
+		case HELLO:
+			s.handleHelloCommand(client, msg)
+		case HEXISTS:
+			s.handleHexistsCommand(conn, msg)
+		case HEXPIRE:
+			s.handleHexpireCommand(conn, msg, time.Second, false)
+		case HEXPIREAT:
+			s.handleHexpireCommand(conn, msg, time.Second, true)
+		case HEXPIRETIME:
+			s.handleHttlCommand(conn, msg, time.Second, true)
+		case HGET:
+			s.handleHgetCommand(conn, msg)
+		case HGETALL:
+			s.handleHgetallCommand(conn, msg)
+		case HINCRBY:
+			s.handleHincrbyCommand(conn, msg)
+		case HINCRBYFLOAT:
+			s.handleHincrbyfloatCommand(conn, msg)
+		case HKEYS:
+			s.handleHkeysCommand(conn, msg)
+		case HLEN:
+			s.handleHlenCommand(conn, msg)
+		case HMGET:
+			s.handleHmgetCommand(conn, msg)
+		case HPERSIST:
+			s.handleHpersistCommand(conn, msg)
+		case HPEXPIRE:
+			s.handleHexpireCommand(conn, msg, time.Millisecond, false)
+		case HPEXPIREAT:
+			s.handleHexpireCommand(conn, msg, time.Millisecond, true)
+		case HPEXPIRETIME:
+			s.handleHttlCommand(conn, msg, time.Millisecond, true)
+		case HPTTL:
+			s.handleHttlCommand(conn, msg, time.Millisecond, false)
+		case HRANDFIELD:
+			s.handleHrandfieldCommand(conn, msg)
+		case HSCAN:
+			s.handleHscanCommand(conn, msg)
+		case HSET:
+			s.handleHsetCommand(conn, msg)
+		case HSETNX:
+			s.handleHsetnxCommand(conn, msg)
+		case HSTRLEN:
+			s.handleHstrlenCommand(conn, msg)
+		case HTTL:
+			s.handleHttlCommand(conn, msg, time.Second, false)
+		case HVALS:
+			s.handleHvalsCommand(conn, msg)
+		case INCR:
+			s.handleIncrCommand(conn, msg, false)
+		case INCRBY:
+			s.handleIncrCommand(conn, msg, false)
+		case INCRBYFLOAT:
+			s.handleIncrbyfloatCommand(conn, msg)
+		case INFO:
+			s.handleInfoCommand(conn, msg)
 		case KEYS:
 			s.handleKeysCommand(conn, msg)
+		case LINDEX:
+			s.handleLindexCommand(conn, msg)
+		case LINSERT:
+			s.handleLinsertCommand(conn, msg)
 		case LLEN:
 			s.handleLlenCommand(conn, msg)
+		case LMOVE:
+			s.handleLmoveCommand(conn, msg)
+		case LMPOP:
+			s.handleLmpopCommand(conn, msg)
 		case LPOP:
 			s.handleLpopCommand(conn, msg)
+		case LPOS:
+			s.handleLposCommand(conn, msg)
 		case LPUSH:
 			s.handleLpushCommand(conn, msg)
+		case LPUSHX:
+			s.handlePushxCommand(conn, msg, false)
 		case LRANGE:
 			s.handleLrangeCommand(conn, msg)
+		case LREM:
+			s.handleLremCommand(conn, msg)
+		case LSET:
+			s.handleLsetCommand(conn, msg)
+		case LTRIM:
+			s.handleLtrimCommand(conn, msg)
+		case MGET:
+			s.handleMgetCommand(conn, msg)
+		case MSET:
+			s.handleMsetCommand(conn, msg, false)
+		case MSETNX:
+			s.handleMsetCommand(conn, msg, true)
+		case PERSIST:
+			s.handlePersistCommand(conn, msg)
+		case PEXPIRE:
+			s.handleExpireCommand(conn, msg, time.Millisecond, false)
+		case PEXPIREAT:
+			s.handleExpireCommand(conn, msg, time.Millisecond, true)
+		case PEXPIRETIME:
+			s.handleTtlCommand(conn, msg, time.Millisecond, true)
+		case PSETEX:
+			s.handleSetexCommand(conn, msg, "PX")
+		case PTTL:
+			s.handleTtlCommand(conn, msg, time.Millisecond, false)
+		case RANDOMKEY:
+			s.handleRandomkeyCommand(conn, msg)
+		case RENAME:
+			s.handleRenameCommand(conn, msg, false)
+		case RENAMENX:
+			s.handleRenameCommand(conn, msg, true)
+		case RPOP:
+			s.handleRpopCommand(conn, msg)
 		case RPUSH:
 			s.handleRpushCommand(conn, msg)
+		case RPUSHX:
+			s.handlePushxCommand(conn, msg, true)
+		case SADD:
+			s.handleSaddCommand(conn, msg)
+		case SCAN:
+			s.handleScanCommand(conn, msg)
+		case SCARD:
+			s.handleScardCommand(conn, msg)
+		case SDIFF:
+			s.handleSetAlgebraCommand(conn, msg, store.SetDiff)
+		case SDIFFSTORE:
+			s.handleSetAlgebraStoreCommand(conn, msg, store.SetDiff)
 		case SET:
 			s.handleSetCommand(conn, msg)
+		case SETEX:
+			s.handleSetexCommand(conn, msg, "EX")
+		case SETNX:
+			s.handleSetnxCommand(conn, msg)
+		case SETRANGE:
+			s.handleSetrangeCommand(conn, msg)
+		case SINTER:
+			s.handleSetAlgebraCommand(conn, msg, store.SetInter)
+		case SINTERCARD:
+			s.handleSintercardCommand(conn, msg)
+		case SINTERSTORE:
+			s.handleSetAlgebraStoreCommand(conn, msg, store.SetInter)
+		case SISMEMBER:
+			s.handleSismemberCommand(conn, msg)
+		case SMEMBERS:
+			s.handleSmembersCommand(conn, msg)
+		case SMISMEMBER:
+			s.handleSmismemberCommand(conn, msg)
+		case SMOVE:
+			s.handleSmoveCommand(conn, msg)
+		case SPOP:
+			s.handleSpopCommand(conn, msg)
+		case SRANDMEMBER:
+			s.handleSrandmemberCommand(conn, msg)
+		case SREM:
+			s.handleSremCommand(conn, msg)
+		case SSCAN:
+			s.handleSscanCommand(conn, msg)
+		case STRLEN:
+			s.handleStrlenCommand(conn, msg)
+		case SUNION:
+			s.handleSetAlgebraCommand(conn, msg, store.SetUnion)
+		case SUNIONSTORE:
+			s.handleSetAlgebraStoreCommand(conn, msg, store.SetUnion)
+		case TOUCH:
+			s.handleExistsCommand(conn, msg)
+		case TTL:
+			s.handleTtlCommand(conn, msg, time.Second, false)
 		case TYPE:
 			s.handleTypeCommand(conn, msg)
+		case UNLINK:
+			s.handleUnlinkCommand(conn, msg)
+		case XACK:
+			s.handleXackCommand(conn, msg)
 		case XADD:
 			s.handleXaddCommand(conn, msg)
+		case XAUTOCLAIM:
+			s.handleXautoclaimCommand(conn, msg)
+		case XCLAIM:
+			s.handleXclaimCommand(conn, msg)
+		case XDEL:
+			s.handleXdelCommand(conn, msg)
+		case XGROUP:
+			s.handleXgroupCommand(conn, msg)
+		case XINFO:
+			s.handleXinfoCommand(conn, msg)
+		case XLEN:
+			s.handleXlenCommand(conn, msg)
+		case XPENDING:
+			s.handleXpendingCommand(conn, msg)
+		case XRANGE:
+			s.handleXrangeCommand(conn, msg, false)
+		case XREAD:
+			s.handleXreadCommand(conn, msg)
+		case XREADGROUP:
+			s.handleXreadgroupCommand(conn, msg)
+		case XREVRANGE:
+			s.handleXrangeCommand(conn, msg, true)
+		case XTRIM:
+			s.handleXtrimCommand(conn, msg)
+		case ZADD:
+			s.handleZaddCommand(conn, msg)
+		case ZCARD:
+			s.handleZcardCommand(conn, msg)
+		case ZCOUNT:
+			s.handleZcountCommand(conn, msg)
+		case ZINCRBY:
+			s.handleZincrbyCommand(conn, msg)
+		case ZMPOP:
+			s.handleZmpopCommand(conn, msg)
+		case ZMSCORE:
+			s.handleZmscoreCommand(conn, msg)
+		case ZPOPMAX:
+			s.handleZpopCommand(conn, msg, true)
+		case ZPOPMIN:
+			s.handleZpopCommand(conn, msg, false)
+		case ZRANGE:
+			s.handleZrangeCommand(conn, msg)
+		case ZRANGESTORE:
+			s.handleZrangestoreCommand(conn, msg)
+		case ZRANK:
+			s.handleZrankCommand(conn, msg, false)
+		case ZREM:
+			s.handleZremCommand(conn, msg)
+		case ZREMRANGEBYLEX:
+			s.handleZremrangeCommand(conn, msg, ZRangeByLex)
+		case ZREMRANGEBYRANK:
+			s.handleZremrangeCommand(conn, msg, ZRangeByRank)
+		case ZREMRANGEBYSCORE:
+			s.handleZremrangeCommand(conn, msg, ZRangeByScore)
+		case ZREVRANK:
+			s.handleZrankCommand(conn, msg, true)
+		case ZSCAN:
+			s.handleZscanCommand(conn, msg)
+		case ZSCORE:
+			s.handleZscoreCommand(conn, msg)
 		default:
-			conn.Write([]byte(resp.EncodeSimpleErr("Unknown command")))
+			client.w.WriteError("ERR Unknown command")
+
 		}
 	}
 }
 
-func (s *Server) handleEchoCommand(conn net.Conn, msg *resp.Message) {
-	if len(msg.Array) != 2 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `ECHO` command")))
-		return
-	}
+// `COPY source destination [DB destination-db] [REPLACE]`
+func (s *Server) handleCopyCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
 
-	argVal := msg.Array[1]
-	if argVal.Type != resp.BulkString {
-		conn.Write([]byte(resp.EncodeSimpleErr("Argument to `ECHO` command must be bulk string type")))
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `COPY` command")
 		return
 	}
 
-	conn.Write([]byte(resp.EncodeBulkString(argVal.String)))
-}
-
-func (s *Server) handleGetCommand(conn net.Conn, msg *resp.Message) {
-	if len(msg.Array) <= 1 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `GET` command")))
+	src, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s COPY: invalid source: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid source type for `COPY` command")
 		return
 	}
 
-	keyMsg := msg.Array[1]
-
-	key, err := keyMsg.ConvStr()
+	dest, err := msg.Array[2].ConvStr()
 	if err != nil {
-		log.Printf("%s: GET: invalid key: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid key type for `GET` command")))
+		log.Printf("%s COPY: invalid destination: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid destination type for `COPY` command")
 		return
 	}
 
-	record, exists := s.store.Get(key)
-	if !exists {
-		conn.Write([]byte(resp.EncodeNullBulkString()))
-		return
+	replace := false
+	for i := 3; i < len(msg.Array); i++ {
+		switch strings.ToUpper(msg.Array[i].String) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			// There is only the one database
+			if i+1 >= len(msg.Array) || msg.Array[i+1].String != "0" {
+				w.WriteError("ERR DB index is out of range")
+				return
+			}
+			i++
+		default:
+			w.WriteError("ERR syntax error")
+			return
+		}
 	}
 
-	respVal, err := toRESPString(record)
-	if err != nil {
-		log.Printf("%s: GET: resp string: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Unable to retrieve SET value")))
+	if src == dest {
+		w.WriteError("ERR source and destination objects are the same")
+		return
 	}
 
-	conn.Write([]byte(respVal))
+	s.store.Atomically(func(tx *store.Tx) {
+		if _, exists := tx.Get(dest); exists && !replace {
+			w.WriteInt(0)
+			return
+		}
+
+		record, exists := tx.Copy(src, dest)
+		if !exists {
+			w.WriteInt(0)
+			return
+		}
+
+		s.notifyKeyReady(dest, record)
+		w.WriteInt(1)
+	})
 }
 
-func (s *Server) handleKeysCommand(conn net.Conn, msg *resp.Message) {
-	if len(msg.Array) != 2 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `KEYS` command")))
-		return
-	}
+func (s *Server) handleDbsizeCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
 
-	patternMsg := msg.Array[1]
-	if patternMsg.Type != resp.SimpleString && patternMsg.Type != resp.BulkString {
-		conn.Write([]byte(resp.EncodeSimpleErr("`KEYS` pattern must be a string, i.e. '*'")))
+	if len(msg.Array) != 1 {
+		w.WriteError("ERR Incorrect amount of args for `DBSIZE` command")
 		return
 	}
 
-	pattern := patternMsg.String
+	w.WriteInt(int64(s.store.Len()))
+}
 
-	result := make([]string, 0, len(msg.Array)*2)
-	for _, k := range s.store.Keys() {
-		match, err := filepath.Match(pattern, k)
-		if err != nil {
-			conn.Write([]byte(resp.EncodeSimpleErr("Error matching pattern for `KEYS` command")))
-		}
+// `DEL key [key ...]`
+func (s *Server) handleDelCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
 
-		if match {
-			result = append(result, resp.EncodeBulkString(k))
-		}
+	if len(msg.Array) < 2 {
+		w.WriteError("ERR Incorrect amount of args for `DEL` command")
+		return
 	}
 
-	conn.Write([]byte(resp.EncodeArray(len(result), result...)))
+	s.store.Atomically(func(tx *store.Tx) {
+		deleted := 0
+		for _, key := range messageStrings(msg.Array[1:]) {
+			if tx.Delete(key) {
+				deleted++
+			}
+		}
+
+		w.WriteInt(int64(deleted))
+	})
 }
 
-func (s *Server) handleLlenCommand(conn net.Conn, msg *resp.Message) {
+func (s *Server) handleEchoCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
 	if len(msg.Array) != 2 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `LLEN` command")))
+		w.WriteError("ERR Incorrect amount of args for `ECHO` command")
 		return
 	}
 
-	keyMsg := msg.Array[1]
-
-	key, err := keyMsg.ConvStr()
-	if err != nil {
-		log.Printf("%s: LLEN: invalid key name: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid key name type for `LLEN` command")))
+	argVal := msg.Array[1]
+	if argVal.Type != resp.BulkString {
+		w.WriteError("ERR Argument to `ECHO` command must be bulk string type")
 		return
 	}
 
-	record, exists := s.store.Get(key)
-	if !exists {
-		conn.Write([]byte(resp.EncodeInteger(0)))
-		return
-	}
+	w.WriteBulkString(argVal.String)
+}
 
-	var length int
-	switch record.Type {
-	case store.ArrayType, store.SetType:
-		length = len(record.Array)
-	case store.MapType:
-		length = len(record.Map)
-	default:
-		log.Printf("%s: LLEN: invalid type: %s", ErrCmdPrefix, record.Type.String())
-		conn.Write([]byte(resp.EncodeSimpleErr("Provided `LLEN` Key produced non-keyed type")))
+// Handles EXISTS and TOUCH, which only differ in TOUCH also updating the
+// keys' last access time, something we don't keep track of. A key given
+// more than once is counted every time: `EXISTS key [key ...]`
+func (s *Server) handleExistsCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 2 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
 		return
 	}
 
-	conn.Write([]byte(resp.EncodeInteger(length)))
+	s.store.Atomically(func(tx *store.Tx) {
+		count := 0
+		for _, key := range messageStrings(msg.Array[1:]) {
+			if _, exists := tx.Get(key); exists {
+				count++
+			}
+		}
+
+		w.WriteInt(int64(count))
+	})
 }
 
-func (s *Server) handleLpopCommand(conn net.Conn, msg *resp.Message) {
-	if len(msg.Array) < 2 || len(msg.Array) > 3 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `LPOP` command")))
+// Handles EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT, which only differ in the
+// unit of their time argument and whether it is relative to now:
+// `EXPIRE key seconds [NX|XX|GT|LT]`
+func (s *Server) handleExpireCommand(conn net.Conn, msg *resp.Message, unit time.Duration, absolute bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 3 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
 		return
 	}
 
-	keyMsg := msg.Array[1]
-
-	key, err := keyMsg.ConvStr()
+	key, err := msg.Array[1].ConvStr()
 	if err != nil {
-		log.Printf("%s: LPOP: invalid key name: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid key name type for `LPOP` command")))
+		log.Printf("%s %s: invalid key: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError(fmt.Sprintf("ERR Invalid key type for `%s` command", cmd))
 		return
 	}
 
-	record, exists := s.store.Get(key)
-	if !exists {
-		log.Printf("%s: LPOP: does not exists: %s", ErrCmdPrefix, key)
-		conn.Write([]byte(resp.EncodeNulls()))
+	amount, err := strconv.ParseInt(msg.Array[2].String, 10, 64)
+	if err != nil {
+		log.Printf("%s %s: time parse: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR value is not an integer or out of range")
 		return
 	}
 
-	if record.Type != store.ArrayType {
-		log.Printf("%s: LPOP: invalid type: %s", ErrCmdPrefix, record.Type.String())
-		conn.Write([]byte(resp.EncodeSimpleErr("Provided `LPOP` Key produced non array/list type")))
+	// Relative times go through a time.Duration, which only spans ~292 years,
+	// and absolute ones must fit a Unix time in milliseconds like Redis'
+	scale := int64(unit)
+	if absolute {
+		scale = int64(unit / time.Millisecond)
+	}
+	if amount > math.MaxInt64/scale || amount < math.MinInt64/scale {
+		w.WriteError(fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(cmd)))
 		return
 	}
 
-	list := record.Array
-
-	if len(list) == 0 {
-		conn.Write([]byte(resp.EncodeNulls()))
+	conds, err := parseExpireConditions(msg.Array[3:])
+	if err != nil {
+		log.Printf("%s %s: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR " + err.Error())
 		return
 	}
 
-	count := 1
-	if len(msg.Array) == 3 {
-		countMsg := msg.Array[2]
-		count, err = countMsg.ConvInt()
-		if err != nil {
-			log.Printf("%s: LPOP: count parse: %v", ErrCmdPrefix, err)
-			conn.Write([]byte(resp.EncodeSimpleErr("Unable to parse `LPOP` [count] arg")))
-			return
-		}
-
-		if count < 0 {
-			conn.Write([]byte(resp.EncodeSimpleErr("`LPOP` [count] arg must be positive")))
-			return
-		}
+	var at time.Time
+	if absolute {
+		perSecond := int64(time.Second / unit)
+		at = time.Unix(amount/perSecond, amount%perSecond*int64(unit))
+	} else {
+		at = time.Now().Add(time.Duration(amount) * unit)
 	}
 
-	count = min(len(list), count)
+	if !s.store.Expire(key, at, conds...) {
+		w.WriteInt(0)
+		return
+	}
 
-	poppedSlice := list[0:count]
-	record.Array = list[count:]
+	w.WriteInt(1)
+}
 
-	s.store.Set(key, record)
+// Handles FLUSHALL and FLUSHDB, which are one and the same with a single
+// database: `FLUSHALL [ASYNC | SYNC]`
+func (s *Server) handleFlushCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
 
-	toResp, err := toBulkRESPString(poppedSlice)
-	if err != nil {
-		log.Printf("%s: LPOP: to resp string: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Unable to output popped array")))
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) > 2 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
 		return
 	}
 
-	conn.Write([]byte(resp.EncodeArray(len(toResp), toResp...)))
+	async := false
+	if len(msg.Array) == 2 {
+		switch strings.ToUpper(msg.Array[1].String) {
+		case "ASYNC":
+			async = true
+		case "SYNC":
+		default:
+			w.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	s.store.Flush(async)
+	w.WriteSimpleString("OK")
 }
 
-func (s *Server) handleLpushCommand(conn net.Conn, msg *resp.Message) {
-	if len(msg.Array) <= 2 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `LPUSH` command")))
+func (s *Server) handleGetCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) <= 1 {
+		w.WriteError("ERR Incorrect amount of args for `GET` command")
 		return
 	}
 
 	keyMsg := msg.Array[1]
-	valMsgs := msg.Array[2:]
 
 	key, err := keyMsg.ConvStr()
 	if err != nil {
-		log.Printf("%s: LPUSH: invalid key name: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid key name type for `LPUSH` command")))
+		log.Printf("%s: GET: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `GET` command")
 		return
 	}
 
-	record, exists := s.store.Get(key)
-	if !exists {
-		record = &store.Record{
-			Type:  store.ArrayType,
-			Array: make([]*store.Record, 0, len(valMsgs)),
+	s.store.View(key, func(record *store.Record, exists bool) {
+		if !exists {
+			w.WriteNull()
+			return
 		}
-	}
 
-	if record.Type != store.ArrayType {
-		log.Printf("%s: LPUSH: invalid type: %s", ErrCmdPrefix, record.Type.String())
-		conn.Write([]byte(resp.EncodeSimpleErr("Provided `LPUSH` Key produced non array/list type")))
-		return
-	}
+		writeRESPRecord(w, record)
+	})
+}
 
-	// NOTE: Can improve perf by iterating over half the slice/array instead and swapping
-	// values with the current index and the 0th + (len - i)th index. Though in practical
-	// situations this will probably be negligible.
-	newVals := make([]*store.Record, len(valMsgs))
-	for i := len(valMsgs) - 1; i >= 0; i-- {
-		v := valMsgs[i]
-		valRecord, err := fromRESP(v, time.Time{})
-		if err != nil {
-			log.Printf("%s LPUSH: value iter: %v", ErrCmdPrefix, err)
+func (s *Server) handleGetdelCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `GETDEL` command")
+		return
+	}
+
+	s.updateKey(w, msg, store.StringType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteNull()
+			return nil, false
 		}
-		newVals[len(valMsgs)-1-i] = valRecord
+
+		w.WriteBulkString(record.String)
+		return nil, true
+	})
+}
+
+// `GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | PERSIST]`
+func (s *Server) handleGetexCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 2 {
+		w.WriteError("ERR Incorrect amount of args for `GETEX` command")
+		return
 	}
 
-	record.Array = append(newVals, record.Array...)
+	expiry, persist, err := parseGETEXOptions(msg.Array[2:])
+	if err != nil {
+		log.Printf("%s GETEX: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
 
-	s.store.Set(key, record)
-	s.blockingManager.NotifyWatchers(key, record)
+	s.updateKey(w, msg, store.StringType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteNull()
+			return nil, false
+		}
+
+		w.WriteBulkString(record.String)
+		switch {
+		case persist:
+			record.ExpiresAt = time.Time{}
+		case !expiry.IsZero() && !expiry.After(time.Now()):
+			// An absolute time in the past expires the key right away
+			return nil, true
+		case !expiry.IsZero():
+			record.ExpiresAt = expiry
+		}
 
-	conn.Write([]byte(resp.EncodeInteger(len(record.Array))))
+		return record, true
+	})
 }
 
-// NOTE: Redis seems to default to an empty array when indices are out of bounds
-// or when the end index is smaller than the start index. Supporting this for now
-// but I feel like it'd be a good idea to let the user know that they've made a
-// mistake rather than think they just have an empty array/list in their store.
-func (s *Server) handleLrangeCommand(conn net.Conn, msg *resp.Message) {
+// `GETRANGE key start end`
+//
+// Both ends are inclusive and may be negative to count from the end. Unlike
+// list ranges, an end before the first byte is clamped to it.
+func (s *Server) handleGetrangeCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
 	if len(msg.Array) != 4 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `LRANGE` command")))
+		w.WriteError("ERR Incorrect amount of args for `GETRANGE` command")
 		return
 	}
 
-	keyMsg := msg.Array[1]
-	startIdxMsg := msg.Array[2]
-	endIdxMsg := msg.Array[3]
+	start, errStart := msg.Array[2].ConvInt()
+	end, errEnd := msg.Array[3].ConvInt()
+	if errStart != nil || errEnd != nil {
+		w.WriteError("ERR value is not an integer or out of range")
+		return
+	}
 
-	key, err := keyMsg.ConvStr()
-	if err != nil {
-		log.Printf("%s: LRANGE: invalid key name: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid key name type for `LRANGE` command")))
+	s.viewKey(w, msg, store.StringType, func(record *store.Record) {
+		if record == nil || len(record.String) == 0 || (start < 0 && end < 0 && start > end) {
+			w.WriteBulkString("")
+			return
+		}
+
+		str := record.String
+		if start < 0 {
+			start += len(str)
+		}
+		if end < 0 {
+			end += len(str)
+		}
+
+		start, end = max(start, 0), min(max(end, 0), len(str)-1)
+		if start > end {
+			w.WriteBulkString("")
+			return
+		}
+
+		w.WriteBulkString(str[start : end+1])
+	})
+}
+
+func (s *Server) handleHdelCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `HDEL` command")
 		return
 	}
 
-	record, exists := s.store.Get(key)
-	if !exists {
-		conn.Write([]byte(resp.EncodeArray(0, "")))
+	s.updateKey(w, msg, store.MapType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteInt(0)
+			return nil, false
+		}
+
+		deleted := 0
+		for _, m := range msg.Array[2:] {
+			if record.DeleteField(m.String) {
+				deleted++
+			}
+		}
+
+		w.WriteInt(int64(deleted))
+		return record, true
+	})
+}
+
+// `HELLO [protover [AUTH username password] [SETNAME clientname]]`
+//
+// Switches the connection to the given protocol, replying with a map about
+// the server in it. Without a protover the protocol stays as it is.
+func (s *Server) handleHelloCommand(c *client, msg *resp.Message) {
+	protocol := c.w.Protocol
+	name := c.name
+
+	args := msg.Array[1:]
+	if len(args) > 0 {
+		version, err := args[0].ConvInt()
+		if err != nil {
+			c.w.WriteError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+
+		if version != resp.RESP2 && version != resp.RESP3 {
+			c.w.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		protocol = version
+
+		for i := 1; i < len(args); i++ {
+			opt := strings.ToUpper(args[i].String)
+			switch {
+			case opt == "AUTH" && i+2 < len(args):
+				// Without ACLs there's only the default user, which has no
+				// password to check against
+				if args[i+1].String != "default" {
+					c.w.WriteError("WRONGPASS invalid username-password pair or user is disabled.")
+					return
+				}
+				i += 2
+			case opt == "SETNAME" && i+1 < len(args):
+				name = args[i+1].String
+				if strings.ContainsAny(name, " \n") {
+					c.w.WriteError("ERR Client names cannot contain spaces, newlines or special characters.")
+					return
+				}
+				i++
+			default:
+				c.w.WriteError(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", args[i].String))
+				return
+			}
+		}
+	}
+
+	// The reply itself is already in the new protocol
+	c.w.Protocol = protocol
+	c.name = name
+
+	c.w.WriteMapHeader(7)
+	c.w.WriteBulkString("server")
+	c.w.WriteBulkString("redis")
+	c.w.WriteBulkString("version")
+	c.w.WriteBulkString(serverVersion)
+	c.w.WriteBulkString("proto")
+	c.w.WriteInt(int64(protocol))
+	c.w.WriteBulkString("id")
+	c.w.WriteInt(c.id)
+	c.w.WriteBulkString("mode")
+	c.w.WriteBulkString("standalone")
+	c.w.WriteBulkString("role")
+	c.w.WriteBulkString("master")
+	c.w.WriteBulkString("modules")
+	c.w.WriteArrayHeader(0)
+}
+
+func (s *Server) handleHexistsCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 3 {
+		w.WriteError("ERR Incorrect amount of args for `HEXISTS` command")
 		return
 	}
 
-	if record.Type != store.ArrayType {
-		log.Printf("%s: LRANGE: invalid type: %s", ErrCmdPrefix, record.Type.String())
-		conn.Write([]byte(resp.EncodeSimpleErr("Provided `LRANGE` Key produced non array/list type")))
+	s.viewKey(w, msg, store.MapType, func(record *store.Record) {
+		if record == nil {
+			w.WriteInt(0)
+			return
+		}
+
+		if _, ok := record.Map[msg.Array[2].String]; ok {
+			w.WriteInt(1)
+			return
+		}
+
+		w.WriteInt(0)
+	})
+}
+
+// Handles HEXPIRE, HPEXPIRE, HEXPIREAT and HPEXPIREAT, which only differ in
+// the unit of their time argument and whether it is relative to now:
+// `HEXPIRE key seconds [NX|XX|GT|LT] FIELDS numfields field [field ...]`
+func (s *Server) handleHexpireCommand(conn net.Conn, msg *resp.Message, unit time.Duration, absolute bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 6 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
 		return
 	}
 
-	startIdx, err := startIdxMsg.ConvInt()
+	key, err := msg.Array[1].ConvStr()
 	if err != nil {
-		log.Printf("%s: LRANGE: err converting starting index to int: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid start index type for `LRANGE` command")))
+		log.Printf("%s %s: invalid key: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError(fmt.Sprintf("ERR Invalid key type for `%s` command", cmd))
 		return
 	}
 
-	endIdx, err := endIdxMsg.ConvInt()
+	amount, err := strconv.ParseInt(msg.Array[2].String, 10, 64)
 	if err != nil {
-		log.Printf("%s: LRANGE: err converting ending index to int: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid end index type for `LRANGE` command")))
+		log.Printf("%s %s: time parse: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR value is not an integer or out of range")
 		return
 	}
 
-	recArr := record.Array
-	startIdx = NormalizeIndex(startIdx, len(recArr))
-	endIdx = NormalizeIndex(endIdx, len(recArr))
-
-	if startIdx >= len(recArr) || endIdx < startIdx {
-		conn.Write([]byte(resp.EncodeArray(0, "")))
+	if amount < 0 || amount > math.MaxInt64/int64(unit) {
+		w.WriteError(fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(cmd)))
 		return
 	}
 
-	if endIdx >= len(recArr) {
-		endIdx = len(recArr) - 1
+	rest := msg.Array[3:]
+	cond, hasCond := parseExpireCondition(rest[0].String)
+	if hasCond {
+		rest = rest[1:]
 	}
 
-	// NOTE: Redis' end index is inclusive, whereas Go's is not, ergo the +1
-	endIdx += 1
-
-	toResp, err := toBulkRESPString(recArr[startIdx:endIdx])
+	fields, err := parseHashFieldsArg(rest)
 	if err != nil {
-		log.Printf("%s: LRANGE: to resp string: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Unable to output array")))
+		log.Printf("%s %s: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR Invalid FIELDS block, expected `FIELDS numfields field [field ...]`")
 		return
 	}
 
-	conn.Write([]byte(resp.EncodeArray(len(toResp), toResp...)))
-}
+	var at time.Time
+	if absolute {
+		at = time.Unix(0, 0).Add(time.Duration(amount) * unit)
+	} else {
+		at = time.Now().Add(time.Duration(amount) * unit)
+	}
 
-func (s *Server) handleRpushCommand(conn net.Conn, msg *resp.Message) {
-	if len(msg.Array) <= 2 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `RPUSH` command")))
+	statuses, err := s.store.ExpireHashFields(key, fields, at, cond)
+	if err != nil {
+		log.Printf("%s %s: expire fields: %v", ErrCmdPrefix, cmd, err)
+		w.WriteWrongTypeErr()
 		return
 	}
 
-	keyMsg := msg.Array[1]
-	valMsgs := msg.Array[2:]
+	w.WriteArrayHeader(len(statuses))
+	for _, status := range statuses {
+		w.WriteInt(int64(status))
+	}
+}
 
-	key, err := keyMsg.ConvStr()
-	if err != nil {
-		log.Printf("%s: RPUSH: invalid key name: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid key name type for `RPUSH` command")))
+func (s *Server) handleHgetallCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `HGETALL` command")
 		return
 	}
 
-	record, exists := s.store.Get(key)
-	if !exists {
-		record = &store.Record{
-			Type:  store.ArrayType,
-			Array: make([]*store.Record, 0, len(valMsgs)),
+	s.viewKey(w, msg, store.MapType, func(record *store.Record) {
+		if record == nil {
+			w.WriteMapHeader(0)
+			return
 		}
-	}
 
-	if record.Type != store.ArrayType {
-		log.Printf("%s: RPUSH: invalid type: %s", ErrCmdPrefix, record.Type.String())
-		conn.Write([]byte(resp.EncodeSimpleErr("Provided `RPUSH` Key produced non array/list type")))
+		w.WriteMapHeader(len(record.Map))
+		for field, value := range record.Map {
+			w.WriteBulkString(field)
+			w.WriteBulkString(value.String)
+		}
+	})
+}
+
+func (s *Server) handleHgetCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 3 {
+		w.WriteError("ERR Incorrect amount of args for `HGET` command")
 		return
 	}
 
-	for _, v := range valMsgs {
-		valRecord, err := fromRESP(v, time.Time{})
-		if err != nil {
-			log.Printf("%s RPUSH: value iter: %v", ErrCmdPrefix, err)
+	s.viewKey(w, msg, store.MapType, func(record *store.Record) {
+		if record == nil {
+			w.WriteNull()
+			return
 		}
-		record.Array = append(record.Array, valRecord)
-	}
 
-	s.store.Set(key, record)
-	s.blockingManager.NotifyWatchers(key, record)
+		value, ok := record.Map[msg.Array[2].String]
+		if !ok {
+			w.WriteNull()
+			return
+		}
 
-	conn.Write([]byte(resp.EncodeInteger(len(record.Array))))
+		w.WriteBulkString(value.String)
+	})
 }
 
-func (s *Server) handleSetCommand(conn net.Conn, msg *resp.Message) {
-	if len(msg.Array) <= 2 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `SET` command")))
+func (s *Server) handleHincrbyCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `HINCRBY` command")
 		return
 	}
 
-	keyMsg := msg.Array[1]
-	valMsg := msg.Array[2]
-
-	key, err := keyMsg.ConvStr()
+	incr, err := strconv.ParseInt(msg.Array[3].String, 10, 64)
 	if err != nil {
-		log.Printf("%s: SET: invalid key: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid key type for `SET` command")))
+		log.Printf("%s HINCRBY: increment parse: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR value is not an integer or out of range")
 		return
 	}
 
-	opts := &SetOptions{}
-	if len(msg.Array) > 3 {
-		opts, err = parseSETOptions(msg.Array[3:])
-		if err != nil {
-			log.Println(err)
-			conn.Write([]byte(resp.EncodeSimpleErr("Invalid data type for `SET` command option")))
-			return
+	s.updateKey(w, msg, store.MapType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			record = &store.Record{Type: store.MapType}
+		}
+
+		field := msg.Array[2].String
+		var current int64
+		if value, ok := record.Map[field]; ok {
+			current, err = strconv.ParseInt(value.String, 10, 64)
+			if err != nil {
+				w.WriteError("ERR hash value is not an integer")
+				return nil, false
+			}
 		}
-	}
 
-	if opts.KEEPTTL {
-		if record, exists := s.store.Get(key); exists {
-			opts.Expiry = record.ExpiresAt
+		if (incr > 0 && current > math.MaxInt64-incr) || (incr < 0 && current < math.MinInt64-incr) {
+			w.WriteError("ERR increment or decrement would overflow")
+			return nil, false
 		}
+
+		current += incr
+		setHashField(record, field, strconv.FormatInt(current, 10))
+
+		w.WriteInt(current)
+		return record, true
+	})
+}
+
+func (s *Server) handleHincrbyfloatCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `HINCRBYFLOAT` command")
+		return
 	}
 
-	storeRecordValue, err := fromRESP(valMsg, opts.Expiry)
-	if err != nil {
-		log.Printf("%s SET: store value: %v", ErrCmdPrefix, err)
+	incr, err := strconv.ParseFloat(msg.Array[3].String, 64)
+	if err != nil || math.IsNaN(incr) || math.IsInf(incr, 0) {
+		log.Printf("%s HINCRBYFLOAT: increment parse: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR value is not a valid float")
+		return
 	}
-	s.store.Set(key, storeRecordValue)
 
-	if opts.GET {
-		respVal, err := toRESPString(storeRecordValue)
-		if err != nil {
-			log.Printf("%s: SET: GET value: %v", ErrCmdPrefix, err)
-			conn.Write([]byte(resp.EncodeSimpleErr("Unable to retrieve SET value")))
+	s.updateKey(w, msg, store.MapType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			record = &store.Record{Type: store.MapType}
 		}
-		conn.Write([]byte(respVal))
-	} else {
-		conn.Write([]byte(resp.EncodeSimpleString("OK")))
-	}
-	return
+
+		field := msg.Array[2].String
+		var current float64
+		if value, ok := record.Map[field]; ok {
+			current, err = strconv.ParseFloat(value.String, 64)
+			if err != nil || math.IsNaN(current) {
+				w.WriteError("ERR hash value is not a float")
+				return nil, false
+			}
+		}
+
+		current += incr
+		if math.IsNaN(current) || math.IsInf(current, 0) {
+			w.WriteError("ERR increment would produce NaN or Infinity")
+			return nil, false
+		}
+
+		formatted := strconv.FormatFloat(current, 'f', -1, 64)
+		setHashField(record, field, formatted)
+
+		w.WriteBulkString(formatted)
+		return record, true
+	})
 }
 
-func (s *Server) handleTypeCommand(conn net.Conn, msg *resp.Message) {
+func (s *Server) handleHkeysCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
 	if len(msg.Array) != 2 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `TYPE` command")))
+		w.WriteError("ERR Incorrect amount of args for `HKEYS` command")
 		return
 	}
 
-	keyMsg := msg.Array[1]
+	s.viewKey(w, msg, store.MapType, func(record *store.Record) {
+		if record == nil {
+			w.WriteArrayHeader(0)
+			return
+		}
 
-	key, err := keyMsg.ConvStr()
-	if err != nil {
-		log.Printf("%s: TYPE: invalid key: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid key type for `TYPE` command")))
+		w.WriteArrayHeader(len(record.Map))
+		for field := range record.Map {
+			w.WriteBulkString(field)
+		}
+	})
+}
+
+func (s *Server) handleHlenCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `HLEN` command")
 		return
 	}
 
-	record, _ := s.store.Get(key)
-	var stype string
+	s.viewKey(w, msg, store.MapType, func(record *store.Record) {
+		if record == nil {
+			w.WriteInt(0)
+			return
+		}
+
+		w.WriteInt(int64(len(record.Map)))
+	})
+}
+
+func (s *Server) handleHmgetCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
 
-	switch record.Type {
-	case store.ArrayType:
-		stype = "list"
-	case store.StringType:
-		stype = "string"
-	case store.SetType:
-		stype = "set"
-	case store.StreamType:
-		stype = "stream"
-	default:
-		stype = "none"
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `HMGET` command")
+		return
 	}
-	conn.Write([]byte(resp.EncodeSimpleString(stype)))
+
+	s.viewKey(w, msg, store.MapType, func(record *store.Record) {
+		fields := msg.Array[2:]
+		w.WriteArrayHeader(len(fields))
+		for _, m := range fields {
+			if record == nil {
+				w.WriteNull()
+			} else if value, ok := record.Map[m.String]; ok {
+				w.WriteBulkString(value.String)
+			} else {
+				w.WriteNull()
+			}
+		}
+	})
 }
 
-func (s *Server) handleXaddCommand(conn net.Conn, msg *resp.Message) {
+// `HPERSIST key FIELDS numfields field [field ...]`
+func (s *Server) handleHpersistCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
 	if len(msg.Array) < 5 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Incorrect amount of args for `XADD` command")))
+		w.WriteError("ERR Incorrect amount of args for `HPERSIST` command")
 		return
 	}
 
-	keyMsg := msg.Array[1]
-	idMsg := msg.Array[2]
-	fieldMsgs := msg.Array[3:]
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s HPERSIST: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `HPERSIST` command")
+		return
+	}
 
-	if len(fieldMsgs)%2 != 0 {
-		conn.Write([]byte(resp.EncodeSimpleErr("Every field needs a value in `XADD` command")))
+	fields, err := parseHashFieldsArg(msg.Array[2:])
+	if err != nil {
+		log.Printf("%s HPERSIST: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid FIELDS block, expected `FIELDS numfields field [field ...]`")
 		return
 	}
 
-	id, err := idMsg.ConvStr()
+	statuses, err := s.store.PersistHashFields(key, fields)
 	if err != nil {
-		log.Printf("%s XADD: invalid id: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid id type for `XADD` command")))
+		log.Printf("%s HPERSIST: persist fields: %v", ErrCmdPrefix, err)
+		w.WriteWrongTypeErr()
 		return
 	}
 
-	fields := make([]*store.Record, len(fieldMsgs))
-	for i, f := range fieldMsgs {
-		fieldRec, err := fromRESP(f, time.Time{})
+	w.WriteArrayHeader(len(statuses))
+	for _, status := range statuses {
+		w.WriteInt(int64(status))
+	}
+}
+
+// `HRANDFIELD key [count [WITHVALUES]]`
+//
+// A positive count returns that many distinct fields (capped at the hash's
+// size), a negative one returns exactly |count| fields which may repeat.
+func (s *Server) handleHrandfieldCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 2 || len(msg.Array) > 4 {
+		w.WriteError("ERR Incorrect amount of args for `HRANDFIELD` command")
+		return
+	}
+
+	hasCount := len(msg.Array) >= 3
+	count := 1
+	if hasCount {
+		var err error
+		count, err = msg.Array[2].ConvInt()
 		if err != nil {
-			log.Printf("%s XADD: field iter: %v", ErrCmdPrefix, err)
+			log.Printf("%s HRANDFIELD: count parse: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR value is not an integer or out of range")
+			return
 		}
-		fields[i] = fieldRec
 	}
 
-	key, err := keyMsg.ConvStr()
+	withValues := false
+	if len(msg.Array) == 4 {
+		if strings.ToUpper(msg.Array[3].String) != "WITHVALUES" {
+			w.WriteError("ERR syntax error")
+			return
+		}
+		withValues = true
+	}
+
+	s.viewKey(w, msg, store.MapType, func(record *store.Record) {
+		var fields []string
+		if record != nil {
+			fields = make([]string, 0, len(record.Map))
+			for field := range record.Map {
+				fields = append(fields, field)
+			}
+		}
+
+		if !hasCount {
+			if len(fields) == 0 {
+				w.WriteNull()
+				return
+			}
+
+			w.WriteBulkString(fields[rand.IntN(len(fields))])
+			return
+		}
+
+		var result []string
+		err := pickRandom(fields, count, func(field string) {
+			result = append(result, field)
+			if withValues {
+				result = append(result, record.Map[field].String)
+			}
+		})
+		if err != nil {
+			w.WriteError("ERR " + err.Error())
+			return
+		}
+
+		w.WriteArrayHeader(len(result))
+		for _, r := range result {
+			w.WriteBulkString(r)
+		}
+	})
+}
+
+// `HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]`
+func (s *Server) handleHscanCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `HSCAN` command")
+		return
+	}
+
+	cursor, opts, err := parseScanArgs(msg.Array[2:], HSCAN)
 	if err != nil {
-		log.Printf("%s XADD: invalid key: %v", ErrCmdPrefix, err)
-		conn.Write([]byte(resp.EncodeSimpleErr("Invalid key type for `XADD` command")))
+		log.Printf("%s HSCAN: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
 		return
 	}
 
-	record, exists := s.store.Get(key)
-	if !exists {
-		stream, err := store.NewStream(id, fields)
-		if err != nil {
-			log.Printf("%s XADD: new stream: %v", ErrCmdPrefix, err)
-			conn.Write([]byte(resp.EncodeSimpleErr("Unable to create new stream for `XADD` command")))
+	s.viewKey(w, msg, store.MapType, func(record *store.Record) {
+		if record == nil {
+			writeRESPScan(w, 0, nil)
 			return
 		}
 
-		record = &store.Record{
-			Type:    store.StreamType,
-			Streams: stream,
+		next, fields := record.ScanFields(cursor, opts.Count)
+		result := make([]string, 0, len(fields)*2)
+		for _, f := range fields {
+			if !opts.matches(f) {
+				continue
+			}
+
+			result = append(result, f)
+			if !opts.NoValues {
+				result = append(result, record.Map[f].String)
+			}
 		}
-		s.store.Set(key, record)
-		conn.Write([]byte(resp.EncodeSimpleString(stream.Root.Value.ID)))
+
+		writeRESPScan(w, next, result)
+
+	})
+}
+
+func (s *Server) handleHsetCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 4 || len(msg.Array)%2 != 0 {
+		w.WriteError("ERR Incorrect amount of args for `HSET` command")
 		return
 	}
 
-	if record.Type != store.StreamType {
-		log.Printf("%s XADD: invalid type: %s", ErrCmdPrefix, record.Type.String())
-		conn.Write([]byte(resp.EncodeSimpleErr("Provided `XADD` Key produced non stream type")))
+	s.updateKey(w, msg, store.MapType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			record = &store.Record{Type: store.MapType}
+		}
+
+		added := 0
+		for i := 2; i < len(msg.Array); i += 2 {
+			field := msg.Array[i].String
+			if _, ok := record.Map[field]; !ok {
+				added++
+			}
+			record.SetField(field, &store.Record{Type: store.StringType, String: msg.Array[i+1].String})
+		}
+
+		w.WriteInt(int64(added))
+		return record, true
+	})
+}
+
+func (s *Server) handleHsetnxCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `HSETNX` command")
 		return
 	}
 
-	// record.Streams.Insert(id, fields)
-	/* TODO:
-	*		1. Parse ID and Fields from `idMsg` and `fieldMsgs`
-	*		2. Implement Insert()
-	*		3. Re-set store record.
-	 */
+	s.updateKey(w, msg, store.MapType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			record = &store.Record{Type: store.MapType}
+		}
+
+		field := msg.Array[2].String
+		if _, ok := record.Map[field]; ok {
+			w.WriteInt(0)
+			return nil, false
+		}
+
+		record.SetField(field, &store.Record{Type: store.StringType, String: msg.Array[3].String})
+
+		w.WriteInt(1)
+		return record, true
+	})
+}
+
+func (s *Server) handleHstrlenCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 3 {
+		w.WriteError("ERR Incorrect amount of args for `HSTRLEN` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.MapType, func(record *store.Record) {
+		length := 0
+		if record != nil {
+			if value, ok := record.Map[msg.Array[2].String]; ok {
+				length = len(value.String)
+			}
+		}
+
+		w.WriteInt(int64(length))
+	})
+}
+
+// Handles HTTL, HPTTL, HEXPIRETIME and HPEXPIRETIME, which report either the
+// remaining TTL or the absolute unix expiry of each field in `unit`:
+// `HTTL key FIELDS numfields field [field ...]`
+func (s *Server) handleHttlCommand(conn net.Conn, msg *resp.Message, unit time.Duration, absolute bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 5 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	fields, err := parseHashFieldsArg(msg.Array[2:])
+	if err != nil {
+		log.Printf("%s %s: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR Invalid FIELDS block, expected `FIELDS numfields field [field ...]`")
+		return
+	}
+
+	s.viewKey(w, msg, store.MapType, func(record *store.Record) {
+		w.WriteArrayHeader(len(fields))
+		for _, field := range fields {
+			var value *store.Record
+			ok := false
+			if record != nil {
+				value, ok = record.Map[field]
+			}
+
+			switch {
+			case !ok:
+				w.WriteInt(store.FieldNotFound)
+			case value.ExpiresAt.IsZero():
+				w.WriteInt(store.FieldNoExpiry)
+			case absolute:
+				w.WriteInt(value.ExpiresAt.UnixNano() / int64(unit))
+			default:
+				// Rounded rather than truncated so a fresh `HEXPIRE key 10` reports 10
+				remaining := time.Until(value.ExpiresAt) + unit/2
+				w.WriteInt(int64(max(remaining/unit, 0)))
+			}
+		}
+	})
+
+}
+
+func (s *Server) handleHvalsCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `HVALS` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.MapType, func(record *store.Record) {
+		if record == nil {
+			w.WriteArrayHeader(0)
+			return
+		}
+
+		w.WriteArrayHeader(len(record.Map))
+		for _, value := range record.Map {
+			w.WriteBulkString(value.String)
+		}
+	})
+}
+
+// `INCRBYFLOAT key increment`
+//
+// The addition is carried out with a 64-bit mantissa, like the long doubles
+// Redis uses, so repeated increments don't pick up float64 rounding noise.
+func (s *Server) handleIncrbyfloatCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 3 {
+		w.WriteError("ERR Incorrect amount of args for `INCRBYFLOAT` command")
+		return
+	}
+
+	incr, ok := parseLongDouble(msg.Array[2].String)
+	if !ok {
+		log.Printf("%s INCRBYFLOAT: invalid increment: %q", ErrCmdPrefix, msg.Array[2].String)
+		w.WriteError("ERR value is not a valid float")
+		return
+	}
+
+	s.updateKey(w, msg, store.StringType, func(record *store.Record) (*store.Record, bool) {
+		current := new(big.Float).SetPrec(longDoublePrec)
+		if record != nil {
+			var ok bool
+			if current, ok = parseLongDouble(record.String); !ok {
+				w.WriteError("ERR value is not a valid float")
+				return nil, false
+			}
+		}
+
+		sum := new(big.Float).SetPrec(longDoublePrec).Add(current, incr)
+		if !inLongDoubleRange(sum) {
+			w.WriteError("ERR increment would produce NaN or Infinity")
+			return nil, false
+		}
+
+		if record == nil {
+			record = &store.Record{Type: store.StringType}
+		}
+		record.String = formatLongDouble(sum)
+
+		w.WriteBulkString(record.String)
+		return record, true
+	})
+}
+
+// Handles INCR, DECR, INCRBY and DECRBY, negating the delta for the latter
+// two: `INCRBY key increment`
+func (s *Server) handleIncrCommand(conn net.Conn, msg *resp.Message, negate bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+
+	delta := int64(1)
+	switch CmdName(cmd) {
+	case INCR, DECR:
+		if len(msg.Array) != 2 {
+			w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+			return
+		}
+	default:
+		if len(msg.Array) != 3 {
+			w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+			return
+		}
+
+		var err error
+		delta, err = strconv.ParseInt(msg.Array[2].String, 10, 64)
+		if err != nil {
+			log.Printf("%s %s: increment parse: %v", ErrCmdPrefix, cmd, err)
+			w.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+	}
+
+	if negate {
+		if delta == math.MinInt64 {
+			w.WriteError("ERR decrement would overflow")
+			return
+		}
+		delta = -delta
+	}
+
+	s.updateKey(w, msg, store.StringType, func(record *store.Record) (*store.Record, bool) {
+		var current int64
+		if record != nil {
+			var ok bool
+			if current, ok = parseStoredInt(record.String); !ok {
+				w.WriteError("ERR value is not an integer or out of range")
+				return nil, false
+			}
+		}
+
+		if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+			w.WriteError("ERR increment or decrement would overflow")
+			return nil, false
+		}
+
+		if record == nil {
+			record = &store.Record{Type: store.StringType}
+		}
+		record.String = strconv.FormatInt(current+delta, 10)
+
+		w.WriteInt(current + delta)
+		return record, true
+	})
+}
+
+// `INFO [section [section ...]]`
+// Only the stats section has anything to report for now. Unknown sections
+// are left out of the reply rather than refused, same as Redis.
+func (s *Server) handleInfoCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	sections := map[string]bool{}
+	for _, m := range msg.Array[1:] {
+		sections[strings.ToLower(m.String)] = true
+	}
+
+	var b strings.Builder
+	if len(sections) == 0 || sections["stats"] || sections["default"] || sections["all"] || sections["everything"] {
+		stats := s.store.ExpiryStats()
+		b.WriteString("# Stats\r\n")
+		fmt.Fprintf(&b, "expired_keys:%d\r\n", stats.ExpiredKeys)
+		fmt.Fprintf(&b, "expired_time_cap_reached_count:%d\r\n", stats.TimeCapReached)
+	}
+
+	w.WriteBulkString(b.String())
+}
+
+func (s *Server) handleKeysCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `KEYS` command")
+		return
+	}
+
+	patternMsg := msg.Array[1]
+	if patternMsg.Type != resp.SimpleString && patternMsg.Type != resp.BulkString {
+		w.WriteError("ERR `KEYS` pattern must be a string, i.e. '*'")
+		return
+	}
+
+	pattern := patternMsg.String
+
+	result := make([]string, 0, len(msg.Array)*2)
+	for _, k := range s.store.Keys() {
+		match, err := filepath.Match(pattern, k)
+		if err != nil {
+			w.WriteError("ERR Error matching pattern for `KEYS` command")
+			return
+		}
+
+		if match {
+			result = append(result, k)
+		}
+	}
+
+	w.WriteArrayHeader(len(result))
+	for _, k := range result {
+		w.WriteBulkString(k)
+	}
+}
+
+func (s *Server) handleLindexCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 3 {
+		w.WriteError("ERR Incorrect amount of args for `LINDEX` command")
+		return
+	}
+
+	index, err := msg.Array[2].ConvInt()
+	if err != nil {
+		log.Printf("%s LINDEX: index parse: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+
+	s.viewKey(w, msg, store.ArrayType, func(record *store.Record) {
+		if record == nil {
+			w.WriteNull()
+			return
+		}
+
+		if index < 0 {
+			index += record.List.Len()
+		}
+
+		element, found := record.List.Index(index)
+		if !found {
+			w.WriteNull()
+			return
+		}
+
+		writeRESPRecord(w, element)
+	})
+}
+
+// `LINSERT key BEFORE|AFTER pivot element`
+func (s *Server) handleLinsertCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 5 {
+		w.WriteError("ERR Incorrect amount of args for `LINSERT` command")
+		return
+	}
+
+	var after bool
+	switch strings.ToUpper(msg.Array[2].String) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		w.WriteError("ERR syntax error")
+		return
+	}
+
+	element, err := fromRESP(msg.Array[4], time.Time{})
+	if err != nil {
+		log.Printf("%s LINSERT: element: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid element type for `LINSERT` command")
+		return
+	}
+
+	s.updateKey(w, msg, store.ArrayType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteInt(0)
+			return nil, false
+		}
+
+		pivot := msg.Array[3].String
+		idx := -1
+		for i, r := range record.List.All() {
+			if r.String == pivot {
+				idx = i
+				break
+			}
+		}
+
+		if idx < 0 {
+			w.WriteInt(-1)
+			return nil, false
+		}
+
+		if after {
+			idx++
+		}
+
+		record.List.Insert(idx, element)
+
+		w.WriteInt(int64(record.List.Len()))
+		return record, true
+	})
+}
+
+func (s *Server) handleLlenCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `LLEN` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.ArrayType, func(record *store.Record) {
+		if record == nil {
+			w.WriteInt(0)
+			return
+		}
+
+		w.WriteInt(int64(record.List.Len()))
+	})
+}
+
+// `LMOVE source destination LEFT|RIGHT LEFT|RIGHT`
+func (s *Server) handleLmoveCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 5 {
+		w.WriteError("ERR Incorrect amount of args for `LMOVE` command")
+		return
+	}
+
+	fromTail, okFrom := parseListEnd(msg.Array[3].String)
+	toTail, okTo := parseListEnd(msg.Array[4].String)
+	if !okFrom || !okTo {
+		w.WriteError("ERR syntax error")
+		return
+	}
+
+	element, err := s.moveListElement(msg.Array[1].String, msg.Array[2].String, fromTail, toTail)
+	if err != nil {
+		log.Printf("%s LMOVE: %v", ErrCmdPrefix, err)
+		w.WriteWrongTypeErr()
+		return
+	}
+
+	if element == nil {
+		w.WriteNull()
+		return
+	}
+
+	writeRESPRecord(w, element)
+}
+
+// `LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]`
+func (s *Server) handleLmpopCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 4 {
+		w.WriteError("ERR Incorrect amount of args for `LMPOP` command")
+		return
+	}
+
+	opts, err := parseMPOPOptions(msg.Array[1:], "LEFT", "RIGHT")
+	if err != nil {
+		log.Printf("%s LMPOP: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	key, popped, err := s.popLists(opts.Keys, opts.FromTail, opts.Count)
+	if err != nil {
+		log.Printf("%s LMPOP: %v", ErrCmdPrefix, err)
+		w.WriteWrongTypeErr()
+		return
+	}
+
+	if key == "" {
+		w.WriteNullArray()
+		return
+	}
+
+	w.WriteArrayHeader(2)
+	w.WriteBulkString(key)
+	writeRESPRecords(w, popped)
+}
+
+func (s *Server) handleLpopCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 2 || len(msg.Array) > 3 {
+		w.WriteError("ERR Incorrect amount of args for `LPOP` command")
+		return
+	}
+
+	count := 1
+	if len(msg.Array) == 3 {
+		var err error
+		countMsg := msg.Array[2]
+		count, err = countMsg.ConvInt()
+		if err != nil {
+			log.Printf("%s: LPOP: count parse: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR Unable to parse `LPOP` [count] arg")
+			return
+		}
+
+		if count < 0 {
+			w.WriteError("ERR `LPOP` [count] arg must be positive")
+			return
+		}
+	}
+
+	s.updateKey(w, msg, store.ArrayType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			if len(msg.Array) > 2 {
+				w.WriteNullArray()
+				return nil, false
+			}
+			w.WriteNull()
+			return nil, false
+		}
+
+		poppedSlice := popList(record, count, false)
+
+		// Without a count, the element itself is replied with rather than an array
+		if len(msg.Array) == 2 {
+			writeRESPRecord(w, poppedSlice[0])
+
+			return record, true
+		}
+
+		writeRESPRecords(w, poppedSlice)
+		return record, true
+	})
+}
+
+// `LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]`
+//
+// A negative RANK searches from the tail, and MAXLEN bounds how many
+// elements are compared in total.
+func (s *Server) handleLposCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `LPOS` command")
+		return
+	}
+
+	opts, err := parseLPOSOptions(msg.Array[3:])
+	if err != nil {
+		log.Printf("%s LPOS: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	s.viewKey(w, msg, store.ArrayType, func(record *store.Record) {
+		var list *store.List
+		if record != nil {
+			list = record.List
+		}
+
+		element := msg.Array[2].String
+		elems, skip := list.All(), opts.Rank-1
+		if opts.Rank < 0 {
+			elems, skip = list.Backward(), -opts.Rank-1
+		}
+
+		matches := []int{}
+		compared := 0
+		for idx, e := range elems {
+			if opts.MaxLen > 0 && compared >= opts.MaxLen {
+				break
+			}
+			compared++
+
+			if e.String != element {
+				continue
+			}
+
+			if skip > 0 {
+				skip--
+				continue
+			}
+
+			matches = append(matches, idx)
+			if !opts.HasCount || (opts.Count > 0 && len(matches) == opts.Count) {
+				break
+			}
+		}
+
+		if opts.HasCount {
+			w.WriteArrayHeader(len(matches))
+			for _, idx := range matches {
+				w.WriteInt(int64(idx))
+			}
+			return
+		}
+
+		if len(matches) == 0 {
+			w.WriteNull()
+			return
+		}
+
+		w.WriteInt(int64(matches[0]))
+	})
+
+}
+
+func (s *Server) handleLpushCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) <= 2 {
+		w.WriteError("ERR Incorrect amount of args for `LPUSH` command")
+		return
+	}
+
+	key := msg.Array[1].String
+	valMsgs := msg.Array[2:]
+
+	s.updateKey(w, msg, store.ArrayType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			record = &store.Record{
+				Type: store.ArrayType,
+				List: store.NewList(),
+			}
+		}
+
+		// Each value is pushed onto the head in turn, which leaves them reversed
+		for _, v := range valMsgs {
+			valRecord, err := fromRESP(v, time.Time{})
+			if err != nil {
+				log.Printf("%s LPUSH: value iter: %v", ErrCmdPrefix, err)
+			}
+			record.List.PushFront(valRecord)
+		}
+
+		s.blockingManager.NotifyWatchers(key, record)
+
+		w.WriteInt(int64(record.List.Len()))
+		return record, true
+	})
+}
+
+// NOTE: Redis seems to default to an empty array when indices are out of bounds
+// or when the end index is smaller than the start index. Supporting this for now
+// but I feel like it'd be a good idea to let the user know that they've made a
+// mistake rather than think they just have an empty array/list in their store.
+func (s *Server) handleLrangeCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `LRANGE` command")
+		return
+	}
+
+	startIdxMsg := msg.Array[2]
+	endIdxMsg := msg.Array[3]
+
+	startIdx, err := startIdxMsg.ConvInt()
+	if err != nil {
+		log.Printf("%s: LRANGE: err converting starting index to int: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid start index type for `LRANGE` command")
+		return
+	}
+
+	endIdx, err := endIdxMsg.ConvInt()
+	if err != nil {
+		log.Printf("%s: LRANGE: err converting ending index to int: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid end index type for `LRANGE` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.ArrayType, func(record *store.Record) {
+		if record == nil {
+			w.WriteArrayHeader(0)
+			return
+		}
+
+		length := record.List.Len()
+		startIdx = NormalizeIndex(startIdx, length)
+		endIdx = NormalizeIndex(endIdx, length)
+
+		if startIdx >= length || endIdx < startIdx {
+			w.WriteArrayHeader(0)
+			return
+		}
+
+		if endIdx >= length {
+			endIdx = length - 1
+		}
+
+		writeRESPRecords(w, record.List.Range(startIdx, endIdx))
+	})
+}
+
+// `LREM key count element`
+//
+// A positive count removes that many matches from the head, a negative one
+// from the tail and 0 removes every match.
+func (s *Server) handleLremCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `LREM` command")
+		return
+	}
+
+	count, err := msg.Array[2].ConvInt()
+	if err != nil {
+		log.Printf("%s LREM: count parse: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+
+	element := msg.Array[3].String
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
+
+	s.updateKey(w, msg, store.ArrayType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteInt(0)
+			return nil, false
+		}
+
+		removed := record.List.RemoveFunc(func(r *store.Record) bool { return r.String == element }, limit, count < 0)
+
+		w.WriteInt(int64(removed))
+		return record, true
+	})
+}
+
+func (s *Server) handleLsetCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `LSET` command")
+		return
+	}
+
+	index, err := msg.Array[2].ConvInt()
+	if err != nil {
+		log.Printf("%s LSET: index parse: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+
+	element, err := fromRESP(msg.Array[3], time.Time{})
+	if err != nil {
+		log.Printf("%s LSET: element: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid element type for `LSET` command")
+		return
+	}
+
+	s.updateKey(w, msg, store.ArrayType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteError("ERR no such key")
+			return nil, false
+		}
+
+		if index < 0 {
+			index += record.List.Len()
+		}
+
+		if !record.List.Set(index, element) {
+			w.WriteError("ERR index out of range")
+			return nil, false
+		}
+
+		w.WriteSimpleString("OK")
+		return record, true
+	})
+}
+
+// `LTRIM key start stop`
+func (s *Server) handleLtrimCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `LTRIM` command")
+		return
+	}
+
+	start, errStart := msg.Array[2].ConvInt()
+	stop, errStop := msg.Array[3].ConvInt()
+	if errStart != nil || errStop != nil {
+		w.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+
+	s.updateKey(w, msg, store.ArrayType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteSimpleString("OK")
+			return nil, false
+		}
+
+		start, stop, nonEmpty := normalizeRankRange(start, stop, record.List.Len())
+		stop = min(stop, record.List.Len()-1)
+		if !nonEmpty {
+			start, stop = 0, -1
+		}
+		record.List.Trim(start, stop)
+
+		w.WriteSimpleString("OK")
+		return record, true
+	})
+}
+
+// `MGET key [key ...]`
+//
+// Keys that are missing or don't hold a string reply with a null.
+func (s *Server) handleMgetCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 2 {
+		w.WriteError("ERR Incorrect amount of args for `MGET` command")
+		return
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		w.WriteArrayHeader(len(msg.Array) - 1)
+		for _, keyMsg := range msg.Array[1:] {
+			record, exists := tx.Get(keyMsg.String)
+			if !exists || record.Type != store.StringType {
+				w.WriteNull()
+				continue
+			}
+
+			w.WriteBulkString(record.String)
+		}
+	})
+}
+
+// Handles MSET and MSETNX, the latter only writing when none of the keys
+// exist. Either way the keys are written under a single lock:
+// `MSET key value [key value ...]`
+func (s *Server) handleMsetCommand(conn net.Conn, msg *resp.Message, nx bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 3 || len(msg.Array)%2 == 0 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	// Later duplicates of a key overwrite earlier ones, as they would if
+	// the pairs were set one by one
+	records := make(map[string]*store.Record, (len(msg.Array)-1)/2)
+	for i := 1; i < len(msg.Array); i += 2 {
+		record, err := fromRESP(msg.Array[i+1], time.Time{})
+		if err != nil {
+			log.Printf("%s %s: value at pos (%d): %v", ErrCmdPrefix, cmd, i+1, err)
+			w.WriteError(fmt.Sprintf("ERR Invalid value type for `%s` command", cmd))
+			return
+		}
+		records[msg.Array[i].String] = record
+	}
+
+	written := s.store.SetMany(records, nx)
+
+	if !nx {
+		w.WriteSimpleString("OK")
+		return
+	}
+
+	if !written {
+		w.WriteInt(0)
+		return
+	}
+	w.WriteInt(1)
+}
+
+// `PERSIST key`
+func (s *Server) handlePersistCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `PERSIST` command")
+		return
+	}
+
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s PERSIST: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `PERSIST` command")
+		return
+	}
+
+	if !s.store.Persist(key) {
+		w.WriteInt(0)
+		return
+	}
+
+	w.WriteInt(1)
+}
+
+// Handles LPUSHX and RPUSHX, which only push onto lists that already exist:
+// `LPUSHX key element [element ...]`
+func (s *Server) handlePushxCommand(conn net.Conn, msg *resp.Message, toTail bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 3 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	key := msg.Array[1].String
+	s.updateKey(w, msg, store.ArrayType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteInt(0)
+			return nil, false
+		}
+
+		for _, v := range msg.Array[2:] {
+			element, err := fromRESP(v, time.Time{})
+			if err != nil {
+				log.Printf("%s %s: value iter: %v", ErrCmdPrefix, cmd, err)
+				continue
+			}
+
+			if toTail {
+				record.List.PushBack(element)
+			} else {
+				record.List.PushFront(element)
+			}
+		}
+
+		s.blockingManager.NotifyWatchers(key, record)
+
+		w.WriteInt(int64(record.List.Len()))
+		return record, true
+	})
+}
+
+func (s *Server) handleRandomkeyCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 1 {
+		w.WriteError("ERR Incorrect amount of args for `RANDOMKEY` command")
+		return
+	}
+
+	key, exists := s.store.RandomKey()
+	if !exists {
+		w.WriteNull()
+		return
+	}
+
+	w.WriteBulkString(key)
+}
+
+// Handles RENAME and RENAMENX, the latter only renaming when the new name
+// isn't taken yet: `RENAME key newkey`
+func (s *Server) handleRenameCommand(conn net.Conn, msg *resp.Message, nx bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) != 3 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	src, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s %s: invalid source: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError(fmt.Sprintf("ERR Invalid key type for `%s` command", cmd))
+		return
+	}
+
+	dest, err := msg.Array[2].ConvStr()
+	if err != nil {
+		log.Printf("%s %s: invalid destination: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError(fmt.Sprintf("ERR Invalid newkey type for `%s` command", cmd))
+		return
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		if _, exists := tx.Get(src); !exists {
+			w.WriteError("ERR no such key")
+			return
+		}
+
+		if _, exists := tx.Get(dest); exists && nx {
+			w.WriteInt(0)
+			return
+		}
+
+		record, _ := tx.Rename(src, dest)
+		if src != dest {
+			s.notifyKeyReady(dest, record)
+		}
+
+		if nx {
+			w.WriteInt(1)
+			return
+		}
+		w.WriteSimpleString("OK")
+	})
+}
+
+// `RPOP key [count]`
+func (s *Server) handleRpopCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 2 || len(msg.Array) > 3 {
+		w.WriteError("ERR Incorrect amount of args for `RPOP` command")
+		return
+	}
+
+	hasCount := len(msg.Array) == 3
+	count := 1
+	if hasCount {
+		var err error
+		count, err = msg.Array[2].ConvInt()
+		if err != nil || count < 0 {
+			log.Printf("%s RPOP: count parse: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	s.updateKey(w, msg, store.ArrayType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			if hasCount {
+				w.WriteNullArray()
+				return nil, false
+			}
+			w.WriteNull()
+			return nil, false
+		}
+
+		popped := popList(record, count, true)
+
+		if !hasCount {
+			writeRESPRecord(w, popped[0])
+
+			return record, true
+		}
+
+		writeRESPRecords(w, popped)
+		return record, true
+	})
+}
+
+func (s *Server) handleRpushCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) <= 2 {
+		w.WriteError("ERR Incorrect amount of args for `RPUSH` command")
+		return
+	}
+
+	key := msg.Array[1].String
+	valMsgs := msg.Array[2:]
+
+	s.updateKey(w, msg, store.ArrayType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			record = &store.Record{
+				Type: store.ArrayType,
+				List: store.NewList(),
+			}
+		}
+
+		for _, v := range valMsgs {
+			valRecord, err := fromRESP(v, time.Time{})
+			if err != nil {
+				log.Printf("%s RPUSH: value iter: %v", ErrCmdPrefix, err)
+			}
+			record.List.PushBack(valRecord)
+		}
+
+		s.blockingManager.NotifyWatchers(key, record)
+
+		w.WriteInt(int64(record.List.Len()))
+		return record, true
+	})
+}
+
+func (s *Server) handleSaddCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `SADD` command")
+		return
+	}
+
+	s.updateKey(w, msg, store.SetType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			record = &store.Record{Type: store.SetType, Set: store.NewSet()}
+		}
+
+		added := record.Set.Add(messageStrings(msg.Array[2:])...)
+
+		w.WriteInt(int64(added))
+		return record, true
+	})
+}
+
+// `SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]`
+func (s *Server) handleScanCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 2 {
+		w.WriteError("ERR Incorrect amount of args for `SCAN` command")
+		return
+	}
+
+	cursor, opts, err := parseScanArgs(msg.Array[1:], SCAN)
+	if err != nil {
+		log.Printf("%s SCAN: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	var keys []string
+	next := s.store.Scan(cursor, opts.Count, func(k string, record *store.Record) {
+		if opts.Type != "" && toTypeName(record.Type) != opts.Type {
+			return
+		}
+
+		if opts.matches(k) {
+			keys = append(keys, k)
+		}
+	})
+
+	writeRESPScan(w, next, keys)
+}
+
+func (s *Server) handleScardCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `SCARD` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.SetType, func(record *store.Record) {
+		if record == nil {
+			w.WriteInt(0)
+			return
+		}
+
+		w.WriteInt(int64(record.Set.Len()))
+	})
+}
+
+// Handles SINTER, SUNION and SDIFF, replying with the members `combine`
+// produces from the sets at every key.
+func (s *Server) handleSetAlgebraCommand(conn net.Conn, msg *resp.Message, combine func(...*store.Set) *store.Set) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 2 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	var result *store.Set
+	var err error
+	s.store.Atomically(func(tx *store.Tx) {
+		var sets []*store.Set
+		sets, err = lookupSets(tx, messageStrings(msg.Array[1:]))
+		if err == nil {
+			result = combine(sets...)
+		}
+	})
+
+	if err != nil {
+		log.Printf("%s %s: %v", ErrCmdPrefix, cmd, err)
+		w.WriteWrongTypeErr()
+		return
+	}
+
+	writeRESPSet(w, result)
+}
+
+// Handles SINTERSTORE, SUNIONSTORE and SDIFFSTORE, overwriting `destination`
+// with the result, or deleting it when the result is empty.
+func (s *Server) handleSetAlgebraStoreCommand(conn net.Conn, msg *resp.Message, combine func(...*store.Set) *store.Set) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 3 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	dest, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s %s: invalid destination: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError(fmt.Sprintf("ERR Invalid destination type for `%s` command", cmd))
+		return
+	}
+
+	var result *store.Set
+	s.store.Atomically(func(tx *store.Tx) {
+		var sets []*store.Set
+		sets, err = lookupSets(tx, messageStrings(msg.Array[2:]))
+		if err != nil {
+			return
+		}
+
+		result = combine(sets...)
+		tx.Set(dest, &store.Record{Type: store.SetType, Set: result})
+	})
+
+	if err != nil {
+		log.Printf("%s %s: %v", ErrCmdPrefix, cmd, err)
+		w.WriteWrongTypeErr()
+		return
+	}
+
+	w.WriteInt(int64(result.Len()))
+}
+
+func (s *Server) handleSetCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) <= 2 {
+		w.WriteError("ERR Incorrect amount of args for `SET` command")
+		return
+	}
+
+	keyMsg := msg.Array[1]
+	valMsg := msg.Array[2]
+
+	key, err := keyMsg.ConvStr()
+	if err != nil {
+		log.Printf("%s: SET: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `SET` command")
+		return
+	}
+
+	opts := &SetOptions{}
+	if len(msg.Array) > 3 {
+		opts, err = parseSETOptions(msg.Array[3:])
+		if err != nil {
+			log.Println(err)
+			w.WriteError("ERR Invalid data type for `SET` command option")
+			return
+		}
+	}
+
+	storeRecordValue, err := fromRESP(valMsg, opts.Expiry)
+	if err != nil {
+		log.Printf("%s SET: store value: %v", ErrCmdPrefix, err)
+	}
+
+	s.store.Update(key, func(record *store.Record, exists bool) (*store.Record, bool) {
+		if opts.KEEPTTL && exists {
+			storeRecordValue.ExpiresAt = record.ExpiresAt
+		}
+
+		return storeRecordValue, true
+	})
+
+	if !opts.GET {
+		w.WriteSimpleString("OK")
+		return
+	}
+
+	writeRESPRecord(w, storeRecordValue)
+}
+
+// Handles SETEX and PSETEX, `unit` being the SET option the TTL maps to:
+// `SETEX key seconds value`
+func (s *Server) handleSetexCommand(conn net.Conn, msg *resp.Message, unit string) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) != 4 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s %s: invalid key: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError(fmt.Sprintf("ERR Invalid key type for `%s` command", cmd))
+		return
+	}
+
+	ttl, err := msg.Array[2].ConvInt()
+	if err != nil {
+		log.Printf("%s %s: ttl parse: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+
+	// A TTL too long for a time.Duration would wrap around into the past
+	maxTTL := math.MaxInt64 / int64(time.Second)
+	if unit == "PX" {
+		maxTTL = math.MaxInt64 / int64(time.Millisecond)
+	}
+	if ttl <= 0 || int64(ttl) > maxTTL {
+		w.WriteError(fmt.Sprintf("ERR invalid expire time in '%s' command", strings.ToLower(cmd)))
+		return
+	}
+
+	expiry, err := parseSETOptionWithArg(unit, msg.Array[2].String)
+	if err != nil {
+		log.Printf("%s %s: expiry: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+
+	record, err := fromRESP(msg.Array[3], expiry)
+	if err != nil {
+		log.Printf("%s %s: value: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError(fmt.Sprintf("ERR Invalid value type for `%s` command", cmd))
+		return
+	}
+
+	// Like SET, whatever the key held is replaced, string or not
+	s.store.Update(key, func(*store.Record, bool) (*store.Record, bool) {
+		return record, true
+	})
+
+	w.WriteSimpleString("OK")
+}
+
+// `SETNX key value`
+func (s *Server) handleSetnxCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 3 {
+		w.WriteError("ERR Incorrect amount of args for `SETNX` command")
+		return
+	}
+
+	record, err := fromRESP(msg.Array[2], time.Time{})
+	if err != nil {
+		log.Printf("%s SETNX: value: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid value type for `SETNX` command")
+		return
+	}
+
+	if !s.store.SetMany(map[string]*store.Record{msg.Array[1].String: record}, true) {
+		w.WriteInt(0)
+		return
+	}
+
+	w.WriteInt(1)
+}
+
+// `SETRANGE key offset value`
+//
+// Overwrites the string from `offset` onwards, padding it with zero bytes
+// first when it's too short.
+func (s *Server) handleSetrangeCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `SETRANGE` command")
+		return
+	}
+
+	offset, err := msg.Array[2].ConvInt()
+	if err != nil || offset < 0 {
+		w.WriteError("ERR offset is out of range")
+		return
+	}
+
+	value := msg.Array[3].String
+	if offset > maxStringLength-len(value) {
+		w.WriteError("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+		return
+	}
+
+	s.updateKey(w, msg, store.StringType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			// Nothing to write means nothing to create
+			if value == "" {
+				w.WriteInt(0)
+				return nil, false
+			}
+			record = &store.Record{Type: store.StringType}
+		}
+
+		if value == "" {
+			w.WriteInt(int64(len(record.String)))
+			return nil, false
+		}
+
+		buf := []byte(record.String)
+		if grow := offset + len(value) - len(buf); grow > 0 {
+			buf = append(buf, make([]byte, grow)...)
+		}
+		copy(buf[offset:], value)
+		record.String = string(buf)
+
+		w.WriteInt(int64(len(record.String)))
+		return record, true
+	})
+}
+
+// `SINTERCARD numkeys key [key ...] [LIMIT limit]`
+func (s *Server) handleSintercardCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `SINTERCARD` command")
+		return
+	}
+
+	numKeys, err := msg.Array[1].ConvInt()
+	if err != nil || numKeys <= 0 {
+		log.Printf("%s SINTERCARD: numkeys parse: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR numkeys should be greater than 0")
+		return
+	}
+
+	if numKeys > len(msg.Array)-2 {
+		w.WriteError("ERR Number of keys can't be greater than number of args")
+		return
+	}
+
+	limit := 0
+	rest := msg.Array[2+numKeys:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(rest[0].String) != "LIMIT" {
+			w.WriteError("ERR syntax error")
+			return
+		}
+
+		limit, err = rest[1].ConvInt()
+		if err != nil || limit < 0 {
+			log.Printf("%s SINTERCARD: limit parse: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR LIMIT can't be negative")
+			return
+		}
+	}
+
+	card := 0
+	s.store.Atomically(func(tx *store.Tx) {
+		var sets []*store.Set
+		sets, err = lookupSets(tx, messageStrings(msg.Array[2:2+numKeys]))
+		if err == nil {
+			card = store.SetInter(sets...).Len()
+		}
+	})
+
+	if err != nil {
+		log.Printf("%s SINTERCARD: %v", ErrCmdPrefix, err)
+		w.WriteWrongTypeErr()
+		return
+	}
+
+	if limit > 0 {
+		card = min(card, limit)
+	}
+
+	w.WriteInt(int64(card))
+}
+
+func (s *Server) handleSismemberCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 3 {
+		w.WriteError("ERR Incorrect amount of args for `SISMEMBER` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.SetType, func(record *store.Record) {
+		if record != nil && record.Set.Has(msg.Array[2].String) {
+			w.WriteInt(1)
+			return
+		}
+
+		w.WriteInt(0)
+	})
+}
+
+func (s *Server) handleSmembersCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `SMEMBERS` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.SetType, func(record *store.Record) {
+		if record == nil {
+			writeRESPSet(w, nil)
+			return
+		}
+
+		writeRESPSet(w, record.Set)
+	})
+}
+
+func (s *Server) handleSmismemberCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `SMISMEMBER` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.SetType, func(record *store.Record) {
+		members := msg.Array[2:]
+		w.WriteArrayHeader(len(members))
+		for _, m := range members {
+			if record != nil && record.Set.Has(m.String) {
+				w.WriteInt(1)
+			} else {
+				w.WriteInt(0)
+			}
+		}
+	})
+}
+
+// `SMOVE source destination member`
+func (s *Server) handleSmoveCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `SMOVE` command")
+		return
+	}
+
+	src, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s SMOVE: invalid source: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid source type for `SMOVE` command")
+		return
+	}
+
+	dest, err := msg.Array[2].ConvStr()
+	if err != nil {
+		log.Printf("%s SMOVE: invalid destination: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid destination type for `SMOVE` command")
+		return
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		srcRecord, srcExists := tx.Get(src)
+		destRecord, destExists := tx.Get(dest)
+		if (srcExists && srcRecord.Type != store.SetType) || (destExists && destRecord.Type != store.SetType) {
+			log.Printf("%s SMOVE: invalid type: %s -> %s", ErrCmdPrefix, src, dest)
+			w.WriteWrongTypeErr()
+			return
+		}
+
+		member := msg.Array[3].String
+		if !srcExists || !srcRecord.Set.Has(member) {
+			w.WriteInt(0)
+			return
+		}
+
+		if src == dest {
+			w.WriteInt(1)
+			return
+		}
+
+		srcRecord.Set.Remove(member)
+		tx.Set(src, srcRecord)
+
+		if !destExists {
+			destRecord = &store.Record{Type: store.SetType, Set: store.NewSet()}
+		}
+
+		destRecord.Set.Add(member)
+		tx.Set(dest, destRecord)
+
+		w.WriteInt(1)
+	})
+}
+
+// `SPOP key [count]`
+func (s *Server) handleSpopCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 2 || len(msg.Array) > 3 {
+		w.WriteError("ERR Incorrect amount of args for `SPOP` command")
+		return
+	}
+
+	hasCount := len(msg.Array) == 3
+	count := 1
+	if hasCount {
+		var err error
+		count, err = msg.Array[2].ConvInt()
+		if err != nil || count < 0 {
+			log.Printf("%s SPOP: count parse: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	s.updateKey(w, msg, store.SetType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			if hasCount {
+				w.WriteSetHeader(0)
+			} else {
+				w.WriteNull()
+			}
+			return nil, false
+		}
+
+		members := record.Set.Members()
+		rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+		popped := members[:min(count, len(members))]
+		record.Set.Remove(popped...)
+
+		if !hasCount {
+			w.WriteBulkString(popped[0])
+			return record, true
+		}
+
+		w.WriteSetHeader(len(popped))
+		for _, m := range popped {
+			w.WriteBulkString(m)
+		}
+		return record, true
+	})
+}
+
+// `SRANDMEMBER key [count]`
+//
+// A positive count returns that many distinct members (capped at the set's
+// size), a negative one returns exactly |count| members which may repeat.
+func (s *Server) handleSrandmemberCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 2 || len(msg.Array) > 3 {
+		w.WriteError("ERR Incorrect amount of args for `SRANDMEMBER` command")
+		return
+	}
+
+	hasCount := len(msg.Array) == 3
+	count := 1
+	if hasCount {
+		var err error
+		count, err = msg.Array[2].ConvInt()
+		if err != nil {
+			log.Printf("%s SRANDMEMBER: count parse: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+	}
+
+	s.viewKey(w, msg, store.SetType, func(record *store.Record) {
+		var members []string
+		if record != nil {
+			members = record.Set.Members()
+		}
+
+		if !hasCount {
+			if len(members) == 0 {
+				w.WriteNull()
+				return
+			}
+
+			w.WriteBulkString(members[rand.IntN(len(members))])
+			return
+		}
+
+		var result []string
+		err := pickRandom(members, count, func(member string) {
+			result = append(result, member)
+		})
+		if err != nil {
+			w.WriteError("ERR " + err.Error())
+			return
+		}
+
+		w.WriteArrayHeader(len(result))
+		for _, m := range result {
+			w.WriteBulkString(m)
+		}
+	})
+}
+
+func (s *Server) handleSremCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `SREM` command")
+		return
+	}
+
+	s.updateKey(w, msg, store.SetType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteInt(0)
+			return nil, false
+		}
+
+		removed := record.Set.Remove(messageStrings(msg.Array[2:])...)
+
+		w.WriteInt(int64(removed))
+		return record, true
+	})
+}
+
+// `SSCAN key cursor [MATCH pattern] [COUNT count]`
+func (s *Server) handleSscanCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `SSCAN` command")
+		return
+	}
+
+	cursor, opts, err := parseScanArgs(msg.Array[2:], SSCAN)
+	if err != nil {
+		log.Printf("%s SSCAN: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	s.viewKey(w, msg, store.SetType, func(record *store.Record) {
+		if record == nil {
+			writeRESPScan(w, 0, nil)
+			return
+		}
+
+		next, members := record.Set.Scan(cursor, opts.Count)
+		result := make([]string, 0, len(members))
+		for _, m := range members {
+			if opts.matches(m) {
+				result = append(result, m)
+			}
+		}
+
+		writeRESPScan(w, next, result)
+	})
+
+}
+
+// `STRLEN key`
+func (s *Server) handleStrlenCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `STRLEN` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.StringType, func(record *store.Record) {
+		if record == nil {
+			w.WriteInt(0)
+			return
+		}
+
+		w.WriteInt(int64(len(record.String)))
+	})
+}
+
+// Handles TTL, PTTL, EXPIRETIME and PEXPIRETIME, which report either the
+// remaining TTL or the absolute unix expiry of the key in `unit`, with -2
+// for a missing key and -1 for one without an expiry: `TTL key`
+func (s *Server) handleTtlCommand(conn net.Conn, msg *resp.Message, unit time.Duration, absolute bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) != 2 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s %s: invalid key: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError(fmt.Sprintf("ERR Invalid key type for `%s` command", cmd))
+		return
+	}
+
+	s.store.View(key, func(record *store.Record, exists bool) {
+		switch {
+		case !exists:
+			w.WriteInt(-2)
+		case record.ExpiresAt.IsZero():
+			w.WriteInt(-1)
+		case absolute:
+			// Not via UnixNano, which overflows past the year 2262
+			at := record.ExpiresAt
+			w.WriteInt(int64(at.Unix()*int64(time.Second/unit) + int64(at.Nanosecond())/int64(unit)))
+		default:
+			// Rounded rather than truncated so a fresh `EXPIRE key 10` reports
+			// 10. Far off expiries saturate the duration, so it mustn't be
+			// offset before dividing.
+			remaining := time.Until(record.ExpiresAt)
+			ttl := remaining / unit
+			if remaining%unit >= unit/2 {
+				ttl++
+			}
+			w.WriteInt(int64(max(ttl, 0)))
+		}
+	})
+}
+
+func (s *Server) handleTypeCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `TYPE` command")
+		return
+	}
+
+	keyMsg := msg.Array[1]
+
+	key, err := keyMsg.ConvStr()
+	if err != nil {
+		log.Printf("%s: TYPE: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `TYPE` command")
+		return
+	}
+
+	stype := "none"
+	s.store.View(key, func(record *store.Record, exists bool) {
+		if exists {
+			stype = toTypeName(record.Type)
+		}
+	})
+
+	w.WriteSimpleString(stype)
+}
+
+// `UNLINK key [key ...]`
+func (s *Server) handleUnlinkCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 2 {
+		w.WriteError("ERR Incorrect amount of args for `UNLINK` command")
+		return
+	}
+
+	w.WriteInt(int64(s.store.Unlink(messageStrings(msg.Array[1:])...)))
+}
+
+func (s *Server) handleXackCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 4 {
+		w.WriteError("ERR Incorrect amount of args for `XACK` command")
+		return
+	}
+
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s XACK: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `XACK` command")
+		return
+	}
+
+	groupName, err := msg.Array[2].ConvStr()
+	if err != nil {
+		log.Printf("%s XACK: invalid group: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid group type for `XACK` command")
+		return
+	}
+
+	ids := make([]store.StreamID, len(msg.Array)-3)
+	for i, m := range msg.Array[3:] {
+		ids[i], err = store.ParseStreamID(m.String, 0)
+		if err != nil {
+			log.Printf("%s XACK: parse id: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR Invalid stream ID specified as stream command argument")
+			return
+		}
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		record, exists := tx.Get(key)
+		if !exists {
+			w.WriteInt(0)
+			return
+		}
+
+		if record.Type != store.StreamType {
+			log.Printf("%s XACK: invalid type: %s", ErrCmdPrefix, record.Type.String())
+			w.WriteError("ERR Provided `XACK` Key produced non stream type")
+			return
+		}
+
+		group, exists := record.Streams.Group(groupName)
+		if !exists {
+			w.WriteInt(0)
+			return
+		}
+
+		w.WriteInt(int64(group.Ack(ids)))
+	})
+}
+
+func (s *Server) handleXaddCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 5 {
+		w.WriteError("ERR Incorrect amount of args for `XADD` command")
+		return
+	}
+
+	keyMsg := msg.Array[1]
+
+	opts, consumed, err := parseXADDOptions(msg.Array[2:])
+	if err != nil {
+		log.Println(err)
+		w.WriteError("ERR Invalid options for `XADD` command")
+		return
+	}
+
+	idMsg := msg.Array[2+consumed]
+	fieldMsgs := msg.Array[3+consumed:]
+
+	if len(fieldMsgs) == 0 || len(fieldMsgs)%2 != 0 {
+		w.WriteError("ERR Every field needs a value in `XADD` command")
+		return
+	}
+
+	id, err := idMsg.ConvStr()
+	if err != nil {
+		log.Printf("%s XADD: invalid id: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid id type for `XADD` command")
+		return
+	}
+
+	fields := make([]*store.Record, len(fieldMsgs))
+	for i, f := range fieldMsgs {
+		fieldRec, err := fromRESP(f, time.Time{})
+		if err != nil {
+			log.Printf("%s XADD: field iter: %v", ErrCmdPrefix, err)
+		}
+		fields[i] = fieldRec
+	}
+
+	key, err := keyMsg.ConvStr()
+	if err != nil {
+		log.Printf("%s XADD: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `XADD` command")
+		return
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		record, exists := tx.Get(key)
+		if !exists {
+			if opts.NoMkStream {
+				w.WriteNull()
+				return
+			}
+
+			record = &store.Record{
+				Type:    store.StreamType,
+				Streams: store.NewStream(),
+			}
+		}
+
+		if record.Type != store.StreamType {
+			log.Printf("%s XADD: invalid type: %s", ErrCmdPrefix, record.Type.String())
+			w.WriteError("ERR Provided `XADD` Key produced non stream type")
+			return
+		}
+
+		newID, err := record.Streams.Insert(id, fields)
+		if err != nil {
+			log.Printf("%s XADD: insert: %v", ErrCmdPrefix, err)
+			switch {
+			case errors.Is(err, store.ErrStreamIDZero):
+				w.WriteError("ERR The ID specified in XADD must be greater than 0-0")
+				return
+			case errors.Is(err, store.ErrStreamIDTooSmall):
+				w.WriteError("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+				return
+			default:
+				w.WriteError("ERR Invalid stream ID specified as stream command argument")
+				return
+			}
+		}
+
+		if opts.Trim != nil {
+			record.Streams.Trim(opts.Trim)
+		}
+
+		tx.Set(key, record)
+		s.blockingManager.NotifyStreamWatchers(key, record.Streams)
+
+		w.WriteBulkString(newID.String())
+	})
+}
+
+func (s *Server) handleXautoclaimCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 6 || len(msg.Array) > 9 {
+		w.WriteError("ERR Incorrect amount of args for `XAUTOCLAIM` command")
+		return
+	}
+
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s XAUTOCLAIM: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `XAUTOCLAIM` command")
+		return
+	}
+
+	groupName, err := msg.Array[2].ConvStr()
+	if err != nil {
+		log.Printf("%s XAUTOCLAIM: invalid group: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid group type for `XAUTOCLAIM` command")
+		return
+	}
+
+	consumerName, err := msg.Array[3].ConvStr()
+	if err != nil {
+		log.Printf("%s XAUTOCLAIM: invalid consumer: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid consumer type for `XAUTOCLAIM` command")
+		return
+	}
+
+	minIdle, err := msg.Array[4].ConvInt()
+	if err != nil || minIdle < 0 {
+		log.Printf("%s XAUTOCLAIM: invalid min-idle-time: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid min-idle-time argument for XAUTOCLAIM")
+		return
+	}
+
+	start, err := parseStreamRangeID(msg.Array[5].String, true)
+	if err != nil {
+		log.Printf("%s XAUTOCLAIM: parse start: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid stream ID specified as stream command argument")
+		return
+	}
+
+	count := 100
+	justID := false
+	for i := 6; i < len(msg.Array); i++ {
+		switch strings.ToUpper(msg.Array[i].String) {
+		case "COUNT":
+			if i+1 >= len(msg.Array) {
+				w.WriteError("ERR syntax error")
+				return
+			}
+			count, err = msg.Array[i+1].ConvInt()
+			if err != nil || count < 1 || count > math.MaxInt/store.AutoClaimAttemptsFactor {
+				w.WriteError("ERR COUNT must be > 0")
+				return
+			}
+			i++
+		case "JUSTID":
+			justID = true
+		default:
+			w.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		stream, group, ok := lookupStreamGroup(w, tx, XAUTOCLAIM, key, groupName)
+		if !ok {
+			return
+		}
+
+		consumer, _ := group.CreateConsumer(consumerName)
+		next, claimed, deleted := group.AutoClaim(stream, consumer, time.Duration(minIdle)*time.Millisecond, start, count, justID)
+
+		w.WriteArrayHeader(3)
+		w.WriteBulkString(next.String())
+		if justID {
+			ids := make([]store.StreamID, len(claimed))
+			for i, e := range claimed {
+				ids[i] = e.ID
+			}
+			writeRESPStreamIDs(w, ids)
+		} else {
+			writeRESPStreamEntries(w, claimed)
+		}
+		writeRESPStreamIDs(w, deleted)
+	})
+
+}
+
+func (s *Server) handleXclaimCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 6 {
+		w.WriteError("ERR Incorrect amount of args for `XCLAIM` command")
+		return
+	}
+
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s XCLAIM: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `XCLAIM` command")
+		return
+	}
+
+	groupName, err := msg.Array[2].ConvStr()
+	if err != nil {
+		log.Printf("%s XCLAIM: invalid group: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid group type for `XCLAIM` command")
+		return
+	}
+
+	consumerName, err := msg.Array[3].ConvStr()
+	if err != nil {
+		log.Printf("%s XCLAIM: invalid consumer: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid consumer type for `XCLAIM` command")
+		return
+	}
+
+	minIdle, err := msg.Array[4].ConvInt()
+	if err != nil || minIdle < 0 {
+		log.Printf("%s XCLAIM: invalid min-idle-time: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid min-idle-time argument for XCLAIM")
+		return
+	}
+
+	// IDs come first, the options begin at the first argument that isn't one
+	ids := make([]store.StreamID, 0, len(msg.Array)-5)
+	i := 5
+	for ; i < len(msg.Array); i++ {
+		id, err := store.ParseStreamID(msg.Array[i].String, 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+
+	if len(ids) == 0 {
+		w.WriteError("ERR Invalid stream ID specified as stream command argument")
+		return
+	}
+
+	opts, err := parseXCLAIMOptions(msg.Array[i:])
+	if err != nil {
+		log.Println(err)
+		w.WriteError("ERR Invalid options for `XCLAIM` command")
+		return
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		stream, group, ok := lookupStreamGroup(w, tx, XCLAIM, key, groupName)
+		if !ok {
+			return
+		}
+
+		consumer, _ := group.CreateConsumer(consumerName)
+		claimed := group.Claim(stream, consumer, ids, time.Duration(minIdle)*time.Millisecond, opts)
+
+		if opts.JustID {
+			claimedIDs := make([]store.StreamID, len(claimed))
+			for i, e := range claimed {
+				claimedIDs[i] = e.ID
+			}
+			writeRESPStreamIDs(w, claimedIDs)
+			return
+		}
+
+		writeRESPStreamEntries(w, claimed)
+	})
+
+}
+
+func (s *Server) handleXdelCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `XDEL` command")
+		return
+	}
+
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s XDEL: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `XDEL` command")
+		return
+	}
+
+	ids := make([]store.StreamID, len(msg.Array)-2)
+	for i, m := range msg.Array[2:] {
+		ids[i], err = store.ParseStreamID(m.String, 0)
+		if err != nil {
+			log.Printf("%s XDEL: parse id: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR Invalid stream ID specified as stream command argument")
+			return
+		}
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		record, exists := tx.Get(key)
+		if !exists {
+			w.WriteInt(0)
+			return
+		}
+
+		if record.Type != store.StreamType {
+			log.Printf("%s XDEL: invalid type: %s", ErrCmdPrefix, record.Type.String())
+			w.WriteError("ERR Provided `XDEL` Key produced non stream type")
+			return
+		}
+
+		deleted := 0
+		for _, id := range ids {
+			if record.Streams.Delete(id) {
+				deleted++
+			}
+		}
+
+		w.WriteInt(int64(deleted))
+	})
+}
+
+func (s *Server) handleXgroupCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 2 {
+		w.WriteError("ERR Incorrect amount of args for `XGROUP *` command")
+		return
+	}
+
+	subCmd := msg.Array[1]
+	switch strings.ToUpper(subCmd.String) {
+	case "CREATE":
+		s.handleXgroupCreateCommand(conn, msg)
+	case "CREATECONSUMER":
+		s.handleXgroupCreateConsumerCommand(conn, msg)
+	case "DELCONSUMER":
+		s.handleXgroupDelConsumerCommand(conn, msg)
+	case "DESTROY":
+		s.handleXgroupDestroyCommand(conn, msg)
+	case "SETID":
+		s.handleXgroupSetIDCommand(conn, msg)
+	default:
+		w.WriteError("ERR Unknown XGROUP subcommand")
+	}
+}
+
+// `XGROUP CREATE key group id|$ [MKSTREAM] [ENTRIESREAD entries-read]`
+func (s *Server) handleXgroupCreateCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 5 || len(msg.Array) > 8 {
+		w.WriteError("ERR Incorrect amount of args for `XGROUP CREATE` command")
+		return
+	}
+
+	key, err := msg.Array[2].ConvStr()
+	if err != nil {
+		log.Printf("%s XGROUP CREATE: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `XGROUP CREATE` command")
+		return
+	}
+
+	groupName, err := msg.Array[3].ConvStr()
+	if err != nil {
+		log.Printf("%s XGROUP CREATE: invalid group: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid group type for `XGROUP CREATE` command")
+		return
+	}
+
+	mkStream := false
+	entriesRead := int64(-1)
+	hasEntriesRead := false
+	for i := 5; i < len(msg.Array); i++ {
+		switch strings.ToUpper(msg.Array[i].String) {
+		case "MKSTREAM":
+			mkStream = true
+		case "ENTRIESREAD":
+			if i+1 >= len(msg.Array) {
+				w.WriteError("ERR syntax error")
+				return
+			}
+			entriesRead, err = parseEntriesRead(msg.Array[i+1])
+			if err != nil {
+				log.Printf("%s XGROUP CREATE: %v", ErrCmdPrefix, err)
+				w.WriteError("ERR value for ENTRIESREAD must be positive or -1")
+				return
+			}
+			hasEntriesRead = true
+			i++
+		default:
+			w.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		record, exists := tx.Get(key)
+		if !exists {
+			if !mkStream {
+				w.WriteError("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+				return
+			}
+
+			record = &store.Record{
+				Type:    store.StreamType,
+				Streams: store.NewStream(),
+			}
+		}
+
+		if record.Type != store.StreamType {
+			log.Printf("%s XGROUP CREATE: invalid type: %s", ErrCmdPrefix, record.Type.String())
+			w.WriteError("ERR Provided `XGROUP CREATE` Key produced non stream type")
+			return
+		}
+
+		lastID := record.Streams.LastID()
+		if rawID := msg.Array[4].String; rawID != "$" {
+			var err error
+			lastID, err = store.ParseStreamID(rawID, 0)
+			if err != nil {
+				log.Printf("%s XGROUP CREATE: parse id: %v", ErrCmdPrefix, err)
+				w.WriteError("ERR Invalid stream ID specified as stream command argument")
+				return
+			}
+		}
+
+		group, err := record.Streams.CreateGroup(groupName, lastID)
+		if err != nil {
+			w.WriteError("BUSYGROUP Consumer Group name already exists")
+			return
+		}
+
+		if hasEntriesRead {
+			group.EntriesRead = entriesRead
+		}
+
+		tx.Set(key, record)
+
+		w.WriteSimpleString("OK")
+	})
+}
+
+// `XGROUP CREATECONSUMER key group consumer`
+func (s *Server) handleXgroupCreateConsumerCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 5 {
+		w.WriteError("ERR Incorrect amount of args for `XGROUP CREATECONSUMER` command")
+		return
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		_, group, ok := lookupStreamGroup(w, tx, XGROUP, msg.Array[2].String, msg.Array[3].String)
+		if !ok {
+			return
+		}
+
+		if _, created := group.CreateConsumer(msg.Array[4].String); !created {
+			w.WriteInt(0)
+			return
+		}
+
+		w.WriteInt(1)
+	})
+}
+
+// `XGROUP DELCONSUMER key group consumer`
+func (s *Server) handleXgroupDelConsumerCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 5 {
+		w.WriteError("ERR Incorrect amount of args for `XGROUP DELCONSUMER` command")
+		return
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		_, group, ok := lookupStreamGroup(w, tx, XGROUP, msg.Array[2].String, msg.Array[3].String)
+		if !ok {
+			return
+		}
+
+		pending, _ := group.DeleteConsumer(msg.Array[4].String)
+
+		w.WriteInt(int64(pending))
+	})
+}
+
+// `XGROUP DESTROY key group`
+func (s *Server) handleXgroupDestroyCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `XGROUP DESTROY` command")
+		return
+	}
+
+	key, err := msg.Array[2].ConvStr()
+	if err != nil {
+		log.Printf("%s XGROUP DESTROY: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `XGROUP DESTROY` command")
+		return
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		record, exists := tx.Get(key)
+		if !exists {
+			w.WriteError("ERR The XGROUP subcommand requires the key to exist.")
+			return
+		}
+
+		if record.Type != store.StreamType {
+			log.Printf("%s XGROUP DESTROY: invalid type: %s", ErrCmdPrefix, record.Type.String())
+			w.WriteError("ERR Provided `XGROUP DESTROY` Key produced non stream type")
+			return
+		}
+
+		if !record.Streams.DestroyGroup(msg.Array[3].String) {
+			w.WriteInt(0)
+			return
+		}
+
+		w.WriteInt(1)
+	})
+}
+
+// `XGROUP SETID key group id|$ [ENTRIESREAD entries-read]`
+func (s *Server) handleXgroupSetIDCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 5 && len(msg.Array) != 7 {
+		w.WriteError("ERR Incorrect amount of args for `XGROUP SETID` command")
+		return
+	}
+
+	entriesRead := int64(-1)
+	hasEntriesRead := false
+	if len(msg.Array) == 7 {
+		if strings.ToUpper(msg.Array[5].String) != "ENTRIESREAD" {
+			w.WriteError("ERR syntax error")
+			return
+		}
+
+		var err error
+		entriesRead, err = parseEntriesRead(msg.Array[6])
+		if err != nil {
+			log.Printf("%s XGROUP SETID: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR value for ENTRIESREAD must be positive or -1")
+			return
+		}
+		hasEntriesRead = true
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		stream, group, ok := lookupStreamGroup(w, tx, XGROUP, msg.Array[2].String, msg.Array[3].String)
+		if !ok {
+			return
+		}
+
+		id := stream.LastID()
+		if rawID := msg.Array[4].String; rawID != "$" {
+			var err error
+			id, err = store.ParseStreamID(rawID, 0)
+			if err != nil {
+				log.Printf("%s XGROUP SETID: parse id: %v", ErrCmdPrefix, err)
+				w.WriteError("ERR Invalid stream ID specified as stream command argument")
+				return
+			}
+		}
+
+		group.SetID(stream, id)
+		if hasEntriesRead {
+			group.EntriesRead = entriesRead
+		}
+
+		w.WriteSimpleString("OK")
+	})
+}
+
+func (s *Server) handleXinfoCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `XINFO *` command")
+		return
+	}
+
+	subCmd := strings.ToUpper(msg.Array[1].String)
+	key, err := msg.Array[2].ConvStr()
+	if err != nil {
+		log.Printf("%s XINFO %s: invalid key: %v", ErrCmdPrefix, subCmd, err)
+		w.WriteError("ERR Invalid key type for `XINFO` command")
+		return
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		record, exists := tx.Get(key)
+		if !exists {
+			w.WriteError("ERR no such key")
+			return
+		}
+
+		if record.Type != store.StreamType {
+			log.Printf("%s XINFO %s: invalid type: %s", ErrCmdPrefix, subCmd, record.Type.String())
+			w.WriteError("ERR Provided `XINFO` Key produced non stream type")
+			return
+		}
+
+		switch subCmd {
+		case "CONSUMERS":
+			xinfoConsumers(w, msg, record.Streams)
+		case "GROUPS":
+			xinfoGroups(w, msg, record.Streams)
+		case "STREAM":
+			xinfoStream(w, msg, record.Streams)
+		default:
+			w.WriteError("ERR Unknown XINFO subcommand")
+			return
+		}
+	})
+}
+
+func (s *Server) handleXlenCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `XLEN` command")
+		return
+	}
+
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s XLEN: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `XLEN` command")
+		return
+	}
+
+	s.store.View(key, func(record *store.Record, exists bool) {
+		switch {
+		case !exists:
+			w.WriteInt(0)
+		case record.Type != store.StreamType:
+			log.Printf("%s XLEN: invalid type: %s", ErrCmdPrefix, record.Type.String())
+			w.WriteError("ERR Provided `XLEN` Key produced non stream type")
+		default:
+			w.WriteInt(int64(record.Streams.Len()))
+		}
+	})
+}
+
+// Handles both forms of the command:
+// summary:  `XPENDING key group`
+// extended: `XPENDING key group [IDLE min-idle-time] start end count [consumer]`
+func (s *Server) handleXpendingCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `XPENDING` command")
+		return
+	}
+
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s XPENDING: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `XPENDING` command")
+		return
+	}
+
+	groupName, err := msg.Array[2].ConvStr()
+	if err != nil {
+		log.Printf("%s XPENDING: invalid group: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid group type for `XPENDING` command")
+		return
+	}
+
+	args := msg.Array[3:]
+	if len(args) == 0 {
+		s.store.Atomically(func(tx *store.Tx) {
+			_, group, ok := lookupStreamGroup(w, tx, XPENDING, key, groupName)
+			if !ok {
+				return
+			}
+
+			xpendingSummary(w, group)
+		})
+		return
+	}
+
+	var minIdle time.Duration
+	if strings.ToUpper(args[0].String) == "IDLE" {
+		if len(args) < 2 {
+			w.WriteError("ERR syntax error")
+			return
+		}
+
+		ms, err := args[1].ConvInt()
+		if err != nil {
+			log.Printf("%s XPENDING: invalid IDLE: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		minIdle = time.Duration(ms) * time.Millisecond
+		args = args[2:]
+	}
+
+	if len(args) != 3 && len(args) != 4 {
+		w.WriteError("ERR syntax error")
+		return
+	}
+
+	start, err := parseStreamRangeID(args[0].String, true)
+	if err != nil {
+		log.Printf("%s XPENDING: parse start: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid stream ID specified as stream command argument")
+		return
+	}
+
+	end, err := parseStreamRangeID(args[1].String, false)
+	if err != nil {
+		log.Printf("%s XPENDING: parse end: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid stream ID specified as stream command argument")
+		return
+	}
+
+	count, err := args[2].ConvInt()
+	if err != nil {
+		log.Printf("%s XPENDING: parse count: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR value is not an integer or out of range")
+		return
+	}
+
+	consumer := ""
+	if len(args) == 4 {
+		consumer = args[3].String
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		_, group, ok := lookupStreamGroup(w, tx, XPENDING, key, groupName)
+		if !ok {
+			return
+		}
+
+		pending := group.Pending(start, end, max(count, 0), consumer, minIdle)
+		w.WriteArrayHeader(len(pending))
+		for _, pe := range pending {
+			w.WriteArrayHeader(4)
+			w.WriteBulkString(pe.ID.String())
+			w.WriteBulkString(pe.Consumer.Name)
+			w.WriteInt(pe.Idle().Milliseconds())
+			w.WriteInt(int64(pe.DeliveryCount))
+		}
+	})
+}
+
+// Handles both `XRANGE key start end` and `XREVRANGE key end start`, the
+// latter simply swapping the position of the bounds and reversing the order.
+func (s *Server) handleXrangeCommand(conn net.Conn, msg *resp.Message, rev bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := XRANGE
+	if rev {
+		cmd = XREVRANGE
+	}
+
+	if len(msg.Array) != 4 && len(msg.Array) != 6 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s %s: invalid key: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError(fmt.Sprintf("ERR Invalid key type for `%s` command", cmd))
+		return
+	}
+
+	startMsg, endMsg := msg.Array[2], msg.Array[3]
+	if rev {
+		startMsg, endMsg = endMsg, startMsg
+	}
+
+	rawStart, err := startMsg.ConvStr()
+	if err != nil {
+		log.Printf("%s %s: invalid start: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR Invalid stream ID specified as stream command argument")
+		return
+	}
+
+	rawEnd, err := endMsg.ConvStr()
+	if err != nil {
+		log.Printf("%s %s: invalid end: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR Invalid stream ID specified as stream command argument")
+		return
+	}
+
+	start, err := parseStreamRangeID(rawStart, true)
+	if err != nil {
+		log.Printf("%s %s: parse start: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR Invalid stream ID specified as stream command argument")
+		return
+	}
+
+	end, err := parseStreamRangeID(rawEnd, false)
+	if err != nil {
+		log.Printf("%s %s: parse end: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError("ERR Invalid stream ID specified as stream command argument")
+		return
+	}
+
+	count := 0
+	if len(msg.Array) == 6 {
+		if strings.ToUpper(msg.Array[4].String) != "COUNT" {
+			w.WriteError("ERR syntax error")
+			return
+		}
+
+		count, err = msg.Array[5].ConvInt()
+		if err != nil {
+			log.Printf("%s %s: count parse: %v", ErrCmdPrefix, cmd, err)
+			w.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+
+		// COUNT 0 is a valid, albeit useless, request for nothing
+		if count <= 0 {
+			w.WriteArrayHeader(0)
+			return
+		}
+	}
+
+	s.store.View(key, func(record *store.Record, exists bool) {
+		if !exists {
+			w.WriteArrayHeader(0)
+			return
+		}
+
+		if record.Type != store.StreamType {
+			log.Printf("%s %s: invalid type: %s", ErrCmdPrefix, cmd, record.Type.String())
+			w.WriteError(fmt.Sprintf("ERR Provided `%s` Key produced non stream type", cmd))
+			return
+		}
+
+		writeRESPStreamEntries(w, record.Streams.Range(start, end, count, rev))
+	})
+}
+
+func (s *Server) handleXreadCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 4 {
+		w.WriteError("ERR Incorrect amount of args for `XREAD` command")
+		return
+	}
+
+	opts, err := parseXREADOptions(msg.Array[1:], false)
+	if err != nil {
+		log.Println(err)
+		w.WriteError("ERR Invalid options for `XREAD` command")
+		return
+	}
+
+	bc := &BlockedClient{
+		conn:      conn,
+		kind:      store.StreamType,
+		replyCh:   make(chan *BlockedClientChanResp, 1),
+		subs:      opts.Keys,
+		count:     opts.Count,
+		streamIDs: make(map[string]store.StreamID, len(opts.Keys)),
+	}
+
+	blocking := false
+	s.store.Atomically(func(tx *store.Tx) {
+		// Everything is looked up before replying, an error may still come
+		// after a key with entries
+		keys := make([]string, 0, len(opts.Keys))
+		results := make([][]*store.StreamEntry, 0, len(opts.Keys))
+		for i, key := range opts.Keys {
+			record, exists := tx.Get(key)
+			if exists && record.Type != store.StreamType {
+				log.Printf("%s XREAD: invalid type from key (%s): %s", ErrCmdPrefix, key, record.Type.String())
+				w.WriteError(fmt.Sprintf("ERR Provided `XREAD` Key (%s) produced non stream type", key))
+				return
+			}
+
+			// `$` means only entries added after this command was issued
+			if opts.IDs[i] == "$" {
+				if exists {
+					bc.streamIDs[key] = record.Streams.LastID()
+				} else {
+					bc.streamIDs[key] = store.MinStreamID
+				}
+				continue
+			}
+
+			id, err := store.ParseStreamID(opts.IDs[i], 0)
+			if err != nil {
+				log.Printf("%s XREAD: parse id: %v", ErrCmdPrefix, err)
+				w.WriteError("ERR Invalid stream ID specified as stream command argument")
+				return
+			}
+			bc.streamIDs[key] = id
+
+			start, ok := id.Next()
+			if !exists || !ok {
+				continue
+			}
+
+			entries := record.Streams.Range(start, store.MaxStreamID, opts.Count, false)
+			if len(entries) == 0 {
+				continue
+			}
+
+			keys = append(keys, key)
+			results = append(results, entries)
+		}
+
+		switch {
+		case len(results) > 0:
+			w.WriteArrayHeader(len(results))
+			for i, entries := range results {
+				writeRESPStreamRead(w, keys[i], entries)
+			}
+		case !opts.IsBlock:
+			w.WriteNullArray()
+		default:
+			// Registering before the lock is released means no XADD can
+			// slip in unnoticed between the read and the wait
+			s.blockingManager.RegisterClient(bc)
+			blocking = true
+		}
+	})
+
+	if !blocking {
+		return
+	}
+
+	/*** BLOCKING BEGINS ***/
+	// BLOCK 0 blocks forever, which a nil channel gives us for free
+	var timeoutCh <-chan time.Time
+	if opts.Block > 0 {
+		timeoutCh = time.After(opts.Block)
+	}
+	flushBeforeBlocking(conn)
+
+	select {
+	case res := <-bc.replyCh:
+		w.WriteArrayHeader(1)
+		writeRESPStreamRead(w, res.key, res.entries)
+	case <-timeoutCh:
+		w.WriteNullArray()
+		s.blockingManager.UnregisterClient(bc)
+	}
+}
+
+func (s *Server) handleXreadgroupCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 7 {
+		w.WriteError("ERR Incorrect amount of args for `XREADGROUP` command")
+		return
+	}
+
+	opts, err := parseXREADOptions(msg.Array[1:], true)
+	if err != nil {
+		log.Println(err)
+		w.WriteError("ERR Invalid options for `XREADGROUP` command")
+		return
+	}
+
+	bc := &BlockedClient{
+		conn:    conn,
+		kind:    store.StreamType,
+		replyCh: make(chan *BlockedClientChanResp, 1),
+		subs:    opts.Keys,
+		count:   opts.Count,
+		group:   opts.Group,
+	}
+
+	blocking := false
+	s.store.Atomically(func(tx *store.Tx) {
+		// Reading history (any ID other than `>`) always replies right away,
+		// even with no entries, while `>` finding nothing new leaves its key
+		// out
+		history := false
+		keys := make([]string, 0, len(opts.Keys))
+		results := make([][]*store.StreamEntry, 0, len(opts.Keys))
+		for i, key := range opts.Keys {
+			entries, ok := readStreamGroup(w, tx, opts, key, opts.IDs[i])
+			if !ok {
+				return
+			}
+
+			if opts.IDs[i] != ">" {
+				history = true
+			} else if len(entries) == 0 {
+				continue
+			}
+
+			keys = append(keys, key)
+			results = append(results, entries)
+		}
+
+		switch {
+		case len(results) > 0 || history:
+			w.WriteArrayHeader(len(results))
+			for i, entries := range results {
+				writeRESPStreamRead(w, keys[i], entries)
+			}
+		case !opts.IsBlock:
+			w.WriteNullArray()
+		default:
+			s.blockingManager.RegisterClient(bc)
+			blocking = true
+		}
+	})
+
+	if !blocking {
+		return
+	}
+
+	/*** BLOCKING BEGINS ***/
+	// BLOCK 0 blocks forever, which a nil channel gives us for free
+	var timeoutCh <-chan time.Time
+	if opts.Block > 0 {
+		timeoutCh = time.After(opts.Block)
+	}
+	flushBeforeBlocking(conn)
+
+	for {
+		select {
+		case res := <-bc.replyCh:
+			replied := true
+			s.store.Atomically(func(tx *store.Tx) {
+				entries, ok := readStreamGroup(w, tx, opts, res.key, ">")
+				switch {
+				case !ok:
+				case len(entries) > 0:
+					w.WriteArrayHeader(1)
+					writeRESPStreamRead(w, res.key, entries)
+				default:
+					// Another consumer of the group got to the new entries
+					// first
+					s.blockingManager.RegisterClient(bc)
+					replied = false
+				}
+			})
+
+			if replied {
+				return
+			}
+
+		case <-timeoutCh:
+			w.WriteNullArray()
+			s.blockingManager.UnregisterClient(bc)
+			return
+		}
+	}
+}
+
+func (s *Server) handleXtrimCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 4 {
+		w.WriteError("ERR Incorrect amount of args for `XTRIM` command")
+		return
+	}
+
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s XTRIM: invalid key: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid key type for `XTRIM` command")
+		return
+	}
+
+	opts, consumed, err := parseStreamTrimOptions(msg.Array[2:])
+	if err != nil || 2+consumed != len(msg.Array) {
+		log.Printf("%s XTRIM: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid options for `XTRIM` command")
+		return
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		record, exists := tx.Get(key)
+		if !exists {
+			w.WriteInt(0)
+			return
+		}
+
+		if record.Type != store.StreamType {
+			log.Printf("%s XTRIM: invalid type: %s", ErrCmdPrefix, record.Type.String())
+			w.WriteError("ERR Provided `XTRIM` Key produced non stream type")
+			return
+		}
+
+		w.WriteInt(int64(record.Streams.Trim(opts)))
+	})
+}
+
+// `ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]`
+func (s *Server) handleZaddCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 4 {
+		w.WriteError("ERR Incorrect amount of args for `ZADD` command")
+		return
+	}
+
+	opts, consumed, err := parseZADDOptions(msg.Array[2:])
+	if err != nil {
+		log.Printf("%s ZADD: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	pairs := msg.Array[2+consumed:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 {
+		w.WriteError("ERR syntax error")
+		return
+	}
+
+	if opts.Flags.Incr && len(pairs) != 2 {
+		w.WriteError("ERR INCR option supports a single increment-element pair")
+		return
+	}
+
+	scores := make([]float64, len(pairs)/2)
+	for i := range scores {
+		scores[i], err = parseScore(pairs[i*2].String)
+		if err != nil {
+			log.Printf("%s ZADD: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR value is not a valid float")
+			return
+		}
+	}
+
+	key := msg.Array[1].String
+	s.updateKey(w, msg, store.SortedSetType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			if opts.Flags.XX {
+				if opts.Flags.Incr {
+					w.WriteNull()
+					return nil, false
+				}
+				w.WriteInt(0)
+				return nil, false
+			}
+			record = &store.Record{Type: store.SortedSetType, SortedSet: store.NewSortedSet()}
+		}
+
+		changed := 0
+		var score float64
+		var status store.ZAddStatus
+		for i, sc := range scores {
+			var err error
+			score, status, err = record.SortedSet.Add(pairs[i*2+1].String, sc, opts.Flags)
+			if err != nil {
+				log.Printf("%s ZADD: add: %v", ErrCmdPrefix, err)
+				w.WriteError("ERR " + err.Error())
+				return nil, false
+			}
+
+			if status == store.ZAddAdded || (opts.CH && status == store.ZAddUpdated) {
+				changed++
+			}
+		}
+
+		if record.SortedSet.Len() > 0 {
+			s.blockingManager.NotifyWatchers(key, record)
+		}
+
+		switch {
+		case !opts.Flags.Incr:
+			w.WriteInt(int64(changed))
+		case status == store.ZAddSkipped:
+			w.WriteNull()
+		default:
+			writeRESPScore(w, score)
+		}
+
+		return record, true
+	})
+}
+
+func (s *Server) handleZcardCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 2 {
+		w.WriteError("ERR Incorrect amount of args for `ZCARD` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.SortedSetType, func(record *store.Record) {
+		if record == nil {
+			w.WriteInt(0)
+			return
+		}
+
+		w.WriteInt(int64(record.SortedSet.Len()))
+	})
+}
+
+func (s *Server) handleZcountCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `ZCOUNT` command")
+		return
+	}
+
+	r, err := parseScoreRange(msg.Array[2].String, msg.Array[3].String)
+	if err != nil {
+		log.Printf("%s ZCOUNT: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR min or max is not a float")
+		return
+	}
+
+	s.viewKey(w, msg, store.SortedSetType, func(record *store.Record) {
+		if record == nil {
+			w.WriteInt(0)
+			return
+		}
+
+		w.WriteInt(int64(record.SortedSet.CountByScore(r)))
+	})
+}
+
+func (s *Server) handleZincrbyCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `ZINCRBY` command")
+		return
+	}
+
+	incr, err := parseScore(msg.Array[2].String)
+	if err != nil {
+		log.Printf("%s ZINCRBY: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR value is not a valid float")
+		return
+	}
+
+	key := msg.Array[1].String
+	s.updateKey(w, msg, store.SortedSetType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			record = &store.Record{Type: store.SortedSetType, SortedSet: store.NewSortedSet()}
+		}
+
+		score, _, err := record.SortedSet.Add(msg.Array[3].String, incr, &store.ZAddFlags{Incr: true})
+		if err != nil {
+			log.Printf("%s ZINCRBY: add: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR " + err.Error())
+			return nil, false
+		}
+
+		s.blockingManager.NotifyWatchers(key, record)
+
+		writeRESPScore(w, score)
+		return record, true
+	})
+}
+
+// `ZMPOP numkeys key [key ...] MIN|MAX [COUNT count]`
+func (s *Server) handleZmpopCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 4 {
+		w.WriteError("ERR Incorrect amount of args for `ZMPOP` command")
+		return
+	}
+
+	opts, err := parseMPOPOptions(msg.Array[1:], "MIN", "MAX")
+	if err != nil {
+		log.Printf("%s ZMPOP: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	key, popped, err := s.popSortedSets(opts.Keys, opts.FromTail, opts.Count)
+	if err != nil {
+		log.Printf("%s ZMPOP: %v", ErrCmdPrefix, err)
+		w.WriteWrongTypeErr()
+		return
+	}
+
+	writeRESPZMPop(w, key, popped)
+}
+
+func (s *Server) handleZmscoreCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `ZMSCORE` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.SortedSetType, func(record *store.Record) {
+		members := msg.Array[2:]
+		w.WriteArrayHeader(len(members))
+		for _, m := range members {
+			if record == nil {
+				w.WriteNull()
+				continue
+			}
+
+			if score, exists := record.SortedSet.Score(m.String); exists {
+				writeRESPScore(w, score)
+			} else {
+				w.WriteNull()
+			}
+		}
+	})
+}
+
+// Handles ZPOPMIN and ZPOPMAX, popping from the high end when `highest` is
+// set: `ZPOPMIN key [count]`
+func (s *Server) handleZpopCommand(conn net.Conn, msg *resp.Message, highest bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 2 || len(msg.Array) > 3 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	count := 1
+	if len(msg.Array) == 3 {
+		var err error
+		count, err = msg.Array[2].ConvInt()
+		if err != nil || count < 0 {
+			log.Printf("%s %s: count parse: %v", ErrCmdPrefix, cmd, err)
+			w.WriteError("ERR value is out of range, must be positive")
+			return
+		}
+	}
+
+	s.updateKey(w, msg, store.SortedSetType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteArrayHeader(0)
+			return nil, false
+		}
+
+		popped := record.SortedSet.Pop(count, highest)
+
+		writeRESPSortedSetMembers(w, popped, true)
+		return record, true
+	})
+}
+
+// `ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]`
+func (s *Server) handleZrangeCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 4 {
+		w.WriteError("ERR Incorrect amount of args for `ZRANGE` command")
+		return
+	}
+
+	opts, err := parseZRANGEOptions(msg.Array[4:], true)
+	if err != nil {
+		log.Printf("%s ZRANGE: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	s.viewKey(w, msg, store.SortedSetType, func(record *store.Record) {
+		if record == nil {
+			w.WriteArrayHeader(0)
+			return
+		}
+
+		members, err := rangeSortedSet(record.SortedSet, msg.Array[2].String, msg.Array[3].String, opts)
+		if err != nil {
+			log.Printf("%s ZRANGE: %v", ErrCmdPrefix, err)
+			w.WriteError("ERR " + err.Error())
+			return
+		}
+
+		writeRESPSortedSetMembers(w, members, opts.WithScores)
+	})
+}
+
+// `ZRANGESTORE dst src min max [BYSCORE|BYLEX] [REV] [LIMIT offset count]`
+func (s *Server) handleZrangestoreCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 5 {
+		w.WriteError("ERR Incorrect amount of args for `ZRANGESTORE` command")
+		return
+	}
+
+	dest, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s ZRANGESTORE: invalid destination: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid destination type for `ZRANGESTORE` command")
+		return
+	}
+
+	src, err := msg.Array[2].ConvStr()
+	if err != nil {
+		log.Printf("%s ZRANGESTORE: invalid source: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid source type for `ZRANGESTORE` command")
+		return
+	}
+
+	opts, err := parseZRANGEOptions(msg.Array[5:], false)
+	if err != nil {
+		log.Printf("%s ZRANGESTORE: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	s.store.Atomically(func(tx *store.Tx) {
+		record, exists := tx.Get(src)
+		if exists && record.Type != store.SortedSetType {
+			log.Printf("%s ZRANGESTORE: invalid type: %s", ErrCmdPrefix, record.Type.String())
+			w.WriteWrongTypeErr()
+			return
+		}
+
+		var members []store.ZMember
+		if exists {
+			members, err = rangeSortedSet(record.SortedSet, msg.Array[3].String, msg.Array[4].String, opts)
+			if err != nil {
+				log.Printf("%s ZRANGESTORE: %v", ErrCmdPrefix, err)
+				w.WriteError("ERR " + err.Error())
+				return
+			}
+		}
+
+		if len(members) == 0 {
+			tx.Delete(dest)
+			w.WriteInt(0)
+			return
+		}
+
+		zset := store.NewSortedSet()
+		for _, m := range members {
+			zset.Add(m.Member, m.Score, nil)
+		}
+		destRecord := &store.Record{Type: store.SortedSetType, SortedSet: zset}
+		tx.Set(dest, destRecord)
+		s.blockingManager.NotifyWatchers(dest, destRecord)
+
+		w.WriteInt(int64(len(members)))
+	})
+}
+
+// Handles ZRANK and ZREVRANK: `ZRANK key member [WITHSCORE]`
+func (s *Server) handleZrankCommand(conn net.Conn, msg *resp.Message, rev bool) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) < 3 || len(msg.Array) > 4 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	withScore := false
+	if len(msg.Array) == 4 {
+		if strings.ToUpper(msg.Array[3].String) != "WITHSCORE" {
+			w.WriteError("ERR syntax error")
+			return
+		}
+		withScore = true
+	}
+
+	writeNull := w.WriteNull
+	if withScore {
+		writeNull = w.WriteNullArray
+	}
+
+	s.viewKey(w, msg, store.SortedSetType, func(record *store.Record) {
+		if record == nil {
+			writeNull()
+			return
+		}
+
+		member := msg.Array[2].String
+		rank, exists := record.SortedSet.Rank(member, rev)
+		if !exists {
+			writeNull()
+			return
+		}
+
+		if !withScore {
+			w.WriteInt(int64(rank))
+			return
+		}
+
+		score, _ := record.SortedSet.Score(member)
+		w.WriteArrayHeader(2)
+		w.WriteInt(int64(rank))
+		writeRESPScore(w, score)
+	})
+}
+
+func (s *Server) handleZremCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `ZREM` command")
+		return
+	}
+
+	s.updateKey(w, msg, store.SortedSetType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteInt(0)
+			return nil, false
+		}
+
+		removed := 0
+		for _, m := range msg.Array[2:] {
+			if record.SortedSet.Remove(m.String) {
+				removed++
+			}
+		}
+
+		w.WriteInt(int64(removed))
+		return record, true
+	})
+}
+
+// Handles ZREMRANGEBYRANK, ZREMRANGEBYSCORE and ZREMRANGEBYLEX, which only
+// differ in how `min` and `max` are interpreted: `ZREMRANGEBYSCORE key min max`
+func (s *Server) handleZremrangeCommand(conn net.Conn, msg *resp.Message, by ZRangeBy) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	cmd := strings.ToUpper(msg.Array[0].String)
+	if len(msg.Array) != 4 {
+		w.WriteError(fmt.Sprintf("ERR Incorrect amount of args for `%s` command", cmd))
+		return
+	}
+
+	rawMin, rawMax := msg.Array[2].String, msg.Array[3].String
+	var remove func(z *store.SortedSet) int
+	switch by {
+	case ZRangeByRank:
+		start, errStart := strconv.Atoi(rawMin)
+		stop, errStop := strconv.Atoi(rawMax)
+		if errStart != nil || errStop != nil {
+			w.WriteError("ERR value is not an integer or out of range")
+			return
+		}
+		remove = func(z *store.SortedSet) int {
+			start, stop, ok := normalizeRankRange(start, stop, z.Len())
+			if !ok {
+				return 0
+			}
+			return z.RemoveRangeByRank(start, stop)
+		}
+	case ZRangeByScore:
+		r, err := parseScoreRange(rawMin, rawMax)
+		if err != nil {
+			log.Printf("%s %s: %v", ErrCmdPrefix, cmd, err)
+			w.WriteError("ERR min or max is not a float")
+			return
+		}
+		remove = func(z *store.SortedSet) int { return z.RemoveRangeByScore(r) }
+	case ZRangeByLex:
+		r, err := parseLexRange(rawMin, rawMax)
+		if err != nil {
+			log.Printf("%s %s: %v", ErrCmdPrefix, cmd, err)
+			w.WriteError("ERR min or max not valid string range item")
+			return
+		}
+		remove = func(z *store.SortedSet) int { return z.RemoveRangeByLex(r) }
+	}
+
+	s.updateKey(w, msg, store.SortedSetType, func(record *store.Record) (*store.Record, bool) {
+		if record == nil {
+			w.WriteInt(0)
+			return nil, false
+		}
+
+		removed := remove(record.SortedSet)
+
+		w.WriteInt(int64(removed))
+		return record, true
+	})
+}
+
+// `ZSCAN key cursor [MATCH pattern] [COUNT count]`
+func (s *Server) handleZscanCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) < 3 {
+		w.WriteError("ERR Incorrect amount of args for `ZSCAN` command")
+		return
+	}
+
+	cursor, opts, err := parseScanArgs(msg.Array[2:], ZSCAN)
+	if err != nil {
+		log.Printf("%s ZSCAN: parse options: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR " + err.Error())
+		return
+	}
+
+	s.viewKey(w, msg, store.SortedSetType, func(record *store.Record) {
+		if record == nil {
+			writeRESPScan(w, 0, nil)
+			return
+		}
+
+		// Scores are bulk strings here even over RESP3, as they share the
+		// array with the members
+		next, members := record.SortedSet.Scan(cursor, opts.Count)
+		result := make([]string, 0, len(members)*2)
+		for _, m := range members {
+			if !opts.matches(m) {
+				continue
+			}
+
+			score, _ := record.SortedSet.Score(m)
+			result = append(result, m, formatScore(score))
+		}
+
+		writeRESPScan(w, next, result)
+	})
+
+}
+
+func (s *Server) handleZscoreCommand(conn net.Conn, msg *resp.Message) {
+	w, done := replyWriter(conn)
+	defer done()
+
+	if len(msg.Array) != 3 {
+		w.WriteError("ERR Incorrect amount of args for `ZSCORE` command")
+		return
+	}
+
+	s.viewKey(w, msg, store.SortedSetType, func(record *store.Record) {
+		if record == nil {
+			w.WriteNull()
+			return
+		}
+
+		score, exists := record.SortedSet.Score(msg.Array[2].String)
+		if !exists {
+			w.WriteNull()
+			return
+		}
+
+		writeRESPScore(w, score)
+	})
+}
+
+// Sends the replies still buffered for `conn` before it blocks, so commands
+// pipelined ahead of a blocking one aren't left waiting on it.
+func flushBeforeBlocking(conn net.Conn) {
+	if c, ok := conn.(*client); ok {
+		c.w.Flush()
+	}
+}
+
+// Fetches the set at each key, with missing keys coming back as nil sets so
+// they behave like empty ones.
+func lookupSets(tx *store.Tx, keys []string) ([]*store.Set, error) {
+	sets := make([]*store.Set, len(keys))
+	for i, key := range keys {
+		record, exists := tx.Get(key)
+		if !exists {
+			continue
+		}
+
+		if record.Type != store.SetType {
+			return nil, fmt.Errorf("%s key (%s): %w", ErrCmdPrefix, key, store.ErrWrongType)
+		}
+
+		sets[i] = record.Set
+	}
+
+	return sets, nil
+}
+
+// Resolves the consumer group `groupName` of the stream at `key`. When either
+// doesn't exist the appropriate error reply is returned instead.
+func lookupStreamGroup(w *resp.Writer, tx *store.Tx, cmd CmdName, key, groupName string) (*store.Stream, *store.ConsumerGroup, bool) {
+	record, exists := tx.Get(key)
+	if exists && record.Type != store.StreamType {
+		log.Printf("%s %s: invalid type: %s", ErrCmdPrefix, cmd, record.Type.String())
+		w.WriteError(fmt.Sprintf("ERR Provided `%s` Key produced non stream type", cmd))
+		return nil, nil, false
+	}
+
+	if exists {
+		if group, ok := record.Streams.Group(groupName); ok {
+			return record.Streams, group, true
+		}
+	}
+
+	errMsg := fmt.Sprintf("NOGROUP No such key '%s' or consumer group '%s'", key, groupName)
+	if cmd == XREADGROUP {
+		errMsg += " in XREADGROUP with GROUP option"
+	}
+	w.WriteError(errMsg)
+	return nil, nil, false
+}
+
+func messageStrings(msgs []*resp.Message) []string {
+	ss := make([]string, len(msgs))
+	for i, m := range msgs {
+		ss[i] = m.String
+	}
+
+	return ss
+}
+
+// Pops the element at one end of the list at `src` and pushes it onto one
+// end of the list at `dest`, which may be the same list. Returns a nil
+// element when `src` is empty. Clients blocked on `dest` are woken up by the
+// push, which is what lets a chain of blocking moves drain into each other.
+func (s *Server) moveListElement(src, dest string, fromTail, toTail bool) (*store.Record, error) {
+	var element *store.Record
+	var err error
+	s.store.Atomically(func(tx *store.Tx) {
+		srcRecord, srcExists := tx.Get(src)
+		if srcExists && srcRecord.Type != store.ArrayType {
+			err = fmt.Errorf("%s source (%s): %w", ErrCmdPrefix, src, store.ErrWrongType)
+			return
+		}
+
+		destRecord, destExists := tx.Get(dest)
+		if destExists && destRecord.Type != store.ArrayType {
+			err = fmt.Errorf("%s destination (%s): %w", ErrCmdPrefix, dest, store.ErrWrongType)
+			return
+		}
+
+		if !srcExists || srcRecord.List.Len() == 0 {
+			return
+		}
+
+		element = popList(srcRecord, 1, fromTail)[0]
+		tx.Set(src, srcRecord)
+		if src != dest && srcRecord.List.Len() > 0 {
+			s.blockingManager.NotifyWatchers(src, srcRecord)
+		}
+
+		if src == dest {
+			destRecord = srcRecord
+		} else if !destExists {
+			destRecord = &store.Record{Type: store.ArrayType, List: store.NewList()}
+		}
+
+		if toTail {
+			destRecord.List.PushBack(element)
+		} else {
+			destRecord.List.PushFront(element)
+		}
+
+		tx.Set(dest, destRecord)
+		s.blockingManager.NotifyWatchers(dest, destRecord)
+	})
+
+	return element, err
+}
+
+// Resolves negative ranks against `length`. Unlike NormalizeIndex, a stop
+// that lands before the first element means the range is empty rather than
+// being anchored at 0.
+func normalizeRankRange(start, stop, length int) (int, int, bool) {
+	start = NormalizeIndex(start, length)
+	if stop < 0 {
+		stop += length
+	}
+
+	return start, stop, stop >= 0 && start <= stop
+}
+
+// Wakes whoever is blocked on `key` now that `record` landed there by some
+// other means than a push, like RENAME or COPY.
+func (s *Server) notifyKeyReady(key string, record *store.Record) {
+	if record.Type == store.StreamType {
+		s.blockingManager.NotifyStreamWatchers(key, record.Streams)
+		return
+	}
+
+	s.blockingManager.NotifyWatchers(key, record)
+}
+
+func parseListEnd(raw string) (fromTail bool, ok bool) {
+	switch strings.ToUpper(raw) {
+	case "LEFT":
+		return false, true
+	case "RIGHT":
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// Hands a wakeup that reached `bc` as it was leaving the queue on to the
+// next client blocked on that key, as `bc` won't be consuming it.
+func (s *Server) passOnWakeup(bc *BlockedClient) {
+	select {
+	case res := <-bc.replyCh:
+		s.blockingManager.NotifyWatchers(res.key, res.rec)
+	default:
+	}
+}
+
+// Picks `count` random elements of `elems` for HRANDFIELD and SRANDMEMBER,
+// handing each to `fn`. A positive count picks distinct elements, up to all
+// of them, while a negative one picks that many allowing repeats. `elems` may
+// be reordered. Like Redis, counts that can't be negated, or doubled for
+// WITHVALUES, are refused before anything is picked.
+func pickRandom(elems []string, count int, fn func(elem string)) error {
+	if count < -math.MaxInt/2 || count > math.MaxInt/2 {
+		return errors.New("value is out of range")
+	}
+
+	switch {
+	case len(elems) == 0 || count == 0:
+	case count > 0:
+		rand.Shuffle(len(elems), func(i, j int) { elems[i], elems[j] = elems[j], elems[i] })
+		for _, e := range elems[:min(count, len(elems))] {
+			fn(e)
+		}
+	default:
+		for range -count {
+			fn(elems[rand.IntN(len(elems))])
+		}
+	}
+
+	return nil
+}
+
+// Removes up to `count` elements from the head of the list, or the tail
+// when `fromTail` is set, returning them in the order they were popped.
+func popList(record *store.Record, count int, fromTail bool) []*store.Record {
+	pop := record.List.PopFront
+	if fromTail {
+		pop = record.List.PopBack
+	}
+
+	popped := make([]*store.Record, 0, min(count, record.List.Len()))
+	for range cap(popped) {
+		element, _ := pop()
+		popped = append(popped, element)
+	}
+
+	return popped
+}
+
+// Pops up to `count` elements from the first non-empty list among `keys`,
+// returning its key, or an empty key when every list is empty. When
+// elements remain afterwards, the next blocked client is woken up in turn.
+func (s *Server) popLists(keys []string, fromTail bool, count int) (string, []*store.Record, error) {
+	var key string
+	var popped []*store.Record
+	var err error
+	s.store.Atomically(func(tx *store.Tx) {
+		for _, k := range keys {
+			record, exists := tx.Get(k)
+			if !exists {
+				continue
+			}
+
+			if record.Type != store.ArrayType {
+				err = fmt.Errorf("%s key (%s): %w", ErrCmdPrefix, k, store.ErrWrongType)
+				return
+			}
+
+			if record.List.Len() == 0 {
+				continue
+			}
+
+			key, popped = k, popList(record, count, fromTail)
+			tx.Set(k, record)
+
+			// Hand what's left over to the next client blocked on this list
+			if record.List.Len() > 0 {
+				s.blockingManager.NotifyWatchers(k, record)
+			}
+			return
+		}
+	})
+
+	return key, popped, err
+}
+
+// Pops up to `count` members from the first non-empty sorted set among
+// `keys`, returning its key, or an empty key when every set is empty. When
+// members remain afterwards, the next blocked client is woken up in turn.
+func (s *Server) popSortedSets(keys []string, highest bool, count int) (string, []store.ZMember, error) {
+	var key string
+	var popped []store.ZMember
+	var err error
+	s.store.Atomically(func(tx *store.Tx) {
+		for _, k := range keys {
+			record, exists := tx.Get(k)
+			if !exists {
+				continue
+			}
+
+			if record.Type != store.SortedSetType {
+				err = fmt.Errorf("%s key (%s): %w", ErrCmdPrefix, k, store.ErrWrongType)
+				return
+			}
+
+			key, popped = k, record.SortedSet.Pop(count, highest)
+			tx.Set(k, record)
+
+			if record.SortedSet.Len() > 0 {
+				s.blockingManager.NotifyWatchers(k, record)
+			}
+			return
+		}
+	})
+
+	return key, popped, err
+}
+
+// Resolves `start` and `stop` according to the ZRANGE options and returns
+// the members in between. With REV, score and lex intervals are given from
+// the high end, i.e. `max min`.
+func rangeSortedSet(z *store.SortedSet, start, stop string, opts *ZRangeOptions) ([]store.ZMember, error) {
+	switch opts.By {
+	case ZRangeByScore:
+		if opts.Rev {
+			start, stop = stop, start
+		}
+
+		r, err := parseScoreRange(start, stop)
+		if err != nil {
+			return nil, errors.New("min or max is not a float")
+		}
+
+		return z.RangeByScore(r, opts.Rev, opts.Offset, opts.Count), nil
+	case ZRangeByLex:
+		if opts.Rev {
+			start, stop = stop, start
+		}
+
+		r, err := parseLexRange(start, stop)
+		if err != nil {
+			return nil, errors.New("min or max not valid string range item")
+		}
+
+		return z.RangeByLex(r, opts.Rev, opts.Offset, opts.Count), nil
+	default:
+		startIdx, errStart := strconv.Atoi(start)
+		stopIdx, errStop := strconv.Atoi(stop)
+		if errStart != nil || errStop != nil {
+			return nil, errors.New("value is not an integer or out of range")
+		}
+
+		startIdx, stopIdx, ok := normalizeRankRange(startIdx, stopIdx, z.Len())
+		if !ok {
+			return []store.ZMember{}, nil
+		}
+
+		return z.RangeByRank(startIdx, stopIdx, opts.Rev), nil
+	}
+}
+
+// Reads the entries at `key` through the consumer group in `opts`. On
+// failure the error reply is written to `w` and false is returned, which
+// must happen before anything else of the reply is written.
+func readStreamGroup(w *resp.Writer, tx *store.Tx, opts *XReadOptions, key, rawID string) ([]*store.StreamEntry, bool) {
+	stream, group, ok := lookupStreamGroup(w, tx, XREADGROUP, key, opts.Group)
+	if !ok {
+		return nil, false
+	}
+
+	consumer, _ := group.CreateConsumer(opts.Consumer)
+
+	if rawID == ">" {
+		return group.ReadNew(stream, consumer, opts.Count, opts.NoAck), true
+	}
+
+	after, err := store.ParseStreamID(rawID, 0)
+	if err != nil {
+		log.Printf("%s XREADGROUP: parse id: %v", ErrCmdPrefix, err)
+		w.WriteError("ERR Invalid stream ID specified as stream command argument")
+		return nil, false
+	}
+
+	return group.ReadPending(stream, consumer, after, opts.Count), true
+}
+
+// Returns the writer to reply to `conn` with, and what to call once done
+// replying. A client's replies are left in its buffer for the connection
+// loop to flush, while anything else gets a writer of its own that is
+// flushed there and then.
+func replyWriter(conn net.Conn) (*resp.Writer, func()) {
+	if c, ok := conn.(*client); ok {
+		return c.w, func() {}
+	}
+
+	w := resp.NewWriter(conn)
+	return w, func() { w.Flush() }
+}
+
+// Updates a field's value in place so that a TTL set on it survives the
+// write, unlike HSET which replaces the field outright.
+func setHashField(record *store.Record, field string, value string) {
+	if existing, ok := record.Map[field]; ok {
+		existing.String = value
+		return
+	}
+
+	record.SetField(field, &store.Record{Type: store.StringType, String: value})
+}
+
+// Runs `fn` on the record at the key in msg.Array[1] under the store's
+// write lock, with `fn` writing its reply to `w`. That's fine under the lock
+// as the writer only buffers, the socket is written once the lock is gone.
+// Keys holding something other than a `kind` get a WRONGTYPE reply without
+// `fn` running, and missing keys hand `fn` a nil record. Returning true
+// stores `fn`'s record back, where a nil record or an empty collection
+// deletes the key.
+func (s *Server) updateKey(w *resp.Writer, msg *resp.Message, kind store.StoreType, fn func(record *store.Record) (*store.Record, bool)) {
+	cmd := strings.ToUpper(msg.Array[0].String)
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s %s: invalid key: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError(fmt.Sprintf("ERR Invalid key type for `%s` command", cmd))
+		return
+	}
+
+	s.store.Update(key, func(rec *store.Record, exists bool) (*store.Record, bool) {
+		if exists && rec.Type != kind {
+			log.Printf("%s %s: invalid type: %s", ErrCmdPrefix, cmd, rec.Type.String())
+			w.WriteWrongTypeErr()
+			return nil, false
+		}
+
+		return fn(rec)
+	})
+}
+
+// Like updateKey, for commands that only read the record.
+func (s *Server) viewKey(w *resp.Writer, msg *resp.Message, kind store.StoreType, fn func(record *store.Record)) {
+	cmd := strings.ToUpper(msg.Array[0].String)
+	key, err := msg.Array[1].ConvStr()
+	if err != nil {
+		log.Printf("%s %s: invalid key: %v", ErrCmdPrefix, cmd, err)
+		w.WriteError(fmt.Sprintf("ERR Invalid key type for `%s` command", cmd))
+		return
+	}
+
+	s.store.View(key, func(rec *store.Record, exists bool) {
+		if exists && rec.Type != kind {
+			log.Printf("%s %s: invalid type: %s", ErrCmdPrefix, cmd, rec.Type.String())
+			w.WriteWrongTypeErr()
+			return
+		}
+
+		fn(rec)
+	})
+}
+
+// Blocks the client on `keys` until `try` succeeds for a key it was woken up
+// for, or the timeout elapses, reporting which one happened. `try` failing
+// means another client got to the key first, so we go back to waiting. A
+// zero timeout waits forever.
+func (s *Server) waitForKeys(conn net.Conn, kind store.StoreType, keys []string, timeout time.Duration, try func(key string) bool) bool {
+	bc := &BlockedClient{
+		conn:    conn,
+		kind:    kind,
+		replyCh: make(chan *BlockedClientChanResp, 1),
+		subs:    keys,
+	}
+
+	// A nil channel never fires, which gives us blocking forever for free
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timeoutCh = time.After(timeout)
+	}
+
+	for {
+		s.blockingManager.RegisterClient(bc)
+
+		// Anything pushed before we were registered didn't wake us up, so
+		// look again now that nothing can slip past
+		if slices.ContainsFunc(keys, try) {
+			s.blockingManager.UnregisterClient(bc)
+			s.passOnWakeup(bc)
+			return true
+		}
+
+		flushBeforeBlocking(conn)
+		select {
+		case res := <-bc.replyCh:
+			if try(res.key) {
+				return true
+			}
+		case <-timeoutCh:
+			s.blockingManager.UnregisterClient(bc)
+			s.passOnWakeup(bc)
+			return false
+		}
+	}
+}
+
+// `XINFO CONSUMERS key group`
+func xinfoConsumers(w *resp.Writer, msg *resp.Message, stream *store.Stream) {
+	if len(msg.Array) != 4 {
+		w.WriteError("ERR Incorrect amount of args for `XINFO CONSUMERS` command")
+		return
+	}
+
+	group, exists := stream.Group(msg.Array[3].String)
+	if !exists {
+		w.WriteError(fmt.Sprintf("NOGROUP No such consumer group '%s' for key name '%s'", msg.Array[3].String, msg.Array[2].String))
+		return
+	}
+
+	consumers := group.Consumers()
+	w.WriteArrayHeader(len(consumers))
+	for _, c := range consumers {
+		inactive := int64(-1)
+		if !c.ActiveTime.IsZero() {
+			inactive = time.Since(c.ActiveTime).Milliseconds()
+		}
+
+		w.WriteMapHeader(4)
+		w.WriteBulkString("name")
+		w.WriteBulkString(c.Name)
+		w.WriteBulkString("pending")
+		w.WriteInt(int64(c.PendingCount()))
+		w.WriteBulkString("idle")
+		w.WriteInt(time.Since(c.SeenTime).Milliseconds())
+		w.WriteBulkString("inactive")
+		w.WriteInt(inactive)
+	}
+}
+
+// `XINFO GROUPS key`
+func xinfoGroups(w *resp.Writer, msg *resp.Message, stream *store.Stream) {
+	if len(msg.Array) != 3 {
+		w.WriteError("ERR Incorrect amount of args for `XINFO GROUPS` command")
+		return
+	}
+
+	groups := stream.Groups()
+	w.WriteArrayHeader(len(groups))
+	for _, g := range groups {
+		w.WriteMapHeader(6)
+		w.WriteBulkString("name")
+		w.WriteBulkString(g.Name)
+		w.WriteBulkString("consumers")
+		w.WriteInt(int64(len(g.Consumers())))
+		w.WriteBulkString("pending")
+		w.WriteInt(int64(g.PendingCount()))
+		w.WriteBulkString("last-delivered-id")
+		w.WriteBulkString(g.LastID.String())
+		w.WriteBulkString("entries-read")
+		writeRESPEntriesRead(w, g.EntriesRead)
+		w.WriteBulkString("lag")
+		writeRESPLag(w, g, stream)
+	}
+}
+
+// `XINFO STREAM key [FULL [COUNT count]]`
+func xinfoStream(w *resp.Writer, msg *resp.Message, stream *store.Stream) {
+	full := false
+	count := 10
+	for i := 3; i < len(msg.Array); i++ {
+		switch strings.ToUpper(msg.Array[i].String) {
+		case "FULL":
+			full = true
+		case "COUNT":
+			if !full || i+1 >= len(msg.Array) {
+				w.WriteError("ERR syntax error")
+				return
+			}
+
+			var err error
+			count, err = msg.Array[i+1].ConvInt()
+			if err != nil {
+				log.Printf("%s XINFO STREAM: count parse: %v", ErrCmdPrefix, err)
+				w.WriteError("ERR value is not an integer or out of range")
+				return
+			}
+			i++
+		default:
+			w.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	recordedFirstID := store.MinStreamID
+	first, hasFirst := stream.First()
+	if hasFirst {
+		recordedFirstID = first.ID
+	}
+
+	// Both forms share the first 7 fields, FULL adds 2 of its own and the
+	// summary 3
+	if full {
+		w.WriteMapHeader(9)
+	} else {
+		w.WriteMapHeader(10)
+	}
+
+	w.WriteBulkString("length")
+	w.WriteInt(int64(stream.Len()))
+	w.WriteBulkString("radix-tree-keys")
+	w.WriteInt(int64(stream.Len()))
+	w.WriteBulkString("radix-tree-nodes")
+	w.WriteInt(int64(stream.NodeCount()))
+	w.WriteBulkString("last-generated-id")
+	w.WriteBulkString(stream.LastID().String())
+	w.WriteBulkString("max-deleted-entry-id")
+	w.WriteBulkString(stream.MaxDeletedID().String())
+	w.WriteBulkString("entries-added")
+	w.WriteInt(int64(stream.EntriesAdded()))
+	w.WriteBulkString("recorded-first-entry-id")
+	w.WriteBulkString(recordedFirstID.String())
+
+	if !full {
+		w.WriteBulkString("groups")
+		w.WriteInt(int64(len(stream.Groups())))
+		w.WriteBulkString("first-entry")
+		if hasFirst {
+			writeRESPStreamEntry(w, first)
+		} else {
+			w.WriteNull()
+		}
+		w.WriteBulkString("last-entry")
+		if last, ok := stream.Last(); ok {
+			writeRESPStreamEntry(w, last)
+		} else {
+			w.WriteNull()
+		}
+		return
+	}
+
+	w.WriteBulkString("entries")
+	writeRESPStreamEntries(w, stream.Range(store.MinStreamID, store.MaxStreamID, max(count, 0), false))
+
+	groups := stream.Groups()
+	w.WriteBulkString("groups")
+	w.WriteArrayHeader(len(groups))
+	for _, g := range groups {
+		pelLimit := g.PendingCount()
+		if count > 0 {
+			pelLimit = min(pelLimit, count)
+		}
+
+		w.WriteMapHeader(7)
+		w.WriteBulkString("name")
+		w.WriteBulkString(g.Name)
+		w.WriteBulkString("last-delivered-id")
+		w.WriteBulkString(g.LastID.String())
+		w.WriteBulkString("entries-read")
+		writeRESPEntriesRead(w, g.EntriesRead)
+		w.WriteBulkString("lag")
+		writeRESPLag(w, g, stream)
+		w.WriteBulkString("pel-count")
+		w.WriteInt(int64(g.PendingCount()))
+
+		pel := g.Pending(store.MinStreamID, store.MaxStreamID, pelLimit, "", 0)
+		w.WriteBulkString("pending")
+		w.WriteArrayHeader(len(pel))
+		for _, pe := range pel {
+			w.WriteArrayHeader(4)
+			w.WriteBulkString(pe.ID.String())
+			w.WriteBulkString(pe.Consumer.Name)
+			w.WriteInt(pe.DeliveryTime.UnixMilli())
+			w.WriteInt(pe.DeliveryCount)
+		}
+
+		consumers := g.Consumers()
+		w.WriteBulkString("consumers")
+		w.WriteArrayHeader(len(consumers))
+		for _, c := range consumers {
+			activeTime := int64(-1)
+			if !c.ActiveTime.IsZero() {
+				activeTime = c.ActiveTime.UnixMilli()
+			}
+
+			w.WriteMapHeader(5)
+			w.WriteBulkString("name")
+			w.WriteBulkString(c.Name)
+			w.WriteBulkString("seen-time")
+			w.WriteInt(c.SeenTime.UnixMilli())
+			w.WriteBulkString("active-time")
+			w.WriteInt(activeTime)
+			w.WriteBulkString("pel-count")
+			w.WriteInt(int64(c.PendingCount()))
+
+			consumerPEL := g.Pending(store.MinStreamID, store.MaxStreamID, pelLimit, c.Name, 0)
+			w.WriteBulkString("pending")
+			w.WriteArrayHeader(len(consumerPEL))
+			for _, pe := range consumerPEL {
+				w.WriteArrayHeader(3)
+				w.WriteBulkString(pe.ID.String())
+				w.WriteInt(pe.DeliveryTime.UnixMilli())
+				w.WriteInt(pe.DeliveryCount)
+			}
+		}
+	}
+}
+
+// Writes the [count, smallest ID, greatest ID, [[consumer, count], ...]]
+// reply
+func xpendingSummary(w *resp.Writer, group *store.ConsumerGroup) {
+	pending := group.Pending(store.MinStreamID, store.MaxStreamID, group.PendingCount(), "", 0)
+	w.WriteArrayHeader(4)
+	if len(pending) == 0 {
+		w.WriteInt(0)
+		w.WriteNull()
+		w.WriteNull()
+		w.WriteNullArray()
+		return
+	}
+
+	consumers := make([]*store.Consumer, 0)
+	for _, c := range group.Consumers() {
+		if c.PendingCount() > 0 {
+			consumers = append(consumers, c)
+		}
+	}
+
+	w.WriteInt(int64(len(pending)))
+	w.WriteBulkString(pending[0].ID.String())
+	w.WriteBulkString(pending[len(pending)-1].ID.String())
+	w.WriteArrayHeader(len(consumers))
+	for _, c := range consumers {
+		w.WriteArrayHeader(2)
+		w.WriteBulkString(c.Name)
+		w.WriteBulkString(strconv.Itoa(c.PendingCount()))
+	}
 }
@@ -0,0 +1,1809 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ev-the-dev/redis-go-clone/resp"
+	"github.com/ev-the-dev/redis-go-clone/store"
+)
+
+// Stands in for a client connection, keeping whatever the server writes.
+type recordingConn struct {
+	net.Conn
+	buf bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	return c.buf.Write(b)
+}
+
+// Runs a single command on `conn` and parses the reply it gets, with nil
+// standing for any null reply.
+func (c *recordingConn) call(t *testing.T, handle func(net.Conn, *resp.Message), args ...string) *resp.Message {
+	t.Helper()
+
+	c.buf.Reset()
+	handle(c, command(args...))
+
+	reply, err := resp.Parse(bufio.NewReader(&c.buf))
+	if err != nil {
+		t.Errorf("%s: parse reply: %v", args[0], err)
+		return nil
+	}
+
+	if reply.Type == resp.Nulls || reply.Length < 0 {
+		return nil
+	}
+
+	return reply
+}
+
+func command(args ...string) *resp.Message {
+	msg := &resp.Message{Type: resp.Array, Length: len(args)}
+	for _, a := range args {
+		msg.Array = append(msg.Array, &resp.Message{Type: resp.BulkString, Length: len(a), String: a})
+	}
+
+	return msg
+}
+
+func newTestServer() *Server {
+	return &Server{
+		blockingManager: &BlockingManager{queue: make(map[string][]*BlockedClient)},
+		store:           store.New(),
+	}
+}
+
+// Flattens a reply so replies of any shape compare as strings: nulls come
+// out as "nil", arrays as "[a b]" and maps as "{k:v}", with the members of
+// sets and maps sorted as their order is unspecified.
+func flatten(reply *resp.Message) string {
+	if reply == nil || reply.Type == resp.Nulls || reply.Length < 0 {
+		return "nil"
+	}
+
+	switch reply.Type {
+	case resp.Integer:
+		return strconv.Itoa(reply.Integer)
+	case resp.Doubles:
+		// Spelled the way scores sent as bulk strings are
+		switch {
+		case math.IsInf(reply.Double, 1):
+			return "inf"
+		case math.IsInf(reply.Double, -1):
+			return "-inf"
+		}
+		return strconv.FormatFloat(reply.Double, 'f', -1, 64)
+	case resp.Booleans:
+		return strconv.FormatBool(reply.Boolean)
+	case resp.Array, resp.Pushes, resp.Sets:
+		elems := make([]string, len(reply.Array))
+		for i, e := range reply.Array {
+			elems[i] = flatten(e)
+		}
+		if reply.Type == resp.Sets {
+			slices.Sort(elems)
+		}
+		return "[" + strings.Join(elems, " ") + "]"
+	case resp.Maps:
+		pairs := make([]string, 0, len(reply.Map))
+		for k, v := range reply.Map {
+			pairs = append(pairs, k+":"+flatten(v))
+		}
+		slices.Sort(pairs)
+		return "{" + strings.Join(pairs, " ") + "}"
+	default:
+		return reply.String
+	}
+}
+
+// A command to run and the flattened reply it should get.
+type testStep struct {
+	handle func(net.Conn, *resp.Message)
+	args   []string
+	want   string
+}
+
+// Runs each step on `conn` in turn, checking the reply to each.
+func runSteps(t *testing.T, conn *recordingConn, steps []testStep) {
+	t.Helper()
+
+	for _, step := range steps {
+		if got := flatten(conn.call(t, step.handle, step.args...)); got != step.want {
+			t.Errorf("%s: got %s, want %s", strings.Join(step.args, " "), got, step.want)
+		}
+	}
+}
+
+const wrongType = "WRONGTYPE Operation against a key holding the wrong kind of value"
+
+// Runs a command that may block on a connection of its own, handing back
+// its reply once there is one.
+func callAsync(t *testing.T, handle func(net.Conn, *resp.Message), args ...string) <-chan *resp.Message {
+	t.Helper()
+
+	ch := make(chan *resp.Message, 1)
+	go func() {
+		ch <- (&recordingConn{}).call(t, handle, args...)
+	}()
+
+	return ch
+}
+
+// Waits for the reply to a command run with callAsync.
+func awaitReply(t *testing.T, ch <-chan *resp.Message) *resp.Message {
+	t.Helper()
+
+	select {
+	case reply := <-ch:
+		return reply
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a reply")
+		return nil
+	}
+}
+
+// Waits until `n` clients are blocked on `key`.
+func waitBlocked(t *testing.T, s *Server, key string, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.blockingManager.mu.Lock()
+		blocked := len(s.blockingManager.queue[key])
+		s.blockingManager.mu.Unlock()
+
+		if blocked >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients blocked on %s, want %d", blocked, key, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Pushes from both ends while LPOP and BLPOP drain the list concurrently,
+// then checks every element came out exactly once. Run with -race.
+func TestConcurrentPushPop(t *testing.T) {
+	const (
+		pushers   = 8
+		perPusher = 500
+		poppers   = 8
+		total     = pushers * perPusher
+	)
+
+	s := newTestServer()
+	var popped atomic.Int64
+	results := make([][]string, poppers)
+
+	var wg sync.WaitGroup
+	for p := range pushers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := &recordingConn{}
+			push := s.handleLpushCommand
+			if p%2 == 1 {
+				push = s.handleRpushCommand
+			}
+
+			for i := range perPusher {
+				push(conn, command("LPUSH", "jobs", fmt.Sprintf("%d-%d", p, i)))
+			}
+		}()
+	}
+
+	for c := range poppers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := &recordingConn{}
+			blpop := func(conn net.Conn, msg *resp.Message) { s.handleBLPOPCommand(conn, msg, false) }
+
+			for popped.Load() < total {
+				var value string
+				if c%2 == 0 {
+					reply := conn.call(t, s.handleLpopCommand, "LPOP", "jobs")
+					if reply == nil {
+						continue
+					}
+					value = reply.String
+				} else {
+					reply := conn.call(t, blpop, "BLPOP", "jobs", "0.01")
+					if reply == nil {
+						continue
+					}
+					value = reply.Array[1].String
+				}
+
+				results[c] = append(results[c], value)
+				popped.Add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	seen := make(map[string]int, total)
+	for _, values := range results {
+		for _, v := range values {
+			seen[v]++
+		}
+	}
+
+	for p := range pushers {
+		for i := range perPusher {
+			v := fmt.Sprintf("%d-%d", p, i)
+			if n := seen[v]; n != 1 {
+				t.Errorf("element %s popped %d times", v, n)
+			}
+		}
+	}
+
+	if len(seen) != total {
+		t.Errorf("popped %d distinct elements, want %d", len(seen), total)
+	}
+}
+
+// Replies are downgraded until the client switches to RESP3 with HELLO, and
+// again once it switches back.
+func TestHelloSwitchesProtocol(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	c := s.newClient(conn)
+	hello := func(_ net.Conn, msg *resp.Message) {
+		s.handleHelloCommand(c, msg)
+		c.w.Flush()
+	}
+
+	s.handleHsetCommand(c, command("HSET", "h", "f", "v"))
+	c.w.Flush()
+
+	for _, tt := range []struct {
+		protocol string
+		want     string
+	}{
+		{"", "*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{"3", "%1\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{"2", "*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+	} {
+		if tt.protocol != "" {
+			if reply := conn.call(t, hello, "HELLO", tt.protocol); reply == nil || fmt.Sprint(c.w.Protocol) != tt.protocol {
+				t.Fatalf("HELLO %s: got %+v on protocol %d", tt.protocol, reply, c.w.Protocol)
+			}
+		}
+
+		conn.buf.Reset()
+		s.handleHgetallCommand(c, command("HGETALL", "h"))
+		c.w.Flush()
+		if got := conn.buf.String(); got != tt.want {
+			t.Errorf("HGETALL after HELLO %q: got %q, want %q", tt.protocol, got, tt.want)
+		}
+	}
+
+	if reply := conn.call(t, hello, "HELLO", "4"); reply == nil || reply.String != "NOPROTO unsupported protocol version" {
+		t.Errorf("HELLO 4: got %+v", reply)
+	}
+}
+
+// Counts the writes that reach the connection, each being a syscall on a
+// real one.
+type countingConn struct {
+	net.Conn
+	writes atomic.Int64
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(b)
+}
+
+// Replies to pipelined commands go out together once the batch is done,
+// except those ahead of a blocking command, which don't wait on it.
+func TestPipelinedRepliesAreBatched(t *testing.T) {
+	s := newTestServer()
+	server, cli := net.Pipe()
+	defer cli.Close()
+
+	conn := &countingConn{Conn: server}
+	go s.handleConnection(conn)
+
+	r := bufio.NewReader(cli)
+	readReplies := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			reply, err := resp.Parse(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if reply.String != w {
+				t.Fatalf("got %+v, want %q", reply, w)
+			}
+		}
+	}
+
+	go cli.Write([]byte(strings.Repeat("*1\r\n$4\r\nPING\r\n", 16)))
+	readReplies(slices.Repeat([]string{"PONG"}, 16)...)
+	if n := conn.writes.Load(); n != 1 {
+		t.Errorf("16 pipelined PINGs took %d writes, want 1", n)
+	}
+
+	go cli.Write([]byte("PING\r\nBLPOP q 0\r\n"))
+	readReplies("PONG")
+
+	s.handleRpushCommand(&recordingConn{}, command("RPUSH", "q", "x"))
+	reply, err := resp.Parse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reply.Array) != 2 || reply.Array[1].String != "x" {
+		t.Errorf("BLPOP: got %+v", reply)
+	}
+}
+
+// Counts too big to negate are refused rather than allocated for, and
+// negative ones repeat elements as needed.
+func TestRandomPickCounts(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleHsetCommand, "HSET", "h", "f", "v")
+	conn.call(t, s.handleSaddCommand, "SADD", "s", "m")
+
+	for _, count := range []string{"-4611686018427387904", "-9223372036854775808", "4611686018427387904"} {
+		if reply := conn.call(t, s.handleHrandfieldCommand, "HRANDFIELD", "h", count); reply == nil || reply.String != "ERR value is out of range" {
+			t.Errorf("HRANDFIELD %s: got %+v", count, reply)
+		}
+		if reply := conn.call(t, s.handleSrandmemberCommand, "SRANDMEMBER", "s", count); reply == nil || reply.String != "ERR value is out of range" {
+			t.Errorf("SRANDMEMBER %s: got %+v", count, reply)
+		}
+	}
+
+	if reply := conn.call(t, s.handleHrandfieldCommand, "HRANDFIELD", "h", "-3", "WITHVALUES"); reply == nil || len(reply.Array) != 6 {
+		t.Errorf("HRANDFIELD -3 WITHVALUES: got %+v", reply)
+	}
+	if reply := conn.call(t, s.handleSrandmemberCommand, "SRANDMEMBER", "s", "5"); reply == nil || len(reply.Array) != 1 {
+		t.Errorf("SRANDMEMBER 5: got %+v", reply)
+	}
+}
+
+// A numkeys too big to add the other arguments to is refused like any other
+// that exceeds the arguments given.
+func TestNumkeysOverflow(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+
+	for _, tt := range []struct {
+		handle func(net.Conn, *resp.Message)
+		args   []string
+	}{
+		{s.handleSintercardCommand, []string{"SINTERCARD", "9223372036854775807", "a"}},
+		{s.handleLmpopCommand, []string{"LMPOP", "9223372036854775807", "a", "LEFT"}},
+		{s.handleZmpopCommand, []string{"ZMPOP", "9223372036854775807", "a", "MIN"}},
+		{s.handleBlmpopCommand, []string{"BLMPOP", "0", "9223372036854775807", "a", "LEFT"}},
+		{s.handleBzmpopCommand, []string{"BZMPOP", "0", "9223372036854775807", "a", "MIN"}},
+	} {
+		if reply := conn.call(t, tt.handle, tt.args...); reply == nil || reply.Type != resp.SimpleError {
+			t.Errorf("%v: got %+v", tt.args, reply)
+		}
+	}
+}
+
+func TestSetrangeOffsetOverflow(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+
+	for _, offset := range []string{"9223372036854775807", "536870912"} {
+		if reply := conn.call(t, s.handleSetrangeCommand, "SETRANGE", "k", offset, "v"); reply == nil || reply.Type != resp.SimpleError {
+			t.Errorf("offset %s: got %+v", offset, reply)
+		}
+	}
+
+	if reply := conn.call(t, s.handleSetrangeCommand, "SETRANGE", "k", "536870911", ""); reply == nil || reply.Integer != 0 {
+		t.Errorf("empty value at the last offset: got %+v", reply)
+	}
+}
+
+func TestInfoReportsExpiredKeys(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+
+	s.store.Set("k", &store.Record{Type: store.StringType, String: "v", ExpiresAt: time.Now().Add(-time.Second)})
+	if reply := conn.call(t, s.handleGetCommand, "GET", "k"); reply != nil {
+		t.Fatalf("GET of an expired key: got %+v", reply)
+	}
+
+	reply := conn.call(t, s.handleInfoCommand, "INFO", "stats")
+	if reply == nil || !strings.Contains(reply.String, "\r\nexpired_keys:1\r\n") || !strings.Contains(reply.String, "\r\nexpired_time_cap_reached_count:0\r\n") {
+		t.Fatalf("INFO stats: got %+v", reply)
+	}
+
+	if reply := conn.call(t, s.handleInfoCommand, "INFO", "keyspace"); reply == nil || reply.String != "" {
+		t.Fatalf("INFO keyspace: got %+v", reply)
+	}
+}
+
+// COUNT is only a hint, however large it is
+func TestScanHugeCount(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleSetCommand, "SET", "k", "v")
+
+	for _, count := range []string{"9223372036854775807", "1000000000"} {
+		reply := conn.call(t, s.handleScanCommand, "SCAN", "0", "COUNT", count)
+		if reply == nil || reply.Type != resp.Array || len(reply.Array) != 2 || len(reply.Array[1].Array) != 1 {
+			t.Errorf("COUNT %s: got %+v", count, reply)
+		}
+	}
+}
+
+// A request that can't be read, inline or RESP, closes the connection, so
+// nothing sent after it is taken for a command of its own.
+func TestInlineProtocolErrorsCloseConnection(t *testing.T) {
+	for _, tt := range []struct {
+		request string
+		want    string
+	}{
+		{strings.Repeat("a", 69632), "ERR Protocol error: too big inline request"},
+		{`GET "k`, "ERR Protocol error: unbalanced quotes in request"},
+		// Malformed RESP is no different
+		{"*1\r\n$abc", "ERR Protocol error: invalid request"},
+		{"*x", "ERR Protocol error: invalid request"},
+		{"*2\r\n$3\r\nGET\r\n$999999999999", "ERR Protocol error: invalid request"},
+		{"*1\r\n$3\r\nGETXX", "ERR Protocol error: invalid request"},
+	} {
+		s := newTestServer()
+		server, cli := net.Pipe()
+		cli.SetDeadline(time.Now().Add(5 * time.Second))
+
+		done := make(chan struct{})
+		go func() {
+			s.handleConnection(server)
+			close(done)
+		}()
+		go cli.Write([]byte(tt.request + "\r\nSET pwned yes\r\n"))
+
+		r := bufio.NewReader(cli)
+		if reply, err := resp.Parse(r); err != nil || reply.Type != resp.SimpleError || reply.String != tt.want {
+			t.Errorf("got %+v, %v, want %q", reply, err, tt.want)
+		}
+		if _, err := r.ReadByte(); !errors.Is(err, io.EOF) {
+			t.Fatalf("connection still open after the protocol error: %v", err)
+		}
+
+		<-done
+		cli.Close()
+		if _, exists := s.store.Get("pwned"); exists {
+			t.Error("the command after the protocol error was run")
+		}
+	}
+}
+
+// Replies written through the client's resp.Writer come out the same as
+// encoding them and converting them would, for either protocol.
+func TestTypedRepliesFollowProtocol(t *testing.T) {
+	s := newTestServer()
+	setup := &recordingConn{}
+	s.handleSetCommand(setup, command("SET", "k", "v"))
+	s.handleHsetCommand(setup, command("HSET", "h", "f", "v"))
+	s.handleZaddCommand(setup, command("ZADD", "z", "1.5", "a", "1000000", "big"))
+
+	zrank := func(conn net.Conn, msg *resp.Message) { s.handleZrankCommand(conn, msg, false) }
+	for _, tt := range []struct {
+		handle       func(net.Conn, *resp.Message)
+		args         []string
+		resp2, resp3 string
+	}{
+		{s.handleGetCommand, []string{"GET", "k"}, "$1\r\nv\r\n", "$1\r\nv\r\n"},
+		{s.handleGetCommand, []string{"GET", "missing"}, "$-1\r\n", "_\r\n"},
+		{s.handleLpopCommand, []string{"LPOP", "missing", "2"}, "*-1\r\n", "_\r\n"},
+		{s.handleLlenCommand, []string{"LLEN", "k"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{s.handleHmgetCommand, []string{"HMGET", "h", "f", "g"}, "*2\r\n$1\r\nv\r\n$-1\r\n", "*2\r\n$1\r\nv\r\n_\r\n"},
+		{s.handleZrangeCommand, []string{"ZRANGE", "z", "0", "0", "WITHSCORES"}, "*2\r\n$1\r\na\r\n$3\r\n1.5\r\n", "*2\r\n$1\r\na\r\n,1.5\r\n"},
+		{zrank, []string{"ZRANK", "z", "b", "WITHSCORE"}, "*-1\r\n", "_\r\n"},
+		{zrank, []string{"ZRANK", "z", "a", "WITHSCORE"}, "*2\r\n:0\r\n$3\r\n1.5\r\n", "*2\r\n:0\r\n,1.5\r\n"},
+		{s.handleZscoreCommand, []string{"ZSCORE", "z", "big"}, "$7\r\n1000000\r\n", ",1000000\r\n"},
+		{s.handleZincrbyCommand, []string{"ZINCRBY", "z", "+inf", "b"}, "$3\r\ninf\r\n", ",inf\r\n"},
+		// ZSCAN's scores share an array with the members, so stay bulk strings
+		{s.handleZscanCommand, []string{"ZSCAN", "z", "0", "MATCH", "a"}, "*2\r\n$1\r\n0\r\n*2\r\n$1\r\na\r\n$3\r\n1.5\r\n", "*2\r\n$1\r\n0\r\n*2\r\n$1\r\na\r\n$3\r\n1.5\r\n"},
+	} {
+		for _, protocol := range []int{resp.RESP2, resp.RESP3} {
+			conn := &recordingConn{}
+			c := s.newClient(conn)
+			c.w.Protocol = protocol
+
+			tt.handle(c, command(tt.args...))
+			c.w.Flush()
+
+			want := tt.resp2
+			if protocol == resp.RESP3 {
+				want = tt.resp3
+			}
+			if got := conn.buf.String(); got != want {
+				t.Errorf("%v on RESP%d: got %q, want %q", tt.args, protocol, got, want)
+			}
+		}
+	}
+}
+
+// Every reader blocked on `$` gets the entry added after it blocked, and
+// only that one.
+func TestXreadBlock(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleXaddCommand, "XADD", "s", "1-1", "f", "old")
+
+	// Checks `reply` holds just `key` with entries with the IDs in `ids`
+	expect := func(name string, reply *resp.Message, key string, ids ...string) {
+		t.Helper()
+		if reply == nil || len(reply.Array) != 1 || len(reply.Array[0].Array) != 2 || reply.Array[0].Array[0].String != key {
+			t.Fatalf("%s: got %+v", name, reply)
+		}
+
+		entries := reply.Array[0].Array[1].Array
+		got := make([]string, len(entries))
+		for i, e := range entries {
+			got[i] = e.Array[0].String
+		}
+		if !slices.Equal(got, ids) {
+			t.Errorf("%s: got entries %v, want %v", name, got, ids)
+		}
+	}
+
+	readers := make([]<-chan *resp.Message, 3)
+	for i := range readers {
+		readers[i] = callAsync(t, s.handleXreadCommand, "XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+	}
+	waitBlocked(t, s, "s", len(readers))
+
+	conn.call(t, s.handleXaddCommand, "XADD", "s", "2-1", "f", "new")
+	for i, ch := range readers {
+		reply := awaitReply(t, ch)
+		expect(fmt.Sprintf("reader %d", i), reply, "s", "2-1")
+		if f := reply.Array[0].Array[1].Array[0].Array[1].Array; len(f) != 2 || f[1].String != "new" {
+			t.Errorf("reader %d: got fields %+v", i, f)
+		}
+	}
+
+	// A missing key is waited on like an empty stream
+	ch := callAsync(t, s.handleXreadCommand, "XREAD", "BLOCK", "0", "STREAMS", "missing", "$")
+	waitBlocked(t, s, "missing", 1)
+	conn.call(t, s.handleXaddCommand, "XADD", "missing", "1-1", "f", "v")
+	expect("missing key", awaitReply(t, ch), "missing", "1-1")
+
+	// Entries after the ID given are there already, so there's no blocking
+	reply := conn.call(t, s.handleXreadCommand, "XREAD", "BLOCK", "0", "STREAMS", "s", "1-1")
+	expect("entries already there", reply, "s", "2-1")
+	if n := len(s.blockingManager.queue["s"]); n != 0 {
+		t.Errorf("%d clients still blocked on s", n)
+	}
+}
+
+func TestXreadBlockTimeout(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleXaddCommand, "XADD", "s", "1-1", "f", "v")
+
+	start := time.Now()
+	if reply := conn.call(t, s.handleXreadCommand, "XREAD", "BLOCK", "20", "STREAMS", "s", "$"); reply != nil {
+		t.Errorf("XREAD BLOCK 20: got %+v", reply)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("XREAD BLOCK 20 returned after %v", elapsed)
+	}
+	if n := len(s.blockingManager.queue["s"]); n != 0 {
+		t.Errorf("%d clients still blocked on s after timing out", n)
+	}
+
+	// Nothing is waited for without BLOCK
+	if reply := conn.call(t, s.handleXreadCommand, "XREAD", "STREAMS", "s", "$"); reply != nil {
+		t.Errorf("XREAD without BLOCK: got %+v", reply)
+	}
+}
+
+func TestXreadCount(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	for _, id := range []string{"1-1", "1-2", "1-3"} {
+		conn.call(t, s.handleXaddCommand, "XADD", "s", id, "f", "v")
+	}
+
+	reply := conn.call(t, s.handleXreadCommand, "XREAD", "COUNT", "2", "STREAMS", "s", "0")
+	if reply == nil || len(reply.Array) != 1 || len(reply.Array[0].Array[1].Array) != 2 || reply.Array[0].Array[1].Array[1].Array[0].String != "1-2" {
+		t.Fatalf("XREAD COUNT 2: got %+v", reply)
+	}
+
+	// A reader woken up by an XADD is handed at most COUNT entries too
+	ch := callAsync(t, s.handleXreadCommand, "XREAD", "COUNT", "1", "BLOCK", "0", "STREAMS", "s", "$")
+	waitBlocked(t, s, "s", 1)
+	conn.call(t, s.handleXaddCommand, "XADD", "s", "2-1", "f", "v")
+	reply = awaitReply(t, ch)
+	if reply == nil || len(reply.Array) != 1 || len(reply.Array[0].Array[1].Array) != 1 || reply.Array[0].Array[1].Array[0].Array[0].String != "2-1" {
+		t.Errorf("XREAD COUNT 1 BLOCK 0: got %+v", reply)
+	}
+}
+
+func TestHashCommands(t *testing.T) {
+	s := newTestServer()
+	hrandfield := s.handleHrandfieldCommand
+
+	runSteps(t, &recordingConn{}, []testStep{
+		{s.handleHsetCommand, []string{"HSET", "h", "f1", "v1", "f2", "v2"}, "2"},
+		{s.handleHsetCommand, []string{"HSET", "h", "f1", "x", "f3", "v3"}, "1"},
+		{s.handleHsetCommand, []string{"HSET", "h", "f1"}, "ERR Incorrect amount of args for `HSET` command"},
+		{s.handleHgetCommand, []string{"HGET", "h", "f1"}, "x"},
+		{s.handleHgetCommand, []string{"HGET", "h", "nope"}, "nil"},
+		{s.handleHgetCommand, []string{"HGET", "missing", "f1"}, "nil"},
+		{s.handleHmgetCommand, []string{"HMGET", "h", "f1", "nope", "f2"}, "[x nil v2]"},
+		{s.handleHmgetCommand, []string{"HMGET", "missing", "f1", "f2"}, "[nil nil]"},
+		{s.handleHsetnxCommand, []string{"HSETNX", "h", "f1", "y"}, "0"},
+		{s.handleHsetnxCommand, []string{"HSETNX", "h", "f4", "v4"}, "1"},
+		{s.handleHexistsCommand, []string{"HEXISTS", "h", "f4"}, "1"},
+		{s.handleHexistsCommand, []string{"HEXISTS", "h", "nope"}, "0"},
+		{s.handleHlenCommand, []string{"HLEN", "h"}, "4"},
+		{s.handleHlenCommand, []string{"HLEN", "missing"}, "0"},
+		{s.handleHstrlenCommand, []string{"HSTRLEN", "h", "f2"}, "2"},
+		{s.handleHstrlenCommand, []string{"HSTRLEN", "h", "nope"}, "0"},
+		{s.handleTypeCommand, []string{"TYPE", "h"}, "hash"},
+		{s.handleHgetallCommand, []string{"HGETALL", "h"}, "{f1:x f2:v2 f3:v3 f4:v4}"},
+		{s.handleHdelCommand, []string{"HDEL", "h", "f3", "f4", "nope"}, "2"},
+		{s.handleHdelCommand, []string{"HDEL", "missing", "f1"}, "0"},
+		{s.handleHdelCommand, []string{"HDEL", "h", "f2"}, "1"},
+		{s.handleHkeysCommand, []string{"HKEYS", "h"}, "[f1]"},
+		{s.handleHvalsCommand, []string{"HVALS", "h"}, "[x]"},
+		{hrandfield, []string{"HRANDFIELD", "h"}, "f1"},
+		{hrandfield, []string{"HRANDFIELD", "h", "2"}, "[f1]"},
+		{hrandfield, []string{"HRANDFIELD", "h", "-2"}, "[f1 f1]"},
+		{hrandfield, []string{"HRANDFIELD", "h", "0"}, "[]"},
+		{hrandfield, []string{"HRANDFIELD", "missing"}, "nil"},
+		{hrandfield, []string{"HRANDFIELD", "missing", "2"}, "[]"},
+
+		// Deleting the last field deletes the key
+		{s.handleHdelCommand, []string{"HDEL", "h", "f1"}, "1"},
+		{s.handleTypeCommand, []string{"TYPE", "h"}, "none"},
+		{s.handleHgetallCommand, []string{"HGETALL", "h"}, "{}"},
+		{s.handleHkeysCommand, []string{"HKEYS", "h"}, "[]"},
+	})
+}
+
+func TestHashIncrements(t *testing.T) {
+	s := newTestServer()
+
+	runSteps(t, &recordingConn{}, []testStep{
+		{s.handleHincrbyCommand, []string{"HINCRBY", "h", "n", "5"}, "5"},
+		{s.handleHincrbyCommand, []string{"HINCRBY", "h", "n", "-7"}, "-2"},
+		{s.handleHincrbyCommand, []string{"HINCRBY", "h", "n", "x"}, "ERR value is not an integer or out of range"},
+		{s.handleHsetCommand, []string{"HSET", "h", "s", "abc", "max", "9223372036854775807", "min", "-9223372036854775808"}, "3"},
+		{s.handleHincrbyCommand, []string{"HINCRBY", "h", "s", "1"}, "ERR hash value is not an integer"},
+		{s.handleHincrbyCommand, []string{"HINCRBY", "h", "max", "1"}, "ERR increment or decrement would overflow"},
+		{s.handleHincrbyCommand, []string{"HINCRBY", "h", "min", "-1"}, "ERR increment or decrement would overflow"},
+		{s.handleHgetCommand, []string{"HGET", "h", "max"}, "9223372036854775807"},
+
+		{s.handleHincrbyfloatCommand, []string{"HINCRBYFLOAT", "h", "f", "10.5"}, "10.5"},
+		{s.handleHincrbyfloatCommand, []string{"HINCRBYFLOAT", "h", "f", "0.1"}, "10.6"},
+		{s.handleHincrbyfloatCommand, []string{"HINCRBYFLOAT", "h", "n", "1.5"}, "-0.5"},
+		{s.handleHincrbyfloatCommand, []string{"HINCRBYFLOAT", "h", "f", "5.0e3"}, "5010.6"},
+		{s.handleHincrbyfloatCommand, []string{"HINCRBYFLOAT", "h", "f", "inf"}, "ERR value is not a valid float"},
+		{s.handleHincrbyfloatCommand, []string{"HINCRBYFLOAT", "h", "f", "nan"}, "ERR value is not a valid float"},
+		{s.handleHincrbyfloatCommand, []string{"HINCRBYFLOAT", "h", "s", "1"}, "ERR hash value is not a float"},
+		{s.handleHsetCommand, []string{"HSET", "h", "huge", "1.7976931348623157e308"}, "1"},
+		{s.handleHincrbyfloatCommand, []string{"HINCRBYFLOAT", "h", "huge", "1.7976931348623157e308"}, "ERR increment would produce NaN or Infinity"},
+		{s.handleHgetCommand, []string{"HGET", "h", "huge"}, "1.7976931348623157e308"},
+	})
+}
+
+func TestHashWrongType(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleSetCommand, "SET", "k", "v")
+
+	for _, tt := range []struct {
+		handle func(net.Conn, *resp.Message)
+		args   []string
+	}{
+		{s.handleHsetCommand, []string{"HSET", "k", "f", "v"}},
+		{s.handleHsetnxCommand, []string{"HSETNX", "k", "f", "v"}},
+		{s.handleHgetCommand, []string{"HGET", "k", "f"}},
+		{s.handleHmgetCommand, []string{"HMGET", "k", "f"}},
+		{s.handleHdelCommand, []string{"HDEL", "k", "f"}},
+		{s.handleHexistsCommand, []string{"HEXISTS", "k", "f"}},
+		{s.handleHlenCommand, []string{"HLEN", "k"}},
+		{s.handleHstrlenCommand, []string{"HSTRLEN", "k", "f"}},
+		{s.handleHkeysCommand, []string{"HKEYS", "k"}},
+		{s.handleHvalsCommand, []string{"HVALS", "k"}},
+		{s.handleHgetallCommand, []string{"HGETALL", "k"}},
+		{s.handleHincrbyCommand, []string{"HINCRBY", "k", "f", "1"}},
+		{s.handleHincrbyfloatCommand, []string{"HINCRBYFLOAT", "k", "f", "1"}},
+		{s.handleHrandfieldCommand, []string{"HRANDFIELD", "k"}},
+	} {
+		if got := flatten(conn.call(t, tt.handle, tt.args...)); got != wrongType {
+			t.Errorf("%v: got %s", tt.args, got)
+		}
+	}
+
+	if reply := conn.call(t, s.handleGetCommand, "GET", "k"); reply == nil || reply.String != "v" {
+		t.Errorf("GET after the hash commands: got %+v", reply)
+	}
+}
+
+func TestSetCommands(t *testing.T) {
+	s := newTestServer()
+	smismember := s.handleSmismemberCommand
+
+	runSteps(t, &recordingConn{}, []testStep{
+		{s.handleSaddCommand, []string{"SADD", "s", "a", "b", "c", "a"}, "3"},
+		{s.handleSaddCommand, []string{"SADD", "s", "c", "d"}, "1"},
+		{s.handleTypeCommand, []string{"TYPE", "s"}, "set"},
+		{s.handleScardCommand, []string{"SCARD", "s"}, "4"},
+		{s.handleScardCommand, []string{"SCARD", "missing"}, "0"},
+		{s.handleSmembersCommand, []string{"SMEMBERS", "s"}, "[a b c d]"},
+		{s.handleSmembersCommand, []string{"SMEMBERS", "missing"}, "[]"},
+		{s.handleSismemberCommand, []string{"SISMEMBER", "s", "a"}, "1"},
+		{s.handleSismemberCommand, []string{"SISMEMBER", "s", "z"}, "0"},
+		{smismember, []string{"SMISMEMBER", "s", "a", "z", "d"}, "[1 0 1]"},
+		{smismember, []string{"SMISMEMBER", "missing", "a", "b"}, "[0 0]"},
+		{s.handleSremCommand, []string{"SREM", "s", "d", "z"}, "1"},
+		{s.handleSremCommand, []string{"SREM", "missing", "a"}, "0"},
+
+		// Removing the last member deletes the key
+		{s.handleSaddCommand, []string{"SADD", "one", "x"}, "1"},
+		{s.handleSremCommand, []string{"SREM", "one", "x"}, "1"},
+		{s.handleTypeCommand, []string{"TYPE", "one"}, "none"},
+	})
+}
+
+func TestSetAlgebra(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleSaddCommand, "SADD", "a", "1", "2", "3", "4")
+	conn.call(t, s.handleSaddCommand, "SADD", "b", "3", "4", "5")
+	conn.call(t, s.handleSaddCommand, "SADD", "c", "4", "5", "6")
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+
+	sinter := func(conn net.Conn, msg *resp.Message) { s.handleSetAlgebraCommand(conn, msg, store.SetInter) }
+	sunion := func(conn net.Conn, msg *resp.Message) { s.handleSetAlgebraCommand(conn, msg, store.SetUnion) }
+	sdiff := func(conn net.Conn, msg *resp.Message) { s.handleSetAlgebraCommand(conn, msg, store.SetDiff) }
+	sinterstore := func(conn net.Conn, msg *resp.Message) { s.handleSetAlgebraStoreCommand(conn, msg, store.SetInter) }
+	sunionstore := func(conn net.Conn, msg *resp.Message) { s.handleSetAlgebraStoreCommand(conn, msg, store.SetUnion) }
+	sdiffstore := func(conn net.Conn, msg *resp.Message) { s.handleSetAlgebraStoreCommand(conn, msg, store.SetDiff) }
+	sintercard := s.handleSintercardCommand
+
+	runSteps(t, conn, []testStep{
+		{sinter, []string{"SINTER", "a", "b"}, "[3 4]"},
+		{sinter, []string{"SINTER", "a", "b", "c"}, "[4]"},
+		{sinter, []string{"SINTER", "a"}, "[1 2 3 4]"},
+		{sinter, []string{"SINTER", "a", "missing"}, "[]"},
+		{sinter, []string{"SINTER", "a", "str"}, wrongType},
+		{sunion, []string{"SUNION", "a", "b", "c"}, "[1 2 3 4 5 6]"},
+		{sunion, []string{"SUNION", "missing", "c"}, "[4 5 6]"},
+		{sunion, []string{"SUNION", "missing"}, "[]"},
+		{sdiff, []string{"SDIFF", "a", "b"}, "[1 2]"},
+		{sdiff, []string{"SDIFF", "a", "b", "c"}, "[1 2]"},
+		{sdiff, []string{"SDIFF", "c", "a"}, "[5 6]"},
+		{sdiff, []string{"SDIFF", "a", "missing"}, "[1 2 3 4]"},
+		{sdiff, []string{"SDIFF", "missing", "a"}, "[]"},
+		{sdiff, []string{"SDIFF", "str", "a"}, wrongType},
+
+		{sunionstore, []string{"SUNIONSTORE", "u", "a", "c"}, "6"},
+		{s.handleSmembersCommand, []string{"SMEMBERS", "u"}, "[1 2 3 4 5 6]"},
+		{sinterstore, []string{"SINTERSTORE", "i", "a", "b"}, "2"},
+		{s.handleSmembersCommand, []string{"SMEMBERS", "i"}, "[3 4]"},
+		{sdiffstore, []string{"SDIFFSTORE", "d", "b", "a"}, "1"},
+		{s.handleSmembersCommand, []string{"SMEMBERS", "d"}, "[5]"},
+
+		// The destination is overwritten whatever it held, and may be a source
+		{sunionstore, []string{"SUNIONSTORE", "str", "b"}, "3"},
+		{s.handleTypeCommand, []string{"TYPE", "str"}, "set"},
+		{sinterstore, []string{"SINTERSTORE", "a", "a", "b"}, "2"},
+		{s.handleSmembersCommand, []string{"SMEMBERS", "a"}, "[3 4]"},
+
+		// An empty result deletes the destination
+		{sinterstore, []string{"SINTERSTORE", "u", "a", "missing"}, "0"},
+		{s.handleTypeCommand, []string{"TYPE", "u"}, "none"},
+		{sdiffstore, []string{"SDIFFSTORE", "d", "b", "b"}, "0"},
+		{s.handleTypeCommand, []string{"TYPE", "d"}, "none"},
+
+		{sintercard, []string{"SINTERCARD", "2", "b", "c"}, "2"},
+		{sintercard, []string{"SINTERCARD", "2", "b", "c", "LIMIT", "1"}, "1"},
+		{sintercard, []string{"SINTERCARD", "2", "b", "c", "LIMIT", "5"}, "2"},
+		{sintercard, []string{"SINTERCARD", "2", "b", "c", "LIMIT", "0"}, "2"},
+		{sintercard, []string{"SINTERCARD", "1", "b", "c"}, "ERR syntax error"},
+		{sintercard, []string{"SINTERCARD", "2", "b", "c", "LIMIT", "-1"}, "ERR LIMIT can't be negative"},
+		{sintercard, []string{"SINTERCARD", "2", "b", "c", "LIMIT"}, "ERR syntax error"},
+		{sintercard, []string{"SINTERCARD", "3", "b", "c"}, "ERR Number of keys can't be greater than number of args"},
+		{sintercard, []string{"SINTERCARD", "0", "b"}, "ERR numkeys should be greater than 0"},
+		{sintercard, []string{"SINTERCARD", "2", "b", "missing"}, "0"},
+	})
+}
+
+func TestSmove(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleSaddCommand, "SADD", "src", "a", "b")
+	conn.call(t, s.handleSaddCommand, "SADD", "dest", "c")
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+
+	runSteps(t, conn, []testStep{
+		{s.handleSmoveCommand, []string{"SMOVE", "src", "dest", "a"}, "1"},
+		{s.handleSmembersCommand, []string{"SMEMBERS", "src"}, "[b]"},
+		{s.handleSmembersCommand, []string{"SMEMBERS", "dest"}, "[a c]"},
+		{s.handleSmoveCommand, []string{"SMOVE", "src", "dest", "z"}, "0"},
+		{s.handleSmoveCommand, []string{"SMOVE", "missing", "dest", "a"}, "0"},
+		{s.handleSmoveCommand, []string{"SMOVE", "src", "src", "b"}, "1"},
+		{s.handleSmembersCommand, []string{"SMEMBERS", "src"}, "[b]"},
+		{s.handleSmoveCommand, []string{"SMOVE", "src", "str", "b"}, wrongType},
+		{s.handleSmoveCommand, []string{"SMOVE", "str", "dest", "b"}, wrongType},
+
+		// Moving the last member deletes the source and creates the destination
+		{s.handleSmoveCommand, []string{"SMOVE", "src", "new", "b"}, "1"},
+		{s.handleTypeCommand, []string{"TYPE", "src"}, "none"},
+		{s.handleSmembersCommand, []string{"SMEMBERS", "new"}, "[b]"},
+	})
+}
+
+func TestSpop(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleSaddCommand, "SADD", "s", "a", "b", "c", "d", "e")
+
+	runSteps(t, conn, []testStep{
+		{s.handleSpopCommand, []string{"SPOP", "missing"}, "nil"},
+		{s.handleSpopCommand, []string{"SPOP", "missing", "2"}, "[]"},
+		{s.handleSpopCommand, []string{"SPOP", "s", "0"}, "[]"},
+		{s.handleSpopCommand, []string{"SPOP", "s", "-1"}, "ERR value is out of range, must be positive"},
+		{s.handleScardCommand, []string{"SCARD", "s"}, "5"},
+	})
+
+	popped := conn.call(t, s.handleSpopCommand, "SPOP", "s")
+	if popped == nil || popped.Type != resp.BulkString {
+		t.Fatalf("SPOP s: got %+v", popped)
+	}
+
+	reply := conn.call(t, s.handleSpopCommand, "SPOP", "s", "3")
+	if reply == nil || len(reply.Array) != 3 {
+		t.Fatalf("SPOP s 3: got %+v", reply)
+	}
+
+	// Popping more than there is pops whatever is left and deletes the key
+	rest := conn.call(t, s.handleSpopCommand, "SPOP", "s", "10")
+	if rest == nil || len(rest.Array) != 1 {
+		t.Fatalf("SPOP s 10: got %+v", rest)
+	}
+
+	members := []string{popped.String, rest.Array[0].String}
+	for _, m := range reply.Array {
+		members = append(members, m.String)
+	}
+	slices.Sort(members)
+	if !slices.Equal(members, []string{"a", "b", "c", "d", "e"}) {
+		t.Errorf("popped %v, want every member once", members)
+	}
+
+	if got := flatten(conn.call(t, s.handleTypeCommand, "TYPE", "s")); got != "none" {
+		t.Errorf("TYPE after popping every member: got %s", got)
+	}
+}
+
+func TestHashFieldExpiry(t *testing.T) {
+	s := newTestServer()
+	hexpire := func(conn net.Conn, msg *resp.Message) { s.handleHexpireCommand(conn, msg, time.Second, false) }
+	hpexpire := func(conn net.Conn, msg *resp.Message) { s.handleHexpireCommand(conn, msg, time.Millisecond, false) }
+	hexpireat := func(conn net.Conn, msg *resp.Message) { s.handleHexpireCommand(conn, msg, time.Second, true) }
+	httl := func(conn net.Conn, msg *resp.Message) { s.handleHttlCommand(conn, msg, time.Second, false) }
+	hexpiretime := func(conn net.Conn, msg *resp.Message) { s.handleHttlCommand(conn, msg, time.Second, true) }
+	hpersist := s.handleHpersistCommand
+
+	conn := &recordingConn{}
+	conn.call(t, s.handleHsetCommand, "HSET", "h", "a", "1", "b", "2", "c", "3")
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+
+	runSteps(t, conn, []testStep{
+		{hexpire, []string{"HEXPIRE", "h", "100", "FIELDS", "2", "a", "nope"}, "[1 -2]"},
+		{httl, []string{"HTTL", "h", "FIELDS", "3", "a", "b", "nope"}, "[100 -1 -2]"},
+		{hexpire, []string{"HEXPIRE", "missing", "100", "FIELDS", "1", "a"}, "[-2]"},
+		{httl, []string{"HTTL", "missing", "FIELDS", "1", "a"}, "[-2]"},
+
+		// NX only sets a TTL where there is none, XX only replaces one
+		{hexpire, []string{"HEXPIRE", "h", "50", "NX", "FIELDS", "2", "a", "b"}, "[0 1]"},
+		{hexpire, []string{"HEXPIRE", "h", "200", "XX", "FIELDS", "2", "a", "c"}, "[1 0]"},
+		{httl, []string{"HTTL", "h", "FIELDS", "3", "a", "b", "c"}, "[200 50 -1]"},
+
+		// A field without a TTL counts as having an infinite one for GT and LT
+		{hexpire, []string{"HEXPIRE", "h", "100", "GT", "FIELDS", "3", "a", "b", "c"}, "[0 1 0]"},
+		{hexpire, []string{"HEXPIRE", "h", "10", "LT", "FIELDS", "3", "a", "b", "c"}, "[1 1 1]"},
+		{httl, []string{"HTTL", "h", "FIELDS", "3", "a", "b", "c"}, "[10 10 10]"},
+
+		{hpersist, []string{"HPERSIST", "h", "FIELDS", "3", "a", "b", "nope"}, "[1 1 -2]"},
+		{hpersist, []string{"HPERSIST", "h", "FIELDS", "1", "a"}, "[-1]"},
+		{hpersist, []string{"HPERSIST", "missing", "FIELDS", "1", "a"}, "[-2]"},
+		{httl, []string{"HTTL", "h", "FIELDS", "3", "a", "b", "c"}, "[-1 -1 10]"},
+
+		// A TTL of 0, or a time in the past, deletes the field there and then
+		{hexpire, []string{"HEXPIRE", "h", "0", "FIELDS", "1", "a"}, "[2]"},
+		{hexpireat, []string{"HEXPIREAT", "h", "1", "FIELDS", "1", "nope"}, "[-2]"},
+		{s.handleHexistsCommand, []string{"HEXISTS", "h", "a"}, "0"},
+		{s.handleHlenCommand, []string{"HLEN", "h"}, "2"},
+
+		{hexpireat, []string{"HEXPIREAT", "h", "4102444800", "FIELDS", "1", "b"}, "[1]"},
+		{hexpiretime, []string{"HEXPIRETIME", "h", "FIELDS", "2", "b", "nope"}, "[4102444800 -2]"},
+
+		// HSET replaces the field, TTL and all, while HINCRBY keeps it
+		{s.handleHsetCommand, []string{"HSET", "h", "b", "new"}, "0"},
+		{httl, []string{"HTTL", "h", "FIELDS", "1", "b"}, "[-1]"},
+		{s.handleHincrbyCommand, []string{"HINCRBY", "h", "c", "1"}, "4"},
+		{httl, []string{"HTTL", "h", "FIELDS", "1", "c"}, "[10]"},
+
+		{hexpire, []string{"HEXPIRE", "h", "-1", "FIELDS", "1", "b"}, "ERR invalid expire time in 'hexpire' command"},
+		{hexpire, []string{"HEXPIRE", "h", "x", "FIELDS", "1", "b"}, "ERR value is not an integer or out of range"},
+		{hexpire, []string{"HEXPIRE", "h", "10", "FIELDS", "2", "b"}, "ERR Invalid FIELDS block, expected `FIELDS numfields field [field ...]`"},
+		{hexpire, []string{"HEXPIRE", "h", "10", "FIELDS", "0", "b"}, "ERR Invalid FIELDS block, expected `FIELDS numfields field [field ...]`"},
+		{hexpire, []string{"HEXPIRE", "str", "10", "FIELDS", "1", "b"}, wrongType},
+		{httl, []string{"HTTL", "str", "FIELDS", "1", "b"}, wrongType},
+		{hpersist, []string{"HPERSIST", "str", "FIELDS", "1", "b"}, wrongType},
+
+		// Deleting the last field with a TTL of 0 deletes the key
+		{hexpire, []string{"HEXPIRE", "h", "0", "FIELDS", "2", "b", "c"}, "[2 2]"},
+		{s.handleTypeCommand, []string{"TYPE", "h"}, "none"},
+	})
+
+	// The key goes too once its last field expires
+	runSteps(t, conn, []testStep{
+		{s.handleHsetCommand, []string{"HSET", "short", "a", "1", "b", "2"}, "2"},
+		{hpexpire, []string{"HPEXPIRE", "short", "20", "FIELDS", "2", "a", "b"}, "[1 1]"},
+	})
+	time.Sleep(30 * time.Millisecond)
+	runSteps(t, conn, []testStep{
+		{s.handleHgetCommand, []string{"HGET", "short", "a"}, "nil"},
+		{s.handleTypeCommand, []string{"TYPE", "short"}, "none"},
+	})
+
+	// HPTTL reports the milliseconds left
+	conn.call(t, s.handleHsetCommand, "HSET", "h", "a", "1")
+	conn.call(t, hpexpire, "HPEXPIRE", "h", "5000", "FIELDS", "1", "a")
+	hpttl := func(conn net.Conn, msg *resp.Message) { s.handleHttlCommand(conn, msg, time.Millisecond, false) }
+	if reply := conn.call(t, hpttl, "HPTTL", "h", "FIELDS", "1", "a"); reply == nil || len(reply.Array) != 1 || reply.Array[0].Integer <= 4000 || reply.Array[0].Integer > 5000 {
+		t.Errorf("HPTTL: got %s", flatten(reply))
+	}
+}
+
+func TestZaddFlags(t *testing.T) {
+	s := newTestServer()
+	zadd := s.handleZaddCommand
+
+	runSteps(t, &recordingConn{}, []testStep{
+		{zadd, []string{"ZADD", "z", "NX", "XX", "1", "a"}, "ERR XX and NX options at the same time are not compatible"},
+		{zadd, []string{"ZADD", "z", "GT", "LT", "1", "a"}, "ERR GT, LT, and/or NX options at the same time are not compatible"},
+		{zadd, []string{"ZADD", "z", "NX", "GT", "1", "a"}, "ERR GT, LT, and/or NX options at the same time are not compatible"},
+		{zadd, []string{"ZADD", "z", "NX", "LT", "1", "a"}, "ERR GT, LT, and/or NX options at the same time are not compatible"},
+		{zadd, []string{"ZADD", "z", "INCR", "1", "a", "2", "b"}, "ERR INCR option supports a single increment-element pair"},
+		{zadd, []string{"ZADD", "z", "1", "a", "2"}, "ERR syntax error"},
+		{zadd, []string{"ZADD", "z", "CH"}, "ERR Incorrect amount of args for `ZADD` command"},
+		{zadd, []string{"ZADD", "z", "x", "a"}, "ERR value is not a valid float"},
+		{zadd, []string{"ZADD", "z", "nan", "a"}, "ERR value is not a valid float"},
+		{s.handleTypeCommand, []string{"TYPE", "z"}, "none"},
+
+		// XX never creates the key
+		{zadd, []string{"ZADD", "z", "XX", "1", "a"}, "0"},
+		{zadd, []string{"ZADD", "z", "XX", "INCR", "1", "a"}, "nil"},
+		{s.handleTypeCommand, []string{"TYPE", "z"}, "none"},
+
+		{zadd, []string{"ZADD", "z", "1", "a"}, "1"},
+		{zadd, []string{"ZADD", "z", "NX", "5", "a", "2", "b"}, "1"},
+		{s.handleZscoreCommand, []string{"ZSCORE", "z", "a"}, "1"},
+		{zadd, []string{"ZADD", "z", "XX", "CH", "3", "a", "3", "c"}, "1"},
+		{s.handleZscoreCommand, []string{"ZSCORE", "z", "c"}, "nil"},
+
+		// GT and LT only gate updates, new members are added regardless
+		{zadd, []string{"ZADD", "z", "GT", "CH", "0", "a", "1", "c"}, "1"},
+		{s.handleZscoreCommand, []string{"ZSCORE", "z", "a"}, "3"},
+		{zadd, []string{"ZADD", "z", "GT", "CH", "4", "a"}, "1"},
+		{zadd, []string{"ZADD", "z", "LT", "CH", "5", "a"}, "0"},
+		{zadd, []string{"ZADD", "z", "LT", "CH", "2", "a"}, "1"},
+		{zadd, []string{"ZADD", "z", "LT", "2", "a"}, "0"},
+
+		// Without CH only additions count
+		{zadd, []string{"ZADD", "z", "7", "a", "8", "d"}, "1"},
+
+		{zadd, []string{"ZADD", "z", "INCR", "2.5", "a"}, "9.5"},
+		{zadd, []string{"ZADD", "z", "NX", "INCR", "1", "a"}, "nil"},
+		{zadd, []string{"ZADD", "z", "GT", "INCR", "-1", "a"}, "nil"},
+		{zadd, []string{"ZADD", "z", "LT", "INCR", "-1", "a"}, "8.5"},
+		{zadd, []string{"ZADD", "z", "INCR", "+inf", "a"}, "inf"},
+		{zadd, []string{"ZADD", "z", "INCR", "-inf", "a"}, "ERR resulting score is not a number (NaN)"},
+		{s.handleZcardCommand, []string{"ZCARD", "z"}, "4"},
+	})
+}
+
+func TestBzpop(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	bzpopmin := func(conn net.Conn, msg *resp.Message) { s.handleBzpopCommand(conn, msg, false) }
+	bzpopmax := func(conn net.Conn, msg *resp.Message) { s.handleBzpopCommand(conn, msg, true) }
+
+	conn.call(t, s.handleZaddCommand, "ZADD", "z", "1", "a", "2", "b", "3", "c")
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+	runSteps(t, conn, []testStep{
+		// Nothing to wait for when there's something to pop
+		{bzpopmin, []string{"BZPOPMIN", "missing", "z", "0"}, "[z a 1]"},
+		{bzpopmax, []string{"BZPOPMAX", "z", "0"}, "[z c 3]"},
+		{bzpopmin, []string{"BZPOPMIN", "str", "0"}, wrongType},
+		{bzpopmin, []string{"BZPOPMIN", "z", "-1"}, "ERR timeout is negative"},
+		{bzpopmin, []string{"BZPOPMIN", "z", "x"}, "ERR timeout is not a float or out of range"},
+		{bzpopmin, []string{"BZPOPMIN", "z"}, "ERR Incorrect amount of args for `BZPOPMIN` command"},
+	})
+
+	// Popping the last member deletes the key, so the next pop waits
+	conn.call(t, bzpopmin, "BZPOPMIN", "z", "0")
+	start := time.Now()
+	if got := flatten(conn.call(t, bzpopmin, "BZPOPMIN", "z", "0.02")); got != "nil" {
+		t.Errorf("BZPOPMIN on an emptied key: got %s", got)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("BZPOPMIN z 0.02 returned after %v", elapsed)
+	}
+
+	popMin := callAsync(t, bzpopmin, "BZPOPMIN", "z1", "z2", "0")
+	waitBlocked(t, s, "z2", 1)
+	conn.call(t, s.handleZaddCommand, "ZADD", "z2", "5", "x", "4", "y")
+	if got := flatten(awaitReply(t, popMin)); got != "[z2 y 4]" {
+		t.Errorf("woken BZPOPMIN: got %s", got)
+	}
+
+	popMax := callAsync(t, bzpopmax, "BZPOPMAX", "z1", "0")
+	waitBlocked(t, s, "z1", 1)
+	conn.call(t, s.handleZaddCommand, "ZADD", "z1", "5", "x", "4", "y")
+	if got := flatten(awaitReply(t, popMax)); got != "[z1 x 5]" {
+		t.Errorf("woken BZPOPMAX: got %s", got)
+	}
+
+	// Nobody was left waiting on either key
+	for _, key := range []string{"z1", "z2"} {
+		if n := len(s.blockingManager.queue[key]); n != 0 {
+			t.Errorf("%d clients still blocked on %s", n, key)
+		}
+	}
+}
+
+// Clients blocked on the same key are served in the order they blocked,
+// one member each.
+func TestBzpopFIFO(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	bzpopmin := func(conn net.Conn, msg *resp.Message) { s.handleBzpopCommand(conn, msg, false) }
+
+	first := callAsync(t, bzpopmin, "BZPOPMIN", "z", "0")
+	waitBlocked(t, s, "z", 1)
+	second := callAsync(t, bzpopmin, "BZPOPMIN", "z", "0")
+	waitBlocked(t, s, "z", 2)
+
+	conn.call(t, s.handleZaddCommand, "ZADD", "z", "1", "a")
+	if got := flatten(awaitReply(t, first)); got != "[z a 1]" {
+		t.Errorf("first client: got %s", got)
+	}
+
+	select {
+	case reply := <-second:
+		t.Fatalf("second client woken up with nothing left to pop: got %s", flatten(reply))
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	conn.call(t, s.handleZaddCommand, "ZADD", "z", "2", "b")
+	if got := flatten(awaitReply(t, second)); got != "[z b 2]" {
+		t.Errorf("second client: got %s", got)
+	}
+}
+
+func TestBzmpop(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	bzmpop := s.handleBzmpopCommand
+
+	conn.call(t, s.handleZaddCommand, "ZADD", "z", "1", "a", "2", "b", "3", "c")
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+	runSteps(t, conn, []testStep{
+		{bzmpop, []string{"BZMPOP", "0", "2", "missing", "z", "MIN"}, "[z [[a 1]]]"},
+		{bzmpop, []string{"BZMPOP", "0", "1", "z", "MAX", "COUNT", "5"}, "[z [[c 3] [b 2]]]"},
+		{bzmpop, []string{"BZMPOP", "0", "1", "str", "MIN"}, wrongType},
+		{bzmpop, []string{"BZMPOP", "-1", "1", "z", "MIN"}, "ERR timeout is negative"},
+		{bzmpop, []string{"BZMPOP", "0", "1", "z", "MIDDLE"}, "ERR syntax error"},
+		{bzmpop, []string{"BZMPOP", "0", "1", "z", "MIN", "COUNT", "0"}, "ERR count should be greater than 0"},
+		{bzmpop, []string{"BZMPOP", "0.02", "1", "z", "MIN"}, "nil"},
+	})
+
+	ch := callAsync(t, bzmpop, "BZMPOP", "0", "2", "z1", "z2", "MIN", "COUNT", "2")
+	waitBlocked(t, s, "z2", 1)
+	conn.call(t, s.handleZaddCommand, "ZADD", "z2", "3", "c", "1", "a", "2", "b")
+	if got := flatten(awaitReply(t, ch)); got != "[z2 [[a 1] [b 2]]]" {
+		t.Errorf("woken BZMPOP: got %s", got)
+	}
+	if got := flatten(conn.call(t, s.handleZcardCommand, "ZCARD", "z2")); got != "1" {
+		t.Errorf("ZCARD after BZMPOP COUNT 2: got %s", got)
+	}
+}
+
+func TestListEditing(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+	lrange := func(key string, want string) testStep {
+		return testStep{s.handleLrangeCommand, []string{"LRANGE", key, "0", "-1"}, want}
+	}
+
+	runSteps(t, conn, []testStep{
+		{s.handleRpushCommand, []string{"RPUSH", "l", "a", "b", "c"}, "3"},
+		{s.handleLinsertCommand, []string{"LINSERT", "l", "BEFORE", "a", "x"}, "4"},
+		{s.handleLinsertCommand, []string{"LINSERT", "l", "after", "c", "y"}, "5"},
+		{s.handleLinsertCommand, []string{"LINSERT", "l", "AFTER", "b", "z"}, "6"},
+		lrange("l", "[x a b z c y]"),
+		{s.handleLinsertCommand, []string{"LINSERT", "l", "BEFORE", "nope", "w"}, "-1"},
+		{s.handleLinsertCommand, []string{"LINSERT", "missing", "BEFORE", "a", "w"}, "0"},
+		{s.handleLinsertCommand, []string{"LINSERT", "l", "AROUND", "a", "w"}, "ERR syntax error"},
+		{s.handleLinsertCommand, []string{"LINSERT", "str", "BEFORE", "a", "w"}, wrongType},
+		{s.handleTypeCommand, []string{"TYPE", "missing"}, "none"},
+
+		{s.handleLsetCommand, []string{"LSET", "l", "0", "first"}, "OK"},
+		{s.handleLsetCommand, []string{"LSET", "l", "-1", "last"}, "OK"},
+		{s.handleLsetCommand, []string{"LSET", "l", "2", "mid"}, "OK"},
+		lrange("l", "[first a mid z c last]"),
+		{s.handleLsetCommand, []string{"LSET", "l", "6", "w"}, "ERR index out of range"},
+		{s.handleLsetCommand, []string{"LSET", "l", "-7", "w"}, "ERR index out of range"},
+		{s.handleLsetCommand, []string{"LSET", "missing", "0", "w"}, "ERR no such key"},
+		{s.handleLsetCommand, []string{"LSET", "l", "x", "w"}, "ERR value is not an integer or out of range"},
+		{s.handleLsetCommand, []string{"LSET", "str", "0", "w"}, wrongType},
+
+		{s.handleLtrimCommand, []string{"LTRIM", "l", "1", "-2"}, "OK"},
+		lrange("l", "[a mid z c]"),
+		{s.handleLtrimCommand, []string{"LTRIM", "l", "-100", "2"}, "OK"},
+		lrange("l", "[a mid z]"),
+		{s.handleLtrimCommand, []string{"LTRIM", "l", "1", "100"}, "OK"},
+		lrange("l", "[mid z]"),
+		{s.handleLtrimCommand, []string{"LTRIM", "missing", "0", "1"}, "OK"},
+		{s.handleLtrimCommand, []string{"LTRIM", "l", "x", "1"}, "ERR value is not an integer or out of range"},
+		{s.handleLtrimCommand, []string{"LTRIM", "str", "0", "1"}, wrongType},
+
+		// An empty range empties the list, deleting the key
+		{s.handleLtrimCommand, []string{"LTRIM", "l", "1", "0"}, "OK"},
+		{s.handleTypeCommand, []string{"TYPE", "l"}, "none"},
+		{s.handleRpushCommand, []string{"RPUSH", "l", "a"}, "1"},
+		{s.handleLtrimCommand, []string{"LTRIM", "l", "5", "10"}, "OK"},
+		{s.handleTypeCommand, []string{"TYPE", "l"}, "none"},
+	})
+}
+
+func TestLrem(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+	reset := testStep{s.handleRpushCommand, []string{"RPUSH", "l", "a", "b", "a", "c", "a", "b"}, "6"}
+	lrange := testStep{s.handleLrangeCommand, []string{"LRANGE", "l", "0", "-1"}, ""}
+	with := func(step testStep, want string) testStep {
+		step.want = want
+		return step
+	}
+
+	runSteps(t, conn, []testStep{
+		reset,
+		{s.handleLremCommand, []string{"LREM", "l", "2", "a"}, "2"},
+		with(lrange, "[b c a b]"),
+		{s.handleDelCommand, []string{"DEL", "l"}, "1"},
+
+		// A negative count removes from the tail first
+		reset,
+		{s.handleLremCommand, []string{"LREM", "l", "-2", "a"}, "2"},
+		with(lrange, "[a b c b]"),
+		{s.handleLremCommand, []string{"LREM", "l", "-5", "b"}, "2"},
+		with(lrange, "[a c]"),
+		{s.handleDelCommand, []string{"DEL", "l"}, "1"},
+
+		// Zero removes every match
+		reset,
+		{s.handleLremCommand, []string{"LREM", "l", "0", "a"}, "3"},
+		with(lrange, "[b c b]"),
+		{s.handleLremCommand, []string{"LREM", "l", "0", "nope"}, "0"},
+		{s.handleLremCommand, []string{"LREM", "missing", "0", "a"}, "0"},
+		{s.handleLremCommand, []string{"LREM", "l", "x", "a"}, "ERR value is not an integer or out of range"},
+		{s.handleLremCommand, []string{"LREM", "str", "0", "a"}, wrongType},
+
+		// Removing every element deletes the key
+		{s.handleLremCommand, []string{"LREM", "l", "0", "b"}, "2"},
+		{s.handleLremCommand, []string{"LREM", "l", "-1", "c"}, "1"},
+		{s.handleTypeCommand, []string{"TYPE", "l"}, "none"},
+	})
+}
+
+func TestLpos(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleRpushCommand, "RPUSH", "l", "a", "b", "c", "1", "2", "3", "c", "c")
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+	lpos := s.handleLposCommand
+
+	runSteps(t, conn, []testStep{
+		{lpos, []string{"LPOS", "l", "c"}, "2"},
+		{lpos, []string{"LPOS", "l", "nope"}, "nil"},
+		{lpos, []string{"LPOS", "missing", "c"}, "nil"},
+		{lpos, []string{"LPOS", "l", "c", "RANK", "2"}, "6"},
+		{lpos, []string{"LPOS", "l", "c", "RANK", "4"}, "nil"},
+		{lpos, []string{"LPOS", "l", "c", "RANK", "-1"}, "7"},
+		{lpos, []string{"LPOS", "l", "c", "RANK", "-3"}, "2"},
+		{lpos, []string{"LPOS", "l", "c", "COUNT", "2"}, "[2 6]"},
+		{lpos, []string{"LPOS", "l", "c", "COUNT", "0"}, "[2 6 7]"},
+		{lpos, []string{"LPOS", "l", "c", "COUNT", "0", "RANK", "2"}, "[6 7]"},
+		{lpos, []string{"LPOS", "l", "c", "COUNT", "0", "RANK", "-1"}, "[7 6 2]"},
+		{lpos, []string{"LPOS", "l", "c", "RANK", "-2", "COUNT", "1"}, "[6]"},
+		{lpos, []string{"LPOS", "l", "nope", "COUNT", "0"}, "[]"},
+		{lpos, []string{"LPOS", "missing", "c", "COUNT", "0"}, "[]"},
+
+		// MAXLEN caps how many elements are compared, from whichever end
+		{lpos, []string{"LPOS", "l", "c", "COUNT", "0", "MAXLEN", "7"}, "[2 6]"},
+		{lpos, []string{"LPOS", "l", "c", "MAXLEN", "2"}, "nil"},
+		{lpos, []string{"LPOS", "l", "c", "RANK", "-1", "COUNT", "0", "MAXLEN", "2"}, "[7 6]"},
+		{lpos, []string{"LPOS", "l", "c", "MAXLEN", "0", "COUNT", "0"}, "[2 6 7]"},
+
+		{lpos, []string{"LPOS", "l", "c", "RANK", "0"}, "ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list"},
+		{lpos, []string{"LPOS", "l", "c", "COUNT", "-1"}, "ERR COUNT can't be negative"},
+		{lpos, []string{"LPOS", "l", "c", "MAXLEN", "-1"}, "ERR MAXLEN can't be negative"},
+		{lpos, []string{"LPOS", "l", "c", "RANK"}, "ERR syntax error"},
+		{lpos, []string{"LPOS", "l", "c", "FIRST", "1"}, "ERR syntax error"},
+		{lpos, []string{"LPOS", "l", "c", "RANK", "x"}, "ERR value is not an integer or out of range"},
+		{lpos, []string{"LPOS", "str", "c"}, wrongType},
+	})
+}
+
+func TestLmove(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleRpushCommand, "RPUSH", "src", "a", "b", "c")
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+	lmove := s.handleLmoveCommand
+	lrange := func(key string, want string) testStep {
+		return testStep{s.handleLrangeCommand, []string{"LRANGE", key, "0", "-1"}, want}
+	}
+
+	runSteps(t, conn, []testStep{
+		{lmove, []string{"LMOVE", "src", "dest", "LEFT", "RIGHT"}, "a"},
+		{lmove, []string{"LMOVE", "src", "dest", "RIGHT", "LEFT"}, "c"},
+		lrange("src", "[b]"),
+		lrange("dest", "[c a]"),
+		{lmove, []string{"LMOVE", "dest", "dest", "left", "right"}, "c"},
+		lrange("dest", "[a c]"),
+		{lmove, []string{"LMOVE", "dest", "dest", "RIGHT", "RIGHT"}, "c"},
+		lrange("dest", "[a c]"),
+		{lmove, []string{"LMOVE", "missing", "dest", "LEFT", "LEFT"}, "nil"},
+		{s.handleTypeCommand, []string{"TYPE", "missing"}, "none"},
+		{lmove, []string{"LMOVE", "src", "dest", "UP", "LEFT"}, "ERR syntax error"},
+		{lmove, []string{"LMOVE", "src", "str", "LEFT", "LEFT"}, wrongType},
+		{lmove, []string{"LMOVE", "str", "dest", "LEFT", "LEFT"}, wrongType},
+
+		// Neither list was touched by the failed moves
+		lrange("src", "[b]"),
+		lrange("dest", "[a c]"),
+
+		// Moving the last element deletes the source
+		{lmove, []string{"LMOVE", "src", "dest", "LEFT", "LEFT"}, "b"},
+		{s.handleTypeCommand, []string{"TYPE", "src"}, "none"},
+		lrange("dest", "[b a c]"),
+	})
+}
+
+func TestBlockingListPops(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	blpop := func(conn net.Conn, msg *resp.Message) { s.handleBLPOPCommand(conn, msg, false) }
+	brpop := func(conn net.Conn, msg *resp.Message) { s.handleBLPOPCommand(conn, msg, true) }
+
+	conn.call(t, s.handleRpushCommand, "RPUSH", "l", "a", "b", "c")
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+	runSteps(t, conn, []testStep{
+		// Keys are tried in order, skipping the empty ones
+		{blpop, []string{"BLPOP", "missing", "l", "0"}, "[l a]"},
+		{brpop, []string{"BRPOP", "missing", "l", "0"}, "[l c]"},
+		{brpop, []string{"BRPOP", "str", "0"}, wrongType},
+		{blpop, []string{"BLPOP", "l", "-1"}, "ERR timeout is negative"},
+		{brpop, []string{"BRPOP", "l", "-0.5"}, "ERR timeout is negative"},
+		{blpop, []string{"BLPOP", "l", "soon"}, "ERR timeout is not a float or out of range"},
+		{blpop, []string{"BLPOP", "l"}, "ERR Incorrect amount of args for `BLPOP` command"},
+		{brpop, []string{"BRPOP", "l", "0"}, "[l b]"},
+		{s.handleTypeCommand, []string{"TYPE", "l"}, "none"},
+	})
+
+	start := time.Now()
+	if got := flatten(conn.call(t, brpop, "BRPOP", "l", "missing", "0.02")); got != "nil" {
+		t.Errorf("BRPOP timing out: got %s", got)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("BRPOP with a 0.02 timeout returned after %v", elapsed)
+	}
+	for _, key := range []string{"l", "missing"} {
+		if n := len(s.blockingManager.queue[key]); n != 0 {
+			t.Errorf("%d clients still blocked on %s after timing out", n, key)
+		}
+	}
+
+	ch := callAsync(t, brpop, "BRPOP", "l1", "l2", "0")
+	waitBlocked(t, s, "l2", 1)
+	conn.call(t, s.handleRpushCommand, "RPUSH", "l2", "x", "y")
+	if got := flatten(awaitReply(t, ch)); got != "[l2 y]" {
+		t.Errorf("woken BRPOP: got %s", got)
+	}
+	if n := len(s.blockingManager.queue["l1"]); n != 0 {
+		t.Errorf("%d clients still blocked on l1 after being served from l2", n)
+	}
+}
+
+// Clients blocked on the same list are served in the order they blocked,
+// and a push of several elements serves several of them.
+func TestBlockingListPopsFIFO(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	blpop := func(conn net.Conn, msg *resp.Message) { s.handleBLPOPCommand(conn, msg, false) }
+
+	waiting := make([]<-chan *resp.Message, 3)
+	for i := range waiting {
+		waiting[i] = callAsync(t, blpop, "BLPOP", "q", "0")
+		waitBlocked(t, s, "q", i+1)
+	}
+
+	conn.call(t, s.handleRpushCommand, "RPUSH", "q", "a", "b")
+	for i, want := range []string{"[q a]", "[q b]"} {
+		if got := flatten(awaitReply(t, waiting[i])); got != want {
+			t.Errorf("client %d: got %s, want %s", i, got, want)
+		}
+	}
+
+	select {
+	case reply := <-waiting[2]:
+		t.Fatalf("third client woken up with nothing left to pop: got %s", flatten(reply))
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	conn.call(t, s.handleLpushCommand, "LPUSH", "q", "c")
+	if got := flatten(awaitReply(t, waiting[2])); got != "[q c]" {
+		t.Errorf("client 2: got %s", got)
+	}
+}
+
+func TestBlmove(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	blmove := s.handleBlmoveCommand
+	lrange := func(key string, want string) testStep {
+		return testStep{s.handleLrangeCommand, []string{"LRANGE", key, "0", "-1"}, want}
+	}
+
+	conn.call(t, s.handleRpushCommand, "RPUSH", "src", "a", "b")
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+	runSteps(t, conn, []testStep{
+		{blmove, []string{"BLMOVE", "src", "dest", "RIGHT", "LEFT", "0"}, "b"},
+		{blmove, []string{"BRPOPLPUSH", "src", "dest", "0"}, "a"},
+		lrange("dest", "[a b]"),
+		{s.handleTypeCommand, []string{"TYPE", "src"}, "none"},
+		{blmove, []string{"BLMOVE", "src", "dest", "LEFT", "LEFT", "0.02"}, "nil"},
+		{blmove, []string{"BLMOVE", "src", "dest", "LEFT", "LEFT", "-1"}, "ERR timeout is negative"},
+		{blmove, []string{"BRPOPLPUSH", "src", "dest", "-1"}, "ERR timeout is negative"},
+		{blmove, []string{"BLMOVE", "src", "dest", "UP", "LEFT", "0"}, "ERR syntax error"},
+		{blmove, []string{"BLMOVE", "dest", "str", "LEFT", "LEFT", "0"}, wrongType},
+		lrange("dest", "[a b]"),
+	})
+
+	ch := callAsync(t, blmove, "BLMOVE", "src", "dest", "LEFT", "RIGHT", "0")
+	waitBlocked(t, s, "src", 1)
+	conn.call(t, s.handleRpushCommand, "RPUSH", "src", "c")
+	if got := flatten(awaitReply(t, ch)); got != "c" {
+		t.Errorf("woken BLMOVE: got %s", got)
+	}
+	runSteps(t, conn, []testStep{
+		lrange("dest", "[a b c]"),
+		{s.handleTypeCommand, []string{"TYPE", "src"}, "none"},
+	})
+}
+
+// An element BLMOVE moves onto a list wakes whoever is blocked on that list
+// in turn.
+func TestBlmoveChainedWakeup(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	blpop := func(conn net.Conn, msg *resp.Message) { s.handleBLPOPCommand(conn, msg, false) }
+
+	moved := callAsync(t, s.handleBlmoveCommand, "BLMOVE", "src", "mid", "LEFT", "RIGHT", "0")
+	waitBlocked(t, s, "src", 1)
+	moved2 := callAsync(t, s.handleBlmoveCommand, "BLMOVE", "mid", "dest", "LEFT", "LEFT", "0")
+	waitBlocked(t, s, "mid", 1)
+	popped := callAsync(t, blpop, "BLPOP", "dest", "0")
+	waitBlocked(t, s, "dest", 1)
+
+	conn.call(t, s.handleRpushCommand, "RPUSH", "src", "x")
+	if got := flatten(awaitReply(t, moved)); got != "x" {
+		t.Errorf("first BLMOVE: got %s", got)
+	}
+	if got := flatten(awaitReply(t, moved2)); got != "x" {
+		t.Errorf("second BLMOVE: got %s", got)
+	}
+	if got := flatten(awaitReply(t, popped)); got != "[dest x]" {
+		t.Errorf("BLPOP: got %s", got)
+	}
+
+	for _, key := range []string{"src", "mid", "dest"} {
+		if got := flatten(conn.call(t, s.handleTypeCommand, "TYPE", key)); got != "none" {
+			t.Errorf("TYPE %s after the element moved on: got %s", key, got)
+		}
+	}
+}
+
+func TestBlmpop(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	blmpop := s.handleBlmpopCommand
+
+	conn.call(t, s.handleRpushCommand, "RPUSH", "l", "a", "b", "c")
+	conn.call(t, s.handleSetCommand, "SET", "str", "v")
+	runSteps(t, conn, []testStep{
+		{blmpop, []string{"BLMPOP", "0", "2", "missing", "l", "LEFT"}, "[l [a]]"},
+		{blmpop, []string{"BLMPOP", "0", "1", "l", "RIGHT", "COUNT", "5"}, "[l [c b]]"},
+		{blmpop, []string{"BLMPOP", "0", "1", "str", "LEFT"}, wrongType},
+		{blmpop, []string{"BLMPOP", "-1", "1", "l", "LEFT"}, "ERR timeout is negative"},
+		{blmpop, []string{"BLMPOP", "0", "1", "l", "UP"}, "ERR syntax error"},
+		{blmpop, []string{"BLMPOP", "0", "0", "l", "LEFT"}, "ERR numkeys should be greater than 0"},
+		{blmpop, []string{"BLMPOP", "0.02", "2", "l", "missing", "LEFT"}, "nil"},
+	})
+
+	ch := callAsync(t, blmpop, "BLMPOP", "0", "2", "l1", "l2", "LEFT", "COUNT", "2")
+	waitBlocked(t, s, "l2", 1)
+	conn.call(t, s.handleRpushCommand, "RPUSH", "l2", "x", "y", "z")
+	if got := flatten(awaitReply(t, ch)); got != "[l2 [x y]]" {
+		t.Errorf("woken BLMPOP: got %s", got)
+	}
+	if got := flatten(conn.call(t, s.handleLrangeCommand, "LRANGE", "l2", "0", "-1")); got != "[z]" {
+		t.Errorf("LRANGE after BLMPOP COUNT 2: got %s", got)
+	}
+}
+
+func TestSetex(t *testing.T) {
+	s := newTestServer()
+	setex := func(conn net.Conn, msg *resp.Message) { s.handleSetexCommand(conn, msg, "EX") }
+	psetex := func(conn net.Conn, msg *resp.Message) { s.handleSetexCommand(conn, msg, "PX") }
+	ttl := func(conn net.Conn, msg *resp.Message) { s.handleTtlCommand(conn, msg, time.Second, false) }
+
+	runSteps(t, &recordingConn{}, []testStep{
+		{setex, []string{"SETEX", "k", "100", "v"}, "OK"},
+		{s.handleGetCommand, []string{"GET", "k"}, "v"},
+		{ttl, []string{"TTL", "k"}, "100"},
+		{psetex, []string{"PSETEX", "k", "5000", "w"}, "OK"},
+		{s.handleGetCommand, []string{"GET", "k"}, "w"},
+		{ttl, []string{"TTL", "k"}, "5"},
+
+		// Whatever the key held is replaced, like SET does
+		{s.handleRpushCommand, []string{"RPUSH", "l", "a"}, "1"},
+		{setex, []string{"SETEX", "l", "100", "v"}, "OK"},
+		{s.handleTypeCommand, []string{"TYPE", "l"}, "string"},
+
+		{setex, []string{"SETEX", "k", "0", "v"}, "ERR invalid expire time in 'setex' command"},
+		{setex, []string{"SETEX", "k", "-5", "v"}, "ERR invalid expire time in 'setex' command"},
+		{setex, []string{"SETEX", "k", "9223372036854775807", "v"}, "ERR invalid expire time in 'setex' command"},
+		{psetex, []string{"PSETEX", "k", "9223372036854775807", "v"}, "ERR invalid expire time in 'psetex' command"},
+		{setex, []string{"SETEX", "k", "x", "v"}, "ERR value is not an integer or out of range"},
+		{setex, []string{"SETEX", "k", "100"}, "ERR Incorrect amount of args for `SETEX` command"},
+		{s.handleGetCommand, []string{"GET", "k"}, "w"},
+	})
+}
+
+func TestStringCommands(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	conn.call(t, s.handleRpushCommand, "RPUSH", "l", "a")
+	getrange := s.handleGetrangeCommand
+	ttl := func(conn net.Conn, msg *resp.Message) { s.handleTtlCommand(conn, msg, time.Second, false) }
+
+	runSteps(t, conn, []testStep{
+		{s.handleAppendCommand, []string{"APPEND", "s", "Hello"}, "5"},
+		{s.handleAppendCommand, []string{"APPEND", "s", " World"}, "11"},
+		{s.handleGetCommand, []string{"GET", "s"}, "Hello World"},
+		{s.handleAppendCommand, []string{"APPEND", "l", "x"}, wrongType},
+
+		{getrange, []string{"GETRANGE", "s", "0", "4"}, "Hello"},
+		{getrange, []string{"GETRANGE", "s", "-5", "-1"}, "World"},
+		{getrange, []string{"GETRANGE", "s", "0", "-1"}, "Hello World"},
+		{getrange, []string{"GETRANGE", "s", "-100", "3"}, "Hell"},
+		{getrange, []string{"GETRANGE", "s", "6", "100"}, "World"},
+		{getrange, []string{"GETRANGE", "s", "5", "2"}, ""},
+		{getrange, []string{"GETRANGE", "s", "100", "200"}, ""},
+		{getrange, []string{"GETRANGE", "s", "-1", "-5"}, ""},
+		{getrange, []string{"GETRANGE", "s", "0", "-100"}, "H"},
+		{getrange, []string{"GETRANGE", "missing", "0", "-1"}, ""},
+		{getrange, []string{"GETRANGE", "s", "x", "1"}, "ERR value is not an integer or out of range"},
+		{getrange, []string{"GETRANGE", "l", "0", "1"}, wrongType},
+
+		{s.handleGetdelCommand, []string{"GETDEL", "s"}, "Hello World"},
+		{s.handleGetdelCommand, []string{"GETDEL", "s"}, "nil"},
+		{s.handleTypeCommand, []string{"TYPE", "s"}, "none"},
+		{s.handleGetdelCommand, []string{"GETDEL", "l"}, wrongType},
+		{s.handleTypeCommand, []string{"TYPE", "l"}, "list"},
+
+		{s.handleSetCommand, []string{"SET", "g", "v"}, "OK"},
+		{s.handleGetexCommand, []string{"GETEX", "g"}, "v"},
+		{ttl, []string{"TTL", "g"}, "-1"},
+		{s.handleGetexCommand, []string{"GETEX", "g", "EX", "100"}, "v"},
+		{ttl, []string{"TTL", "g"}, "100"},
+		{s.handleGetexCommand, []string{"GETEX", "g"}, "v"},
+		{ttl, []string{"TTL", "g"}, "100"},
+		{s.handleGetexCommand, []string{"GETEX", "g", "persist"}, "v"},
+		{ttl, []string{"TTL", "g"}, "-1"},
+		{s.handleGetexCommand, []string{"GETEX", "g", "PXAT", "4102444800000"}, "v"},
+		{s.handleGetexCommand, []string{"GETEX", "g", "EX", "0"}, "ERR invalid expire time in 'getex' command"},
+		{s.handleGetexCommand, []string{"GETEX", "g", "EX", "x"}, "ERR value is not an integer or out of range"},
+		{s.handleGetexCommand, []string{"GETEX", "g", "EX", "10", "PERSIST"}, "ERR syntax error"},
+		{s.handleGetexCommand, []string{"GETEX", "g", "KEEPTTL"}, "ERR syntax error"},
+		{s.handleGetexCommand, []string{"GETEX", "missing", "EX", "10"}, "nil"},
+		{s.handleGetexCommand, []string{"GETEX", "l"}, wrongType},
+
+		// An absolute time in the past expires the key once it's been read
+		{s.handleGetexCommand, []string{"GETEX", "g", "EXAT", "1"}, "v"},
+		{s.handleGetCommand, []string{"GET", "g"}, "nil"},
+	})
+}
+
+func TestMsetnx(t *testing.T) {
+	s := newTestServer()
+	mset := func(conn net.Conn, msg *resp.Message) { s.handleMsetCommand(conn, msg, false) }
+	msetnx := func(conn net.Conn, msg *resp.Message) { s.handleMsetCommand(conn, msg, true) }
+
+	runSteps(t, &recordingConn{}, []testStep{
+		{msetnx, []string{"MSETNX", "a", "1", "b", "2"}, "1"},
+		{s.handleMgetCommand, []string{"MGET", "a", "b"}, "[1 2]"},
+
+		// One existing key is enough for none of them to be set
+		{msetnx, []string{"MSETNX", "c", "3", "a", "x"}, "0"},
+		{s.handleMgetCommand, []string{"MGET", "a", "c"}, "[1 nil]"},
+
+		// Whatever the type of the key that exists
+		{s.handleRpushCommand, []string{"RPUSH", "l", "a"}, "1"},
+		{msetnx, []string{"MSETNX", "c", "3", "l", "x"}, "0"},
+		{s.handleTypeCommand, []string{"TYPE", "l"}, "list"},
+
+		// A repeated key is set once, to its last value
+		{msetnx, []string{"MSETNX", "d", "1", "d", "2"}, "1"},
+		{s.handleGetCommand, []string{"GET", "d"}, "2"},
+
+		{msetnx, []string{"MSETNX", "e"}, "ERR Incorrect amount of args for `MSETNX` command"},
+		{msetnx, []string{"MSETNX", "e", "1", "f"}, "ERR Incorrect amount of args for `MSETNX` command"},
+		{mset, []string{"MSET", "a", "x", "l", "y"}, "OK"},
+		{s.handleMgetCommand, []string{"MGET", "a", "l"}, "[x y]"},
+	})
+}
+
+func TestIncrbyfloat(t *testing.T) {
+	s := newTestServer()
+	conn := &recordingConn{}
+	incrbyfloat := s.handleIncrbyfloatCommand
+	conn.call(t, s.handleRpushCommand, "RPUSH", "l", "a")
+
+	runSteps(t, conn, []testStep{
+		// Long double precision, then trimmed, leaves no float64 artifacts
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "10.1"}, "10.1"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "0.2"}, "10.3"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "-10.3"}, "0"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "-0.5"}, "-0.5"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "g", "5.0e3"}, "5000"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "g", "3"}, "5003"},
+		{s.handleGetCommand, []string{"GET", "g"}, "5003"},
+
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "inf"}, "ERR value is not a valid float"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "-inf"}, "ERR value is not a valid float"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "nan"}, "ERR value is not a valid float"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "abc"}, "ERR value is not a valid float"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", " 1"}, "ERR value is not a valid float"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", ""}, "ERR value is not a valid float"},
+
+		// Beyond what a long double holds, either way
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "1e5000"}, "ERR value is not a valid float"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "-1e1000000"}, "ERR value is not a valid float"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "1e-5000"}, "ERR value is not a valid float"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f", "1e99999999999"}, "ERR value is not a valid float"},
+		{s.handleSetCommand, []string{"SET", "huge", "1e5000"}, "OK"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "huge", "1"}, "ERR value is not a valid float"},
+		{s.handleSetCommand, []string{"SET", "str", "abc"}, "OK"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "str", "1"}, "ERR value is not a valid float"},
+		{s.handleSetCommand, []string{"SET", "max", "1e4932"}, "OK"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "max", "1e4932"}, "ERR increment would produce NaN or Infinity"},
+		{s.handleGetCommand, []string{"GET", "max"}, "1e4932"},
+		{s.handleSetCommand, []string{"SET", "min", "-1e4932"}, "OK"},
+		{incrbyfloat, []string{"INCRBYFLOAT", "min", "-1e4932"}, "ERR increment would produce NaN or Infinity"},
+
+		{incrbyfloat, []string{"INCRBYFLOAT", "l", "1"}, wrongType},
+		{incrbyfloat, []string{"INCRBYFLOAT", "f"}, "ERR Incorrect amount of args for `INCRBYFLOAT` command"},
+		{s.handleGetCommand, []string{"GET", "f"}, "-0.5"},
+	})
+
+	// The largest values a long double holds still fit in a reply of a few KiB
+	reply := conn.call(t, incrbyfloat, "INCRBYFLOAT", "big", "1.18e4932")
+	if reply == nil || reply.Type != resp.BulkString || len(reply.String) < 4933 || len(reply.String) > 5000 {
+		t.Errorf("INCRBYFLOAT by 1.18e4932: got %d bytes of %s", len(reply.String), reply.Type)
+	}
+}
+
+func TestIncrOverflow(t *testing.T) {
+	s := newTestServer()
+	incr := func(conn net.Conn, msg *resp.Message) { s.handleIncrCommand(conn, msg, false) }
+	decr := func(conn net.Conn, msg *resp.Message) { s.handleIncrCommand(conn, msg, true) }
+	const overflow = "ERR increment or decrement would overflow"
+
+	runSteps(t, &recordingConn{}, []testStep{
+		{s.handleSetCommand, []string{"SET", "max", "9223372036854775807"}, "OK"},
+		{incr, []string{"INCR", "max"}, overflow},
+		{incr, []string{"INCRBY", "max", "1"}, overflow},
+		{decr, []string{"DECRBY", "max", "-1"}, overflow},
+		{incr, []string{"INCRBY", "max", "0"}, "9223372036854775807"},
+		{decr, []string{"DECR", "max"}, "9223372036854775806"},
+		{incr, []string{"INCR", "max"}, "9223372036854775807"},
+
+		{s.handleSetCommand, []string{"SET", "min", "-9223372036854775808"}, "OK"},
+		{decr, []string{"DECR", "min"}, overflow},
+		{decr, []string{"DECRBY", "min", "1"}, overflow},
+		{incr, []string{"INCRBY", "min", "-1"}, overflow},
+		{incr, []string{"INCR", "min"}, "-9223372036854775807"},
+		{s.handleGetCommand, []string{"GET", "min"}, "-9223372036854775807"},
+
+		// An increment that can't be negated can't be a decrement
+		{decr, []string{"DECRBY", "n", "-9223372036854775808"}, "ERR decrement would overflow"},
+		{incr, []string{"INCRBY", "n", "-9223372036854775808"}, "-9223372036854775808"},
+		{incr, []string{"INCRBY", "n", "9223372036854775807"}, "-1"},
+		{incr, []string{"INCRBY", "n", "9223372036854775808"}, "ERR value is not an integer or out of range"},
+		{decr, []string{"DECRBY", "n", "x"}, "ERR value is not an integer or out of range"},
+
+		// Stored values must be canonical integers
+		{s.handleSetCommand, []string{"SET", "s", "abc"}, "OK"},
+		{incr, []string{"INCR", "s"}, "ERR value is not an integer or out of range"},
+		{s.handleSetCommand, []string{"SET", "s", "9223372036854775808"}, "OK"},
+		{incr, []string{"INCR", "s"}, "ERR value is not an integer or out of range"},
+		{s.handleSetCommand, []string{"SET", "s", "01"}, "OK"},
+		{decr, []string{"DECR", "s"}, "ERR value is not an integer or out of range"},
+		{s.handleGetCommand, []string{"GET", "s"}, "01"},
+	})
+}
+
+func TestKeyExpiry(t *testing.T) {
+	s := newTestServer()
+	expire := func(conn net.Conn, msg *resp.Message) { s.handleExpireCommand(conn, msg, time.Second, false) }
+	pexpire := func(conn net.Conn, msg *resp.Message) { s.handleExpireCommand(conn, msg, time.Millisecond, false) }
+	expireat := func(conn net.Conn, msg *resp.Message) { s.handleExpireCommand(conn, msg, time.Second, true) }
+	pexpireat := func(conn net.Conn, msg *resp.Message) { s.handleExpireCommand(conn, msg, time.Millisecond, true) }
+	ttl := func(conn net.Conn, msg *resp.Message) { s.handleTtlCommand(conn, msg, time.Second, false) }
+	pttl := func(conn net.Conn, msg *resp.Message) { s.handleTtlCommand(conn, msg, time.Millisecond, false) }
+	expiretime := func(conn net.Conn, msg *resp.Message) { s.handleTtlCommand(conn, msg, time.Second, true) }
+	pexpiretime := func(conn net.Conn, msg *resp.Message) { s.handleTtlCommand(conn, msg, time.Millisecond, true) }
+	persist := s.handlePersistCommand
+
+	conn := &recordingConn{}
+	conn.call(t, s.handleSetCommand, "SET", "k", "v")
+	runSteps(t, conn, []testStep{
+		{ttl, []string{"TTL", "k"}, "-1"},
+		{ttl, []string{"TTL", "missing"}, "-2"},
+		{pttl, []string{"PTTL", "missing"}, "-2"},
+		{expiretime, []string{"EXPIRETIME", "k"}, "-1"},
+		{expiretime, []string{"EXPIRETIME", "missing"}, "-2"},
+		{expire, []string{"EXPIRE", "missing", "10"}, "0"},
+		{persist, []string{"PERSIST", "k"}, "0"},
+		{persist, []string{"PERSIST", "missing"}, "0"},
+
+		// A key without a TTL counts as having an infinite one for GT and LT
+		{expire, []string{"EXPIRE", "k", "100", "XX"}, "0"},
+		{expire, []string{"EXPIRE", "k", "100", "GT"}, "0"},
+		{ttl, []string{"TTL", "k"}, "-1"},
+		{expire, []string{"EXPIRE", "k", "100", "LT"}, "1"},
+		{ttl, []string{"TTL", "k"}, "100"},
+
+		{expire, []string{"EXPIRE", "k", "50", "NX"}, "0"},
+		{expire, []string{"EXPIRE", "k", "200", "XX"}, "1"},
+		{ttl, []string{"TTL", "k"}, "200"},
+		{expire, []string{"EXPIRE", "k", "100", "GT"}, "0"},
+		{expire, []string{"EXPIRE", "k", "300", "gt"}, "1"},
+		{expire, []string{"EXPIRE", "k", "400", "LT"}, "0"},
+		{expire, []string{"EXPIRE", "k", "250", "LT"}, "1"},
+		{expire, []string{"EXPIRE", "k", "100", "XX", "GT"}, "0"},
+		{expire, []string{"EXPIRE", "k", "260", "XX", "GT"}, "1"},
+		{ttl, []string{"TTL", "k"}, "260"},
+
+		{expire, []string{"EXPIRE", "k", "10", "NX", "XX"}, "ERR NX and XX, GT or LT options at the same time are not compatible"},
+		{expire, []string{"EXPIRE", "k", "10", "NX", "GT"}, "ERR NX and XX, GT or LT options at the same time are not compatible"},
+		{expire, []string{"EXPIRE", "k", "10", "GT", "LT"}, "ERR GT and LT options at the same time are not compatible"},
+		{expire, []string{"EXPIRE", "k", "10", "SOON"}, "ERR Unsupported option SOON"},
+		{expire, []string{"EXPIRE", "k", "x"}, "ERR value is not an integer or out of range"},
+		{expire, []string{"EXPIRE", "k", "9223372036854775807"}, "ERR invalid expire time in 'expire' command"},
+		{pexpire, []string{"PEXPIRE", "k", "-9223372036854775808"}, "ERR invalid expire time in 'pexpire' command"},
+		{ttl, []string{"TTL", "k"}, "260"},
+
+		{persist, []string{"PERSIST", "k"}, "1"},
+		{ttl, []string{"TTL", "k"}, "-1"},
+		{expire, []string{"EXPIRE", "k", "100", "NX"}, "1"},
+
+		{pexpire, []string{"PEXPIRE", "k", "5000"}, "1"},
+		{ttl, []string{"TTL", "k"}, "5"},
+		{expireat, []string{"EXPIREAT", "k", "4102444800"}, "1"},
+		{expiretime, []string{"EXPIRETIME", "k"}, "4102444800"},
+		{pexpiretime, []string{"PEXPIRETIME", "k"}, "4102444800000"},
+		{pexpireat, []string{"PEXPIREAT", "k", "4102444800123"}, "1"},
+		{pexpiretime, []string{"PEXPIRETIME", "k"}, "4102444800123"},
+		{expiretime, []string{"EXPIRETIME", "k"}, "4102444800"},
+
+		// Far off absolute times don't overflow
+		{expireat, []string{"EXPIREAT", "k", "9223372036854775807"}, "ERR invalid expire time in 'expireat' command"},
+		{expireat, []string{"EXPIREAT", "k", "9223372036854775", "GT"}, "1"},
+		{expiretime, []string{"EXPIRETIME", "k"}, "9223372036854775"},
+		{pexpireat, []string{"PEXPIREAT", "k", "9223372036854775807"}, "1"},
+		{pexpiretime, []string{"PEXPIRETIME", "k"}, "9223372036854775807"},
+
+		// Replacing the value drops the TTL, unless asked to keep it
+		{s.handleSetCommand, []string{"SET", "k", "w", "EX", "100"}, "OK"},
+		{s.handleSetCommand, []string{"SET", "k", "x", "KEEPTTL"}, "OK"},
+		{ttl, []string{"TTL", "k"}, "100"},
+		{s.handleSetCommand, []string{"SET", "k", "y"}, "OK"},
+		{ttl, []string{"TTL", "k"}, "-1"},
+
+		// Any type of key may expire
+		{s.handleHsetCommand, []string{"HSET", "h", "f", "v"}, "1"},
+		{expire, []string{"EXPIRE", "h", "100"}, "1"},
+		{ttl, []string{"TTL", "h"}, "100"},
+
+		// Times already past delete the key there and then
+		{expire, []string{"EXPIRE", "k", "-1"}, "1"},
+		{ttl, []string{"TTL", "k"}, "-2"},
+		{expireat, []string{"EXPIREAT", "h", "1"}, "1"},
+		{s.handleTypeCommand, []string{"TYPE", "h"}, "none"},
+	})
+
+	if reply := conn.call(t, pttl, "PTTL", "missing"); flatten(reply) != "-2" {
+		t.Errorf("PTTL on a missing key: got %s", flatten(reply))
+	}
+
+	conn.call(t, s.handleSetCommand, "SET", "short", "v")
+	conn.call(t, pexpire, "PEXPIRE", "short", "5000")
+	if reply := conn.call(t, pttl, "PTTL", "short"); reply == nil || reply.Integer <= 4000 || reply.Integer > 5000 {
+		t.Errorf("PTTL: got %s", flatten(reply))
+	}
+
+	conn.call(t, pexpire, "PEXPIRE", "short", "20")
+	time.Sleep(30 * time.Millisecond)
+	runSteps(t, conn, []testStep{
+		{ttl, []string{"TTL", "short"}, "-2"},
+		{s.handleGetCommand, []string{"GET", "short"}, "nil"},
+		{persist, []string{"PERSIST", "short"}, "0"},
+	})
+}
+
+// Discards whatever the server writes.
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// Sixteen pipelined GETs, replied to through a client and flushed once.
+func BenchmarkGetPipeline(b *testing.B) {
+	s := newTestServer()
+	s.handleSetCommand(&recordingConn{}, command("SET", "k", "value"))
+	c := s.newClient(discardConn{})
+	msg := command("GET", "k")
+
+	b.ReportAllocs()
+	for b.Loop() {
+		for range 16 {
+			s.handleGetCommand(c, msg)
+		}
+		c.w.Flush()
+	}
+}
@@ -3,11 +3,16 @@ package server
 import (
 	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/ev-the-dev/redis-go-clone/resp"
+	"github.com/ev-the-dev/redis-go-clone/store"
 )
 
 type SetOptions struct {
@@ -18,6 +23,34 @@ type SetOptions struct {
 	XX      bool
 }
 
+// Parses the NX/XX/GT/LT flags of the EXPIRE family. XX may be combined with
+// GT or LT, every other combination is rejected like Redis does.
+func parseExpireConditions(msgs []*resp.Message) ([]store.ExpireCondition, error) {
+	var conds []store.ExpireCondition
+	for _, m := range msgs {
+		cond, ok := parseExpireCondition(m.String)
+		if !ok {
+			return nil, fmt.Errorf("Unsupported option %s", m.String)
+		}
+
+		if !slices.Contains(conds, cond) {
+			conds = append(conds, cond)
+		}
+	}
+
+	hasNX := slices.Contains(conds, store.ExpireNX)
+	hasGT := slices.Contains(conds, store.ExpireGT)
+	hasLT := slices.Contains(conds, store.ExpireLT)
+	switch {
+	case hasNX && len(conds) > 1:
+		return nil, errors.New("NX and XX, GT or LT options at the same time are not compatible")
+	case hasGT && hasLT:
+		return nil, errors.New("GT and LT options at the same time are not compatible")
+	}
+
+	return conds, nil
+}
+
 // NOTE: not sure if I should define a new error type like ErrParseSet for all of the errors here
 func parseSETOptions(msgs []*resp.Message) (*SetOptions, error) {
 	opts := &SetOptions{}
@@ -93,3 +126,795 @@ func parseSETOptionWithArg(opt string, rawArg string) (time.Time, error) {
 		return time.Time{}, errors.New("should not have gotten here: unsupported option")
 	}
 }
+
+// Parses an XRANGE/XREVRANGE interval bound. Besides complete IDs this
+// supports `-` and `+`, incomplete IDs (a start bound missing its sequence
+// begins at sequence 0 while an end bound ends at the maximum sequence) and
+// exclusive bounds prefixed with `(`.
+func parseStreamRangeID(raw string, isStart bool) (store.StreamID, error) {
+	switch raw {
+	case "-":
+		return store.MinStreamID, nil
+	case "+":
+		return store.MaxStreamID, nil
+	}
+
+	exclusive := strings.HasPrefix(raw, "(")
+	if exclusive {
+		raw = raw[1:]
+	}
+
+	missingSeq := uint64(0)
+	if !isStart {
+		missingSeq = math.MaxUint64
+	}
+
+	id, err := store.ParseStreamID(raw, missingSeq)
+	if err != nil {
+		return store.StreamID{}, err
+	}
+
+	if !exclusive {
+		return id, nil
+	}
+
+	var ok bool
+	if isStart {
+		id, ok = id.Next()
+	} else {
+		id, ok = id.Prev()
+	}
+	if !ok {
+		return store.StreamID{}, fmt.Errorf("%s stream range: exclusive bound out of range: %s", ErrCmdPrefix, raw)
+	}
+
+	return id, nil
+}
+
+type XReadOptions struct {
+	Block    time.Duration
+	Consumer string
+	Count    int
+	Group    string
+	IDs      []string
+	IsBlock  bool
+	Keys     []string
+	NoAck    bool
+}
+
+// Parses the options shared by XREAD and XREADGROUP. `isGroup` additionally
+// allows the XREADGROUP only GROUP and NOACK options.
+func parseXREADOptions(msgs []*resp.Message, isGroup bool) (*XReadOptions, error) {
+	opts := &XReadOptions{}
+
+	for i := 0; i < len(msgs); i++ {
+		opt, err := msgs[i].ConvStr()
+		if err != nil {
+			return nil, fmt.Errorf("%s XREAD option: parse: %w", ErrCmdPrefix, err)
+		}
+
+		switch strings.ToUpper(opt) {
+		case "COUNT":
+			if i+1 >= len(msgs) {
+				return nil, fmt.Errorf("%s XREAD option: COUNT provided without arg", ErrCmdPrefix)
+			}
+			count, err := msgs[i+1].ConvInt()
+			if err != nil {
+				return nil, fmt.Errorf("%s XREAD option: COUNT invalid arg: %w", ErrCmdPrefix, err)
+			}
+			opts.Count = max(count, 0)
+			i++
+		case "BLOCK":
+			if i+1 >= len(msgs) {
+				return nil, fmt.Errorf("%s XREAD option: BLOCK provided without arg", ErrCmdPrefix)
+			}
+			ms, err := msgs[i+1].ConvInt()
+			if err != nil {
+				return nil, fmt.Errorf("%s XREAD option: BLOCK invalid arg: %w", ErrCmdPrefix, err)
+			}
+			if ms < 0 {
+				return nil, fmt.Errorf("%s XREAD option: BLOCK timeout is negative", ErrCmdPrefix)
+			}
+			opts.Block = time.Duration(ms) * time.Millisecond
+			opts.IsBlock = true
+			i++
+		case "GROUP":
+			if !isGroup {
+				return nil, fmt.Errorf("%s XREAD option: GROUP is only supported by XREADGROUP", ErrCmdPrefix)
+			}
+			if i+2 >= len(msgs) {
+				return nil, fmt.Errorf("%s XREAD option: GROUP provided without group and consumer", ErrCmdPrefix)
+			}
+			if opts.Group, err = msgs[i+1].ConvStr(); err != nil {
+				return nil, fmt.Errorf("%s XREAD option: GROUP invalid group: %w", ErrCmdPrefix, err)
+			}
+			if opts.Consumer, err = msgs[i+2].ConvStr(); err != nil {
+				return nil, fmt.Errorf("%s XREAD option: GROUP invalid consumer: %w", ErrCmdPrefix, err)
+			}
+			i += 2
+		case "NOACK":
+			if !isGroup {
+				return nil, fmt.Errorf("%s XREAD option: NOACK is only supported by XREADGROUP", ErrCmdPrefix)
+			}
+			opts.NoAck = true
+		case "STREAMS":
+			// Everything after STREAMS is the keys followed by their IDs
+			rest := msgs[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, fmt.Errorf("%s XREAD option: STREAMS: unbalanced list of streams", ErrCmdPrefix)
+			}
+
+			half := len(rest) / 2
+			opts.Keys = make([]string, half)
+			opts.IDs = make([]string, half)
+			for j := range half {
+				if opts.Keys[j], err = rest[j].ConvStr(); err != nil {
+					return nil, fmt.Errorf("%s XREAD option: STREAMS: key: %w", ErrCmdPrefix, err)
+				}
+				if opts.IDs[j], err = rest[half+j].ConvStr(); err != nil {
+					return nil, fmt.Errorf("%s XREAD option: STREAMS: id: %w", ErrCmdPrefix, err)
+				}
+			}
+
+			if isGroup && opts.Group == "" {
+				return nil, fmt.Errorf("%s XREAD option: GROUP not provided", ErrCmdPrefix)
+			}
+
+			return opts, nil
+		default:
+			return nil, fmt.Errorf("%s XREAD option: unsupported option: %s", ErrCmdPrefix, opt)
+		}
+	}
+
+	return nil, fmt.Errorf("%s XREAD option: STREAMS not provided", ErrCmdPrefix)
+}
+
+func parseXCLAIMOptions(msgs []*resp.Message) (*store.ClaimOptions, error) {
+	opts := &store.ClaimOptions{}
+
+	for i := 0; i < len(msgs); i++ {
+		opt, err := msgs[i].ConvStr()
+		if err != nil {
+			return nil, fmt.Errorf("%s XCLAIM option: parse: %w", ErrCmdPrefix, err)
+		}
+
+		opt = strings.ToUpper(opt)
+		switch opt {
+		case "FORCE":
+			opts.Force = true
+		case "JUSTID":
+			opts.JustID = true
+		case "IDLE", "TIME", "RETRYCOUNT":
+			if i+1 >= len(msgs) {
+				return nil, fmt.Errorf("%s XCLAIM option: %s provided without arg", ErrCmdPrefix, opt)
+			}
+			arg, err := msgs[i+1].ConvInt()
+			if err != nil {
+				return nil, fmt.Errorf("%s XCLAIM option: %s invalid arg: %w", ErrCmdPrefix, opt, err)
+			}
+
+			switch opt {
+			case "IDLE":
+				opts.Idle = time.Duration(arg) * time.Millisecond
+			case "TIME":
+				opts.Time = time.UnixMilli(int64(arg))
+			case "RETRYCOUNT":
+				retryCount := int64(arg)
+				opts.RetryCount = &retryCount
+			}
+			i++
+		case "LASTID":
+			if i+1 >= len(msgs) {
+				return nil, fmt.Errorf("%s XCLAIM option: LASTID provided without arg", ErrCmdPrefix)
+			}
+			id, err := store.ParseStreamID(msgs[i+1].String, 0)
+			if err != nil {
+				return nil, fmt.Errorf("%s XCLAIM option: LASTID invalid arg: %w", ErrCmdPrefix, err)
+			}
+			opts.LastID = &id
+			i++
+		default:
+			return nil, fmt.Errorf("%s XCLAIM option: unsupported option: %s", ErrCmdPrefix, opt)
+		}
+	}
+
+	return opts, nil
+}
+
+type XAddOptions struct {
+	NoMkStream bool
+	Trim       *store.TrimOptions
+}
+
+// Parses the options that precede the ID in XADD, returning them along with
+// how many messages they span so the caller knows where the ID is.
+func parseXADDOptions(msgs []*resp.Message) (*XAddOptions, int, error) {
+	opts := &XAddOptions{}
+
+	i := 0
+	for ; i < len(msgs); i++ {
+		switch strings.ToUpper(msgs[i].String) {
+		case "NOMKSTREAM":
+			opts.NoMkStream = true
+		case "MAXLEN", "MINID":
+			if opts.Trim != nil {
+				return nil, 0, fmt.Errorf("%s XADD option: MAXLEN and MINID are mutually exclusive", ErrCmdPrefix)
+			}
+			trim, consumed, err := parseStreamTrimOptions(msgs[i:])
+			if err != nil {
+				return nil, 0, fmt.Errorf("%s XADD option: %w", ErrCmdPrefix, err)
+			}
+			opts.Trim = trim
+			i += consumed - 1
+		default:
+			// Reached the ID, and past it the fields which need at least a pair
+			if len(msgs)-i < 3 {
+				return nil, 0, fmt.Errorf("%s XADD option: missing ID or fields", ErrCmdPrefix)
+			}
+			return opts, i, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("%s XADD option: missing ID and fields", ErrCmdPrefix)
+}
+
+// Parses `<MAXLEN | MINID> [= | ~] threshold [LIMIT count]` from the start of
+// `msgs`, returning the options along with how many messages they span.
+func parseStreamTrimOptions(msgs []*resp.Message) (*store.TrimOptions, int, error) {
+	opts := &store.TrimOptions{}
+
+	i := 0
+	strategy := strings.ToUpper(msgs[i].String)
+	switch strategy {
+	case "MAXLEN":
+		opts.Strategy = store.TrimMaxLen
+	case "MINID":
+		opts.Strategy = store.TrimMinID
+	default:
+		return nil, 0, fmt.Errorf("%s trim option: unsupported strategy: %s", ErrCmdPrefix, strategy)
+	}
+	i++
+
+	if i < len(msgs) && (msgs[i].String == "~" || msgs[i].String == "=") {
+		opts.Approx = msgs[i].String == "~"
+		i++
+	}
+
+	if i >= len(msgs) {
+		return nil, 0, fmt.Errorf("%s trim option: %s provided without threshold", ErrCmdPrefix, strategy)
+	}
+
+	switch opts.Strategy {
+	case store.TrimMaxLen:
+		maxLen, err := msgs[i].ConvInt()
+		if err != nil || maxLen < 0 {
+			return nil, 0, fmt.Errorf("%s trim option: MAXLEN must be a positive integer", ErrCmdPrefix)
+		}
+		opts.MaxLen = maxLen
+	case store.TrimMinID:
+		minID, err := store.ParseStreamID(msgs[i].String, 0)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s trim option: MINID: %w", ErrCmdPrefix, err)
+		}
+		opts.MinID = minID
+	}
+	i++
+
+	if i < len(msgs) && strings.ToUpper(msgs[i].String) == "LIMIT" {
+		if !opts.Approx {
+			return nil, 0, fmt.Errorf("%s trim option: LIMIT cannot be used without the special ~ option", ErrCmdPrefix)
+		}
+		if i+1 >= len(msgs) {
+			return nil, 0, fmt.Errorf("%s trim option: LIMIT provided without arg", ErrCmdPrefix)
+		}
+		limit, err := msgs[i+1].ConvInt()
+		if err != nil || limit < 0 {
+			return nil, 0, fmt.Errorf("%s trim option: LIMIT must be a positive integer", ErrCmdPrefix)
+		}
+		opts.HasLimit = true
+		opts.Limit = limit
+		i += 2
+	}
+
+	return opts, i, nil
+}
+
+func parseEntriesRead(m *resp.Message) (int64, error) {
+	n, err := m.ConvInt()
+	if err != nil {
+		return 0, fmt.Errorf("%s ENTRIESREAD: %w", ErrCmdPrefix, err)
+	}
+
+	if n < -1 {
+		return 0, fmt.Errorf("%s ENTRIESREAD: must be positive or -1", ErrCmdPrefix)
+	}
+
+	return int64(n), nil
+}
+
+func parseExpireCondition(raw string) (store.ExpireCondition, bool) {
+	switch strings.ToUpper(raw) {
+	case "NX":
+		return store.ExpireNX, true
+	case "XX":
+		return store.ExpireXX, true
+	case "GT":
+		return store.ExpireGT, true
+	case "LT":
+		return store.ExpireLT, true
+	default:
+		return store.ExpireAlways, false
+	}
+}
+
+// Parses the trailing `FIELDS numfields field [field ...]` block of the hash
+// field TTL commands.
+func parseHashFieldsArg(msgs []*resp.Message) ([]string, error) {
+	if len(msgs) < 3 || strings.ToUpper(msgs[0].String) != "FIELDS" {
+		return nil, fmt.Errorf("%s FIELDS: mandatory argument FIELDS is missing or not at the right position", ErrCmdPrefix)
+	}
+
+	numFields, err := msgs[1].ConvInt()
+	if err != nil || numFields <= 0 {
+		return nil, fmt.Errorf("%s FIELDS: numfields must be a positive integer", ErrCmdPrefix)
+	}
+
+	if numFields != len(msgs)-2 {
+		return nil, fmt.Errorf("%s FIELDS: the `numfields` parameter must match the number of arguments", ErrCmdPrefix)
+	}
+
+	fields := make([]string, numFields)
+	for i, m := range msgs[2:] {
+		fields[i] = m.String
+	}
+
+	return fields, nil
+}
+
+// Parses a sorted set score, accepting `inf`/`+inf`/`-inf` but not NaN.
+func parseScore(raw string) (float64, error) {
+	score, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, fmt.Errorf("%s score: not a valid float: %s", ErrCmdPrefix, raw)
+	}
+
+	return score, nil
+}
+
+// Parses the `min` and `max` of a score interval, where a leading `(` makes
+// that end exclusive.
+func parseScoreRange(rawMin, rawMax string) (*store.ScoreRange, error) {
+	r := &store.ScoreRange{}
+
+	var err error
+	r.Min, r.MinExclusive, err = parseScoreBound(rawMin)
+	if err != nil {
+		return nil, err
+	}
+
+	r.Max, r.MaxExclusive, err = parseScoreBound(rawMax)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func parseScoreBound(raw string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(raw, "(")
+	if exclusive {
+		raw = raw[1:]
+	}
+
+	score, err := parseScore(raw)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s score range: min or max is not a float: %w", ErrCmdPrefix, err)
+	}
+
+	return score, exclusive, nil
+}
+
+// Parses the `min` and `max` of a lexicographical interval, where each end
+// is either `-`, `+`, or a value prefixed by `[` (inclusive) or `(`
+// (exclusive).
+func parseLexRange(rawMin, rawMax string) (*store.LexRange, error) {
+	lexMin, err := parseLexBound(rawMin)
+	if err != nil {
+		return nil, err
+	}
+
+	lexMax, err := parseLexBound(rawMax)
+	if err != nil {
+		return nil, err
+	}
+
+	return &store.LexRange{Min: lexMin, Max: lexMax}, nil
+}
+
+func parseLexBound(raw string) (store.LexBound, error) {
+	switch {
+	case raw == "-":
+		return store.LexBound{Inf: -1}, nil
+	case raw == "+":
+		return store.LexBound{Inf: 1}, nil
+	case strings.HasPrefix(raw, "["):
+		return store.LexBound{Value: raw[1:]}, nil
+	case strings.HasPrefix(raw, "("):
+		return store.LexBound{Exclusive: true, Value: raw[1:]}, nil
+	default:
+		return store.LexBound{}, fmt.Errorf("%s lex range: min or max not valid string range item: %s", ErrCmdPrefix, raw)
+	}
+}
+
+type ZAddOptions struct {
+	CH    bool
+	Flags *store.ZAddFlags
+}
+
+// Parses the flags preceding the score/member pairs of `ZADD`. Like
+// parseXADDOptions, the amount of consumed messages is returned so the
+// caller knows where the pairs begin.
+func parseZADDOptions(msgs []*resp.Message) (*ZAddOptions, int, error) {
+	opts := &ZAddOptions{Flags: &store.ZAddFlags{}}
+
+	i := 0
+flags:
+	for ; i < len(msgs); i++ {
+		switch strings.ToUpper(msgs[i].String) {
+		case "CH":
+			opts.CH = true
+		case "GT":
+			opts.Flags.GT = true
+		case "INCR":
+			opts.Flags.Incr = true
+		case "LT":
+			opts.Flags.LT = true
+		case "NX":
+			opts.Flags.NX = true
+		case "XX":
+			opts.Flags.XX = true
+		default:
+			break flags
+		}
+	}
+
+	f := opts.Flags
+	if f.NX && f.XX {
+		return nil, i, errors.New("XX and NX options at the same time are not compatible")
+	}
+
+	if (f.GT && f.LT) || (f.NX && (f.GT || f.LT)) {
+		return nil, i, errors.New("GT, LT, and/or NX options at the same time are not compatible")
+	}
+
+	return opts, i, nil
+}
+
+type ZRangeBy int
+
+const (
+	ZRangeByRank ZRangeBy = iota
+	ZRangeByScore
+	ZRangeByLex
+)
+
+type ZRangeOptions struct {
+	By         ZRangeBy
+	Count      int
+	Offset     int
+	Rev        bool
+	WithScores bool
+}
+
+// Parses the options following `start stop` in `ZRANGE` and `ZRANGESTORE`,
+// the latter of which doesn't accept WITHSCORES. A Count of -1 means no
+// LIMIT was given.
+func parseZRANGEOptions(msgs []*resp.Message, allowWithScores bool) (*ZRangeOptions, error) {
+	opts := &ZRangeOptions{Count: -1}
+
+	hasLimit := false
+	for i := 0; i < len(msgs); i++ {
+		switch strings.ToUpper(msgs[i].String) {
+		case "BYLEX":
+			opts.By = ZRangeByLex
+		case "BYSCORE":
+			opts.By = ZRangeByScore
+		case "LIMIT":
+			if i+2 >= len(msgs) {
+				return nil, errors.New("syntax error")
+			}
+
+			var err error
+			opts.Offset, err = msgs[i+1].ConvInt()
+			if err != nil {
+				return nil, errors.New("value is not an integer or out of range")
+			}
+
+			opts.Count, err = msgs[i+2].ConvInt()
+			if err != nil {
+				return nil, errors.New("value is not an integer or out of range")
+			}
+
+			hasLimit = true
+			i += 2
+		case "REV":
+			opts.Rev = true
+		case "WITHSCORES":
+			if !allowWithScores {
+				return nil, errors.New("syntax error")
+			}
+			opts.WithScores = true
+		default:
+			return nil, errors.New("syntax error")
+		}
+	}
+
+	if hasLimit && opts.By == ZRangeByRank {
+		return nil, errors.New("syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+
+	if opts.WithScores && opts.By == ZRangeByLex {
+		return nil, errors.New("syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+
+	return opts, nil
+}
+
+// Parses the seconds a blocking command may wait for, which unlike BLPOP's
+// original integer timeout may be fractional. Zero means wait forever.
+func parseBlockTimeout(raw string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(raw, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, errors.New("timeout is not a float or out of range")
+	}
+
+	if seconds < 0 {
+		return 0, errors.New("timeout is negative")
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Options shared by LMPOP, ZMPOP and their blocking variants. FromTail is
+// set for RIGHT on lists and MAX on sorted sets.
+type MPopOptions struct {
+	Count    int
+	FromTail bool
+	Keys     []string
+}
+
+// Parses `numkeys key [key ...] head|tail [COUNT count]`, where `head` and
+// `tail` are the words the command uses for either end, i.e. LEFT/RIGHT or
+// MIN/MAX.
+func parseMPOPOptions(msgs []*resp.Message, head, tail string) (*MPopOptions, error) {
+	if len(msgs) < 3 {
+		return nil, errors.New("wrong number of arguments")
+	}
+
+	numKeys, err := msgs[0].ConvInt()
+	if err != nil || numKeys <= 0 {
+		return nil, errors.New("numkeys should be greater than 0")
+	}
+
+	if numKeys >= len(msgs)-1 {
+		return nil, errors.New("syntax error")
+	}
+
+	opts := &MPopOptions{Count: 1, Keys: make([]string, numKeys)}
+	for i, m := range msgs[1 : 1+numKeys] {
+		opts.Keys[i] = m.String
+	}
+
+	rest := msgs[1+numKeys:]
+	switch strings.ToUpper(rest[0].String) {
+	case head:
+	case tail:
+		opts.FromTail = true
+	default:
+		return nil, errors.New("syntax error")
+	}
+
+	switch {
+	case len(rest) == 1:
+	case len(rest) == 3 && strings.ToUpper(rest[1].String) == "COUNT":
+		opts.Count, err = rest[2].ConvInt()
+		if err != nil || opts.Count <= 0 {
+			return nil, errors.New("count should be greater than 0")
+		}
+	default:
+		return nil, errors.New("syntax error")
+	}
+
+	return opts, nil
+}
+
+type LPosOptions struct {
+	// Zero when COUNT wasn't given, in which case a single position (or
+	// null) is replied with rather than an array
+	Count    int
+	HasCount bool
+	MaxLen   int
+	Rank     int
+}
+
+// Parses `[RANK rank] [COUNT num-matches] [MAXLEN len]` of LPOS.
+func parseLPOSOptions(msgs []*resp.Message) (*LPosOptions, error) {
+	opts := &LPosOptions{Rank: 1}
+
+	for i := 0; i < len(msgs); i += 2 {
+		if i+1 >= len(msgs) {
+			return nil, errors.New("syntax error")
+		}
+
+		n, err := msgs[i+1].ConvInt()
+		if err != nil {
+			return nil, errors.New("value is not an integer or out of range")
+		}
+
+		switch strings.ToUpper(msgs[i].String) {
+		case "COUNT":
+			if n < 0 {
+				return nil, errors.New("COUNT can't be negative")
+			}
+			opts.Count = n
+			opts.HasCount = true
+		case "MAXLEN":
+			if n < 0 {
+				return nil, errors.New("MAXLEN can't be negative")
+			}
+			opts.MaxLen = n
+		case "RANK":
+			if n == 0 || n == math.MinInt {
+				return nil, errors.New("RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			opts.Rank = n
+		default:
+			return nil, errors.New("syntax error")
+		}
+	}
+
+	return opts, nil
+}
+
+type ScanOptions struct {
+	Count int
+	// Empty when no MATCH was given
+	Match    string
+	NoValues bool
+	// Empty when no TYPE was given, otherwise a name as TYPE replies with
+	Type string
+}
+
+// Parses the cursor SCAN and its HSCAN, SSCAN and ZSCAN variants start
+// from, followed by their options.
+func parseScanArgs(msgs []*resp.Message, cmd CmdName) (uint64, *ScanOptions, error) {
+	cursor, err := strconv.ParseUint(msgs[0].String, 10, 64)
+	if err != nil {
+		return 0, nil, errors.New("invalid cursor")
+	}
+
+	opts, err := parseSCANOptions(msgs[1:], cmd)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return cursor, opts, nil
+}
+
+// Parses `[MATCH pattern] [COUNT count]` of SCAN and its variants, along
+// with TYPE, which only SCAN takes, and NOVALUES, which only HSCAN takes.
+func parseSCANOptions(msgs []*resp.Message, cmd CmdName) (*ScanOptions, error) {
+	opts := &ScanOptions{Count: 10}
+
+	for i := 0; i < len(msgs); i++ {
+		opt := strings.ToUpper(msgs[i].String)
+		if opt == "NOVALUES" && cmd == HSCAN {
+			opts.NoValues = true
+			continue
+		}
+
+		if i+1 >= len(msgs) {
+			return nil, errors.New("syntax error")
+		}
+		arg := msgs[i+1].String
+		i++
+
+		switch {
+		case opt == "COUNT":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				return nil, errors.New("value is not an integer or out of range")
+			}
+			if n < 1 {
+				return nil, errors.New("syntax error")
+			}
+			opts.Count = n
+		case opt == "MATCH":
+			if _, err := filepath.Match(arg, ""); err != nil {
+				return nil, fmt.Errorf("invalid MATCH pattern %q", arg)
+			}
+			opts.Match = arg
+		case opt == "TYPE" && cmd == SCAN:
+			opts.Type = strings.ToLower(arg)
+			if !slices.Contains([]string{"string", "list", "hash", "set", "zset", "stream"}, opts.Type) {
+				return nil, fmt.Errorf("unknown type name '%s'", arg)
+			}
+		default:
+			return nil, errors.New("syntax error")
+		}
+	}
+
+	return opts, nil
+}
+
+// Reports whether `s` passes the MATCH filter, if there is one.
+func (o *ScanOptions) matches(s string) bool {
+	if o.Match == "" {
+		return true
+	}
+
+	match, _ := filepath.Match(o.Match, s)
+	return match
+}
+
+// Parses GETEX's options, which are SET's expiry options plus PERSIST. At
+// most one may be given; a zero expiry without `persist` leaves the TTL be.
+func parseGETEXOptions(msgs []*resp.Message) (expiry time.Time, persist bool, err error) {
+	if len(msgs) == 0 {
+		return time.Time{}, false, nil
+	}
+
+	opt := strings.ToUpper(msgs[0].String)
+	switch {
+	case opt == "PERSIST" && len(msgs) == 1:
+		return time.Time{}, true, nil
+	case (opt == "EX" || opt == "PX" || opt == "EXAT" || opt == "PXAT") && len(msgs) == 2:
+		expiry, err = parseSETOptionWithArg(opt, msgs[1].String)
+		if err != nil {
+			return time.Time{}, false, errors.New("value is not an integer or out of range")
+		}
+		if arg, _ := strconv.ParseInt(msgs[1].String, 10, 64); arg <= 0 {
+			return time.Time{}, false, errors.New("invalid expire time in 'getex' command")
+		}
+		return expiry, false, nil
+	default:
+		return time.Time{}, false, errors.New("syntax error")
+	}
+}
+
+// Parses a float for INCRBYFLOAT, refusing what a long double couldn't hold:
+// the infinities, and magnitudes so large or so small they'd overflow to an
+// infinity or underflow to zero.
+func parseLongDouble(raw string) (*big.Float, bool) {
+	f, _, err := big.ParseFloat(raw, 10, longDoublePrec, big.ToNearestEven)
+	if err != nil || !inLongDoubleRange(f) {
+		return nil, false
+	}
+
+	return f, true
+}
+
+// Reports whether `f` is zero or within the finite range of a long double,
+// which also keeps INCRBYFLOAT's fixed point replies to a few KiB at most.
+func inLongDoubleRange(f *big.Float) bool {
+	if f.IsInf() {
+		return false
+	}
+	if f.Sign() == 0 {
+		return true
+	}
+
+	exp := f.MantExp(nil)
+	return exp >= longDoubleMinExp && exp <= longDoubleMaxExp
+}
+
+// Parses a stored string as a 64-bit integer the way INCR and friends do. It
+// has to be in canonical form, so no `+` sign, spaces or leading zeros.
+func parseStoredInt(raw string) (int64, bool) {
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != raw {
+		return 0, false
+	}
+
+	return v, true
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"math"
+	"testing"
+
+	"github.com/ev-the-dev/redis-go-clone/store"
+)
+
+func TestParseStreamRangeID(t *testing.T) {
+	tests := []struct {
+		raw     string
+		isStart bool
+		want    store.StreamID
+		wantErr bool
+	}{
+		{raw: "-", isStart: true, want: store.MinStreamID},
+		{raw: "+", isStart: false, want: store.MaxStreamID},
+		// `-` and `+` mean the same thing regardless of which bound they are
+		{raw: "-", isStart: false, want: store.MinStreamID},
+		{raw: "+", isStart: true, want: store.MaxStreamID},
+
+		{raw: "5-3", isStart: true, want: store.StreamID{Ms: 5, Seq: 3}},
+		{raw: "5-3", isStart: false, want: store.StreamID{Ms: 5, Seq: 3}},
+
+		// Incomplete IDs
+		{raw: "5", isStart: true, want: store.StreamID{Ms: 5, Seq: 0}},
+		{raw: "5", isStart: false, want: store.StreamID{Ms: 5, Seq: math.MaxUint64}},
+
+		// Exclusive IDs
+		{raw: "(5-3", isStart: true, want: store.StreamID{Ms: 5, Seq: 4}},
+		{raw: "(5-3", isStart: false, want: store.StreamID{Ms: 5, Seq: 2}},
+		{raw: "(5-0", isStart: false, want: store.StreamID{Ms: 4, Seq: math.MaxUint64}},
+		{raw: "(5-18446744073709551615", isStart: true, want: store.StreamID{Ms: 6, Seq: 0}},
+		{raw: "(5", isStart: true, want: store.StreamID{Ms: 5, Seq: 1}},
+		{raw: "(5", isStart: false, want: store.StreamID{Ms: 5, Seq: math.MaxUint64 - 1}},
+		{raw: "(0-0", isStart: false, wantErr: true},
+		{raw: "(18446744073709551615-18446744073709551615", isStart: true, wantErr: true},
+
+		// `-` and `+` can't be exclusive
+		{raw: "(-", isStart: true, wantErr: true},
+		{raw: "(+", isStart: false, wantErr: true},
+
+		{raw: "", isStart: true, wantErr: true},
+		{raw: "(", isStart: true, wantErr: true},
+		{raw: "abc", isStart: true, wantErr: true},
+		{raw: "5-", isStart: true, wantErr: true},
+		{raw: "5-abc", isStart: false, wantErr: true},
+		{raw: "5-*", isStart: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseStreamRangeID(tt.raw, tt.isStart)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseStreamRangeID(%q, %t) = %v, want error", tt.raw, tt.isStart, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseStreamRangeID(%q, %t) = (%v, %v), want %v", tt.raw, tt.isStart, got, err, tt.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"github.com/ev-the-dev/redis-go-clone/config"
@@ -15,6 +16,7 @@ import (
 
 type Server struct {
 	blockingManager *BlockingManager
+	clientIDs       atomic.Int64
 	config          *config.Config
 	store           *store.Store
 }
@@ -47,6 +49,8 @@ func (s *Server) Start() {
 
 	fmt.Println("Listening on port: 6379")
 
+	go s.store.RunActiveExpiry(100 * time.Millisecond)
+
 	for {
 		conn, err := l.Accept()
 		if err != nil {
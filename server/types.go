@@ -14,18 +14,161 @@ const (
 type CmdName string
 
 const (
-	BLPOP  CmdName = "BLPOP"
-	CONFIG CmdName = "CONFIG"
-	ECHO   CmdName = "ECHO"
-	GET    CmdName = "GET"
-	KEYS   CmdName = "KEYS"
-	LLEN   CmdName = "LLEN"
-	LPOP   CmdName = "LPOP"
-	LPUSH  CmdName = "LPUSH"
-	LRANGE CmdName = "LRANGE"
-	PING   CmdName = "PING"
-	RPUSH  CmdName = "RPUSH"
-	SET    CmdName = "SET"
-	TYPE   CmdName = "TYPE"
-	XADD   CmdName = "XADD"
+	APPEND           CmdName = "APPEND"
+	BLMOVE           CmdName = "BLMOVE"
+	BLMPOP           CmdName = "BLMPOP"
+	BLPOP            CmdName = "BLPOP"
+	BRPOP            CmdName = "BRPOP"
+	BRPOPLPUSH       CmdName = "BRPOPLPUSH"
+	BZMPOP           CmdName = "BZMPOP"
+	BZPOPMAX         CmdName = "BZPOPMAX"
+	BZPOPMIN         CmdName = "BZPOPMIN"
+	CONFIG           CmdName = "CONFIG"
+	COPY             CmdName = "COPY"
+	DBSIZE           CmdName = "DBSIZE"
+	DECR             CmdName = "DECR"
+	DECRBY           CmdName = "DECRBY"
+	DEL              CmdName = "DEL"
+	ECHO             CmdName = "ECHO"
+	EXISTS           CmdName = "EXISTS"
+	EXPIRE           CmdName = "EXPIRE"
+	EXPIREAT         CmdName = "EXPIREAT"
+	EXPIRETIME       CmdName = "EXPIRETIME"
+	FLUSHALL         CmdName = "FLUSHALL"
+	FLUSHDB          CmdName = "FLUSHDB"
+	GET              CmdName = "GET"
+	GETDEL           CmdName = "GETDEL"
+	GETEX            CmdName = "GETEX"
+	GETRANGE         CmdName = "GETRANGE"
+	HDEL             CmdName = "HDEL"
+	HELLO            CmdName = "HELLO"
+	HEXISTS          CmdName = "HEXISTS"
+	HEXPIRE          CmdName = "HEXPIRE"
+	HEXPIREAT        CmdName = "HEXPIREAT"
+	HEXPIRETIME      CmdName = "HEXPIRETIME"
+	HGET             CmdName = "HGET"
+	HGETALL          CmdName = "HGETALL"
+	HINCRBY          CmdName = "HINCRBY"
+	HINCRBYFLOAT     CmdName = "HINCRBYFLOAT"
+	HKEYS            CmdName = "HKEYS"
+	HLEN             CmdName = "HLEN"
+	HMGET            CmdName = "HMGET"
+	HPERSIST         CmdName = "HPERSIST"
+	HPEXPIRE         CmdName = "HPEXPIRE"
+	HPEXPIREAT       CmdName = "HPEXPIREAT"
+	HPEXPIRETIME     CmdName = "HPEXPIRETIME"
+	HPTTL            CmdName = "HPTTL"
+	HRANDFIELD       CmdName = "HRANDFIELD"
+	HSCAN            CmdName = "HSCAN"
+	HSET             CmdName = "HSET"
+	HSETNX           CmdName = "HSETNX"
+	HSTRLEN          CmdName = "HSTRLEN"
+	HTTL             CmdName = "HTTL"
+	HVALS            CmdName = "HVALS"
+	INCR             CmdName = "INCR"
+	INCRBY           CmdName = "INCRBY"
+	INCRBYFLOAT      CmdName = "INCRBYFLOAT"
+	INFO             CmdName = "INFO"
+	KEYS             CmdName = "KEYS"
+	LINDEX           CmdName = "LINDEX"
+	LINSERT          CmdName = "LINSERT"
+	LLEN             CmdName = "LLEN"
+	LMOVE            CmdName = "LMOVE"
+	LMPOP            CmdName = "LMPOP"
+	LPOP             CmdName = "LPOP"
+	LPOS             CmdName = "LPOS"
+	LPUSH            CmdName = "LPUSH"
+	LPUSHX           CmdName = "LPUSHX"
+	LRANGE           CmdName = "LRANGE"
+	LREM             CmdName = "LREM"
+	LSET             CmdName = "LSET"
+	LTRIM            CmdName = "LTRIM"
+	MGET             CmdName = "MGET"
+	MSET             CmdName = "MSET"
+	MSETNX           CmdName = "MSETNX"
+	PERSIST          CmdName = "PERSIST"
+	PEXPIRE          CmdName = "PEXPIRE"
+	PEXPIREAT        CmdName = "PEXPIREAT"
+	PEXPIRETIME      CmdName = "PEXPIRETIME"
+	PING             CmdName = "PING"
+	PSETEX           CmdName = "PSETEX"
+	PTTL             CmdName = "PTTL"
+	RANDOMKEY        CmdName = "RANDOMKEY"
+	RENAME           CmdName = "RENAME"
+	RENAMENX         CmdName = "RENAMENX"
+	RPOP             CmdName = "RPOP"
+	RPUSH            CmdName = "RPUSH"
+	RPUSHX           CmdName = "RPUSHX"
+	SADD             CmdName = "SADD"
+	SCAN             CmdName = "SCAN"
+	SCARD            CmdName = "SCARD"
+	SDIFF            CmdName = "SDIFF"
+	SDIFFSTORE       CmdName = "SDIFFSTORE"
+	SET              CmdName = "SET"
+	SETEX            CmdName = "SETEX"
+	SETNX            CmdName = "SETNX"
+	SETRANGE         CmdName = "SETRANGE"
+	SINTER           CmdName = "SINTER"
+	SINTERCARD       CmdName = "SINTERCARD"
+	SINTERSTORE      CmdName = "SINTERSTORE"
+	SISMEMBER        CmdName = "SISMEMBER"
+	SMEMBERS         CmdName = "SMEMBERS"
+	SMISMEMBER       CmdName = "SMISMEMBER"
+	SMOVE            CmdName = "SMOVE"
+	SPOP             CmdName = "SPOP"
+	SRANDMEMBER      CmdName = "SRANDMEMBER"
+	SREM             CmdName = "SREM"
+	SSCAN            CmdName = "SSCAN"
+	STRLEN           CmdName = "STRLEN"
+	SUNION           CmdName = "SUNION"
+	SUNIONSTORE      CmdName = "SUNIONSTORE"
+	TOUCH            CmdName = "TOUCH"
+	TTL              CmdName = "TTL"
+	TYPE             CmdName = "TYPE"
+	UNLINK           CmdName = "UNLINK"
+	XACK             CmdName = "XACK"
+	XADD             CmdName = "XADD"
+	XAUTOCLAIM       CmdName = "XAUTOCLAIM"
+	XCLAIM           CmdName = "XCLAIM"
+	XDEL             CmdName = "XDEL"
+	XGROUP           CmdName = "XGROUP"
+	XINFO            CmdName = "XINFO"
+	XLEN             CmdName = "XLEN"
+	XPENDING         CmdName = "XPENDING"
+	XRANGE           CmdName = "XRANGE"
+	XREAD            CmdName = "XREAD"
+	XREADGROUP       CmdName = "XREADGROUP"
+	XREVRANGE        CmdName = "XREVRANGE"
+	XTRIM            CmdName = "XTRIM"
+	ZADD             CmdName = "ZADD"
+	ZCARD            CmdName = "ZCARD"
+	ZCOUNT           CmdName = "ZCOUNT"
+	ZINCRBY          CmdName = "ZINCRBY"
+	ZMPOP            CmdName = "ZMPOP"
+	ZMSCORE          CmdName = "ZMSCORE"
+	ZPOPMAX          CmdName = "ZPOPMAX"
+	ZPOPMIN          CmdName = "ZPOPMIN"
+	ZRANGE           CmdName = "ZRANGE"
+	ZRANGESTORE      CmdName = "ZRANGESTORE"
+	ZRANK            CmdName = "ZRANK"
+	ZREM             CmdName = "ZREM"
+	ZREMRANGEBYLEX   CmdName = "ZREMRANGEBYLEX"
+	ZREMRANGEBYRANK  CmdName = "ZREMRANGEBYRANK"
+	ZREMRANGEBYSCORE CmdName = "ZREMRANGEBYSCORE"
+	ZREVRANK         CmdName = "ZREVRANK"
+	ZSCAN            CmdName = "ZSCAN"
+	ZSCORE           CmdName = "ZSCORE"
+)
+
+const (
+	// Mantissa bits INCRBYFLOAT computes with, those of an x87 long double.
+	longDoublePrec = 64
+	// Bounds on the binary exponent, of a mantissa in [0.5, 1), of the
+	// non-zero values a long double holds: its largest finite value and its
+	// smallest subnormal one.
+	longDoubleMaxExp = 16384
+	longDoubleMinExp = -16444
+	// Largest size SETRANGE may grow a string to, matching Redis' default
+	// proto-max-bulk-len.
+	maxStringLength = 512 * 1024 * 1024
 )
@@ -0,0 +1,184 @@
+package store
+
+import "time"
+
+// Gates whether a new expiry replaces the current one, as with the
+// NX/XX/GT/LT flags of the EXPIRE family. A missing expiry counts as an
+// infinite TTL when comparing with GT and LT.
+type ExpireCondition int
+
+const (
+	ExpireAlways ExpireCondition = iota
+	ExpireNX
+	ExpireXX
+	ExpireGT
+	ExpireLT
+)
+
+func (c ExpireCondition) allows(current, next time.Time) bool {
+	switch c {
+	case ExpireNX:
+		return current.IsZero()
+	case ExpireXX:
+		return !current.IsZero()
+	case ExpireGT:
+		return !current.IsZero() && next.After(current)
+	case ExpireLT:
+		return current.IsZero() || next.Before(current)
+	default:
+		return true
+	}
+}
+
+// Sets the expiry of the key when every one of `conds` allows it, deleting
+// the key outright when `at` is already in the past. Reports false when the
+// key doesn't exist or a condition wasn't met.
+func (s *Store) Expire(k string, at time.Time, conds ...ExpireCondition) bool {
+	set := false
+	s.Atomically(func(tx *Tx) {
+		item, exists := tx.Get(k)
+		if !exists {
+			return
+		}
+
+		for _, c := range conds {
+			if !c.allows(item.ExpiresAt, at) {
+				return
+			}
+		}
+
+		set = true
+		if !at.After(tx.now) {
+			s.deleteLocked(k)
+			return
+		}
+
+		item.ExpiresAt = at
+		s.volatileKeys[k] = struct{}{}
+	})
+
+	return set
+}
+
+// Clears the expiry of the key, reporting false when it doesn't exist or
+// had none to begin with.
+func (s *Store) Persist(k string) bool {
+	persisted := false
+	s.Atomically(func(tx *Tx) {
+		item, exists := tx.Get(k)
+		if !exists || item.ExpiresAt.IsZero() {
+			return
+		}
+
+		item.ExpiresAt = time.Time{}
+		delete(s.volatileKeys, k)
+		persisted = true
+	})
+
+	return persisted
+}
+
+// Active expiry samples this many keys at a time, and keeps sampling while
+// more than a quarter of them turn out to have expired, as that suggests
+// plenty more are left to find. A cycle stops once it has used up its share
+// of the interval between cycles, so it can't hog the store's lock.
+const (
+	activeExpireSampleSize    = 20
+	activeExpireStalePercent  = 25
+	activeExpireBudgetPercent = 25
+)
+
+// Counters describing how much data has expired so far
+type ExpiryStats struct {
+	// Keys removed because their TTL passed, whether found by a lookup or by
+	// active expiry
+	ExpiredKeys int64
+	// Active expiry cycles run
+	Cycles int64
+	// Cycles that ran out of time while expired keys were still turning up
+	TimeCapReached int64
+}
+
+func (s *Store) ExpiryStats() ExpiryStats {
+	return ExpiryStats{
+		ExpiredKeys:    s.expiredKeys.Load(),
+		Cycles:         s.expireCycles.Load(),
+		TimeCapReached: s.timeCapReached.Load(),
+	}
+}
+
+// Periodically evicts expired data that nobody has touched since it
+// expired, so it doesn't linger in memory until the next lookup. Blocks
+// forever, so callers are expected to run it on its own goroutine.
+func (s *Store) RunActiveExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	budget := interval * activeExpireBudgetPercent / 100
+	for now := range ticker.C {
+		s.activeExpireKeys(now.Add(budget))
+		s.activeExpireHashFields(now)
+	}
+}
+
+// Runs one active expiry cycle over the keys with a TTL, giving up at
+// `deadline`. The lock is released between samples so clients get a look in.
+func (s *Store) activeExpireKeys(deadline time.Time) {
+	s.expireCycles.Add(1)
+
+	for {
+		sampled, expired := s.sampleExpiredKeys(time.Now())
+		if sampled == 0 || expired*100 <= sampled*activeExpireStalePercent {
+			return
+		}
+
+		if !time.Now().Before(deadline) {
+			s.timeCapReached.Add(1)
+			return
+		}
+	}
+}
+
+// Checks up to activeExpireSampleSize keys with a TTL, deleting the expired
+// ones, and reports how many were checked and how many deleted.
+func (s *Store) sampleExpiredKeys(now time.Time) (sampled, expired int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Map iteration order is randomized, which gives us the sampling
+	for k := range s.volatileKeys {
+		if sampled >= activeExpireSampleSize {
+			break
+		}
+		sampled++
+
+		item, exists := s.data[k]
+		if !exists || item.ExpiresAt.IsZero() {
+			delete(s.volatileKeys, k)
+			continue
+		}
+
+		if now.After(item.ExpiresAt) {
+			s.expireLocked(k)
+			expired++
+		}
+	}
+
+	return sampled, expired
+}
+
+func (s *Store) activeExpireHashFields(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sampled := 0
+	// Map iteration order is randomized, which gives us the sampling
+	for k := range s.volatileHashes {
+		if sampled >= activeExpireSampleSize {
+			return
+		}
+
+		s.expireHashFieldsLocked(k, now)
+		sampled++
+	}
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Keys that expired but are never read again must still go away, while live
+// ones with and without a TTL are left alone.
+func TestActiveExpireKeys(t *testing.T) {
+	s := New()
+	past := time.Now().Add(-time.Second)
+	future := time.Now().Add(time.Hour)
+
+	for i := range 1000 {
+		s.Set("stale:"+strconv.Itoa(i), &Record{Type: StringType, ExpiresAt: past})
+	}
+	for i := range 50 {
+		s.Set("volatile:"+strconv.Itoa(i), &Record{Type: StringType, ExpiresAt: future})
+		s.Set("persistent:"+strconv.Itoa(i), &Record{Type: StringType})
+	}
+
+	if n := len(s.Keys()); n != 100 {
+		t.Fatalf("Keys returned %d keys, want the 100 live ones", n)
+	}
+
+	// A cycle stops once few of the keys it samples have expired, so the
+	// last stragglers can take a few more.
+	cycles := 0
+	for ; len(s.data) > 100; cycles++ {
+		if cycles == 100 {
+			t.Fatalf("%d keys left after %d active expiry cycles, want 100", len(s.data), cycles)
+		}
+		s.activeExpireKeys(time.Now().Add(time.Minute))
+	}
+
+	if n := len(s.volatileKeys); n != 50 {
+		t.Fatalf("%d keys tracked as volatile, want 50", n)
+	}
+
+	stats := s.ExpiryStats()
+	if stats.ExpiredKeys != 1000 || stats.Cycles != int64(cycles) || stats.TimeCapReached != 0 {
+		t.Fatalf("unexpected stats after %d cycles: %+v", cycles, stats)
+	}
+}
+
+// Once a cycle's time is up it stops, even with expired keys still left.
+func TestActiveExpireKeysTimeCap(t *testing.T) {
+	s := New()
+	past := time.Now().Add(-time.Second)
+	for i := range 1000 {
+		s.Set(strconv.Itoa(i), &Record{Type: StringType, ExpiresAt: past})
+	}
+
+	s.activeExpireKeys(time.Now())
+
+	if n := len(s.data); n != 1000-activeExpireSampleSize {
+		t.Fatalf("%d keys left, want only a single sample's worth expired", n)
+	}
+
+	if stats := s.ExpiryStats(); stats.TimeCapReached != 1 {
+		t.Fatalf("expected the time cap to be counted, got %+v", stats)
+	}
+}
+
+func TestPersistStopsTracking(t *testing.T) {
+	s := New()
+	s.Set("k", &Record{Type: StringType, String: "v"})
+	if !s.Expire("k", time.Now().Add(time.Hour)) {
+		t.Fatal("Expire on an existing key reported false")
+	}
+
+	if _, tracked := s.volatileKeys["k"]; !tracked {
+		t.Fatal("key with a TTL isn't tracked as volatile")
+	}
+
+	if !s.Persist("k") {
+		t.Fatal("Persist on a key with a TTL reported false")
+	}
+
+	if _, tracked := s.volatileKeys["k"]; tracked {
+		t.Fatal("persisted key is still tracked as volatile")
+	}
+}
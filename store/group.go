@@ -0,0 +1,508 @@
+package store
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrGroupExists = errors.New("consumer group already exists")
+)
+
+// ConsumerGroup tracks, for a single stream, the last entry delivered to the
+// group as well as every entry that was delivered to one of its consumers
+// but not yet acknowledged, a.k.a. the pending entries list (PEL).
+type ConsumerGroup struct {
+	Name   string
+	LastID StreamID
+	// The logical "read counter" of the group, or -1 when unknown, e.g. after
+	// the group was created at or moved to an arbitrary ID.
+	EntriesRead int64
+	consumers   map[string]*Consumer
+
+	// Ordered by ID, with an index for direct lookups. Entries are mostly
+	// delivered in ID order so inserting into the slice is usually an append.
+	pending    []*PendingEntry
+	pendingIdx map[StreamID]*PendingEntry
+}
+
+type Consumer struct {
+	Name       string
+	ActiveTime time.Time
+	SeenTime   time.Time
+	pending    int
+}
+
+func (c *Consumer) PendingCount() int {
+	return c.pending
+}
+
+type PendingEntry struct {
+	ID            StreamID
+	Consumer      *Consumer
+	DeliveryCount int64
+	DeliveryTime  time.Time
+}
+
+func (pe *PendingEntry) Idle() time.Duration {
+	return max(time.Since(pe.DeliveryTime), 0)
+}
+
+// ClaimOptions mirrors the optional arguments of XCLAIM.
+type ClaimOptions struct {
+	Force      bool
+	Idle       time.Duration
+	JustID     bool
+	LastID     *StreamID
+	RetryCount *int64
+	Time       time.Time
+}
+
+func (s *Stream) CreateGroup(name string, lastID StreamID) (*ConsumerGroup, error) {
+	if s.groups == nil {
+		s.groups = make(map[string]*ConsumerGroup)
+	}
+
+	if _, exists := s.groups[name]; exists {
+		return nil, ErrGroupExists
+	}
+
+	g := &ConsumerGroup{
+		Name:        name,
+		LastID:      lastID,
+		EntriesRead: s.entriesReadAt(lastID),
+		consumers:   make(map[string]*Consumer),
+		pending:     make([]*PendingEntry, 0),
+		pendingIdx:  make(map[StreamID]*PendingEntry),
+	}
+	s.groups[name] = g
+
+	return g, nil
+}
+
+func (s *Stream) DestroyGroup(name string) bool {
+	if _, exists := s.groups[name]; !exists {
+		return false
+	}
+
+	delete(s.groups, name)
+	return true
+}
+
+func (s *Stream) Group(name string) (*ConsumerGroup, bool) {
+	g, exists := s.groups[name]
+	return g, exists
+}
+
+// Groups returns the consumer groups of the stream sorted by name.
+func (s *Stream) Groups() []*ConsumerGroup {
+	groups := make([]*ConsumerGroup, 0, len(s.groups))
+	for _, g := range s.groups {
+		groups = append(groups, g)
+	}
+
+	slices.SortFunc(groups, func(a, b *ConsumerGroup) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return groups
+}
+
+func (g *ConsumerGroup) Consumer(name string) (*Consumer, bool) {
+	c, exists := g.consumers[name]
+	return c, exists
+}
+
+// Consumers returns the consumers of the group sorted by name.
+func (g *ConsumerGroup) Consumers() []*Consumer {
+	consumers := make([]*Consumer, 0, len(g.consumers))
+	for _, c := range g.consumers {
+		consumers = append(consumers, c)
+	}
+
+	slices.SortFunc(consumers, func(a, b *Consumer) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return consumers
+}
+
+// CreateConsumer returns the consumer named `name`, creating it first if
+// needed. The boolean reports whether the consumer was created.
+func (g *ConsumerGroup) CreateConsumer(name string) (*Consumer, bool) {
+	if c, exists := g.consumers[name]; exists {
+		return c, false
+	}
+
+	c := &Consumer{
+		Name:     name,
+		SeenTime: time.Now(),
+	}
+	g.consumers[name] = c
+
+	return c, true
+}
+
+// DeleteConsumer removes the consumer along with its pending entries,
+// returning how many pending entries it had.
+func (g *ConsumerGroup) DeleteConsumer(name string) (int, bool) {
+	c, exists := g.consumers[name]
+	if !exists {
+		return 0, false
+	}
+
+	removed := c.pending
+	g.pending = slices.DeleteFunc(g.pending, func(pe *PendingEntry) bool {
+		if pe.Consumer != c {
+			return false
+		}
+		delete(g.pendingIdx, pe.ID)
+		return true
+	})
+	delete(g.consumers, name)
+
+	return removed, true
+}
+
+// ReadNew delivers to `c` up to `count` (0 being unlimited) entries that were
+// never delivered to the group, i.e. XREADGROUP's `>` ID. Unless `noAck` is
+// set the entries are added to the PEL.
+func (g *ConsumerGroup) ReadNew(s *Stream, c *Consumer, count int, noAck bool) []*StreamEntry {
+	now := time.Now()
+	c.SeenTime = now
+
+	start, ok := g.LastID.Next()
+	if !ok {
+		return nil
+	}
+
+	entries := s.Range(start, MaxStreamID, count, false)
+	if len(entries) == 0 {
+		return entries
+	}
+
+	c.ActiveTime = now
+	for _, e := range entries {
+		g.LastID = e.ID
+		if g.EntriesRead >= 0 {
+			g.EntriesRead++
+		}
+		if noAck {
+			continue
+		}
+
+		// The group's last ID may have been moved backwards by SETID, in which
+		// case the entry could already be pending.
+		if pe, exists := g.pendingIdx[e.ID]; exists {
+			g.reassign(pe, c)
+			pe.DeliveryCount = 1
+			pe.DeliveryTime = now
+			continue
+		}
+
+		g.addPending(&PendingEntry{
+			ID:            e.ID,
+			Consumer:      c,
+			DeliveryCount: 1,
+			DeliveryTime:  now,
+		})
+	}
+
+	if g.EntriesRead < 0 {
+		g.EntriesRead = s.entriesReadAt(g.LastID)
+	}
+
+	return entries
+}
+
+// Lag returns how many entries of the stream have yet to be delivered to the
+// group. False is returned when that can't be determined.
+func (g *ConsumerGroup) Lag(s *Stream) (int64, bool) {
+	if g.LastID.Compare(s.lastID) >= 0 {
+		return 0, true
+	}
+
+	if g.EntriesRead < 0 {
+		return 0, false
+	}
+
+	return int64(s.entriesAdded) - g.EntriesRead, true
+}
+
+// ReadPending re-delivers up to `count` (0 being unlimited) of the entries
+// pending for `c` whose IDs are greater than `after`. Entries that no longer
+// exist in the stream are returned with nil Fields.
+func (g *ConsumerGroup) ReadPending(s *Stream, c *Consumer, after StreamID, count int) []*StreamEntry {
+	now := time.Now()
+	c.SeenTime = now
+
+	entries := make([]*StreamEntry, 0)
+	for _, pe := range g.pending[g.pendingIndex(after):] {
+		if count > 0 && len(entries) >= count {
+			break
+		}
+
+		if pe.Consumer != c || pe.ID.Compare(after) <= 0 {
+			continue
+		}
+
+		e, exists := s.lookup(pe.ID)
+		if !exists {
+			entries = append(entries, &StreamEntry{ID: pe.ID})
+			continue
+		}
+
+		pe.DeliveryCount++
+		pe.DeliveryTime = now
+		entries = append(entries, e)
+	}
+
+	return entries
+}
+
+// Ack removes the given IDs from the PEL, returning how many were pending.
+func (g *ConsumerGroup) Ack(ids []StreamID) int {
+	acked := 0
+	for _, id := range ids {
+		if g.removePending(id) {
+			acked++
+		}
+	}
+
+	return acked
+}
+
+func (g *ConsumerGroup) PendingCount() int {
+	return len(g.pending)
+}
+
+// Pending returns up to `count` PEL entries within the inclusive [start, end]
+// interval, optionally filtered down to a single consumer and to entries
+// that have been idle for at least `minIdle`.
+func (g *ConsumerGroup) Pending(start, end StreamID, count int, consumer string, minIdle time.Duration) []*PendingEntry {
+	result := make([]*PendingEntry, 0)
+	for _, pe := range g.pending[g.pendingIndex(start):] {
+		if len(result) >= count || pe.ID.Compare(end) > 0 {
+			break
+		}
+
+		if consumer != "" && pe.Consumer.Name != consumer {
+			continue
+		}
+
+		if pe.Idle() < minIdle {
+			continue
+		}
+
+		result = append(result, pe)
+	}
+
+	return result
+}
+
+// Claim transfers ownership of the given pending IDs to `c` when they have
+// been idle for at least `minIdle`. Pending IDs no longer in the stream are
+// dropped from the PEL instead. The claimed entries are returned.
+func (g *ConsumerGroup) Claim(s *Stream, c *Consumer, ids []StreamID, minIdle time.Duration, opts *ClaimOptions) []*StreamEntry {
+	now := time.Now()
+	c.SeenTime = now
+
+	deliveryTime := now
+	if opts.Idle > 0 {
+		deliveryTime = now.Add(-opts.Idle)
+	} else if !opts.Time.IsZero() {
+		deliveryTime = opts.Time
+	}
+
+	if opts.LastID != nil && opts.LastID.Compare(g.LastID) > 0 {
+		g.LastID = *opts.LastID
+	}
+
+	claimed := make([]*StreamEntry, 0, len(ids))
+	for _, id := range ids {
+		e, inStream := s.lookup(id)
+
+		pe, exists := g.pendingIdx[id]
+		if !exists {
+			if !opts.Force || !inStream {
+				continue
+			}
+
+			pe = &PendingEntry{
+				ID:           id,
+				Consumer:     c,
+				DeliveryTime: now,
+			}
+			g.addPending(pe)
+		} else if minIdle > 0 && pe.Idle() < minIdle {
+			continue
+		}
+
+		if !inStream {
+			g.removePending(id)
+			continue
+		}
+
+		g.reassign(pe, c)
+		pe.DeliveryTime = deliveryTime
+		if opts.RetryCount != nil {
+			pe.DeliveryCount = *opts.RetryCount
+		} else if !opts.JustID {
+			pe.DeliveryCount++
+		}
+
+		claimed = append(claimed, e)
+	}
+
+	if len(claimed) > 0 {
+		c.ActiveTime = now
+	}
+
+	return claimed
+}
+
+// How many PEL entries AutoClaim looks at, at most, per entry it may claim.
+// COUNT can't be more than math.MaxInt divided by this.
+const AutoClaimAttemptsFactor = 10
+
+// AutoClaim scans the PEL from `start` and claims up to `count` entries idle
+// for at least `minIdle`, like a Claim over a SCAN-like cursor. It returns
+// the cursor to continue from (`0-0` once the whole PEL was scanned), the
+// claimed entries and the IDs dropped because they were deleted from the
+// stream.
+func (g *ConsumerGroup) AutoClaim(s *Stream, c *Consumer, minIdle time.Duration, start StreamID, count int, justID bool) (StreamID, []*StreamEntry, []StreamID) {
+	now := time.Now()
+	c.SeenTime = now
+
+	// Not preallocated from `count`, which comes straight from the client
+	var claimed []*StreamEntry
+	deleted := make([]StreamID, 0)
+
+	// Bound the work done when most of the PEL isn't idle long enough
+	attempts := count * AutoClaimAttemptsFactor
+
+	i := g.pendingIndex(start)
+	for i < len(g.pending) && len(claimed) < count && attempts > 0 {
+		attempts--
+		pe := g.pending[i]
+
+		if pe.Idle() < minIdle {
+			i++
+			continue
+		}
+
+		e, inStream := s.lookup(pe.ID)
+		if !inStream {
+			deleted = append(deleted, pe.ID)
+			// Removal shifts the next entry into index i
+			g.removePending(pe.ID)
+			continue
+		}
+
+		g.reassign(pe, c)
+		pe.DeliveryTime = now
+		if !justID {
+			pe.DeliveryCount++
+		}
+
+		claimed = append(claimed, e)
+		i++
+	}
+
+	if len(claimed) > 0 {
+		c.ActiveTime = now
+	}
+
+	next := MinStreamID
+	if i < len(g.pending) {
+		next = g.pending[i].ID
+	}
+
+	return next, claimed, deleted
+}
+
+// SetID moves the last delivered ID of the group, which is how a group can
+// be made to re-deliver (or skip) entries.
+func (g *ConsumerGroup) SetID(s *Stream, id StreamID) {
+	g.LastID = id
+	g.EntriesRead = s.entriesReadAt(id)
+}
+
+// Returns the read counter of a group positioned at `id` when it can be
+// determined without a scan: before the first entry ever added nothing has
+// been read, while at the last ID everything has.
+func (s *Stream) entriesReadAt(id StreamID) int64 {
+	switch {
+	case s.entriesAdded == 0 || id.Compare(s.lastID) >= 0:
+		return int64(s.entriesAdded)
+	case id == MinStreamID && s.maxDeletedID == MinStreamID:
+		return 0
+	default:
+		return -1
+	}
+}
+
+func (g *ConsumerGroup) addPending(pe *PendingEntry) {
+	idx := g.pendingIndex(pe.ID)
+	g.pending = slices.Insert(g.pending, idx, pe)
+	g.pendingIdx[pe.ID] = pe
+	pe.Consumer.pending++
+}
+
+// Copies the group for Stream.Clone, with the copied pending entries
+// pointing at the copied consumers.
+func (g *ConsumerGroup) clone() *ConsumerGroup {
+	c := &ConsumerGroup{
+		Name:        g.Name,
+		LastID:      g.LastID,
+		EntriesRead: g.EntriesRead,
+		consumers:   make(map[string]*Consumer, len(g.consumers)),
+		pending:     make([]*PendingEntry, 0, len(g.pending)),
+		pendingIdx:  make(map[StreamID]*PendingEntry, len(g.pendingIdx)),
+	}
+
+	for name, consumer := range g.consumers {
+		copied := *consumer
+		c.consumers[name] = &copied
+	}
+
+	for _, pe := range g.pending {
+		copied := *pe
+		copied.Consumer = c.consumers[pe.Consumer.Name]
+		c.pending = append(c.pending, &copied)
+		c.pendingIdx[copied.ID] = &copied
+	}
+
+	return c
+}
+
+func (g *ConsumerGroup) removePending(id StreamID) bool {
+	pe, exists := g.pendingIdx[id]
+	if !exists {
+		return false
+	}
+
+	idx := g.pendingIndex(id)
+	g.pending = slices.Delete(g.pending, idx, idx+1)
+	delete(g.pendingIdx, id)
+	pe.Consumer.pending--
+
+	return true
+}
+
+func (g *ConsumerGroup) reassign(pe *PendingEntry, c *Consumer) {
+	pe.Consumer.pending--
+	pe.Consumer = c
+	c.pending++
+}
+
+// Returns the index of the first PEL entry whose ID is >= `id`.
+func (g *ConsumerGroup) pendingIndex(id StreamID) int {
+	idx, _ := slices.BinarySearchFunc(g.pending, id, func(pe *PendingEntry, target StreamID) int {
+		return pe.ID.Compare(target)
+	})
+
+	return idx
+}
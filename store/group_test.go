@@ -0,0 +1,202 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Returns a stream holding the entries `1-0` up to `n-0` and a group on it
+// that has yet to read any of them.
+func newGroupStream(t *testing.T, n int) (*Stream, *ConsumerGroup) {
+	t.Helper()
+
+	s := NewStream()
+	for i := 1; i <= n; i++ {
+		if _, err := s.Insert(StreamID{Ms: uint64(i)}.String(), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g, err := s.CreateGroup("g", MinStreamID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s, g
+}
+
+// Lists the PEL as `id:consumer:deliveries`, in order.
+func pelString(g *ConsumerGroup) []string {
+	var pel []string
+	for _, pe := range g.Pending(MinStreamID, MaxStreamID, math.MaxInt, "", 0) {
+		pel = append(pel, fmt.Sprintf("%s:%s:%d", pe.ID, pe.Consumer.Name, pe.DeliveryCount))
+	}
+
+	return pel
+}
+
+func entryIDs(entries []*StreamEntry) []string {
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ids = append(ids, e.ID.String())
+	}
+
+	return ids
+}
+
+// Makes every pending entry look delivered an hour ago.
+func idleAll(g *ConsumerGroup) {
+	for _, pe := range g.pending {
+		pe.DeliveryTime = time.Now().Add(-time.Hour)
+	}
+}
+
+func TestGroupPendingOrderAndDeliveryCounts(t *testing.T) {
+	s, g := newGroupStream(t, 5)
+	a, _ := g.CreateConsumer("a")
+	b, _ := g.CreateConsumer("b")
+
+	if got := entryIDs(g.ReadNew(s, a, 2, false)); !slices.Equal(got, []string{"1-0", "2-0"}) {
+		t.Fatalf("a read %v", got)
+	}
+	if got := entryIDs(g.ReadNew(s, b, 0, false)); !slices.Equal(got, []string{"3-0", "4-0", "5-0"}) {
+		t.Fatalf("b read %v", got)
+	}
+
+	want := []string{"1-0:a:1", "2-0:a:1", "3-0:b:1", "4-0:b:1", "5-0:b:1"}
+	if got := pelString(g); !slices.Equal(got, want) {
+		t.Fatalf("PEL after reading: got %v, want %v", got, want)
+	}
+
+	// Moving the group back re-delivers an entry pending for another
+	// consumer, which takes it over with a fresh count
+	g.SetID(s, StreamID{Ms: 2})
+	g.ReadNew(s, a, 1, false)
+
+	// Re-reading its own history counts another delivery
+	if got := entryIDs(g.ReadPending(s, a, MinStreamID, 0)); !slices.Equal(got, []string{"1-0", "2-0", "3-0"}) {
+		t.Fatalf("a's history: %v", got)
+	}
+
+	want = []string{"1-0:a:2", "2-0:a:2", "3-0:a:2", "4-0:b:1", "5-0:b:1"}
+	if got := pelString(g); !slices.Equal(got, want) {
+		t.Fatalf("PEL after re-reading: got %v, want %v", got, want)
+	}
+
+	if a.PendingCount() != 3 || b.PendingCount() != 2 {
+		t.Fatalf("pending counts: a %d, b %d", a.PendingCount(), b.PendingCount())
+	}
+
+	if acked := g.Ack([]StreamID{{Ms: 2}, {Ms: 99}}); acked != 1 {
+		t.Fatalf("acked %d, want 1", acked)
+	}
+
+	// Deleted entries are still listed in the history, without fields
+	s.Delete(StreamID{Ms: 1})
+	history := g.ReadPending(s, a, MinStreamID, 0)
+	if got := entryIDs(history); !slices.Equal(got, []string{"1-0", "3-0"}) || history[0].Fields != nil {
+		t.Fatalf("a's history after deleting 1-0: %v", got)
+	}
+}
+
+func TestGroupClaimBetweenConsumers(t *testing.T) {
+	s, g := newGroupStream(t, 3)
+	a, _ := g.CreateConsumer("a")
+	b, _ := g.CreateConsumer("b")
+	g.ReadNew(s, a, 0, false)
+
+	// Nothing has been idle long enough
+	if claimed := g.Claim(s, b, []StreamID{{Ms: 1}, {Ms: 2}}, time.Minute, &ClaimOptions{}); len(claimed) != 0 {
+		t.Fatalf("claimed %v before it was idle", entryIDs(claimed))
+	}
+
+	g.pendingIdx[StreamID{Ms: 1}].DeliveryTime = time.Now().Add(-time.Hour)
+	if got := entryIDs(g.Claim(s, b, []StreamID{{Ms: 1}, {Ms: 2}}, time.Minute, &ClaimOptions{})); !slices.Equal(got, []string{"1-0"}) {
+		t.Fatalf("claimed %v, want only the idle 1-0", got)
+	}
+
+	// JUSTID leaves the delivery count alone
+	g.Claim(s, b, []StreamID{{Ms: 2}}, 0, &ClaimOptions{JustID: true})
+
+	want := []string{"1-0:b:2", "2-0:b:1", "3-0:a:1"}
+	if got := pelString(g); !slices.Equal(got, want) {
+		t.Fatalf("PEL after claiming: got %v, want %v", got, want)
+	}
+
+	if a.PendingCount() != 1 || b.PendingCount() != 2 {
+		t.Fatalf("pending counts: a %d, b %d", a.PendingCount(), b.PendingCount())
+	}
+
+	// FORCE claims acknowledged entries, as long as they still exist
+	g.Ack([]StreamID{{Ms: 3}})
+	claimed := g.Claim(s, b, []StreamID{{Ms: 3}, {Ms: 9}}, 0, &ClaimOptions{Force: true})
+	if got := entryIDs(claimed); !slices.Equal(got, []string{"3-0"}) {
+		t.Fatalf("forced claim: %v", got)
+	}
+
+	// Claiming an entry deleted from the stream drops it from the PEL
+	s.Delete(StreamID{Ms: 2})
+	if claimed := g.Claim(s, a, []StreamID{{Ms: 2}}, 0, &ClaimOptions{}); len(claimed) != 0 {
+		t.Fatalf("claimed deleted entry: %v", entryIDs(claimed))
+	}
+
+	want = []string{"1-0:b:2", "3-0:b:1"}
+	if got := pelString(g); !slices.Equal(got, want) {
+		t.Fatalf("PEL after dropping 2-0: got %v, want %v", got, want)
+	}
+}
+
+func TestGroupAutoClaim(t *testing.T) {
+	s, g := newGroupStream(t, 6)
+	a, _ := g.CreateConsumer("a")
+	b, _ := g.CreateConsumer("b")
+	g.ReadNew(s, a, 0, false)
+	idleAll(g)
+
+	s.Delete(StreamID{Ms: 2})
+	s.Delete(StreamID{Ms: 4})
+
+	next, claimed, deleted := g.AutoClaim(s, b, time.Minute, MinStreamID, 2, false)
+	if next != (StreamID{Ms: 4}) || !slices.Equal(entryIDs(claimed), []string{"1-0", "3-0"}) || !slices.Equal(deleted, []StreamID{{Ms: 2}}) {
+		t.Fatalf("first call: next %v, claimed %v, deleted %v", next, entryIDs(claimed), deleted)
+	}
+
+	next, claimed, deleted = g.AutoClaim(s, b, time.Minute, next, 2, false)
+	if next != MinStreamID || !slices.Equal(entryIDs(claimed), []string{"5-0", "6-0"}) || !slices.Equal(deleted, []StreamID{{Ms: 4}}) {
+		t.Fatalf("second call: next %v, claimed %v, deleted %v", next, entryIDs(claimed), deleted)
+	}
+
+	want := []string{"1-0:b:2", "3-0:b:2", "5-0:b:2", "6-0:b:2"}
+	if got := pelString(g); !slices.Equal(got, want) {
+		t.Fatalf("PEL after claiming: got %v, want %v", got, want)
+	}
+
+	if a.PendingCount() != 0 || b.PendingCount() != 4 {
+		t.Fatalf("pending counts: a %d, b %d", a.PendingCount(), b.PendingCount())
+	}
+}
+
+// Entries that aren't idle long enough only use up attempts, of which there
+// are ten per entry that may be claimed.
+func TestGroupAutoClaimBoundsAttempts(t *testing.T) {
+	s, g := newGroupStream(t, 30)
+	a, _ := g.CreateConsumer("a")
+	b, _ := g.CreateConsumer("b")
+	g.ReadNew(s, a, 0, false)
+
+	next, claimed, _ := g.AutoClaim(s, b, time.Hour, MinStreamID, 1, false)
+	if len(claimed) != 0 || next != (StreamID{Ms: 1 + AutoClaimAttemptsFactor}) {
+		t.Fatalf("got next %v after claiming %v", next, entryIDs(claimed))
+	}
+
+	// The largest COUNT XAUTOCLAIM accepts neither overflows nor allocates
+	// for itself
+	idleAll(g)
+	next, claimed, _ = g.AutoClaim(s, b, time.Minute, MinStreamID, math.MaxInt/AutoClaimAttemptsFactor, false)
+	if len(claimed) != 30 || next != MinStreamID {
+		t.Fatalf("claimed %d entries, next %v", len(claimed), next)
+	}
+}
@@ -0,0 +1,197 @@
+package store
+
+import "time"
+
+// Per-field statuses reported by the hash field TTL commands
+const (
+	FieldNotFound        = -2
+	FieldNoExpiry        = -1
+	FieldConditionNotMet = 0
+	FieldExpirySet       = 1
+	FieldPersisted       = 1
+	FieldDeleted         = 2
+)
+
+// Sets the field of the hash to `v`, creating the hash's map on its first
+// field.
+func (r *Record) SetField(field string, v *Record) {
+	if r.Map == nil {
+		r.Map = make(map[string]*Record)
+		r.fields = newKeyIndex()
+	}
+
+	if _, exists := r.Map[field]; !exists {
+		r.fields.add(field)
+	}
+	r.Map[field] = v
+}
+
+// Removes the field from the hash, reporting whether it was there.
+func (r *Record) DeleteField(field string) bool {
+	if _, exists := r.Map[field]; !exists {
+		return false
+	}
+
+	delete(r.Map, field)
+	r.fields.remove(field)
+	return true
+}
+
+// Returns the `count` or so fields following `cursor`, and the cursor to
+// continue from, walking the hash the same way Store.Scan walks the
+// keyspace.
+func (r *Record) ScanFields(cursor uint64, count int) (uint64, []string) {
+	if r.fields == nil {
+		return 0, nil
+	}
+
+	return r.fields.collect(cursor, count)
+}
+
+// Sets the expiry of each of the hash's fields, reporting per field whether
+// it was set, skipped because of `cond`, or the field deleted outright
+// because `at` is already in the past. A missing key reports every field
+// as not found.
+func (s *Store) ExpireHashFields(k string, fields []string, at time.Time, cond ExpireCondition) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	item, err := s.lookupHashLocked(k, now)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]int, len(fields))
+	for i, field := range fields {
+		if item == nil {
+			statuses[i] = FieldNotFound
+			continue
+		}
+
+		v, ok := item.Map[field]
+		if !ok {
+			statuses[i] = FieldNotFound
+			continue
+		}
+
+		if !cond.allows(v.ExpiresAt, at) {
+			statuses[i] = FieldConditionNotMet
+			continue
+		}
+
+		if !at.After(now) {
+			item.DeleteField(field)
+			statuses[i] = FieldDeleted
+			continue
+		}
+
+		v.ExpiresAt = at
+		statuses[i] = FieldExpirySet
+	}
+
+	if item != nil {
+		s.volatileHashes[k] = struct{}{}
+		s.expireHashFieldsLocked(k, now)
+	}
+
+	return statuses, nil
+}
+
+// Clears the expiry of each of the hash's fields, reporting per field
+// whether it was persisted, had no expiry, or doesn't exist.
+func (s *Store) PersistHashFields(k string, fields []string) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	item, err := s.lookupHashLocked(k, now)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]int, len(fields))
+	for i, field := range fields {
+		if item == nil {
+			statuses[i] = FieldNotFound
+			continue
+		}
+
+		v, ok := item.Map[field]
+		switch {
+		case !ok:
+			statuses[i] = FieldNotFound
+		case v.ExpiresAt.IsZero():
+			statuses[i] = FieldNoExpiry
+		default:
+			v.ExpiresAt = time.Time{}
+			statuses[i] = FieldPersisted
+		}
+	}
+
+	if item != nil {
+		s.expireHashFieldsLocked(k, now)
+	}
+
+	return statuses, nil
+}
+
+// Returns the live hash stored at `k` with its expired fields already
+// evicted, nil if there is none, or ErrWrongType.
+func (s *Store) lookupHashLocked(k string, now time.Time) (*Record, error) {
+	item, exists := s.data[k]
+	if !exists {
+		return nil, nil
+	}
+
+	if !item.ExpiresAt.IsZero() && now.After(item.ExpiresAt) {
+		s.expireLocked(k)
+		return nil, nil
+	}
+
+	if item.Type != MapType {
+		return nil, ErrWrongType
+	}
+
+	if _, isVolatile := s.volatileHashes[k]; isVolatile && !s.expireHashFieldsLocked(k, now) {
+		return nil, nil
+	}
+
+	return item, nil
+}
+
+// Evicts the hash's expired fields, stops tracking it once none of its
+// fields are volatile and deletes the key once it has no fields left.
+// Reports whether the key still exists.
+func (s *Store) expireHashFieldsLocked(k string, now time.Time) bool {
+	item, exists := s.data[k]
+	if !exists || item.Type != MapType {
+		delete(s.volatileHashes, k)
+		return exists
+	}
+
+	volatile := 0
+	for field, v := range item.Map {
+		if v.ExpiresAt.IsZero() {
+			continue
+		}
+
+		if !now.Before(v.ExpiresAt) {
+			item.DeleteField(field)
+			continue
+		}
+
+		volatile++
+	}
+
+	if volatile == 0 {
+		delete(s.volatileHashes, k)
+	}
+
+	if len(item.Map) == 0 {
+		s.deleteLocked(k)
+		return false
+	}
+
+	return true
+}
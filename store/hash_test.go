@@ -0,0 +1,121 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func newHash(fields ...string) *Record {
+	rec := &Record{Type: MapType}
+	for _, f := range fields {
+		rec.SetField(f, &Record{Type: StringType, String: f})
+	}
+
+	return rec
+}
+
+func TestExpireHashFieldsStatuses(t *testing.T) {
+	s := New()
+	s.Set("h", newHash("a", "b"))
+
+	statuses, err := s.ExpireHashFields("h", []string{"a", "nope"}, time.Now().Add(time.Hour), ExpireAlways)
+	if err != nil || statuses[0] != FieldExpirySet || statuses[1] != FieldNotFound {
+		t.Fatalf("expire: got (%v, %v)", statuses, err)
+	}
+	if _, tracked := s.volatileHashes["h"]; !tracked {
+		t.Fatal("hash with a field TTL isn't tracked as volatile")
+	}
+
+	statuses, _ = s.ExpireHashFields("h", []string{"a", "b"}, time.Now().Add(time.Minute), ExpireNX)
+	if statuses[0] != FieldConditionNotMet || statuses[1] != FieldExpirySet {
+		t.Fatalf("expire NX: got %v", statuses)
+	}
+
+	statuses, _ = s.PersistHashFields("h", []string{"a", "b", "nope"})
+	if statuses[0] != FieldPersisted || statuses[1] != FieldPersisted || statuses[2] != FieldNotFound {
+		t.Fatalf("persist: got %v", statuses)
+	}
+	if _, tracked := s.volatileHashes["h"]; tracked {
+		t.Fatal("hash without field TTLs is still tracked as volatile")
+	}
+
+	statuses, _ = s.ExpireHashFields("missing", []string{"a"}, time.Now().Add(time.Hour), ExpireAlways)
+	if statuses[0] != FieldNotFound {
+		t.Fatalf("expire on a missing key: got %v", statuses)
+	}
+
+	s.Set("str", &Record{Type: StringType, String: "v"})
+	if _, err := s.ExpireHashFields("str", []string{"a"}, time.Now().Add(time.Hour), ExpireAlways); err != ErrWrongType {
+		t.Fatalf("expire on a string: got %v", err)
+	}
+}
+
+// A TTL in the past deletes the field there and then, and the key with its
+// last field.
+func TestExpireHashFieldsInThePast(t *testing.T) {
+	s := New()
+	s.Set("h", newHash("a", "b"))
+
+	statuses, _ := s.ExpireHashFields("h", []string{"a"}, time.Now().Add(-time.Second), ExpireAlways)
+	if statuses[0] != FieldDeleted {
+		t.Fatalf("expire in the past: got %v", statuses)
+	}
+	if rec, exists := s.Get("h"); !exists || len(rec.Map) != 1 {
+		t.Fatalf("after deleting a field: got (%+v, %t)", rec, exists)
+	}
+
+	s.ExpireHashFields("h", []string{"b"}, time.Now().Add(-time.Second), ExpireAlways)
+	if _, exists := s.data["h"]; exists {
+		t.Fatal("key still exists after deleting its last field")
+	}
+	if _, tracked := s.volatileHashes["h"]; tracked {
+		t.Fatal("deleted key is still tracked as volatile")
+	}
+}
+
+func TestExpiredHashFieldsDeleteKey(t *testing.T) {
+	s := New()
+	s.Set("h", newHash("a", "b"))
+	s.ExpireHashFields("h", []string{"a", "b"}, time.Now().Add(10*time.Millisecond), ExpireAlways)
+
+	if _, exists := s.Get("h"); !exists {
+		t.Fatal("key gone before its fields expired")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	s.activeExpireHashFields(time.Now())
+
+	if _, exists := s.data["h"]; exists {
+		t.Fatal("key still exists after its last field expired")
+	}
+	if _, tracked := s.volatileHashes["h"]; tracked {
+		t.Fatal("deleted key is still tracked as volatile")
+	}
+}
+
+// Replacing a hash with a field TTL by anything else stops it from being
+// tracked, and a hash stored there afterwards doesn't inherit the TTLs.
+func TestHashFieldTrackingFollowsType(t *testing.T) {
+	s := New()
+	s.Set("h", newHash("a"))
+	s.ExpireHashFields("h", []string{"a"}, time.Now().Add(time.Hour), ExpireAlways)
+
+	s.Set("h", &Record{Type: StringType, String: "v"})
+	if _, tracked := s.volatileHashes["h"]; tracked {
+		t.Fatal("string replacing a volatile hash is still tracked as volatile")
+	}
+
+	s.Set("h", newHash("a"))
+	if rec, exists := s.Get("h"); !exists || !rec.Map["a"].ExpiresAt.IsZero() {
+		t.Fatalf("new hash at the key: got (%+v, %t)", rec, exists)
+	}
+
+	// Through a transaction too, as commands write
+	s.ExpireHashFields("h", []string{"a"}, time.Now().Add(time.Hour), ExpireAlways)
+	s.Atomically(func(tx *Tx) {
+		tx.Set("h", &Record{Type: SetType, Set: NewSet("m")})
+	})
+	if _, tracked := s.volatileHashes["h"]; tracked {
+		t.Fatal("set replacing a volatile hash is still tracked as volatile")
+	}
+}
@@ -0,0 +1,168 @@
+package store
+
+import "time"
+
+// Values with more elements than this are taken apart on a background
+// goroutine by Unlink and asynchronous flushes, like Redis's lazyfree does,
+// while smaller ones aren't worth the hand-off.
+const lazyFreeThreshold = 64
+
+// Returns a deep copy of the record, so that changes made to one never show
+// up in the other.
+func (r *Record) Clone() *Record {
+	c := *r
+	switch r.Type {
+	case ArrayType:
+		c.List = r.List.Clone()
+	case MapType:
+		c.Map, c.fields = nil, nil
+		for field, v := range r.Map {
+			c.SetField(field, v.Clone())
+		}
+	case SetType:
+		c.Set = r.Set.Clone()
+	case SortedSetType:
+		c.SortedSet = r.SortedSet.Clone()
+	case StreamType:
+		c.Streams = r.Streams.Clone()
+	}
+
+	return &c
+}
+
+// How many elements the record holds, with anything that isn't a
+// collection counting as one.
+func (r *Record) size() int {
+	switch r.Type {
+	case ArrayType:
+		return r.List.Len()
+	case MapType:
+		return len(r.Map)
+	case SetType:
+		return r.Set.Len()
+	case SortedSetType:
+		return r.SortedSet.Len()
+	case StreamType:
+		return r.Streams.Len()
+	default:
+		return 1
+	}
+}
+
+// Takes apart the collection held by a record that is no longer in the
+// keyspace. The garbage collector reclaims the memory either way, but for a
+// large value this is O(n) work that has no business on a client's path.
+func (r *Record) free() {
+	switch r.Type {
+	case ArrayType:
+		for n := r.List.head; n != nil; {
+			next := n.next
+			*n = listNode{}
+			n = next
+		}
+		*r.List = List{}
+	case MapType:
+		clear(r.Map)
+		r.fields = nil
+	case SetType:
+		clear(r.Set.members)
+		r.Set.index = newKeyIndex()
+	case SortedSetType:
+		clear(r.SortedSet.dict)
+		r.SortedSet.zsl = newSkiplist()
+		r.SortedSet.index = newKeyIndex()
+	case StreamType:
+		*r.Streams = Stream{Root: &StreamNode{}}
+	}
+}
+
+// Removes the keys like Delete, returning how many existed, but leaves
+// taking large values apart to a background goroutine so it returns right
+// away whatever their size.
+func (s *Store) Unlink(keys ...string) int {
+	var large []*Record
+	removed := 0
+	s.Atomically(func(tx *Tx) {
+		for _, k := range keys {
+			rec, exists := tx.Get(k)
+			if !exists {
+				continue
+			}
+
+			tx.s.deleteLocked(k)
+			removed++
+			if rec.size() > lazyFreeThreshold {
+				large = append(large, rec)
+			}
+		}
+	})
+
+	if len(large) > 0 {
+		go func() {
+			for _, rec := range large {
+				rec.free()
+			}
+		}()
+	}
+
+	return removed
+}
+
+// Removes every key. A synchronous flush empties the keyspace in place
+// while holding the lock, whereas an asynchronous one swaps in an empty
+// keyspace and clears the old one on a background goroutine.
+func (s *Store) Flush(async bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !async {
+		clear(s.data)
+		s.index = newKeyIndex()
+		clear(s.volatileHashes)
+		clear(s.volatileKeys)
+		return
+	}
+
+	old := s.data
+	s.data = make(map[string]*Record)
+	s.index = newKeyIndex()
+	s.volatileHashes = make(map[string]struct{})
+	s.volatileKeys = make(map[string]struct{})
+
+	go func() {
+		for _, rec := range old {
+			if rec.size() > lazyFreeThreshold {
+				rec.free()
+			}
+		}
+		clear(old)
+	}()
+}
+
+// Returns the amount of keys, including expired ones nobody has removed
+// yet, as does Redis's DBSIZE.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.data)
+}
+
+// Returns a random live key, removing any expired ones it comes across on
+// the way. Reports false when there are no keys.
+func (s *Store) RandomKey() (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	// Map iteration order is randomized, which gives us the pick
+	for k, item := range s.data {
+		if !item.ExpiresAt.IsZero() && now.After(item.ExpiresAt) {
+			s.expireLocked(k)
+			continue
+		}
+
+		return k, true
+	}
+
+	return "", false
+}
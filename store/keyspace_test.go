@@ -0,0 +1,156 @@
+package store
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Writing to a copy, or to what it was copied from, must never show up in
+// the other one.
+func TestCopyIsIndependent(t *testing.T) {
+	s := New()
+
+	stream := NewStream()
+	for range 3 {
+		if _, err := stream.Insert("*", []*Record{{String: "f"}, {String: "v"}}); err != nil {
+			t.Fatalf("insert: %v", err)
+		}
+	}
+	g, _ := stream.CreateGroup("g", StreamID{})
+	c, _ := g.CreateConsumer("alice")
+	g.ReadNew(stream, c, 2, false)
+
+	zset := NewSortedSet()
+	zset.Add("a", 1, nil)
+
+	s.Set("list", &Record{Type: ArrayType, List: NewList(&Record{String: "a"})})
+	s.Set("hash", &Record{Type: MapType, Map: map[string]*Record{"f": {Type: StringType, String: "v"}}})
+	s.Set("set", &Record{Type: SetType, Set: NewSet("a")})
+	s.Set("zset", &Record{Type: SortedSetType, SortedSet: zset})
+	s.Set("stream", &Record{Type: StreamType, Streams: stream, ExpiresAt: time.Now().Add(time.Hour)})
+
+	s.Atomically(func(tx *Tx) {
+		for _, k := range []string{"list", "hash", "set", "zset", "stream"} {
+			if _, ok := tx.Copy(k, k+":copy"); !ok {
+				t.Fatalf("Copy of %s reported a missing key", k)
+			}
+		}
+	})
+
+	s.Atomically(func(tx *Tx) {
+		list, _ := tx.Get("list:copy")
+		list.List.PushBack(&Record{String: "b"})
+		hash, _ := tx.Get("hash:copy")
+		hash.Map["f"].String = "changed"
+		set, _ := tx.Get("set:copy")
+		set.Set.Add("b")
+		zset, _ := tx.Get("zset:copy")
+		zset.SortedSet.Add("a", 2, nil)
+
+		stream, _ := tx.Get("stream:copy")
+		g, _ := stream.Streams.Group("g")
+		for _, e := range stream.Streams.Range(MinStreamID, MaxStreamID, 0, false) {
+			g.Ack([]StreamID{e.ID})
+		}
+		stream.Streams.Insert("*", nil)
+	})
+
+	s.View("list", func(rec *Record, _ bool) {
+		if got := listValues(rec.List); !slices.Equal(got, []string{"a"}) {
+			t.Errorf("original list changed to %v", got)
+		}
+	})
+	s.View("hash", func(rec *Record, _ bool) {
+		if v := rec.Map["f"].String; v != "v" {
+			t.Errorf("original hash field changed to %q", v)
+		}
+	})
+	s.View("set", func(rec *Record, _ bool) {
+		if rec.Set.Has("b") {
+			t.Error("member added to the copy showed up in the original set")
+		}
+	})
+	s.View("zset", func(rec *Record, _ bool) {
+		if score, _ := rec.SortedSet.Score("a"); score != 1 {
+			t.Errorf("original score changed to %v", score)
+		}
+	})
+	s.View("stream", func(rec *Record, _ bool) {
+		if n := rec.Streams.Len(); n != 3 {
+			t.Errorf("original stream has %d entries, want 3", n)
+		}
+		g, _ := rec.Streams.Group("g")
+		if n := g.PendingCount(); n != 2 {
+			t.Errorf("original group has %d pending entries, want 2", n)
+		}
+		c, _ := g.Consumer("alice")
+		if n := c.PendingCount(); n != 2 {
+			t.Errorf("original consumer has %d pending entries, want 2", n)
+		}
+	})
+
+	s.View("stream:copy", func(rec *Record, _ bool) {
+		if rec.ExpiresAt.IsZero() {
+			t.Error("copy lost the TTL")
+		}
+		g, _ := rec.Streams.Group("g")
+		c, _ := g.Consumer("alice")
+		if n := c.PendingCount(); n != 0 {
+			t.Errorf("copied consumer has %d pending entries after acking them all, want 0", n)
+		}
+	})
+}
+
+func TestRenameKeepsTTL(t *testing.T) {
+	s := New()
+	s.Set("old", &Record{Type: StringType, String: "v", ExpiresAt: time.Now().Add(time.Hour)})
+	s.Set("new", &Record{Type: StringType, String: "replaced"})
+
+	s.Atomically(func(tx *Tx) {
+		if _, ok := tx.Rename("old", "new"); !ok {
+			t.Fatal("Rename reported a missing key")
+		}
+	})
+
+	if _, exists := s.Get("old"); exists {
+		t.Error("old name still exists after the rename")
+	}
+
+	rec, exists := s.Get("new")
+	if !exists || rec.String != "v" || rec.ExpiresAt.IsZero() {
+		t.Fatalf("unexpected record after the rename: %+v", rec)
+	}
+
+	if _, tracked := s.volatileKeys["new"]; !tracked {
+		t.Error("renamed key with a TTL isn't tracked as volatile")
+	}
+}
+
+func TestFlushAsync(t *testing.T) {
+	s := New()
+	for i := range 10 {
+		list := NewList()
+		for range 2 * lazyFreeThreshold {
+			list.PushBack(&Record{String: "job"})
+		}
+		s.Set(strconv.Itoa(i), &Record{Type: ArrayType, List: list, ExpiresAt: time.Now().Add(time.Hour)})
+	}
+
+	s.Flush(true)
+
+	if n := s.Len(); n != 0 {
+		t.Fatalf("%d keys left after flushing", n)
+	}
+
+	if n := len(s.volatileKeys); n != 0 {
+		t.Fatalf("%d keys still tracked as volatile after flushing", n)
+	}
+
+	// The new keyspace must be usable while the old one is being freed
+	s.Set("k", &Record{Type: StringType, String: "v"})
+	if key, ok := s.RandomKey(); !ok || key != "k" {
+		t.Fatalf("RandomKey returned %q, %t after writing to a flushed store", key, ok)
+	}
+}
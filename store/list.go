@@ -0,0 +1,434 @@
+package store
+
+import "iter"
+
+// How many elements each node of a List holds at most.
+const listChunkSize = 128
+
+// Lists are a quicklist: a doubly linked list of nodes, each holding up to
+// listChunkSize elements in a fixed array. Pushing and popping at either end
+// is O(1) and never copies the rest of the list, while positional access
+// only walks nodes rather than elements, so it's O(n/listChunkSize).
+type List struct {
+	head, tail *listNode
+	length     int
+}
+
+// Elements live in items[lo:hi]. Nodes grown at the head fill from the
+// right and those grown at the tail from the left, so pushes at either end
+// never have to shift anything.
+type listNode struct {
+	prev, next *listNode
+	items      [listChunkSize]*Record
+	lo, hi     int
+}
+
+func (n *listNode) len() int {
+	return n.hi - n.lo
+}
+
+func NewList(elems ...*Record) *List {
+	l := &List{}
+	for _, e := range elems {
+		l.PushBack(e)
+	}
+
+	return l
+}
+
+// Returns a copy of the list holding copies of its elements, so neither
+// list sees changes made to the other.
+func (l *List) Clone() *List {
+	c := &List{}
+	for _, e := range l.All() {
+		elem := *e
+		c.PushBack(&elem)
+	}
+
+	return c
+}
+
+func (l *List) Len() int {
+	if l == nil {
+		return 0
+	}
+
+	return l.length
+}
+
+func (l *List) PushFront(e *Record) {
+	if l.head == nil || l.head.lo == 0 {
+		n := &listNode{lo: listChunkSize, hi: listChunkSize}
+		l.linkBefore(n, l.head)
+	}
+
+	l.head.lo--
+	l.head.items[l.head.lo] = e
+	l.length++
+}
+
+func (l *List) PushBack(e *Record) {
+	if l.tail == nil || l.tail.hi == listChunkSize {
+		l.linkAfter(&listNode{}, l.tail)
+	}
+
+	l.tail.items[l.tail.hi] = e
+	l.tail.hi++
+	l.length++
+}
+
+// Removes and returns the first element, or false when the list is empty.
+func (l *List) PopFront() (*Record, bool) {
+	if l.Len() == 0 {
+		return nil, false
+	}
+
+	n := l.head
+	e := n.items[n.lo]
+	n.items[n.lo] = nil
+	n.lo++
+	l.length--
+	if n.len() == 0 {
+		l.unlink(n)
+	}
+
+	return e, true
+}
+
+// Removes and returns the last element, or false when the list is empty.
+func (l *List) PopBack() (*Record, bool) {
+	if l.Len() == 0 {
+		return nil, false
+	}
+
+	n := l.tail
+	n.hi--
+	e := n.items[n.hi]
+	n.items[n.hi] = nil
+	l.length--
+	if n.len() == 0 {
+		l.unlink(n)
+	}
+
+	return e, true
+}
+
+// Returns the element at the zero-based index `i`, or false when it's out
+// of range.
+func (l *List) Index(i int) (*Record, bool) {
+	n, off := l.locate(i)
+	if n == nil {
+		return nil, false
+	}
+
+	return n.items[off], true
+}
+
+// Replaces the element at index `i`, reporting false when it's out of range.
+func (l *List) Set(i int, e *Record) bool {
+	n, off := l.locate(i)
+	if n == nil {
+		return false
+	}
+
+	n.items[off] = e
+	return true
+}
+
+// Inserts `e` so that it ends up at index `i`, shifting everything from `i`
+// onwards back by one. `i` may be Len() to append. Only the node the index
+// falls in is touched: it's split in half when it has no room left.
+func (l *List) Insert(i int, e *Record) bool {
+	switch {
+	case i < 0 || i > l.Len():
+		return false
+	case i == 0:
+		l.PushFront(e)
+		return true
+	case i == l.length:
+		l.PushBack(e)
+		return true
+	}
+
+	n, off := l.locate(i)
+	if n.len() == listChunkSize {
+		l.split(n)
+		if off >= n.len() {
+			off -= n.len()
+			n = n.next
+		}
+	}
+
+	switch {
+	case n.hi < listChunkSize:
+		copy(n.items[off+1:n.hi+1], n.items[off:n.hi])
+		n.hi++
+	default:
+		copy(n.items[n.lo-1:off-1], n.items[n.lo:off])
+		n.lo--
+		off--
+	}
+
+	n.items[off] = e
+	l.length++
+	return true
+}
+
+// Returns the elements between the zero-based indexes `start` and `stop`,
+// both inclusive and already clamped to the list's bounds.
+func (l *List) Range(start, stop int) []*Record {
+	if start > stop {
+		return nil
+	}
+
+	out := make([]*Record, 0, stop-start+1)
+	for i, e := range l.from(start) {
+		if i > stop {
+			break
+		}
+		out = append(out, e)
+	}
+
+	return out
+}
+
+// Iterates over the elements and their indexes from head to tail.
+func (l *List) All() iter.Seq2[int, *Record] {
+	return l.from(0)
+}
+
+// Iterates over the elements and their indexes from tail to head.
+func (l *List) Backward() iter.Seq2[int, *Record] {
+	return func(yield func(int, *Record) bool) {
+		if l == nil {
+			return
+		}
+
+		i := l.length - 1
+		for n := l.tail; n != nil; n = n.prev {
+			for j := n.hi - 1; j >= n.lo; j-- {
+				if !yield(i, n.items[j]) {
+					return
+				}
+				i--
+			}
+		}
+	}
+}
+
+// Removes up to `limit` elements matching `match`, all of them when `limit`
+// is 0, scanning from the tail when `fromTail` is set. Returns how many were
+// removed.
+func (l *List) RemoveFunc(match func(*Record) bool, limit int, fromTail bool) int {
+	if l == nil {
+		return 0
+	}
+
+	removed := 0
+	more := func(e *Record) bool {
+		return (limit == 0 || removed < limit) && match(e)
+	}
+
+	n := l.head
+	if fromTail {
+		n = l.tail
+	}
+
+	for n != nil {
+		next := n.next
+		if fromTail {
+			next = n.prev
+		}
+
+		// Compact the survivors towards the end we're scanning from
+		if fromTail {
+			w := n.hi
+			for r := n.hi - 1; r >= n.lo; r-- {
+				if more(n.items[r]) {
+					removed++
+					continue
+				}
+				w--
+				n.items[w] = n.items[r]
+			}
+			clear(n.items[n.lo:w])
+			n.lo = w
+		} else {
+			w := n.lo
+			for r := n.lo; r < n.hi; r++ {
+				if more(n.items[r]) {
+					removed++
+					continue
+				}
+				n.items[w] = n.items[r]
+				w++
+			}
+			clear(n.items[w:n.hi])
+			n.hi = w
+		}
+
+		if n.len() == 0 {
+			l.unlink(n)
+		}
+
+		if limit != 0 && removed >= limit {
+			break
+		}
+		n = next
+	}
+
+	l.length -= removed
+	return removed
+}
+
+// Keeps only the elements between the zero-based indexes `start` and
+// `stop`, both inclusive and already clamped to the list's bounds. Whole
+// nodes outside the range are dropped without visiting their elements.
+func (l *List) Trim(start, stop int) {
+	if start > stop {
+		l.head, l.tail, l.length = nil, nil, 0
+		return
+	}
+
+	l.dropFront(start)
+	l.dropBack(l.length - (stop - start + 1))
+}
+
+func (l *List) dropFront(count int) {
+	for count > 0 && l.head != nil {
+		n := l.head
+		if n.len() <= count {
+			count -= n.len()
+			l.length -= n.len()
+			l.unlink(n)
+			continue
+		}
+
+		clear(n.items[n.lo : n.lo+count])
+		n.lo += count
+		l.length -= count
+		count = 0
+	}
+}
+
+func (l *List) dropBack(count int) {
+	for count > 0 && l.tail != nil {
+		n := l.tail
+		if n.len() <= count {
+			count -= n.len()
+			l.length -= n.len()
+			l.unlink(n)
+			continue
+		}
+
+		clear(n.items[n.hi-count : n.hi])
+		n.hi -= count
+		l.length -= count
+		count = 0
+	}
+}
+
+// Iterates from the zero-based index `start` to the tail.
+func (l *List) from(start int) iter.Seq2[int, *Record] {
+	return func(yield func(int, *Record) bool) {
+		n, off := l.locate(start)
+		i := start
+		for ; n != nil; n = n.next {
+			for j := off; j < n.hi; j++ {
+				if !yield(i, n.items[j]) {
+					return
+				}
+				i++
+			}
+			if n.next != nil {
+				off = n.next.lo
+			}
+		}
+	}
+}
+
+// Finds the node holding index `i` and its offset within that node's
+// items, walking from whichever end is closer. Returns a nil node when `i`
+// is out of range.
+func (l *List) locate(i int) (*listNode, int) {
+	if i < 0 || i >= l.Len() {
+		return nil, 0
+	}
+
+	if i < l.length/2 {
+		for n := l.head; n != nil; n = n.next {
+			if i < n.len() {
+				return n, n.lo + i
+			}
+			i -= n.len()
+		}
+	} else {
+		i = l.length - 1 - i
+		for n := l.tail; n != nil; n = n.prev {
+			if i < n.len() {
+				return n, n.hi - 1 - i
+			}
+			i -= n.len()
+		}
+	}
+
+	return nil, 0
+}
+
+// Moves the back half of the full node `n` into a new node right after it.
+func (l *List) split(n *listNode) {
+	half := n.len() / 2
+	m := &listNode{}
+	m.hi = copy(m.items[:], n.items[n.hi-half:n.hi])
+	clear(n.items[n.hi-half : n.hi])
+	n.hi -= half
+	l.linkAfter(m, n)
+}
+
+// Links `n` in before `at`, or as the only node when `at` is nil.
+func (l *List) linkBefore(n, at *listNode) {
+	if at == nil {
+		l.head, l.tail = n, n
+		return
+	}
+
+	n.prev, n.next = at.prev, at
+	if at.prev != nil {
+		at.prev.next = n
+	} else {
+		l.head = n
+	}
+	at.prev = n
+}
+
+// Links `n` in after `at`, or as the only node when `at` is nil.
+func (l *List) linkAfter(n, at *listNode) {
+	if at == nil {
+		l.head, l.tail = n, n
+		return
+	}
+
+	n.prev, n.next = at, at.next
+	if at.next != nil {
+		at.next.prev = n
+	} else {
+		l.tail = n
+	}
+	at.next = n
+}
+
+func (l *List) unlink(n *listNode) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		l.head = n.next
+	}
+
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		l.tail = n.prev
+	}
+
+	n.prev, n.next = nil, nil
+}
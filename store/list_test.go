@@ -0,0 +1,197 @@
+package store
+
+import (
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func listValues(l *List) []string {
+	var out []string
+	for _, e := range l.All() {
+		out = append(out, e.String)
+	}
+	return out
+}
+
+// Drives a List and a plain slice through the same random operations and
+// checks they never disagree, with enough elements to span many nodes.
+func TestListMatchesSlice(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	l := NewList()
+	var model []string
+
+	for step := range 20_000 {
+		v := strconv.Itoa(step)
+		switch op := rng.IntN(8); {
+		case op == 0:
+			l.PushFront(&Record{String: v})
+			model = slices.Insert(model, 0, v)
+		case op == 1:
+			l.PushBack(&Record{String: v})
+			model = append(model, v)
+		case op == 2 && len(model) > 0:
+			e, _ := l.PopFront()
+			if e.String != model[0] {
+				t.Fatalf("step %d: PopFront got %q, want %q", step, e.String, model[0])
+			}
+			model = model[1:]
+		case op == 3 && len(model) > 0:
+			e, _ := l.PopBack()
+			if e.String != model[len(model)-1] {
+				t.Fatalf("step %d: PopBack got %q, want %q", step, e.String, model[len(model)-1])
+			}
+			model = model[:len(model)-1]
+		case op == 4:
+			i := rng.IntN(len(model) + 1)
+			l.Insert(i, &Record{String: v})
+			model = slices.Insert(model, i, v)
+		case op == 5 && len(model) > 0:
+			i := rng.IntN(len(model))
+			l.Set(i, &Record{String: v})
+			model[i] = v
+		case op == 6 && len(model) > 0 && step%50 == 0:
+			start := rng.IntN(len(model))
+			stop := start + rng.IntN(len(model)-start)
+			l.Trim(start, stop)
+			model = slices.Clone(model[start : stop+1])
+		case op == 7 && len(model) > 0 && step%50 == 0:
+			target := model[rng.IntN(len(model))]
+			fromTail := rng.IntN(2) == 0
+			want := 0
+			for _, m := range model {
+				if m == target {
+					want++
+				}
+			}
+
+			got := l.RemoveFunc(func(r *Record) bool { return r.String == target }, 0, fromTail)
+			if got != want {
+				t.Fatalf("step %d: RemoveFunc removed %d, want %d", step, got, want)
+			}
+			model = slices.DeleteFunc(model, func(m string) bool { return m == target })
+		}
+
+		if l.Len() != len(model) {
+			t.Fatalf("step %d: Len is %d, want %d", step, l.Len(), len(model))
+		}
+
+		if len(model) > 0 {
+			i := rng.IntN(len(model))
+			if e, ok := l.Index(i); !ok || e.String != model[i] {
+				t.Fatalf("step %d: Index(%d) mismatch", step, i)
+			}
+		}
+	}
+
+	if got := listValues(l); !slices.Equal(got, model) {
+		t.Fatalf("final contents differ: got %d elements, want %d", len(got), len(model))
+	}
+}
+
+func TestListRemoveFuncLimit(t *testing.T) {
+	l := NewList()
+	for _, v := range []string{"a", "b", "a", "c", "a"} {
+		l.PushBack(&Record{String: v})
+	}
+
+	isA := func(r *Record) bool { return r.String == "a" }
+	if n := l.RemoveFunc(isA, 2, true); n != 2 {
+		t.Fatalf("expected 2 removed, got %d", n)
+	}
+
+	if got := listValues(l); !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Fatalf("unexpected contents after removing from the tail: %v", got)
+	}
+}
+
+func TestListBackward(t *testing.T) {
+	l := NewList()
+	for i := range 3 * listChunkSize {
+		l.PushFront(&Record{String: strconv.Itoa(i)})
+	}
+
+	want := l.Len() - 1
+	for i, e := range l.Backward() {
+		if i != want || e.String != strconv.Itoa(l.Len()-1-i) {
+			t.Fatalf("unexpected element %q at %d", e.String, i)
+		}
+		want--
+	}
+}
+
+// The slice benchmarks mirror how lists were handled before the quicklist:
+// LPUSH prepended by copying the whole slice and LPOP resliced the front.
+
+func BenchmarkListPushFront(b *testing.B) {
+	rec := &Record{String: "job"}
+	for b.Loop() {
+		l := NewList()
+		for range 10_000 {
+			l.PushFront(rec)
+		}
+	}
+}
+
+func BenchmarkSlicePushFront(b *testing.B) {
+	rec := &Record{String: "job"}
+	for b.Loop() {
+		var s []*Record
+		for range 10_000 {
+			s = append([]*Record{rec}, s...)
+		}
+	}
+}
+
+func BenchmarkListQueue(b *testing.B) {
+	rec := &Record{String: "job"}
+	for b.Loop() {
+		l := NewList()
+		for range 10_000 {
+			l.PushBack(rec)
+		}
+		for l.Len() > 0 {
+			l.PopFront()
+		}
+	}
+}
+
+func BenchmarkSliceQueue(b *testing.B) {
+	rec := &Record{String: "job"}
+	for b.Loop() {
+		var s []*Record
+		for range 10_000 {
+			s = append(s, rec)
+		}
+		for len(s) > 0 {
+			s = s[1:]
+		}
+	}
+}
+
+func BenchmarkListIndex(b *testing.B) {
+	l := NewList()
+	for range 100_000 {
+		l.PushBack(&Record{String: "job"})
+	}
+
+	i := 0
+	for b.Loop() {
+		l.Index(i % l.Len())
+		i += 7919
+	}
+}
+
+func BenchmarkSliceIndex(b *testing.B) {
+	var s []*Record
+	for range 100_000 {
+		s = append(s, &Record{String: "job"})
+	}
+
+	i := 0
+	for b.Loop() {
+		_ = s[i%len(s)]
+		i += 7919
+	}
+}
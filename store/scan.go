@@ -0,0 +1,228 @@
+package store
+
+import (
+	"hash/maphash"
+	"math"
+	"math/bits"
+	"slices"
+	"time"
+)
+
+// Go maps can't be iterated a bit at a time, so the keyspace also keeps its
+// keys in a hash table of its own that SCAN walks with a cursor, the same
+// way Redis walks its dict. Hashes, sets and sorted sets keep one of their
+// members too, for HSCAN, SSCAN and ZSCAN. The table grows and shrinks by
+// powers of two and moves keys to the new size a bucket at a time, like
+// Redis's incremental rehashing, so no single write has to move them all.
+type keyIndex struct {
+	seed maphash.Seed
+	// While rehashing, keys move from tables[0] to tables[1], with every
+	// bucket of tables[0] below rehashIdx already moved
+	tables    [2][][]string
+	rehashIdx int
+	count     int
+}
+
+const (
+	keyIndexInitialSize = 4
+	// Caps how many empty buckets a single rehash step skips over
+	keyIndexEmptyVisits = 10
+)
+
+func newKeyIndex() *keyIndex {
+	return &keyIndex{
+		seed:      maphash.MakeSeed(),
+		tables:    [2][][]string{make([][]string, keyIndexInitialSize)},
+		rehashIdx: -1,
+	}
+}
+
+func (ix *keyIndex) rehashing() bool {
+	return ix.rehashIdx >= 0
+}
+
+func (ix *keyIndex) bucket(table int, k string) *[]string {
+	t := ix.tables[table]
+	return &t[maphash.String(ix.seed, k)&uint64(len(t)-1)]
+}
+
+// Adds a key that isn't in the index yet.
+func (ix *keyIndex) add(k string) {
+	ix.rehashStep()
+	if !ix.rehashing() && ix.count >= len(ix.tables[0]) {
+		ix.resize(len(ix.tables[0]) * 2)
+	}
+
+	table := 0
+	if ix.rehashing() {
+		table = 1
+	}
+
+	b := ix.bucket(table, k)
+	*b = append(*b, k)
+	ix.count++
+}
+
+// Removes a key, if it's in the index.
+func (ix *keyIndex) remove(k string) {
+	ix.rehashStep()
+
+	for table := range ix.tables {
+		if ix.tables[table] == nil {
+			continue
+		}
+
+		b := ix.bucket(table, k)
+		if i := slices.Index(*b, k); i >= 0 {
+			last := len(*b) - 1
+			(*b)[i] = (*b)[last]
+			(*b)[last] = ""
+			*b = (*b)[:last]
+			ix.count--
+			break
+		}
+	}
+
+	if size := len(ix.tables[0]); !ix.rehashing() && size > keyIndexInitialSize && ix.count*8 < size {
+		ix.resize(max(size/2, keyIndexInitialSize))
+	}
+}
+
+// Starts moving the keys over to a table of `size` buckets.
+func (ix *keyIndex) resize(size int) {
+	ix.tables[1] = make([][]string, size)
+	ix.rehashIdx = 0
+}
+
+// Moves the keys of the next non-empty bucket over to the new table.
+func (ix *keyIndex) rehashStep() {
+	if !ix.rehashing() {
+		return
+	}
+
+	old := ix.tables[0]
+	for visits := 0; ix.rehashIdx < len(old) && len(old[ix.rehashIdx]) == 0; visits++ {
+		if visits == keyIndexEmptyVisits {
+			return
+		}
+		ix.rehashIdx++
+	}
+
+	if ix.rehashIdx < len(old) {
+		for _, k := range old[ix.rehashIdx] {
+			b := ix.bucket(1, k)
+			*b = append(*b, k)
+		}
+		old[ix.rehashIdx] = nil
+		ix.rehashIdx++
+	}
+
+	if ix.rehashIdx == len(old) {
+		ix.tables = [2][][]string{ix.tables[1]}
+		ix.rehashIdx = -1
+	}
+}
+
+// Hands every key in the bucket(s) `cursor` points at to `fn`, and returns
+// the cursor of the next bucket, which is 0 once the whole table was
+// visited. Cursors count up with their bits reversed, so that after the
+// table doubles or halves, the buckets already visited map onto buckets
+// that were too: every key present for a whole iteration is returned at
+// least once, though some may be returned twice after a shrink.
+func (ix *keyIndex) scan(cursor uint64, fn func(k string)) uint64 {
+	if !ix.rehashing() {
+		t := ix.tables[0]
+		mask := uint64(len(t) - 1)
+		for _, k := range t[cursor&mask] {
+			fn(k)
+		}
+
+		return nextCursor(cursor, mask)
+	}
+
+	// Mid-rehash the keys are split between both tables. Visit the bucket in
+	// the smaller one, then every bucket of the larger one it expands to
+	small, large := ix.tables[0], ix.tables[1]
+	if len(small) > len(large) {
+		small, large = large, small
+	}
+	smallMask, largeMask := uint64(len(small)-1), uint64(len(large)-1)
+
+	for _, k := range small[cursor&smallMask] {
+		fn(k)
+	}
+
+	for {
+		for _, k := range large[cursor&largeMask] {
+			fn(k)
+		}
+
+		cursor = nextCursor(cursor, largeMask)
+		if cursor&(smallMask^largeMask) == 0 {
+			return cursor
+		}
+	}
+}
+
+// Increments the bits of the cursor covered by `mask` as if they were
+// reversed.
+func nextCursor(cursor, mask uint64) uint64 {
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// Walks part of the keyspace starting at `cursor`, handing the live keys it
+// comes across to `fn`, and returns the cursor to continue from, which is 0
+// once every key was visited.
+func (s *Store) Scan(cursor uint64, count int, fn func(k string, rec *Record)) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	return s.index.walk(cursor, count, func(k string) {
+		if item, exists := s.data[k]; exists && (item.ExpiresAt.IsZero() || now.Before(item.ExpiresAt)) {
+			fn(k, item)
+		}
+	})
+}
+
+// Hands the keys of the buckets from `cursor` on to `fn` until it has seen
+// `count` keys or so, which may be a few more to finish a bucket, or fewer
+// once it has passed ten times as many empty buckets. Returns the cursor to
+// continue from, which is 0 once the whole table was visited.
+func (ix *keyIndex) walk(cursor uint64, count int, fn func(k string)) uint64 {
+	// COUNT is up to the client, so it may be too large to multiply
+	maxEmptyVisits := math.MaxInt
+	if count <= math.MaxInt/10 {
+		maxEmptyVisits = count * 10
+	}
+
+	seen, emptyVisits := 0, 0
+	for {
+		before := seen
+		cursor = ix.scan(cursor, func(k string) {
+			seen++
+			fn(k)
+		})
+
+		if seen == before {
+			emptyVisits++
+		}
+
+		if cursor == 0 || seen >= count || emptyVisits >= maxEmptyVisits {
+			return cursor
+		}
+	}
+}
+
+// Walks the index like walk, collecting the keys it comes across.
+func (ix *keyIndex) collect(cursor uint64, count int) (uint64, []string) {
+	var batch []string
+	next := ix.walk(cursor, count, func(k string) {
+		batch = append(batch, k)
+	})
+
+	return next, batch
+}
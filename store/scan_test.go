@@ -0,0 +1,186 @@
+package store
+
+import (
+	"math"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// Keys present from start to finish must all come up, however much the
+// index grows, shrinks or rehashes in between calls.
+func TestScanWhileResizing(t *testing.T) {
+	s := New()
+	for i := range 1000 {
+		s.Set("stable:"+strconv.Itoa(i), &Record{Type: StringType})
+	}
+
+	seen := make(map[string]bool)
+	cursor, calls, extra := uint64(0), 0, 0
+	for {
+		cursor = s.Scan(cursor, 10, func(k string, _ *Record) {
+			seen[k] = true
+		})
+		if cursor == 0 {
+			break
+		}
+
+		// Grow for a while, then shrink back down past where we started
+		calls++
+		if calls < 40 {
+			for range 200 {
+				s.Set("extra:"+strconv.Itoa(extra), &Record{Type: StringType})
+				extra++
+			}
+			continue
+		}
+
+		for range min(400, extra) {
+			extra--
+			s.Delete("extra:" + strconv.Itoa(extra))
+		}
+
+		// Rehashing only moves along as keys are written
+		for range 100 {
+			s.Set("churn", &Record{Type: StringType})
+			s.Delete("churn")
+		}
+	}
+
+	for i := range 1000 {
+		if k := "stable:" + strconv.Itoa(i); !seen[k] {
+			t.Errorf("%s never came up", k)
+		}
+	}
+}
+
+func TestScanSkipsExpiredKeys(t *testing.T) {
+	s := New()
+	s.Set("live", &Record{Type: StringType})
+	s.Set("stale", &Record{Type: StringType, ExpiresAt: time.Now().Add(-time.Second)})
+
+	var keys []string
+	for cursor := uint64(0); ; {
+		cursor = s.Scan(cursor, 10, func(k string, _ *Record) {
+			keys = append(keys, k)
+		})
+		if cursor == 0 {
+			break
+		}
+	}
+
+	if len(keys) != 1 || keys[0] != "live" {
+		t.Fatalf("scan returned %v, want only the live key", keys)
+	}
+}
+
+// Members present from start to finish come up exactly once, even with
+// others being added in between calls.
+func TestSetScan(t *testing.T) {
+	set := NewSet()
+	for i := range 500 {
+		set.Add("stable:" + strconv.Itoa(i))
+	}
+
+	seen := make(map[string]int)
+	cursor, calls, added := uint64(0), 0, 0
+	for {
+		var batch []string
+		cursor, batch = set.Scan(cursor, 7)
+		for _, m := range batch {
+			seen[m]++
+		}
+		calls++
+		if cursor == 0 {
+			break
+		}
+
+		set.Add("extra:" + strconv.Itoa(added))
+		added++
+	}
+
+	for i := range 500 {
+		if m := "stable:" + strconv.Itoa(i); seen[m] != 1 {
+			t.Errorf("%s came up %d times", m, seen[m])
+		}
+	}
+
+	// Each call only walks a few buckets rather than the whole set
+	if calls < 500/7/2 {
+		t.Errorf("scan took %d calls, want one per few members", calls)
+	}
+}
+
+// Removing members shrinks the index, which may repeat some but never loses
+// any that stay.
+func TestSortedSetScanWhileRemoving(t *testing.T) {
+	z := NewSortedSet()
+	for i := range 1000 {
+		z.Add("m:"+strconv.Itoa(i), float64(i), nil)
+	}
+
+	seen := make(map[string]bool)
+	cursor, removed := uint64(0), 999
+	for {
+		var batch []string
+		cursor, batch = z.Scan(cursor, 10)
+		for _, m := range batch {
+			seen[m] = true
+		}
+		if cursor == 0 {
+			break
+		}
+
+		for range min(50, removed-99) {
+			z.Remove("m:" + strconv.Itoa(removed))
+			removed--
+		}
+	}
+
+	for i := range 100 {
+		if m := "m:" + strconv.Itoa(i); !seen[m] {
+			t.Errorf("%s never came up", m)
+		}
+	}
+}
+
+func TestScanFields(t *testing.T) {
+	rec := &Record{Type: MapType}
+	for i := range 100 {
+		rec.SetField(strconv.Itoa(i), &Record{Type: StringType})
+	}
+	rec.DeleteField("0")
+
+	cursor, batch := rec.ScanFields(0, math.MaxInt)
+	if cursor != 0 || len(batch) != 99 {
+		t.Fatalf("got %d fields and cursor %d, want all 99 and 0", len(batch), cursor)
+	}
+
+	// The clone has an index of its own
+	c := rec.Clone()
+	c.DeleteField("1")
+	if _, batch := rec.ScanFields(0, math.MaxInt); len(batch) != 99 {
+		t.Fatalf("deleting from the clone left the original with %d fields", len(batch))
+	}
+}
+
+func TestScanAllAtOnce(t *testing.T) {
+	set := NewSet()
+	for i := range 100 {
+		set.Add(strconv.Itoa(i))
+	}
+
+	cursor, batch := set.Scan(0, math.MaxInt)
+	if cursor != 0 || len(batch) != 100 {
+		t.Fatalf("got %d members and cursor %d, want all 100 and 0", len(batch), cursor)
+	}
+
+	if cursor, batch := NewSet().Scan(0, 10); cursor != 0 || len(batch) != 0 {
+		t.Fatalf("empty set: got %v and cursor %d", batch, cursor)
+	}
+
+	var missing *Set
+	if cursor, batch := missing.Scan(0, 10); cursor != 0 || len(batch) != 0 {
+		t.Fatalf("nil set: got %v and cursor %d", batch, cursor)
+	}
+}
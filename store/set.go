@@ -0,0 +1,166 @@
+package store
+
+import (
+	"iter"
+	"maps"
+)
+
+// Sets keep their members in a Go map so membership checks, adds and
+// removes are O(1), rather than the slice RDB sets used to land in, and in
+// a keyIndex as well so SSCAN can walk them a bit at a time. A nil set is
+// treated as empty, the same as a missing key.
+type Set struct {
+	members map[string]struct{}
+	index   *keyIndex
+}
+
+func NewSet(members ...string) *Set {
+	s := &Set{
+		members: make(map[string]struct{}, len(members)),
+		index:   newKeyIndex(),
+	}
+	s.Add(members...)
+	return s
+}
+
+// Adds the members, returning how many weren't already present.
+func (s *Set) Add(members ...string) int {
+	added := 0
+	for _, m := range members {
+		if _, ok := s.members[m]; !ok {
+			s.members[m] = struct{}{}
+			s.index.add(m)
+			added++
+		}
+	}
+
+	return added
+}
+
+// Iterates over the members in no particular order.
+func (s *Set) All() iter.Seq[string] {
+	if s == nil {
+		return func(func(string) bool) {}
+	}
+
+	return maps.Keys(s.members)
+}
+
+// Returns a copy of the set that shares nothing with the original.
+func (s *Set) Clone() *Set {
+	c := NewSet()
+	for m := range s.All() {
+		c.Add(m)
+	}
+
+	return c
+}
+
+func (s *Set) Has(member string) bool {
+	if s == nil {
+		return false
+	}
+
+	_, ok := s.members[member]
+	return ok
+}
+
+func (s *Set) Len() int {
+	if s == nil {
+		return 0
+	}
+
+	return len(s.members)
+}
+
+func (s *Set) Members() []string {
+	members := make([]string, 0, s.Len())
+	for m := range s.All() {
+		members = append(members, m)
+	}
+
+	return members
+}
+
+// Removes the members, returning how many were present.
+func (s *Set) Remove(members ...string) int {
+	removed := 0
+	for _, m := range members {
+		if _, ok := s.members[m]; ok {
+			delete(s.members, m)
+			s.index.remove(m)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+// Returns the `count` or so members following `cursor`, and the cursor to
+// continue from, walking the set the same way Store.Scan walks the keyspace.
+func (s *Set) Scan(cursor uint64, count int) (uint64, []string) {
+	if s == nil {
+		return 0, nil
+	}
+
+	return s.index.collect(cursor, count)
+}
+
+// Members of the first set that are in none of the others.
+func SetDiff(sets ...*Set) *Set {
+	result := NewSet()
+	if len(sets) == 0 {
+		return result
+	}
+
+outer:
+	for m := range sets[0].All() {
+		for _, other := range sets[1:] {
+			if other.Has(m) {
+				continue outer
+			}
+		}
+		result.Add(m)
+	}
+
+	return result
+}
+
+// Members present in every set. Iterates the smallest set so the cost is
+// bounded by it rather than by the first one given.
+func SetInter(sets ...*Set) *Set {
+	result := NewSet()
+	if len(sets) == 0 {
+		return result
+	}
+
+	smallest := sets[0]
+	for _, s := range sets[1:] {
+		if s.Len() < smallest.Len() {
+			smallest = s
+		}
+	}
+
+outer:
+	for m := range smallest.All() {
+		for _, other := range sets {
+			if !other.Has(m) {
+				continue outer
+			}
+		}
+		result.Add(m)
+	}
+
+	return result
+}
+
+func SetUnion(sets ...*Set) *Set {
+	result := NewSet()
+	for _, s := range sets {
+		for m := range s.All() {
+			result.Add(m)
+		}
+	}
+
+	return result
+}
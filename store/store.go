@@ -1,29 +1,51 @@
 package store
 
 import (
+	"errors"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+var ErrWrongType = errors.New("operation against a key holding the wrong kind of value")
+
 type Store struct {
 	data map[string]*Record
 	mu   sync.RWMutex
+	// Every key in data, in a form SCAN can walk a bit at a time
+	index *keyIndex
+	// Keys of hashes that have at least one field with a TTL
+	volatileHashes map[string]struct{}
+	// Keys that have a TTL, which active expiry samples from
+	volatileKeys map[string]struct{}
+
+	expiredKeys    atomic.Int64
+	expireCycles   atomic.Int64
+	timeCapReached atomic.Int64
 }
 
 type Record struct {
 	ExpiresAt time.Time
 	Type      StoreType
-	Array     []*Record
+	List      *List
 	Boolean   bool
 	Integer   int
 	Map       map[string]*Record
+	Set       *Set
+	SortedSet *SortedSet
 	Streams   *Stream
 	String    string
+	// Indexes the fields of Map for HSCAN, which is why fields are only ever
+	// added and removed through SetField and DeleteField
+	fields *keyIndex
 }
 
 func New() *Store {
 	return &Store{
-		data: make(map[string]*Record),
+		data:           make(map[string]*Record),
+		index:          newKeyIndex(),
+		volatileHashes: make(map[string]struct{}),
+		volatileKeys:   make(map[string]struct{}),
 	}
 }
 
@@ -36,31 +58,88 @@ func (s *Store) Get(k string) (*Record, bool) {
 	}
 
 	isExpired := !item.ExpiresAt.IsZero() && time.Now().After(item.ExpiresAt)
+	_, isVolatile := s.volatileHashes[k]
 	s.mu.RUnlock()
 
-	if !isExpired {
+	if !isExpired && !isVolatile {
 		return item, exists
 	}
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	if !isExpired {
+		if !s.expireHashFieldsLocked(k, time.Now()) {
+			return &Record{}, false
+		}
+
+		return item, exists
+	}
+
 	// Checking using write lock in case a write occurred that extended TTL between releasing the Read lock and acquiring this Write lock
-	if item, exists := s.data[k]; exists && time.Now().After(item.ExpiresAt) {
-		delete(s.data, k)
+	if item, exists := s.data[k]; exists && !item.ExpiresAt.IsZero() && time.Now().After(item.ExpiresAt) {
+		s.expireLocked(k)
 	}
 
 	return &Record{}, false
 }
 
+// Like Get, for callers already holding the write lock: expired keys and
+// hash fields are removed on the way.
+func (s *Store) lookupLocked(k string, now time.Time) (*Record, bool) {
+	item, exists := s.data[k]
+	if !exists {
+		return nil, false
+	}
+
+	if !item.ExpiresAt.IsZero() && now.After(item.ExpiresAt) {
+		s.expireLocked(k)
+		return nil, false
+	}
+
+	if _, isVolatile := s.volatileHashes[k]; isVolatile && !s.expireHashFieldsLocked(k, now) {
+		return nil, false
+	}
+
+	return item, true
+}
+
+// Removes the key, reporting whether it was present.
+func (s *Store) Delete(k string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, exists := s.lookupLocked(k, time.Now())
+	s.deleteLocked(k)
+	return exists
+}
+
+func (s *Store) deleteLocked(k string) {
+	if _, exists := s.data[k]; exists {
+		s.index.remove(k)
+	}
+	delete(s.data, k)
+	delete(s.volatileHashes, k)
+	delete(s.volatileKeys, k)
+}
+
+// Deletes a key whose TTL has passed, counting it as expired.
+func (s *Store) expireLocked(k string) {
+	s.deleteLocked(k)
+	s.expiredKeys.Add(1)
+}
+
+// Returns every live key. Expired keys nobody has removed yet are left out,
+// but left in place, as only a read lock is held.
 func (s *Store) Keys() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	keys := make([]string, len(s.data))
-	i := 0
-	for k := range s.data {
-		keys[i] = k
-		i++
+	now := time.Now()
+	keys := make([]string, 0, len(s.data))
+	for k, item := range s.data {
+		if !item.ExpiresAt.IsZero() && now.After(item.ExpiresAt) {
+			continue
+		}
+		keys = append(keys, k)
 	}
 
 	return keys
@@ -69,5 +148,46 @@ func (s *Store) Keys() []string {
 func (s *Store) Set(k string, v *Record) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	s.setLocked(k, v)
+}
+
+// Stores `v` at `k`, keeping track of whether the key now has a TTL. A hash
+// replaced by anything else takes its field TTLs with it.
+func (s *Store) setLocked(k string, v *Record) {
+	if _, exists := s.data[k]; !exists {
+		s.index.add(k)
+	}
 	s.data[k] = v
+	if v.ExpiresAt.IsZero() {
+		delete(s.volatileKeys, k)
+	} else {
+		s.volatileKeys[k] = struct{}{}
+	}
+	if v.Type != MapType {
+		delete(s.volatileHashes, k)
+	}
+}
+
+// Sets every key under a single lock, so no reader ever sees some of the
+// writes without the others. With `nx`, nothing is written unless none of
+// the keys exist, reporting whether the writes happened.
+func (s *Store) SetMany(records map[string]*Record, nx bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if nx {
+		now := time.Now()
+		for k := range records {
+			if item, exists := s.data[k]; exists && (item.ExpiresAt.IsZero() || now.Before(item.ExpiresAt)) {
+				return false
+			}
+		}
+	}
+
+	for k, v := range records {
+		s.deleteLocked(k)
+		s.setLocked(k, v)
+	}
+
+	return true
 }
@@ -0,0 +1,131 @@
+package store
+
+import "time"
+
+// Tx is the store as seen from inside Atomically, with the write lock held
+// for its whole lifetime. It must not be used once Atomically returns.
+type Tx struct {
+	s   *Store
+	now time.Time
+}
+
+// Runs `fn` with the write lock held, so every key it reads and writes
+// through `tx` changes together or not at all as far as other clients can
+// tell. Used by commands spanning several keys, like LMOVE or SUNIONSTORE.
+func (s *Store) Atomically(fn func(tx *Tx)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(&Tx{s: s, now: time.Now()})
+}
+
+// Runs `fn` on the record at `k` under the write lock, so nothing can read
+// or write the key between `fn` seeing the record and its result landing.
+// `fn` gets a nil record when the key doesn't exist. When it returns true its
+// record replaces the current one, as with Tx.Set, while returning false
+// leaves the key untouched.
+func (s *Store) Update(k string, fn func(rec *Record, exists bool) (*Record, bool)) {
+	s.Atomically(func(tx *Tx) {
+		rec, exists := tx.Get(k)
+		if next, write := fn(rec, exists); write {
+			tx.Set(k, next)
+		}
+	})
+}
+
+// Runs `fn` on the record at `k` with it locked against writers, for
+// commands that only read. `fn` gets a nil record when the key doesn't exist
+// and must not modify the record.
+func (s *Store) View(k string, fn func(rec *Record, exists bool)) {
+	s.mu.RLock()
+	item, exists := s.data[k]
+	_, isVolatile := s.volatileHashes[k]
+	if !exists || (!isVolatile && (item.ExpiresAt.IsZero() || time.Now().Before(item.ExpiresAt))) {
+		defer s.mu.RUnlock()
+		fn(item, exists)
+		return
+	}
+	s.mu.RUnlock()
+
+	// Expiring the key or some of its fields on the way means writing
+	s.Atomically(func(tx *Tx) {
+		fn(tx.Get(k))
+	})
+}
+
+// Returns the record at `k`, removing it first when it has expired.
+func (tx *Tx) Get(k string) (*Record, bool) {
+	return tx.s.lookupLocked(k, tx.now)
+}
+
+// Stores `v` at `k`. A nil record or an empty list, set, sorted set or hash
+// deletes the key instead, as no key ever holds an empty collection.
+func (tx *Tx) Set(k string, v *Record) {
+	if v == nil || v.empty() {
+		tx.s.deleteLocked(k)
+		return
+	}
+
+	tx.s.setLocked(k, v)
+}
+
+// Removes the key, reporting whether it was present.
+func (tx *Tx) Delete(k string) bool {
+	_, exists := tx.Get(k)
+	tx.s.deleteLocked(k)
+	return exists
+}
+
+// Moves the record at `src` to `dst`, TTL included, replacing whatever `dst`
+// held. Reports false, changing nothing, when `src` doesn't exist.
+func (tx *Tx) Rename(src, dst string) (*Record, bool) {
+	rec, exists := tx.Get(src)
+	if !exists || src == dst {
+		return rec, exists
+	}
+
+	_, isVolatile := tx.s.volatileHashes[src]
+	tx.s.deleteLocked(src)
+	tx.s.deleteLocked(dst)
+	tx.s.setLocked(dst, rec)
+	if isVolatile {
+		tx.s.volatileHashes[dst] = struct{}{}
+	}
+
+	return rec, true
+}
+
+// Stores a deep copy of the record at `src`, TTL included, at `dst`,
+// replacing whatever `dst` held, and returns the copy. Reports false,
+// changing nothing, when `src` doesn't exist.
+func (tx *Tx) Copy(src, dst string) (*Record, bool) {
+	rec, exists := tx.Get(src)
+	if !exists {
+		return nil, false
+	}
+
+	clone := rec.Clone()
+	_, isVolatile := tx.s.volatileHashes[src]
+	tx.s.deleteLocked(dst)
+	tx.s.setLocked(dst, clone)
+	if isVolatile {
+		tx.s.volatileHashes[dst] = struct{}{}
+	}
+
+	return clone, true
+}
+
+func (r *Record) empty() bool {
+	switch r.Type {
+	case ArrayType:
+		return r.List.Len() == 0
+	case MapType:
+		return len(r.Map) == 0
+	case SetType:
+		return r.Set.Len() == 0
+	case SortedSetType:
+		return r.SortedSet == nil || r.SortedSet.Len() == 0
+	default:
+		return false
+	}
+}
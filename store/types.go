@@ -1,8 +1,11 @@
 package store
 
 import (
+	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"math"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -25,6 +28,7 @@ const (
 	MapType
 	NilType
 	SetType
+	SortedSetType
 	StreamType
 	NoneType
 )
@@ -45,6 +49,8 @@ func (t StoreType) String() string {
 		return "Nulls"
 	case SetType:
 		return "Sets"
+	case SortedSetType:
+		return "SortedSet"
 	case StreamType:
 		return "Stream"
 	case NoneType:
@@ -54,70 +60,335 @@ func (t StoreType) String() string {
 	}
 }
 
+var (
+	ErrStreamIDInvalid  = errors.New("invalid stream id")
+	ErrStreamIDTooSmall = errors.New("stream id is equal or smaller than the stream's last id")
+	ErrStreamIDZero     = errors.New("stream id must be greater than 0-0")
+)
+
 type Stream struct {
-	Root   *StreamNode
-	lastID string
+	Root         *StreamNode
+	entriesAdded uint64
+	groups       map[string]*ConsumerGroup
+	lastID       StreamID
+	length       int
+	maxDeletedID StreamID
+}
+
+type TrimStrategy uint
+
+const (
+	TrimNone TrimStrategy = iota
+	TrimMaxLen
+	TrimMinID
+)
+
+// NOTE: Redis stores stream entries in listpacks of (by default) 100 entries
+// and approximate trimming only ever evicts whole listpacks. We don't have
+// listpacks, but approximate trimming still evicts in batches of this size so
+// that it behaves the same from a client's perspective.
+const (
+	streamNodeMaxEntries = 100
+	defaultTrimLimit     = 100 * streamNodeMaxEntries
+)
+
+// TrimOptions mirrors the `<MAXLEN | MINID> [= | ~] threshold [LIMIT count]`
+// arguments of XADD and XTRIM.
+type TrimOptions struct {
+	Approx bool
+	// Without a LIMIT, approximate trimming evicts up to defaultTrimLimit
+	// entries, while LIMIT 0 means no limit at all
+	HasLimit bool
+	Limit    int
+	MaxLen   int
+	MinID    StreamID
+	Strategy TrimStrategy
 }
 
-func NewStream(id string, fields []*Record) (*Stream, error) {
-	id, err := resolveStreamID(id, "")
+func NewStream() *Stream {
+	return &Stream{
+		Root: &StreamNode{},
+	}
+}
+
+// Returns a copy of the stream, consumer groups and their pending entries
+// included. Entry fields are never modified once added, so they're shared.
+func (s *Stream) Clone() *Stream {
+	c := &Stream{
+		Root:         &StreamNode{},
+		entriesAdded: s.entriesAdded,
+		lastID:       s.lastID,
+		length:       s.length,
+		maxDeletedID: s.maxDeletedID,
+	}
+
+	for _, e := range s.Range(MinStreamID, MaxStreamID, 0, false) {
+		c.Root.insert(e.ID.key(), &StreamEntry{ID: e.ID, Fields: e.Fields})
+	}
+
+	if s.groups != nil {
+		c.groups = make(map[string]*ConsumerGroup, len(s.groups))
+		for name, g := range s.groups {
+			c.groups[name] = g.clone()
+		}
+	}
+
+	return c
+}
+
+func (s *Stream) Get(id string) (*StreamEntry, bool) {
+	sid, err := ParseStreamID(id, 0)
 	if err != nil {
-		log.Printf("%s new stream: resolve id: %v", ErrStreamPrefix, err)
-		return nil, fmt.Errorf("%s new stream: resolve id: %w", ErrStreamPrefix, err)
+		return nil, false
 	}
 
-	return &Stream{
-		lastID: id,
-		Root: &StreamNode{
-			Prefix: id,
-			IsLeaf: true,
-			Value: &StreamEntry{
-				ID:     id,
-				Fields: fields,
-			},
-		},
-	}, nil
-}
-
-func (s *Stream) Get(id string) (any, bool) {
+	return s.lookup(sid)
+}
+
+func (s *Stream) lookup(id StreamID) (*StreamEntry, bool) {
+	key := id.key()
 	node := s.Root
-	for len(id) > 0 {
-		child, _ := node.findChild(id[0])
+	for len(key) > 0 {
+		child, _ := node.findChild(key[0])
 		if child == nil {
 			return nil, false
 		}
 
-		shared := child.commonPrefixLen(id)
+		shared := child.commonPrefixLen(key)
 		if shared != len(child.Prefix) {
 			return nil, false
 		}
 
-		id = id[shared:]
+		key = key[shared:]
 		node = child
 	}
 
 	return node.Value, node.IsLeaf
 }
 
-func (s *Stream) Insert(id string, fields []*Record) error {
-	/* TODO:
-	*		1. Create StreamEntry from `fields` and `id`
-	*		2. append entry as child StreamNode at prefix with StreamEntry containing full ID
-	 */
+// Insert resolves `id` against the last ID of the stream (supporting the
+// explicit, `<ms>-*` and `*` forms) and stores the entry under the resolved
+// ID, which is returned.
+func (s *Stream) Insert(id string, fields []*Record) (StreamID, error) {
+	resolved, err := resolveStreamID(id, s.lastID)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("%s insert: resolve id: %w", ErrStreamPrefix, err)
+	}
+
+	s.Root.insert(resolved.key(), &StreamEntry{
+		ID:     resolved,
+		Fields: fields,
+	})
+	s.lastID = resolved
+	s.length++
+	s.entriesAdded++
 
-	return nil
+	return resolved, nil
 }
 
-type streamNodeId struct {
-	timestamp *int64
-	seq       *int64
+// Delete removes the entry with the given ID, reporting whether it existed.
+func (s *Stream) Delete(id StreamID) bool {
+	if !s.Root.remove(id.key()) {
+		return false
+	}
+
+	s.length--
+	if id.Compare(s.maxDeletedID) > 0 {
+		s.maxDeletedID = id
+	}
+
+	return true
 }
 
-func parseStreamID(id string) (*streamNodeId, error) {
-	if id == "" {
-		return &streamNodeId{}, nil
+// Trim evicts the oldest entries according to `opts`, returning how many were
+// evicted.
+func (s *Stream) Trim(opts *TrimOptions) int {
+	var toEvict int
+	switch opts.Strategy {
+	case TrimMaxLen:
+		toEvict = max(s.length-opts.MaxLen, 0)
+	case TrimMinID:
+		end, ok := opts.MinID.Prev()
+		if !ok {
+			return 0
+		}
+		toEvict = len(s.Range(MinStreamID, end, 0, false))
+	default:
+		return 0
+	}
+
+	if opts.Approx {
+		toEvict -= toEvict % streamNodeMaxEntries
+
+		limit := opts.Limit
+		if !opts.HasLimit {
+			limit = defaultTrimLimit
+		}
+		if limit > 0 {
+			toEvict = min(toEvict, limit)
+		}
+	}
+
+	if toEvict <= 0 {
+		return 0
+	}
+
+	for _, e := range s.Range(MinStreamID, MaxStreamID, toEvict, false) {
+		s.Delete(e.ID)
+	}
+
+	return toEvict
+}
+
+func (s *Stream) EntriesAdded() uint64 {
+	return s.entriesAdded
+}
+
+func (s *Stream) First() (*StreamEntry, bool) {
+	entries := s.Range(MinStreamID, MaxStreamID, 1, false)
+	if len(entries) == 0 {
+		return nil, false
+	}
+
+	return entries[0], true
+}
+
+func (s *Stream) Last() (*StreamEntry, bool) {
+	entries := s.Range(MinStreamID, MaxStreamID, 1, true)
+	if len(entries) == 0 {
+		return nil, false
+	}
+
+	return entries[0], true
+}
+
+func (s *Stream) LastID() StreamID {
+	return s.lastID
+}
+
+func (s *Stream) Len() int {
+	return s.length
+}
+
+func (s *Stream) MaxDeletedID() StreamID {
+	return s.maxDeletedID
+}
+
+// NodeCount returns the amount of nodes in the radix tree, root included.
+func (s *Stream) NodeCount() int {
+	return s.Root.count()
+}
+
+// Range returns the entries whose IDs fall within the inclusive [start, end]
+// interval, ordered by ID (or in reverse when `rev` is set). A `count` of
+// zero means there is no limit on the amount of entries returned.
+func (s *Stream) Range(start, end StreamID, count int, rev bool) []*StreamEntry {
+	entries := make([]*StreamEntry, 0)
+	if start.Compare(end) > 0 {
+		return entries
+	}
+
+	s.Root.walk("", start.key(), end.key(), rev, func(e *StreamEntry) bool {
+		entries = append(entries, e)
+		return count <= 0 || len(entries) < count
+	})
+
+	return entries
+}
+
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	MinStreamID = StreamID{}
+	MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+// ParseStreamID parses a complete `<ms>-<seq>` ID. When the sequence part is
+// omitted `defaultSeq` is used in its place.
+func ParseStreamID(id string, defaultSeq uint64) (StreamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(id, "-")
+
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("%s parse stream id: timestamp: %w", ErrStreamPrefix, ErrStreamIDInvalid)
+	}
+
+	if !hasSeq {
+		return StreamID{Ms: ms, Seq: defaultSeq}, nil
+	}
+
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return StreamID{}, fmt.Errorf("%s parse stream id: sequence: %w", ErrStreamPrefix, ErrStreamIDInvalid)
 	}
 
+	return StreamID{Ms: ms, Seq: seq}, nil
+}
+
+func (id StreamID) Compare(other StreamID) int {
+	switch {
+	case id.Ms < other.Ms:
+		return -1
+	case id.Ms > other.Ms:
+		return 1
+	case id.Seq < other.Seq:
+		return -1
+	case id.Seq > other.Seq:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Next returns the smallest ID greater than `id`. False is returned when
+// `id` is already the maximum possible ID.
+func (id StreamID) Next() (StreamID, bool) {
+	if id.Seq == math.MaxUint64 {
+		if id.Ms == math.MaxUint64 {
+			return id, false
+		}
+		return StreamID{Ms: id.Ms + 1}, true
+	}
+
+	return StreamID{Ms: id.Ms, Seq: id.Seq + 1}, true
+}
+
+// Prev returns the greatest ID smaller than `id`. False is returned when
+// `id` is `0-0`.
+func (id StreamID) Prev() (StreamID, bool) {
+	if id.Seq == 0 {
+		if id.Ms == 0 {
+			return id, false
+		}
+		return StreamID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+
+	return StreamID{Ms: id.Ms, Seq: id.Seq - 1}, true
+}
+
+func (id StreamID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// NOTE: The radix tree is keyed on the big-endian bytes of the ID rather than
+// its string form. Decimal strings don't sort numerically ("10-0" < "9-0"),
+// whereas fixed width big-endian bytes do, which keeps children ordered.
+func (id StreamID) key() string {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], id.Ms)
+	binary.BigEndian.PutUint64(b[8:], id.Seq)
+	return string(b[:])
+}
+
+type streamNodeId struct {
+	timestamp *uint64
+	seq       *uint64
+}
+
+func parseStreamID(id string) (*streamNodeId, error) {
 	split := strings.Split(id, "-")
 	snId := &streamNodeId{}
 
@@ -126,9 +397,9 @@ func parseStreamID(id string) (*streamNodeId, error) {
 			return snId, nil
 		}
 
-		timestamp, err := strconv.ParseInt(split[0], 10, 0)
+		timestamp, err := strconv.ParseUint(split[0], 10, 64)
 		if err != nil {
-			return nil, fmt.Errorf("%s parse stream id: timestamp: %w", ErrStreamPrefix, err)
+			return nil, fmt.Errorf("%s parse stream id: timestamp: %w", ErrStreamPrefix, ErrStreamIDInvalid)
 		}
 
 		snId.timestamp = &timestamp
@@ -136,9 +407,9 @@ func parseStreamID(id string) (*streamNodeId, error) {
 	}
 
 	if len(split) == 2 {
-		timestamp, err := strconv.ParseInt(split[0], 10, 0)
+		timestamp, err := strconv.ParseUint(split[0], 10, 64)
 		if err != nil {
-			return nil, fmt.Errorf("%s parse stream id: timestamp: %w", ErrStreamPrefix, err)
+			return nil, fmt.Errorf("%s parse stream id: timestamp: %w", ErrStreamPrefix, ErrStreamIDInvalid)
 		}
 		snId.timestamp = &timestamp
 
@@ -146,79 +417,75 @@ func parseStreamID(id string) (*streamNodeId, error) {
 			return snId, nil
 		}
 
-		seq, err := strconv.ParseInt(split[1], 10, 0)
+		seq, err := strconv.ParseUint(split[1], 10, 64)
 		if err != nil {
-			return nil, fmt.Errorf("%s parse stream id: sequence: %w", ErrStreamPrefix, err)
+			return nil, fmt.Errorf("%s parse stream id: sequence: %w", ErrStreamPrefix, ErrStreamIDInvalid)
 		}
 		snId.seq = &seq
 
 		return snId, nil
 	}
 
-	return nil, fmt.Errorf("%s parse stream id: incorrect id format: %s", ErrStreamPrefix, id)
+	return nil, fmt.Errorf("%s parse stream id: incorrect id format: %s: %w", ErrStreamPrefix, id, ErrStreamIDInvalid)
 }
 
-func resolveStreamID(id string, lastId string) (string, error) {
-	now := time.Now().UnixMilli()
-
+// NOTE: An empty stream has a last ID of `0-0`, which is also why `0-0` can
+// never be added to a stream.
+func resolveStreamID(id string, lastID StreamID) (StreamID, error) {
 	snId, err := parseStreamID(id)
 	if err != nil {
-		return "", err
-	}
-
-	lastSnId, err := parseStreamID(lastId)
-	if err != nil {
-		return "", err
-	}
-
-	// This is to cover the case where there isn't a lastID
-	// so that the rest of the calculation can still work.
-	if lastSnId.timestamp == nil {
-		ts := int64(0)
-		seq := int64(-1)
-		lastSnId.timestamp = &ts
-		lastSnId.seq = &seq
+		return StreamID{}, err
 	}
 
 	// Full * Scenario
 	if snId.timestamp == nil {
-		if now > *lastSnId.timestamp {
-			return fmt.Sprintf("%d-%d", now, 0), nil
+		now := uint64(time.Now().UnixMilli())
+		if now > lastID.Ms {
+			return StreamID{Ms: now}, nil
 		}
 
-		return fmt.Sprintf("%d-%d", *lastSnId.timestamp, *lastSnId.seq+1), nil
+		next, ok := lastID.Next()
+		if !ok {
+			return StreamID{}, ErrStreamIDTooSmall
+		}
+		return next, nil
 	}
 
-	if snId.timestamp != nil && *snId.timestamp < *lastSnId.timestamp {
-		return "", fmt.Errorf("provided ID timestamp for stream is older than current stream ID timestamp")
+	// Redis does not support ID of `0-0`
+	if *snId.timestamp == 0 && snId.seq != nil && *snId.seq == 0 {
+		return StreamID{}, ErrStreamIDZero
+	}
+
+	ts := *snId.timestamp
+	if ts < lastID.Ms {
+		return StreamID{}, ErrStreamIDTooSmall
 	}
 
 	// Partial * Scenario
 	if snId.seq == nil {
-		if *snId.timestamp > *lastSnId.timestamp {
-			return fmt.Sprintf("%d-%d", *snId.timestamp, 0), nil
+		if ts > lastID.Ms {
+			return StreamID{Ms: ts}, nil
 		}
 
-		if *snId.timestamp == *lastSnId.timestamp {
-			return fmt.Sprintf("%d-%d", *snId.timestamp, *lastSnId.seq+1), nil
+		// NOTE: Unlike the full `*` form the timestamp is fixed here, so an
+		// exhausted sequence can't roll over into the next millisecond.
+		if lastID.Seq == math.MaxUint64 {
+			return StreamID{}, ErrStreamIDTooSmall
 		}
+		return StreamID{Ms: ts, Seq: lastID.Seq + 1}, nil
 	}
 
 	// Explicit Scenario
-	if *snId.timestamp == *lastSnId.timestamp && snId.seq != nil && *snId.seq <= *lastSnId.seq {
-		return "", fmt.Errorf("provided ID sequence for stream is older than current stream ID sequence")
+	resolved := StreamID{Ms: ts, Seq: *snId.seq}
+	if resolved.Compare(lastID) <= 0 {
+		return StreamID{}, ErrStreamIDTooSmall
 	}
 
-	// Redis does not support ID of `0-0`
-	if *snId.timestamp == 0 && *snId.seq == 0 {
-		return "", fmt.Errorf("invalid ID")
-	}
-
-	return fmt.Sprintf("%d-%d", *snId.timestamp, *snId.seq), nil
+	return resolved, nil
 }
 
 type StreamEntry struct {
-	ID     string
+	ID     StreamID
 	Fields []*Record
 }
 
@@ -262,3 +529,134 @@ func (sn *StreamNode) findChild(b byte) (*StreamNode, int) {
 	// Not found -- lo is where it *would* be inserted
 	return nil, lo
 }
+
+/*
+* Walks down the tree consuming `key`. When a child only partially shares
+* its prefix with what's left of the key, that child is split in two: a new
+* node holding the shared part, with the old child (minus the shared part)
+* as its only child. The remainder of the key then becomes a sibling, placed
+* at the index `findChild` reports so that children stay sorted by first byte.
+ */
+func (sn *StreamNode) insert(key string, entry *StreamEntry) {
+	node := sn
+	for len(key) > 0 {
+		child, idx := node.findChild(key[0])
+		if child == nil {
+			node.Children = slices.Insert(node.Children, idx, &StreamNode{
+				Prefix: key,
+				Value:  entry,
+				IsLeaf: true,
+			})
+			return
+		}
+
+		shared := child.commonPrefixLen(key)
+		if shared < len(child.Prefix) {
+			split := &StreamNode{
+				Prefix:   child.Prefix[:shared],
+				Children: []*StreamNode{child},
+			}
+			child.Prefix = child.Prefix[shared:]
+			node.Children[idx] = split
+			child = split
+		}
+
+		key = key[shared:]
+		node = child
+	}
+
+	node.Value = entry
+	node.IsLeaf = true
+}
+
+/*
+* Visits, in key order, every leaf whose full key falls within [lo, hi].
+* Because all keys share the same width, a node whose accumulated path is
+* smaller than the same length prefix of `lo` (or bigger than that of `hi`)
+* can be skipped along with its whole subtree. `fn` returning false stops the
+* walk, which is reported back up the recursion by returning false as well.
+ */
+func (sn *StreamNode) walk(path, lo, hi string, rev bool, fn func(*StreamEntry) bool) bool {
+	path += sn.Prefix
+	if path < lo[:min(len(path), len(lo))] || path > hi[:min(len(path), len(hi))] {
+		return true
+	}
+
+	if !rev && sn.IsLeaf && !fn(sn.Value) {
+		return false
+	}
+
+	for i := range sn.Children {
+		child := sn.Children[i]
+		if rev {
+			child = sn.Children[len(sn.Children)-1-i]
+		}
+
+		if !child.walk(path, lo, hi, rev, fn) {
+			return false
+		}
+	}
+
+	if rev && sn.IsLeaf && !fn(sn.Value) {
+		return false
+	}
+
+	return true
+}
+
+/*
+* Removes the leaf at `key`, which is relative to `sn`, reporting whether it
+* existed. On the way back up the recursion the tree is kept compressed:
+* children left without an entry or children are dropped, and children left
+* without an entry but with a single child are merged with that child.
+ */
+func (sn *StreamNode) remove(key string) bool {
+	if len(key) == 0 {
+		return false
+	}
+
+	child, idx := sn.findChild(key[0])
+	if child == nil {
+		return false
+	}
+
+	shared := child.commonPrefixLen(key)
+	if shared != len(child.Prefix) {
+		return false
+	}
+
+	rest := key[shared:]
+	if len(rest) == 0 {
+		if !child.IsLeaf {
+			return false
+		}
+		child.IsLeaf = false
+		child.Value = nil
+	} else if !child.remove(rest) {
+		return false
+	}
+
+	if child.IsLeaf {
+		return true
+	}
+
+	switch len(child.Children) {
+	case 0:
+		sn.Children = slices.Delete(sn.Children, idx, idx+1)
+	case 1:
+		grandchild := child.Children[0]
+		grandchild.Prefix = child.Prefix + grandchild.Prefix
+		sn.Children[idx] = grandchild
+	}
+
+	return true
+}
+
+func (sn *StreamNode) count() int {
+	n := 1
+	for _, c := range sn.Children {
+		n += c.count()
+	}
+
+	return n
+}
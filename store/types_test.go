@@ -0,0 +1,288 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStreamNodeInsertSplitsSharedPrefix(t *testing.T) {
+	root := &StreamNode{}
+	root.insert("abcd", &StreamEntry{ID: StreamID{Ms: 1}})
+	root.insert("abxy", &StreamEntry{ID: StreamID{Ms: 2}})
+
+	if len(root.Children) != 1 {
+		t.Fatalf("expected a single shared child, got %d", len(root.Children))
+	}
+
+	split := root.Children[0]
+	if split.Prefix != "ab" || split.IsLeaf {
+		t.Fatalf("expected non-leaf split node with prefix %q, got %q (leaf: %t)", "ab", split.Prefix, split.IsLeaf)
+	}
+
+	if len(split.Children) != 2 {
+		t.Fatalf("expected split node to have 2 children, got %d", len(split.Children))
+	}
+
+	if split.Children[0].Prefix != "cd" || split.Children[1].Prefix != "xy" {
+		t.Fatalf("unexpected children prefixes: %q, %q", split.Children[0].Prefix, split.Children[1].Prefix)
+	}
+
+	// Splitting a node that was itself created by a split
+	root.insert("abcz", &StreamEntry{ID: StreamID{Ms: 3}})
+	c := split.Children[0]
+	if c.Prefix != "c" || len(c.Children) != 2 {
+		t.Fatalf("expected nested split with prefix %q and 2 children, got %q with %d", "c", c.Prefix, len(c.Children))
+	}
+	if c.Children[0].Prefix != "d" || c.Children[1].Prefix != "z" {
+		t.Fatalf("unexpected nested children prefixes: %q, %q", c.Children[0].Prefix, c.Children[1].Prefix)
+	}
+}
+
+func TestStreamNodeFindChildOrdering(t *testing.T) {
+	root := &StreamNode{}
+	for _, k := range []string{"m", "c", "x", "a", "p"} {
+		root.insert(k, &StreamEntry{})
+	}
+
+	want := "acmpx"
+	for i, c := range root.Children {
+		if c.Prefix[0] != want[i] {
+			t.Fatalf("children out of order at %d: got %q want %q", i, c.Prefix[0], want[i])
+		}
+	}
+
+	for i := range len(want) {
+		child, idx := root.findChild(want[i])
+		if child == nil || idx != i {
+			t.Fatalf("findChild(%q) = (%v, %d), want index %d", want[i], child, idx, i)
+		}
+	}
+
+	if child, idx := root.findChild('b'); child != nil || idx != 1 {
+		t.Fatalf("findChild('b') = (%v, %d), want (nil, 1)", child, idx)
+	}
+	if child, idx := root.findChild('z'); child != nil || idx != len(want) {
+		t.Fatalf("findChild('z') = (%v, %d), want (nil, %d)", child, idx, len(want))
+	}
+}
+
+func TestStreamInsertResolvesIDs(t *testing.T) {
+	s := NewStream()
+
+	id, err := s.Insert("1-1", nil)
+	if err != nil || id != (StreamID{Ms: 1, Seq: 1}) {
+		t.Fatalf("explicit insert: got (%v, %v)", id, err)
+	}
+
+	id, err = s.Insert("1-*", nil)
+	if err != nil || id != (StreamID{Ms: 1, Seq: 2}) {
+		t.Fatalf("partial insert on same ms: got (%v, %v)", id, err)
+	}
+
+	id, err = s.Insert("5-*", nil)
+	if err != nil || id != (StreamID{Ms: 5, Seq: 0}) {
+		t.Fatalf("partial insert on newer ms: got (%v, %v)", id, err)
+	}
+
+	if _, err = s.Insert("5-0", nil); !errors.Is(err, ErrStreamIDTooSmall) {
+		t.Fatalf("equal id: expected ErrStreamIDTooSmall, got %v", err)
+	}
+
+	if _, err = s.Insert("4-9", nil); !errors.Is(err, ErrStreamIDTooSmall) {
+		t.Fatalf("smaller id: expected ErrStreamIDTooSmall, got %v", err)
+	}
+
+	id, err = s.Insert("*", nil)
+	if err != nil || id.Compare(StreamID{Ms: 5, Seq: 0}) <= 0 {
+		t.Fatalf("auto insert: got (%v, %v)", id, err)
+	}
+
+	if _, err = NewStream().Insert("0-0", nil); !errors.Is(err, ErrStreamIDZero) {
+		t.Fatalf("0-0: expected ErrStreamIDZero, got %v", err)
+	}
+
+	id, err = NewStream().Insert("0-*", nil)
+	if err != nil || id != (StreamID{Ms: 0, Seq: 1}) {
+		t.Fatalf("0-*: got (%v, %v)", id, err)
+	}
+
+	s = NewStream()
+	if _, err = s.Insert("7-18446744073709551615", nil); err != nil {
+		t.Fatalf("max seq insert: %v", err)
+	}
+	if _, err = s.Insert("7-*", nil); !errors.Is(err, ErrStreamIDTooSmall) {
+		t.Fatalf("partial insert on exhausted seq: expected ErrStreamIDTooSmall, got %v", err)
+	}
+	id, err = s.Insert("8-*", nil)
+	if err != nil || id != (StreamID{Ms: 8, Seq: 0}) {
+		t.Fatalf("partial insert after exhausted seq: got (%v, %v)", id, err)
+	}
+}
+
+func TestStreamGetNumericOrdering(t *testing.T) {
+	s := NewStream()
+	for _, id := range []string{"9-0", "10-0", "10-9", "10-10", "100-1"} {
+		if _, err := s.Insert(id, nil); err != nil {
+			t.Fatalf("insert %s: %v", id, err)
+		}
+	}
+
+	for _, id := range []string{"9-0", "10-0", "10-9", "10-10", "100-1"} {
+		entry, ok := s.Get(id)
+		if !ok || entry.ID.String() != id {
+			t.Fatalf("get %s: got (%v, %t)", id, entry, ok)
+		}
+	}
+
+	if _, ok := s.Get("10-1"); ok {
+		t.Fatalf("get 10-1: expected miss")
+	}
+}
+
+func TestStreamRange(t *testing.T) {
+	s := NewStream()
+	ids := []string{"1-1", "1-2", "2-0", "9-0", "10-0", "256-3"}
+	for _, id := range ids {
+		if _, err := s.Insert(id, nil); err != nil {
+			t.Fatalf("insert %s: %v", id, err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		start, end StreamID
+		count      int
+		rev        bool
+		want       []string
+	}{
+		{"full", MinStreamID, MaxStreamID, 0, false, ids},
+		{"full reversed", MinStreamID, MaxStreamID, 0, true, []string{"256-3", "10-0", "9-0", "2-0", "1-2", "1-1"}},
+		{"bounded", StreamID{Ms: 1, Seq: 2}, StreamID{Ms: 10}, 0, false, []string{"1-2", "2-0", "9-0", "10-0"}},
+		{"count", MinStreamID, MaxStreamID, 2, false, []string{"1-1", "1-2"}},
+		{"count reversed", StreamID{Ms: 2}, MaxStreamID, 2, true, []string{"256-3", "10-0"}},
+		{"empty interval", StreamID{Ms: 3}, StreamID{Ms: 8}, 0, false, []string{}},
+		{"inverted interval", StreamID{Ms: 10}, StreamID{Ms: 1}, 0, false, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.Range(tt.start, tt.end, tt.count, tt.rev)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d", len(got), len(tt.want))
+			}
+			for i, e := range got {
+				if e.ID.String() != tt.want[i] {
+					t.Fatalf("entry %d: got %s want %s", i, e.ID.String(), tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// Lists the prefixes of the tree depth first, marking leaves with a `*` and
+// closing each node's children with `)`.
+func treeShape(sn *StreamNode) string {
+	var b strings.Builder
+	for _, c := range sn.Children {
+		b.WriteString(c.Prefix)
+		if c.IsLeaf {
+			b.WriteByte('*')
+		}
+		if len(c.Children) > 0 {
+			b.WriteByte('(')
+			b.WriteString(treeShape(c))
+			b.WriteByte(')')
+		}
+		b.WriteByte(' ')
+	}
+
+	return strings.TrimSpace(b.String())
+}
+
+func TestStreamNodeRemoveMergesNodes(t *testing.T) {
+	root := &StreamNode{}
+	for _, k := range []string{"abcd", "abxy", "abcz", "ab"} {
+		root.insert(k, &StreamEntry{})
+	}
+
+	if got := treeShape(root); got != "ab*(c(d* z*) xy*)" {
+		t.Fatalf("before removing: got %q", got)
+	}
+
+	tests := []struct {
+		key  string
+		ok   bool
+		want string
+	}{
+		// Missing keys, including a prefix that isn't a leaf
+		{"zz", false, "ab*(c(d* z*) xy*)"},
+		{"abc", false, "ab*(c(d* z*) xy*)"},
+		{"abcdx", false, "ab*(c(d* z*) xy*)"},
+		// A node left with a single child is merged into it
+		{"abcz", true, "ab*(cd* xy*)"},
+		// So is a leaf with a single child that stops being a leaf
+		{"ab", true, "ab(cd* xy*)"},
+		{"abxy", true, "abcd*"},
+		{"abcd", true, ""},
+		{"abcd", false, ""},
+	}
+
+	for _, tt := range tests {
+		if ok := root.remove(tt.key); ok != tt.ok {
+			t.Fatalf("remove %q: got %t, want %t", tt.key, ok, tt.ok)
+		}
+
+		if got := treeShape(root); got != tt.want {
+			t.Fatalf("after removing %q: got %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestStreamTrim(t *testing.T) {
+	newStream := func(n int) *Stream {
+		s := NewStream()
+		for i := 1; i <= n; i++ {
+			if _, err := s.Insert(StreamID{Ms: uint64(i)}.String(), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return s
+	}
+
+	tests := []struct {
+		name    string
+		entries int
+		opts    TrimOptions
+		evicted int
+	}{
+		{"maxlen", 250, TrimOptions{Strategy: TrimMaxLen, MaxLen: 100}, 150},
+		{"maxlen above length", 250, TrimOptions{Strategy: TrimMaxLen, MaxLen: 300}, 0},
+		{"approx maxlen evicts whole nodes", 250, TrimOptions{Strategy: TrimMaxLen, MaxLen: 100, Approx: true}, 100},
+		{"approx maxlen under a node", 250, TrimOptions{Strategy: TrimMaxLen, MaxLen: 200, Approx: true}, 0},
+		{"minid", 250, TrimOptions{Strategy: TrimMinID, MinID: StreamID{Ms: 51}}, 50},
+		{"minid below first", 250, TrimOptions{Strategy: TrimMinID, MinID: MinStreamID}, 0},
+		{"approx minid", 250, TrimOptions{Strategy: TrimMinID, MinID: StreamID{Ms: 151}, Approx: true}, 100},
+		{"limit", 250, TrimOptions{Strategy: TrimMaxLen, Approx: true, HasLimit: true, Limit: 100}, 100},
+		{"default limit", 20100, TrimOptions{Strategy: TrimMaxLen, Approx: true}, defaultTrimLimit},
+		{"limit 0 is unlimited", 20100, TrimOptions{Strategy: TrimMaxLen, Approx: true, HasLimit: true}, 20100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStream(tt.entries)
+			if evicted := s.Trim(&tt.opts); evicted != tt.evicted {
+				t.Fatalf("evicted %d, want %d", evicted, tt.evicted)
+			}
+
+			if s.Len() != tt.entries-tt.evicted {
+				t.Fatalf("%d entries left, want %d", s.Len(), tt.entries-tt.evicted)
+			}
+
+			// The oldest entries are the ones evicted
+			if first, ok := s.First(); ok && first.ID != (StreamID{Ms: uint64(tt.evicted + 1)}) {
+				t.Fatalf("first entry left is %v", first.ID)
+			}
+		})
+	}
+}
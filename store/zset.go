@@ -0,0 +1,597 @@
+package store
+
+import (
+	"errors"
+	"iter"
+	"maps"
+	"math"
+	"math/rand/v2"
+)
+
+var ErrScoreNaN = errors.New("resulting score is not a number (NaN)")
+
+// Sorted sets pair a dictionary, for O(1) score lookups by member, with a
+// skiplist ordered by (score, member) for everything positional: ranks,
+// ranges and pops, and a keyIndex for ZSCAN. All of them always hold exactly
+// the same members.
+type SortedSet struct {
+	dict  map[string]float64
+	zsl   *skiplist
+	index *keyIndex
+}
+
+type ZMember struct {
+	Member string
+	Score  float64
+}
+
+// Flags for ZADD. NX/XX gate on whether the member already exists, GT/LT on
+// how the new score compares to the current one, and Incr adds the given
+// score to the current one instead of replacing it.
+type ZAddFlags struct {
+	GT   bool
+	Incr bool
+	LT   bool
+	NX   bool
+	XX   bool
+}
+
+type ZAddStatus int
+
+const (
+	// The flags ruled the update out
+	ZAddSkipped ZAddStatus = iota
+	// The member already had the resulting score
+	ZAddUnchanged
+	ZAddAdded
+	ZAddUpdated
+)
+
+// An interval over scores, with either end optionally exclusive, as given
+// to ZRANGE BYSCORE as `(1.5` or `-inf`.
+type ScoreRange struct {
+	Min, Max     float64
+	MinExclusive bool
+	MaxExclusive bool
+}
+
+func (r *ScoreRange) aboveMin(score float64) bool {
+	if r.MinExclusive {
+		return score > r.Min
+	}
+	return score >= r.Min
+}
+
+func (r *ScoreRange) belowMax(score float64) bool {
+	if r.MaxExclusive {
+		return score < r.Max
+	}
+	return score <= r.Max
+}
+
+func (r *ScoreRange) empty() bool {
+	return r.Min > r.Max || (r.Min == r.Max && (r.MinExclusive || r.MaxExclusive))
+}
+
+// One end of a lexicographical interval, as given to ZRANGE BYLEX as
+// `[a`, `(a`, `-` or `+`. Inf is -1 for `-` and 1 for `+`, which sort
+// before and after every member respectively.
+type LexBound struct {
+	Exclusive bool
+	Inf       int
+	Value     string
+}
+
+type LexRange struct {
+	Min, Max LexBound
+}
+
+func (r *LexRange) aboveMin(member string) bool {
+	switch {
+	case r.Min.Inf < 0:
+		return true
+	case r.Min.Inf > 0:
+		return false
+	case r.Min.Exclusive:
+		return member > r.Min.Value
+	default:
+		return member >= r.Min.Value
+	}
+}
+
+func (r *LexRange) belowMax(member string) bool {
+	switch {
+	case r.Max.Inf > 0:
+		return true
+	case r.Max.Inf < 0:
+		return false
+	case r.Max.Exclusive:
+		return member < r.Max.Value
+	default:
+		return member <= r.Max.Value
+	}
+}
+
+func NewSortedSet() *SortedSet {
+	return &SortedSet{
+		dict:  make(map[string]float64),
+		zsl:   newSkiplist(),
+		index: newKeyIndex(),
+	}
+}
+
+// Returns a copy of the sorted set that shares nothing with the original.
+func (z *SortedSet) Clone() *SortedSet {
+	c := NewSortedSet()
+	for x := z.zsl.header.level[0].forward; x != nil; x = x.level[0].forward {
+		c.dict[x.member] = x.score
+		c.zsl.insert(x.score, x.member)
+		c.index.add(x.member)
+	}
+
+	return c
+}
+
+// Adds or updates the member according to `flags`, returning its resulting
+// score and what happened. Nil flags behave like a plain ZADD.
+func (z *SortedSet) Add(member string, score float64, flags *ZAddFlags) (float64, ZAddStatus, error) {
+	if flags == nil {
+		flags = &ZAddFlags{}
+	}
+
+	current, exists := z.dict[member]
+	if (exists && flags.NX) || (!exists && flags.XX) {
+		return current, ZAddSkipped, nil
+	}
+
+	if flags.Incr && exists {
+		score += current
+		if math.IsNaN(score) {
+			return current, ZAddSkipped, ErrScoreNaN
+		}
+	}
+
+	if !exists {
+		z.dict[member] = score
+		z.zsl.insert(score, member)
+		z.index.add(member)
+		return score, ZAddAdded, nil
+	}
+
+	if (flags.GT && score <= current) || (flags.LT && score >= current) {
+		return current, ZAddSkipped, nil
+	}
+
+	if score == current {
+		return current, ZAddUnchanged, nil
+	}
+
+	z.zsl.delete(current, member)
+	z.zsl.insert(score, member)
+	z.dict[member] = score
+
+	return score, ZAddUpdated, nil
+}
+
+// Counts the members whose score falls within `r`
+func (z *SortedSet) CountByScore(r *ScoreRange) int {
+	first := z.zsl.firstInScoreRange(r)
+	if first == nil {
+		return 0
+	}
+
+	last := z.zsl.lastInScoreRange(r)
+	return z.zsl.rank(last.score, last.member) - z.zsl.rank(first.score, first.member) + 1
+}
+
+func (z *SortedSet) Len() int {
+	return len(z.dict)
+}
+
+// Iterates over the members in no particular order.
+func (z *SortedSet) Members() iter.Seq[string] {
+	return maps.Keys(z.dict)
+}
+
+// Removes and returns up to `count` members from the low end, or the high
+// end when `highest` is set.
+func (z *SortedSet) Pop(count int, highest bool) []ZMember {
+	popped := z.RangeByRank(0, count-1, highest)
+	for _, m := range popped {
+		z.Remove(m.Member)
+	}
+
+	return popped
+}
+
+// Returns the members between the 0-based ranks `start` and `stop`, both
+// inclusive and already normalized to be non-negative. Ranks count from the
+// highest score when `rev` is set.
+func (z *SortedSet) RangeByRank(start, stop int, rev bool) []ZMember {
+	length := z.zsl.length
+	stop = min(stop, length-1)
+	if start > stop || start >= length {
+		return []ZMember{}
+	}
+
+	result := make([]ZMember, 0, stop-start+1)
+	var x *skiplistNode
+	if rev {
+		x = z.zsl.byRank(length - start)
+	} else {
+		x = z.zsl.byRank(start + 1)
+	}
+
+	for i := start; i <= stop && x != nil; i++ {
+		result = append(result, ZMember{Member: x.member, Score: x.score})
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+
+	return result
+}
+
+// Returns the members within `r`, in descending order when `rev` is set,
+// after skipping `offset` of them. A negative `count` means no limit.
+func (z *SortedSet) RangeByScore(r *ScoreRange, rev bool, offset, count int) []ZMember {
+	var x *skiplistNode
+	if rev {
+		x = z.zsl.lastInScoreRange(r)
+	} else {
+		x = z.zsl.firstInScoreRange(r)
+	}
+
+	return z.collect(x, rev, offset, count, func(n *skiplistNode) bool {
+		return r.aboveMin(n.score) && r.belowMax(n.score)
+	})
+}
+
+// Returns the members within `r`, in descending order when `rev` is set,
+// after skipping `offset` of them. A negative `count` means no limit. Only
+// meaningful when every member has the same score.
+func (z *SortedSet) RangeByLex(r *LexRange, rev bool, offset, count int) []ZMember {
+	var x *skiplistNode
+	if rev {
+		x = z.zsl.lastInLexRange(r)
+	} else {
+		x = z.zsl.firstInLexRange(r)
+	}
+
+	return z.collect(x, rev, offset, count, func(n *skiplistNode) bool {
+		return r.aboveMin(n.member) && r.belowMax(n.member)
+	})
+}
+
+// Returns the member's 0-based rank, counting from the highest score when
+// `rev` is set.
+func (z *SortedSet) Rank(member string, rev bool) (int, bool) {
+	score, exists := z.dict[member]
+	if !exists {
+		return 0, false
+	}
+
+	rank := z.zsl.rank(score, member)
+	if rev {
+		return z.zsl.length - rank, true
+	}
+
+	return rank - 1, true
+}
+
+func (z *SortedSet) Remove(member string) bool {
+	score, exists := z.dict[member]
+	if !exists {
+		return false
+	}
+
+	z.zsl.delete(score, member)
+	delete(z.dict, member)
+	z.index.remove(member)
+	return true
+}
+
+// Removes the members returned by the equivalent range query, returning
+// how many there were.
+func (z *SortedSet) RemoveRangeByLex(r *LexRange) int {
+	return z.removeAll(z.RangeByLex(r, false, 0, -1))
+}
+
+func (z *SortedSet) RemoveRangeByRank(start, stop int) int {
+	return z.removeAll(z.RangeByRank(start, stop, false))
+}
+
+func (z *SortedSet) RemoveRangeByScore(r *ScoreRange) int {
+	return z.removeAll(z.RangeByScore(r, false, 0, -1))
+}
+
+// Returns the `count` or so members following `cursor`, and the cursor to
+// continue from, walking the sorted set the same way Store.Scan walks the
+// keyspace.
+func (z *SortedSet) Scan(cursor uint64, count int) (uint64, []string) {
+	return z.index.collect(cursor, count)
+}
+
+func (z *SortedSet) Score(member string) (float64, bool) {
+	score, exists := z.dict[member]
+	return score, exists
+}
+
+func (z *SortedSet) collect(x *skiplistNode, rev bool, offset, count int, inRange func(*skiplistNode) bool) []ZMember {
+	result := []ZMember{}
+	for ; x != nil && count != 0 && inRange(x); offset-- {
+		if offset <= 0 {
+			result = append(result, ZMember{Member: x.member, Score: x.score})
+			count--
+		}
+
+		if rev {
+			x = x.backward
+		} else {
+			x = x.level[0].forward
+		}
+	}
+
+	return result
+}
+
+func (z *SortedSet) removeAll(members []ZMember) int {
+	for _, m := range members {
+		z.Remove(m.Member)
+	}
+
+	return len(members)
+}
+
+const (
+	skiplistMaxLevel = 32
+	// Chance of a node being promoted to each further level
+	skiplistP = 0.25
+)
+
+// A skiplist ordered by (score, member), where every forward link also
+// records its span, the number of level-0 nodes it skips, so ranks can be
+// computed while descending rather than by walking the bottom level.
+type skiplist struct {
+	header *skiplistNode
+	tail   *skiplistNode
+	length int
+	level  int
+}
+
+type skiplistNode struct {
+	member   string
+	score    float64
+	backward *skiplistNode
+	level    []skiplistLevel
+}
+
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{
+		header: &skiplistNode{level: make([]skiplistLevel, skiplistMaxLevel)},
+		level:  1,
+	}
+}
+
+func randomSkiplistLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+
+	return level
+}
+
+// Whether the node sorts strictly before (score, member)
+func (n *skiplistNode) before(score float64, member string) bool {
+	return n.score < score || (n.score == score && n.member < member)
+}
+
+// Whether the node sorts strictly after (score, member)
+func (n *skiplistNode) after(score float64, member string) bool {
+	return n.score > score || (n.score == score && n.member > member)
+}
+
+func (sl *skiplist) insert(score float64, member string) {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		if i < sl.level-1 {
+			rank[i] = rank[i+1]
+		}
+
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			rank[i] += x.level[i].span
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	level := randomSkiplistLevel()
+	if level > sl.level {
+		for i := sl.level; i < level; i++ {
+			update[i] = sl.header
+			update[i].level[i].span = sl.length
+		}
+		sl.level = level
+	}
+
+	x = &skiplistNode{member: member, score: score, level: make([]skiplistLevel, level)}
+	for i := range level {
+		x.level[i].forward = update[i].level[i].forward
+		update[i].level[i].forward = x
+
+		x.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = rank[0] - rank[i] + 1
+	}
+
+	// Levels above the new node now skip over one more node
+	for i := level; i < sl.level; i++ {
+		update[i].level[i].span++
+	}
+
+	if update[0] != sl.header {
+		x.backward = update[0]
+	}
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x
+	} else {
+		sl.tail = x
+	}
+
+	sl.length++
+}
+
+func (sl *skiplist) delete(score float64, member string) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && x.level[i].forward.before(score, member) {
+			x = x.level[i].forward
+		}
+		update[i] = x
+	}
+
+	x = x.level[0].forward
+	if x == nil || x.score != score || x.member != member {
+		return false
+	}
+
+	for i := range sl.level {
+		if update[i].level[i].forward == x {
+			update[i].level[i].span += x.level[i].span - 1
+			update[i].level[i].forward = x.level[i].forward
+		} else {
+			update[i].level[i].span--
+		}
+	}
+
+	if x.level[0].forward != nil {
+		x.level[0].forward.backward = x.backward
+	} else {
+		sl.tail = x.backward
+	}
+
+	for sl.level > 1 && sl.header.level[sl.level-1].forward == nil {
+		sl.level--
+	}
+
+	sl.length--
+	return true
+}
+
+// 1-based rank of the node, or 0 when it isn't in the list
+func (sl *skiplist) rank(score float64, member string) int {
+	rank := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !x.level[i].forward.after(score, member) {
+			rank += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		if x != sl.header && x.score == score && x.member == member {
+			return rank
+		}
+	}
+
+	return 0
+}
+
+// Node at the 1-based rank
+func (sl *skiplist) byRank(rank int) *skiplistNode {
+	traversed := 0
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && traversed+x.level[i].span <= rank {
+			traversed += x.level[i].span
+			x = x.level[i].forward
+		}
+
+		if traversed == rank {
+			return x
+		}
+	}
+
+	return nil
+}
+
+func (sl *skiplist) firstInScoreRange(r *ScoreRange) *skiplistNode {
+	if r.empty() {
+		return nil
+	}
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.aboveMin(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+
+	x = x.level[0].forward
+	if x == nil || !r.belowMax(x.score) {
+		return nil
+	}
+
+	return x
+}
+
+func (sl *skiplist) lastInScoreRange(r *ScoreRange) *skiplistNode {
+	if r.empty() {
+		return nil
+	}
+
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.belowMax(x.level[i].forward.score) {
+			x = x.level[i].forward
+		}
+	}
+
+	if x == sl.header || !r.aboveMin(x.score) {
+		return nil
+	}
+
+	return x
+}
+
+func (sl *skiplist) firstInLexRange(r *LexRange) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && !r.aboveMin(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+
+	x = x.level[0].forward
+	if x == nil || !r.belowMax(x.member) {
+		return nil
+	}
+
+	return x
+}
+
+func (sl *skiplist) lastInLexRange(r *LexRange) *skiplistNode {
+	x := sl.header
+	for i := sl.level - 1; i >= 0; i-- {
+		for x.level[i].forward != nil && r.belowMax(x.level[i].forward.member) {
+			x = x.level[i].forward
+		}
+	}
+
+	if x == sl.header || !r.aboveMin(x.member) {
+		return nil
+	}
+
+	return x
+}
@@ -0,0 +1,214 @@
+package store
+
+import (
+	"cmp"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func compareZMembers(a, b ZMember) int {
+	if c := cmp.Compare(a.Score, b.Score); c != 0 {
+		return c
+	}
+	return cmp.Compare(a.Member, b.Member)
+}
+
+// Applies a range query's direction, offset and count to the members of a
+// sorted slice that are within the range.
+func modelRange(model []ZMember, inRange func(ZMember) bool, rev bool, offset, count int) []ZMember {
+	matched := []ZMember{}
+	for _, m := range model {
+		if inRange(m) {
+			matched = append(matched, m)
+		}
+	}
+
+	if rev {
+		slices.Reverse(matched)
+	}
+
+	matched = matched[min(offset, len(matched)):]
+	if count >= 0 {
+		matched = matched[:min(count, len(matched))]
+	}
+
+	return matched
+}
+
+func randomScoreRange(rng *rand.Rand) *ScoreRange {
+	r := &ScoreRange{
+		Min:          float64(rng.IntN(12) - 1),
+		Max:          float64(rng.IntN(12) - 1),
+		MinExclusive: rng.IntN(2) == 0,
+		MaxExclusive: rng.IntN(2) == 0,
+	}
+
+	switch rng.IntN(8) {
+	case 0:
+		r.Min = math.Inf(-1)
+	case 1:
+		r.Max = math.Inf(1)
+	}
+
+	return r
+}
+
+func randomLexRange(rng *rand.Rand) *LexRange {
+	bound := func() LexBound {
+		b := LexBound{
+			Exclusive: rng.IntN(2) == 0,
+			Value:     strconv.Itoa(rng.IntN(300)),
+		}
+
+		switch rng.IntN(10) {
+		case 0:
+			b.Inf = -1
+		case 1:
+			b.Inf = 1
+		}
+
+		return b
+	}
+
+	return &LexRange{Min: bound(), Max: bound()}
+}
+
+// Drives a SortedSet and a sorted slice through the same random operations
+// and checks every positional query agrees, with enough members for the
+// skiplist to grow several levels. Scores are drawn from a handful of values
+// so plenty of members tie and are ordered by member instead.
+func TestSortedSetMatchesSlice(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	z := NewSortedSet()
+	var model []ZMember
+
+	for step := range 10_000 {
+		member := strconv.Itoa(rng.IntN(300))
+		i := slices.IndexFunc(model, func(m ZMember) bool { return m.Member == member })
+
+		switch op := rng.IntN(10); {
+		case op < 5:
+			score := float64(rng.IntN(10))
+			z.Add(member, score, nil)
+			if i >= 0 {
+				model = slices.Delete(model, i, i+1)
+			}
+			model = append(model, ZMember{Member: member, Score: score})
+		case op == 5:
+			score, _, _ := z.Add(member, 1, &ZAddFlags{Incr: true})
+			if i >= 0 {
+				model = slices.Delete(model, i, i+1)
+			}
+			model = append(model, ZMember{Member: member, Score: score})
+		case op == 6:
+			if z.Remove(member) != (i >= 0) {
+				t.Fatalf("step %d: Remove(%q) disagreed on whether it existed", step, member)
+			}
+			if i >= 0 {
+				model = slices.Delete(model, i, i+1)
+			}
+		case op == 7 && len(model) > 0:
+			count := rng.IntN(4) + 1
+			highest := rng.IntN(2) == 0
+			want := modelRange(model, func(ZMember) bool { return true }, highest, 0, count)
+			if got := z.Pop(count, highest); !slices.Equal(got, want) {
+				t.Fatalf("step %d: Pop(%d, %t) got %v, want %v", step, count, highest, got, want)
+			}
+			model = slices.DeleteFunc(model, func(m ZMember) bool { return slices.Contains(want, m) })
+		case op == 8 && step%20 == 0:
+			r := randomScoreRange(rng)
+			want := modelRange(model, func(m ZMember) bool { return r.aboveMin(m.Score) && r.belowMax(m.Score) }, false, 0, -1)
+			if got := z.RemoveRangeByScore(r); got != len(want) {
+				t.Fatalf("step %d: RemoveRangeByScore(%+v) removed %d, want %d", step, r, got, len(want))
+			}
+			model = slices.DeleteFunc(model, func(m ZMember) bool { return slices.Contains(want, m) })
+		case op == 9 && step%20 == 0 && len(model) > 0:
+			start := rng.IntN(len(model))
+			stop := start + rng.IntN(len(model)-start)
+			if got := z.RemoveRangeByRank(start, stop); got != stop-start+1 {
+				t.Fatalf("step %d: RemoveRangeByRank(%d, %d) removed %d", step, start, stop, got)
+			}
+			model = slices.Delete(model, start, stop+1)
+		}
+		slices.SortFunc(model, compareZMembers)
+
+		if z.Len() != len(model) {
+			t.Fatalf("step %d: Len is %d, want %d", step, z.Len(), len(model))
+		}
+
+		for _, rev := range []bool{false, true} {
+			if len(model) > 0 {
+				want := rng.IntN(len(model))
+				m := model[want]
+				if rev {
+					want = len(model) - 1 - want
+				}
+				if got, ok := z.Rank(m.Member, rev); !ok || got != want {
+					t.Fatalf("step %d: Rank(%q, %t) got %d, want %d", step, m.Member, rev, got, want)
+				}
+
+				start := rng.IntN(len(model))
+				stop := start + rng.IntN(len(model)-start+2)
+				wantRange := modelRange(model, func(ZMember) bool { return true }, rev, start, stop-start+1)
+				if got := z.RangeByRank(start, stop, rev); !slices.Equal(got, wantRange) {
+					t.Fatalf("step %d: RangeByRank(%d, %d, %t) got %v, want %v", step, start, stop, rev, got, wantRange)
+				}
+			}
+
+			r := randomScoreRange(rng)
+			offset, count := rng.IntN(5), rng.IntN(8)-1
+			inRange := func(m ZMember) bool { return r.aboveMin(m.Score) && r.belowMax(m.Score) }
+			want := modelRange(model, inRange, rev, offset, count)
+			if got := z.RangeByScore(r, rev, offset, count); !slices.Equal(got, want) {
+				t.Fatalf("step %d: RangeByScore(%+v, %t, %d, %d) got %v, want %v", step, r, rev, offset, count, got, want)
+			}
+
+			if rev {
+				continue
+			}
+			if got, want := z.CountByScore(r), len(modelRange(model, inRange, false, 0, -1)); got != want {
+				t.Fatalf("step %d: CountByScore(%+v) got %d, want %d", step, r, got, want)
+			}
+		}
+	}
+}
+
+// Lexicographical ranges are only meaningful when every member has the same
+// score, so they're checked on a sorted set of their own.
+func TestSortedSetRangeByLexMatchesSlice(t *testing.T) {
+	rng := rand.New(rand.NewPCG(5, 6))
+	z := NewSortedSet()
+	var model []ZMember
+
+	for step := range 5_000 {
+		member := strconv.Itoa(rng.IntN(300))
+		if rng.IntN(4) == 0 {
+			z.Remove(member)
+			model = slices.DeleteFunc(model, func(m ZMember) bool { return m.Member == member })
+		} else if _, status, _ := z.Add(member, 0, nil); status == ZAddAdded {
+			model = append(model, ZMember{Member: member})
+			slices.SortFunc(model, compareZMembers)
+		}
+
+		r := randomLexRange(rng)
+		inRange := func(m ZMember) bool { return r.aboveMin(m.Member) && r.belowMax(m.Member) }
+		for _, rev := range []bool{false, true} {
+			offset, count := rng.IntN(5), rng.IntN(8)-1
+			want := modelRange(model, inRange, rev, offset, count)
+			if got := z.RangeByLex(r, rev, offset, count); !slices.Equal(got, want) {
+				t.Fatalf("step %d: RangeByLex(%+v, %t, %d, %d) got %v, want %v", step, r, rev, offset, count, got, want)
+			}
+		}
+
+		if step%50 == 0 {
+			want := modelRange(model, inRange, false, 0, -1)
+			if got := z.RemoveRangeByLex(r); got != len(want) {
+				t.Fatalf("step %d: RemoveRangeByLex(%+v) removed %d, want %d", step, r, got, len(want))
+			}
+			model = slices.DeleteFunc(model, inRange)
+		}
+	}
+}